@@ -0,0 +1,118 @@
+package client
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned without contacting the server while the circuit breaker is open
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+// BreakerState is the state of a client's circuit breaker
+type BreakerState int
+
+const (
+	BreakerClosed   BreakerState = iota // requests go through
+	BreakerOpen                         // requests fail fast with ErrCircuitOpen
+	BreakerHalfOpen                     // the cooldown is over and one trial request may go through
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// breaker fails requests fast once the server looks unreachable. Only transport failures count;
+// a status from the server, even an error one, shows it is reachable.
+type breaker struct {
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mu       sync.Mutex
+	state    BreakerState // BreakerClosed or BreakerOpen; half-open is derived from openedAt
+	failures int          // consecutive transport failures while closed
+	openedAt time.Time
+	probing  bool // a half-open trial request is in flight
+}
+
+// WithCircuitBreaker makes the client fail fast with ErrCircuitOpen for cooldown after threshold
+// consecutive requests fail to reach the server. After the cooldown a single trial request goes
+// through: success closes the breaker, failure opens it for another cooldown.
+func WithCircuitBreaker(threshold int, cooldown time.Duration) Option {
+	return func(c *Client) {
+		c.breaker = &breaker{threshold: threshold, cooldown: cooldown, now: time.Now}
+	}
+}
+
+// BreakerState returns the state of the client's circuit breaker. A client without one is
+// always BreakerClosed.
+func (c *Client) BreakerState() BreakerState {
+	if c.breaker == nil {
+		return BreakerClosed
+	}
+	c.breaker.mu.Lock()
+	defer c.breaker.mu.Unlock()
+	return c.breaker.stateLocked()
+}
+
+// stateLocked returns the current state, moving to half-open once the cooldown has passed. Must
+// be called with b.mu held.
+func (b *breaker) stateLocked() BreakerState {
+	if b.state == BreakerOpen && b.now().Sub(b.openedAt) >= b.cooldown {
+		return BreakerHalfOpen
+	}
+	return b.state
+}
+
+// allow reports whether a request may be sent, returning ErrCircuitOpen if not. A request it
+// allows must be followed by record.
+func (b *breaker) allow() error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.stateLocked() {
+	case BreakerOpen:
+		return ErrCircuitOpen
+	case BreakerHalfOpen:
+		if b.probing {
+			return ErrCircuitOpen
+		}
+		b.probing = true
+	}
+	return nil
+}
+
+// record notes whether an allowed request reached the server
+func (b *breaker) record(reached bool) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if reached {
+		b.state = BreakerClosed
+		b.failures = 0
+		b.probing = false
+		return
+	}
+	b.failures++
+	if b.probing || b.failures >= b.threshold {
+		b.state = BreakerOpen
+		b.openedAt = b.now()
+		b.probing = false
+	}
+}
@@ -0,0 +1,89 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// flakyConn is a net.Conn whose writes fail while down is set, like a server that went away
+type flakyConn struct {
+	net.Conn
+	down   atomic.Bool
+	writes atomic.Int32
+}
+
+func (c *flakyConn) Write(p []byte) (int, error) {
+	c.writes.Add(1)
+	if c.down.Load() {
+		return 0, errors.New("connection refused")
+	}
+	return c.Conn.Write(p)
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer serverConn.Close()
+	fakeServer(t, serverConn)
+	conn := &flakyConn{Conn: clientConn}
+
+	now := time.Unix(1000, 0)
+	c := New(conn, uuid.New(), WithCircuitBreaker(3, 5*time.Second))
+	c.breaker.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	// Consecutive transport failures open the breaker at the threshold
+	conn.down.Store(true)
+	for i := 0; i < 3; i++ {
+		if _, err := c.Acquire(ctx, "lock1", time.Second); err == nil || errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("Call %d: expected a transport error, got %v", i, err)
+		}
+	}
+	if state := c.BreakerState(); state != BreakerOpen {
+		t.Fatalf("Expected breaker open, got %s", state)
+	}
+
+	// While open, calls fail fast without touching the connection
+	writes := conn.writes.Load()
+	if _, err := c.Acquire(ctx, "lock1", time.Second); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("Expected ErrCircuitOpen, got %v", err)
+	}
+	if conn.writes.Load() != writes {
+		t.Error("Expected an open breaker not to write to the connection")
+	}
+
+	// After the cooldown a failed trial opens it again for another cooldown
+	now = now.Add(5 * time.Second)
+	if state := c.BreakerState(); state != BreakerHalfOpen {
+		t.Fatalf("Expected breaker half-open after the cooldown, got %s", state)
+	}
+	if _, err := c.Acquire(ctx, "lock1", time.Second); err == nil || errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Expected the trial to reach the transport and fail, got %v", err)
+	}
+	if state := c.BreakerState(); state != BreakerOpen {
+		t.Fatalf("Expected a failed trial to reopen the breaker, got %s", state)
+	}
+
+	// A successful trial closes it
+	now = now.Add(5 * time.Second)
+	conn.down.Store(false)
+	if _, err := c.Acquire(ctx, "lock1", time.Second); err != nil {
+		t.Fatalf("Expected the trial to succeed, got %v", err)
+	}
+	if state := c.BreakerState(); state != BreakerClosed {
+		t.Errorf("Expected breaker closed, got %s", state)
+	}
+
+	// Error statuses come from a reachable server and don't count as failures
+	for i := 0; i < 3; i++ {
+		c.Acquire(ctx, "lock1", time.Second)
+	}
+	if state := c.BreakerState(); state != BreakerClosed {
+		t.Errorf("Expected status errors to leave the breaker closed, got %s", state)
+	}
+}
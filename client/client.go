@@ -0,0 +1,724 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mrdhat/clutchdb/clutcherrors"
+	"github.com/mrdhat/clutchdb/protocol"
+)
+
+// StatusError is returned when the server answers with a non-success status
+type StatusError struct {
+	Status     clutcherrors.StatusCode
+	LeaderHint string // where to retry, if the server is a follower that knows its leader
+	Message    string // the server's explanation, if it sends them; for humans, not for branching on
+
+	FencingToken uint64 // with STATUS_FENCED_OUT, the token of the hold that replaced the caller's; with STATUS_HELD_BY_CALLER, the caller's own
+}
+
+func (e *StatusError) Error() string {
+	msg := fmt.Sprintf("server returned status %d", e.Status)
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	if e.LeaderHint != "" {
+		msg += ", leader is " + e.LeaderHint
+	}
+	return msg
+}
+
+// Client talks to a clutchdb server over a single connection.
+// Requests are serialized: one request-response pair is in flight at a time.
+type Client struct {
+	conn    net.Conn
+	ownerID [16]byte
+	version uint16 // protocol version agreed with the server
+
+	connMu         sync.Mutex                      // serializes request/response pairs on conn
+	reqBuf         [protocol.RequestFrameSize]byte // guarded by connMu
+	broken         atomic.Bool                     // set under connMu, read without it; see ErrConnBroken
+	stopCancel     func() bool                     // guarded by connMu; stops the running request's cancellation
+	requestTimeout time.Duration                   // for requests whose context has no deadline
+
+	tokensMu sync.Mutex
+	tokens   map[string]HeldLock // lock ID -> latest hold granted
+
+	leasesMu sync.Mutex
+	leases   map[*Lease]struct{} // running keepalives
+	closed   bool                // guarded by leasesMu; no new leases after Close
+
+	breaker *breaker // nil unless WithCircuitBreaker
+	secret  []byte   // signs every request when set; see WithSharedSecret
+
+	clockMu sync.Mutex
+	clock   clockEstimate // server clock and round trip, learned from responses
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithSharedSecret signs every request with secret, for a server that authenticates requests
+// with server.HMACAuth under the same secret
+func WithSharedSecret(secret []byte) Option {
+	return func(c *Client) {
+		c.secret = bytes.Clone(secret)
+	}
+}
+
+// NewOwnerID returns a fresh random owner ID
+func NewOwnerID() [16]byte {
+	return uuid.New()
+}
+
+// New returns a Client that issues commands on conn as ownerID. A zero ownerID is replaced
+// with one from NewOwnerID, so every operation from the client still shares a single owner.
+func New(conn net.Conn, ownerID [16]byte, opts ...Option) *Client {
+	if ownerID == ([16]byte{}) {
+		ownerID = NewOwnerID()
+	}
+	c := &Client{
+		conn:           conn,
+		ownerID:        ownerID,
+		version:        1,
+		tokens:         make(map[string]HeldLock),
+		leases:         make(map[*Lease]struct{}),
+		requestTimeout: DefaultRequestTimeout,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Dial connects to the server at addr and negotiates the newest protocol version both sides speak
+func Dial(addr string, ownerID [16]byte, opts ...Option) (*Client, error) {
+	return DialVersion(addr, ownerID, protocol.MaxVersion, opts...)
+}
+
+// DialVersion is like Dial but offers protocol versions only up to maxVersion. A server that
+// predates version negotiation rejects the handshake and closes the connection, so the client
+// reconnects without it and speaks version 1.
+func DialVersion(addr string, ownerID [16]byte, maxVersion uint16, opts ...Option) (*Client, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	version, err := handshake(conn, maxVersion)
+	if errors.Is(err, errNoHandshake) {
+		conn.Close()
+		if conn, err = net.Dial("tcp", addr); err != nil {
+			return nil, err
+		}
+		version, err = 1, nil
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	c := New(conn, ownerID, opts...)
+	c.version = version
+	return c, nil
+}
+
+// errNoHandshake means the server doesn't understand HELLO
+var errNoHandshake = errors.New("server does not support version negotiation")
+
+// handshake offers versions up to maxVersion on conn and returns the one the server chose
+func handshake(conn net.Conn, maxVersion uint16) (uint16, error) {
+	if err := protocol.WriteHello(conn, maxVersion); err != nil {
+		return 0, fmt.Errorf("failed to write hello: %w", err)
+	}
+	status, version, err := protocol.ReadHelloResponse(conn)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read hello response: %w", err)
+	}
+	switch status {
+	case clutcherrors.STATUS_SUCCESS:
+		return version, nil
+	case clutcherrors.STATUS_INVALID_REQUEST:
+		return 0, errNoHandshake
+	default:
+		return 0, &StatusError{Status: status}
+	}
+}
+
+// Version returns the protocol version agreed with the server. Clients built with New
+// skip negotiation and speak version 1.
+func (c *Client) Version() uint16 {
+	return c.version
+}
+
+// OwnerID returns the owner ID the client issues commands as
+func (c *Client) OwnerID() [16]byte {
+	return c.ownerID
+}
+
+// Close stops every keepalive, releases every lock the client still holds and closes the
+// connection. Releases are best-effort within ctx; any lock that isn't released falls back to
+// TTL expiry. The returned error joins any release failures with the close error.
+func (c *Client) Close(ctx context.Context) error {
+	c.leasesMu.Lock()
+	c.closed = true
+	leases := make([]*Lease, 0, len(c.leases))
+	for l := range c.leases {
+		leases = append(leases, l)
+	}
+	c.leasesMu.Unlock()
+
+	for _, l := range leases {
+		l.Stop()
+	}
+
+	c.tokensMu.Lock()
+	lockIDs := make([]string, 0, len(c.tokens))
+	for lockID := range c.tokens {
+		lockIDs = append(lockIDs, lockID)
+	}
+	c.tokensMu.Unlock()
+
+	var errs []error
+	for _, lockID := range lockIDs {
+		if err := c.Release(ctx, lockID); err != nil {
+			errs = append(errs, fmt.Errorf("failed to release %s: %w", lockID, err))
+		}
+	}
+	errs = append(errs, c.conn.Close())
+	return errors.Join(errs...)
+}
+
+// Acquire acquires lockID for ttl and records the granted fencing token. If the server says the
+// client already holds lockID, with STATUS_HELD_BY_CALLER, the hold's token is recorded too, so
+// the caller can Renew it.
+func (c *Client) Acquire(ctx context.Context, lockID string, ttl time.Duration) (*protocol.Response, error) {
+	resp, err := c.do(ctx, protocol.ACQUIRE, lockID, protocol.DurationToMillis(ttl), 0)
+	if err != nil {
+		return nil, err
+	}
+	c.setHeld(lockID, resp.FencingToken, resp.ExpiresAt)
+	return resp, nil
+}
+
+// AcquireWithValue acquires lockID like Acquire and attaches value to the hold, such as the
+// client's address when the lock elects a leader. Anyone can read it back with LockInfos for as
+// long as the hold lasts. Values are at most 16 bytes, like lock IDs.
+func (c *Client) AcquireWithValue(ctx context.Context, lockID string, ttl time.Duration, value string) (*protocol.Response, error) {
+	req := &protocol.Request{Cmd: protocol.ACQUIRE, TTLMS: protocol.DurationToMillis(ttl)}
+	if len(value) > len(req.Value) {
+		return nil, fmt.Errorf("value too long: %d bytes, max %d", len(value), len(req.Value))
+	}
+	copy(req.Value[:], value)
+	resp, err := c.send(ctx, lockID, req)
+	if err != nil {
+		return nil, err
+	}
+	c.setHeld(lockID, resp.FencingToken, resp.ExpiresAt)
+	return resp, nil
+}
+
+// AcquireAbove acquires lockID like Acquire, but the server only grants a fencing token
+// strictly greater than minToken
+func (c *Client) AcquireAbove(ctx context.Context, lockID string, ttl time.Duration, minToken uint64) (*protocol.Response, error) {
+	resp, err := c.do(ctx, protocol.ACQUIRE, lockID, protocol.DurationToMillis(ttl), minToken)
+	if err != nil {
+		return nil, err
+	}
+	c.setHeld(lockID, resp.FencingToken, resp.ExpiresAt)
+	return resp, nil
+}
+
+// AcquireWait acquires lockID like Acquire, but if it is held the server queues the request and
+// answers once the lock frees. If more than maxQueue other callers are already waiting it fails
+// at once with STATUS_LOCK_HELD instead. The wait counts against ctx's deadline, and running out
+// breaks the connection, so give ctx a deadline long enough to cover the wait. Without one the
+// request timeout doesn't apply and the wait lasts until the lock is granted, ctx is cancelled
+// or the server's command timeout ends it.
+func (c *Client) AcquireWait(ctx context.Context, lockID string, ttl time.Duration, maxQueue uint64) (*protocol.Response, error) {
+	return c.AcquireWaitPriority(ctx, lockID, ttl, maxQueue, 0)
+}
+
+// AcquireWaitPriority is AcquireWait with a place in the server's queue: when the lock frees it
+// goes to the highest-priority waiter, earlier arrivals first among equals. The server raises
+// the priority of long waiters over time, so low priorities are delayed but not starved.
+func (c *Client) AcquireWaitPriority(ctx context.Context, lockID string, ttl time.Duration, maxQueue uint64, priority uint32) (*protocol.Response, error) {
+	resp, err := c.send(ctx, lockID, &protocol.Request{Cmd: protocol.ACQUIRE_WAIT, TTLMS: protocol.DurationToMillis(ttl), FencingToken: maxQueue, Priority: priority})
+	if err != nil {
+		return nil, err
+	}
+	c.setHeld(lockID, resp.FencingToken, resp.ExpiresAt)
+	return resp, nil
+}
+
+// WaitFree waits up to maxWait for nobody to hold lockID, by release or expiry, without taking
+// it. It returns nil once the lock is free, at once if it is already, or a *StatusError with
+// STATUS_LOCK_HELD if it is still held when maxWait runs out. A maxWait of 0 waits for as long as
+// ctx allows, as AcquireWait does. The connection is busy for the whole wait.
+func (c *Client) WaitFree(ctx context.Context, lockID string, maxWait time.Duration) error {
+	_, err := c.send(ctx, lockID, &protocol.Request{Cmd: protocol.WATCH, TTLMS: protocol.DurationToMillis(maxWait)})
+	return err
+}
+
+// CheckAcquire acquires lockID like Acquire, and reports whether the server had to reclaim it
+// from a holder whose lease lapsed without a release, typically one that crashed
+func (c *Client) CheckAcquire(ctx context.Context, lockID string, ttl time.Duration) (*protocol.Response, bool, error) {
+	resp, err := c.do(ctx, protocol.CHECK_ACQUIRE, lockID, protocol.DurationToMillis(ttl), 0)
+	reclaimed := resp != nil && resp.Status == clutcherrors.STATUS_ACQUIRED_RECLAIMED
+	if err != nil && !reclaimed {
+		return nil, false, err
+	}
+	c.setHeld(lockID, resp.FencingToken, resp.ExpiresAt)
+	return resp, reclaimed, nil
+}
+
+// Renew extends lockID by ttl using the fencing token from the last acquire
+func (c *Client) Renew(ctx context.Context, lockID string, ttl time.Duration) (*protocol.Response, error) {
+	token, ok := c.Token(lockID)
+	if !ok {
+		return nil, errors.New("lock not held by client")
+	}
+
+	resp, err := c.do(ctx, protocol.RENEW, lockID, protocol.DurationToMillis(ttl), token)
+	if err != nil {
+		c.forgetIfLost(lockID, err)
+		return nil, err
+	}
+	c.setHeld(lockID, resp.FencingToken, resp.ExpiresAt)
+	return resp, nil
+}
+
+// CompareAndRenew renews lockID like Renew, but only if less than threshold of its hold is
+// left, so an auto-renewing caller can renew lazily without tracking expiry itself. renewed is
+// false, with no error, when the server found enough time left and kept the hold as it was; resp
+// then carries its current expiry.
+func (c *Client) CompareAndRenew(ctx context.Context, lockID string, ttl time.Duration, threshold time.Duration) (resp *protocol.Response, renewed bool, err error) {
+	token, ok := c.Token(lockID)
+	if !ok {
+		return nil, false, errors.New("lock not held by client")
+	}
+
+	req := &protocol.Request{Cmd: protocol.COMPARE_AND_RENEW, TTLMS: protocol.DurationToMillis(ttl), FencingToken: token, ThresholdMS: protocol.DurationToMillis(threshold)}
+	resp, err = c.send(ctx, lockID, req)
+	notNeeded := resp != nil && resp.Status == clutcherrors.STATUS_RENEW_NOT_NEEDED
+	if err != nil && !notNeeded {
+		c.forgetIfLost(lockID, err)
+		return nil, false, err
+	}
+	c.setHeld(lockID, resp.FencingToken, resp.ExpiresAt)
+	return resp, !notNeeded, nil
+}
+
+// RenewAll extends every lock the client holds by ttl in one round-trip, checking each against
+// the fencing token the client last saw. Locks the server reports as lost are forgotten, as
+// with Renew. The error is only for the request as a whole; per-lock failures are in the results.
+func (c *Client) RenewAll(ctx context.Context, ttl time.Duration) ([]protocol.RenewResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	held := c.HeldLocks()
+	if len(held) == 0 {
+		return nil, nil
+	}
+	req := &protocol.RenewAllRequest{
+		RequestID: uuid.New(),
+		OwnerID:   c.ownerID,
+		TTLMS:     protocol.DurationToMillis(ttl),
+		Tokens:    make([]protocol.LockToken, len(held)),
+	}
+	for i, h := range held {
+		req.Tokens[i] = protocol.LockToken{LockID: h.LockID, FencingToken: h.FencingToken}
+	}
+	req.MAC = c.frameMAC(req.Envelope())
+
+	var (
+		status  clutcherrors.StatusCode
+		results []protocol.RenewResult
+	)
+	err := c.roundTrip(ctx,
+		func(w io.Writer) error { return protocol.WriteRenewAllRequest(w, req) },
+		func(r io.Reader) (err error) { status, results, err = protocol.ReadRenewAllResponse(r); return err })
+	if err != nil {
+		return nil, err
+	}
+	if status != clutcherrors.STATUS_SUCCESS {
+		return nil, &StatusError{Status: status}
+	}
+
+	for _, result := range results {
+		switch result.Status {
+		case clutcherrors.STATUS_SUCCESS:
+			c.setHeld(result.LockID, result.FencingToken, result.ExpiresAt)
+		case clutcherrors.STATUS_LOCK_NOT_HELD, clutcherrors.STATUS_LOCK_EXPIRED, clutcherrors.STATUS_FENCED_OUT:
+			c.deleteToken(result.LockID)
+		}
+	}
+	return results, nil
+}
+
+// LockInfos looks up the current hold on each of lockIDs in one round-trip. Results are in the
+// same order; a lock that isn't held has a FencingToken of 0.
+func (c *Client) LockInfos(ctx context.Context, lockIDs []string) ([]protocol.LockInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	req := &protocol.LockInfoMultiRequest{RequestID: uuid.New(), LockIDs: lockIDs}
+	req.MAC = c.frameMAC(req.Envelope())
+
+	var (
+		status clutcherrors.StatusCode
+		infos  []protocol.LockInfo
+	)
+	err := c.roundTrip(ctx,
+		func(w io.Writer) error { return protocol.WriteLockInfoMultiRequest(w, req) },
+		func(r io.Reader) (err error) { status, infos, err = protocol.ReadLockInfoMultiResponse(r); return err })
+	if err != nil {
+		return nil, err
+	}
+	if status != clutcherrors.STATUS_SUCCESS {
+		return nil, &StatusError{Status: status}
+	}
+	return infos, nil
+}
+
+// ServerLimits asks the server for the limits it enforces, such as its TTL bounds, so requests
+// can be checked before they are sent
+func (c *Client) ServerLimits(ctx context.Context) (*protocol.ServerLimits, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var (
+		status clutcherrors.StatusCode
+		limits *protocol.ServerLimits
+	)
+	err := c.roundTrip(ctx,
+		func(w io.Writer) error { return protocol.WriteReportRequest(w, c.reportRequest(protocol.SERVER_INFO)) },
+		func(r io.Reader) (err error) { status, limits, err = protocol.ReadServerInfoResponse(r); return err })
+	if err != nil {
+		return nil, err
+	}
+	if status != clutcherrors.STATUS_SUCCESS {
+		return nil, &StatusError{Status: status}
+	}
+	return limits, nil
+}
+
+// Stats asks the server for its live counters, such as how many locks it holds and how many
+// acquires it has committed since it started
+func (c *Client) Stats(ctx context.Context) (*protocol.ServerStats, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var (
+		status clutcherrors.StatusCode
+		stats  *protocol.ServerStats
+	)
+	err := c.roundTrip(ctx,
+		func(w io.Writer) error { return protocol.WriteReportRequest(w, c.reportRequest(protocol.SERVER_STATS)) },
+		func(r io.Reader) (err error) { status, stats, err = protocol.ReadServerStatsResponse(r); return err })
+	if err != nil {
+		return nil, err
+	}
+	if status != clutcherrors.STATUS_SUCCESS {
+		return nil, &StatusError{Status: status}
+	}
+	return stats, nil
+}
+
+// ListOwners asks the server how many live locks each owner holds, most first. The server sends
+// at most protocol.MaxOwnerSummaries owners; total is how many there are in all.
+func (c *Client) ListOwners(ctx context.Context) (owners []protocol.OwnerSummary, total int, err error) {
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	var (
+		status clutcherrors.StatusCode
+		n      uint32
+	)
+	err = c.roundTrip(ctx,
+		func(w io.Writer) error { return protocol.WriteReportRequest(w, c.reportRequest(protocol.LIST_OWNERS)) },
+		func(r io.Reader) (err error) { status, owners, n, err = protocol.ReadListOwnersResponse(r); return err })
+	if err != nil {
+		return nil, 0, err
+	}
+	if status != clutcherrors.STATUS_SUCCESS {
+		return nil, 0, &StatusError{Status: status}
+	}
+	return owners, int(n), nil
+}
+
+// Tombstones asks the server for the releases it has recorded, oldest first. Servers refuse it
+// unless they enable admin commands, and keep no tombstones unless configured to. The server
+// sends at most protocol.MaxTombstones of the most recent; total is how many there are in all.
+func (c *Client) Tombstones(ctx context.Context) (tombstones []protocol.Tombstone, total int, err error) {
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	var (
+		status clutcherrors.StatusCode
+		n      uint32
+	)
+	err = c.roundTrip(ctx,
+		func(w io.Writer) error { return protocol.WriteReportRequest(w, c.reportRequest(protocol.TOMBSTONES)) },
+		func(r io.Reader) (err error) {
+			status, tombstones, n, err = protocol.ReadTombstonesResponse(r)
+			return err
+		})
+	if err != nil {
+		return nil, 0, err
+	}
+	if status != clutcherrors.STATUS_SUCCESS {
+		return nil, 0, &StatusError{Status: status}
+	}
+	return tombstones, int(n), nil
+}
+
+// ReleaseByPrefix releases every lock the client's owner holds whose ID starts with prefix, and
+// returns how many were released. The prefix matches bytes, so end it with a separator to stay
+// within one subtree: "tenant/42/" rather than "tenant/42", which also covers "tenant/420/".
+func (c *Client) ReleaseByPrefix(ctx context.Context, prefix string) (int, error) {
+	status, n, err := c.doPrefix(ctx, &protocol.PrefixRequest{Cmd: protocol.RELEASE_BY_PREFIX, OwnerID: c.ownerID, Prefix: prefix})
+	if err != nil {
+		return 0, err
+	}
+	// Forget the tokens even if some releases failed: those holds may be gone too
+	c.tokensMu.Lock()
+	for lockID := range c.tokens {
+		if strings.HasPrefix(lockID, prefix) {
+			delete(c.tokens, lockID)
+		}
+	}
+	c.tokensMu.Unlock()
+	if status != clutcherrors.STATUS_SUCCESS {
+		return n, &StatusError{Status: status}
+	}
+	return n, nil
+}
+
+// CountByPrefix asks the server how many live locks, held by any owner, have IDs starting with
+// prefix
+func (c *Client) CountByPrefix(ctx context.Context, prefix string) (int, error) {
+	status, n, err := c.doPrefix(ctx, &protocol.PrefixRequest{Cmd: protocol.COUNT_BY_PREFIX, Prefix: prefix})
+	if err != nil {
+		return 0, err
+	}
+	if status != clutcherrors.STATUS_SUCCESS {
+		return 0, &StatusError{Status: status}
+	}
+	return n, nil
+}
+
+// doPrefix sends a RELEASE_BY_PREFIX or COUNT_BY_PREFIX and returns the server's answer
+func (c *Client) doPrefix(ctx context.Context, req *protocol.PrefixRequest) (clutcherrors.StatusCode, int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, 0, err
+	}
+	req.RequestID = uuid.New()
+	req.MAC = c.frameMAC(req.Envelope())
+
+	var (
+		status clutcherrors.StatusCode
+		n      uint32
+	)
+	err := c.roundTrip(ctx,
+		func(w io.Writer) error { return protocol.WritePrefixRequest(w, req) },
+		func(r io.Reader) (err error) { status, n, err = protocol.ReadPrefixResponse(r); return err })
+	if err != nil {
+		return 0, 0, err
+	}
+	return status, int(n), nil
+}
+
+// reportRequest returns a SERVER_INFO, SERVER_STATS, LIST_OWNERS or TOMBSTONES request for cmd,
+// signed if the client has a shared secret
+func (c *Client) reportRequest(cmd uint8) *protocol.ReportRequest {
+	req := &protocol.ReportRequest{Cmd: cmd, RequestID: uuid.New()}
+	req.MAC = c.frameMAC(req.Envelope())
+	return req
+}
+
+// frameMAC returns the MAC for a request in its own frame layout given its envelope, zero if
+// the client has no shared secret
+func (c *Client) frameMAC(env *protocol.Request) [protocol.MACLength]byte {
+	if c.secret != nil {
+		protocol.SignRequest(env, c.secret)
+	}
+	return env.MAC
+}
+
+// Release releases lockID using the fencing token from the last acquire
+func (c *Client) Release(ctx context.Context, lockID string) error {
+	token, ok := c.Token(lockID)
+	if !ok {
+		return errors.New("lock not held by client")
+	}
+
+	if _, err := c.do(ctx, protocol.RELEASE, lockID, 0, token); err != nil {
+		c.forgetIfLost(lockID, err)
+		return err
+	}
+	c.deleteToken(lockID)
+	return nil
+}
+
+// Token returns the latest fencing token the client holds for lockID, so callers
+// can stamp writes to downstream resource servers with it
+func (c *Client) Token(lockID string) (uint64, bool) {
+	c.tokensMu.Lock()
+	defer c.tokensMu.Unlock()
+	held, ok := c.tokens[lockID]
+	return held.FencingToken, ok
+}
+
+// HeldLock is a lock the client believes it holds
+type HeldLock struct {
+	LockID       string
+	FencingToken uint64 // token from the last acquire, renew or bump
+	ExpiresAt    uint64 // expiry the server last reported, in milliseconds
+}
+
+// HeldLocks returns the locks the client believes it holds, sorted by lock ID, without asking
+// the server. This is the client's optimistic view: a hold that expired or was taken over
+// server-side stays listed until a command on it reports the loss.
+func (c *Client) HeldLocks() []HeldLock {
+	c.tokensMu.Lock()
+	held := make([]HeldLock, 0, len(c.tokens))
+	for _, h := range c.tokens {
+		held = append(held, h)
+	}
+	c.tokensMu.Unlock()
+
+	sort.Slice(held, func(i, j int) bool { return held[i].LockID < held[j].LockID })
+	return held
+}
+
+func (c *Client) setHeld(lockID string, token uint64, expiresAt uint64) {
+	c.tokensMu.Lock()
+	defer c.tokensMu.Unlock()
+	c.tokens[lockID] = HeldLock{LockID: lockID, FencingToken: token, ExpiresAt: expiresAt}
+}
+
+func (c *Client) deleteToken(lockID string) {
+	c.tokensMu.Lock()
+	defer c.tokensMu.Unlock()
+	delete(c.tokens, lockID)
+}
+
+func (c *Client) forgetLease(l *Lease) {
+	c.leasesMu.Lock()
+	defer c.leasesMu.Unlock()
+	delete(c.leases, l)
+}
+
+// forgetIfLost drops the stored token when the server reports the lock is no longer ours
+func (c *Client) forgetIfLost(lockID string, err error) {
+	if lostHold(err) {
+		c.deleteToken(lockID)
+	}
+}
+
+// lostHold reports whether err is the server saying the caller's hold is gone, as opposed to a
+// status such as STATUS_RATE_LIMITED that says nothing about the hold
+func lostHold(err error) bool {
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) {
+		return false
+	}
+	switch statusErr.Status {
+	case clutcherrors.STATUS_LOCK_NOT_HELD, clutcherrors.STATUS_LOCK_EXPIRED, clutcherrors.STATUS_FENCED_OUT:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *Client) do(ctx context.Context, cmd uint8, lockID string, ttlMS uint64, fencingToken uint64) (*protocol.Response, error) {
+	return c.send(ctx, lockID, &protocol.Request{Cmd: cmd, TTLMS: ttlMS, FencingToken: fencingToken})
+}
+
+// send is do for requests setting fields beyond the TTL and token, such as an ACQUIRE_WAIT's
+// priority. It fills in req's request ID, owner and lockID, and signs it.
+func (c *Client) send(ctx context.Context, lockID string, req *protocol.Request) (*protocol.Response, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(lockID) > 16 {
+		return nil, fmt.Errorf("lock id too long: %d bytes, max 16", len(lockID))
+	}
+
+	req.RequestID = uuid.New()
+	req.OwnerID = c.ownerID
+	copy(req.LockID[:], lockID)
+	if c.secret != nil {
+		protocol.SignRequest(req, c.secret)
+	}
+
+	timeout := c.requestTimeout
+	switch {
+	case req.Cmd == protocol.ACQUIRE_WAIT, req.Cmd == protocol.WATCH && req.TTLMS == 0:
+		// Queued on the server for as long as the lock stays held; a timeout picked for quick
+		// requests would only break the connection
+		timeout = 0
+	case req.Cmd == protocol.WATCH && timeout > 0:
+		// Held on the server for up to the wait on top of the round trip
+		timeout += protocol.MillisToDuration(req.TTLMS)
+	}
+
+	var (
+		resp           *protocol.Response
+		sent, received time.Time
+	)
+	err := c.roundTripWithin(ctx, timeout,
+		func(w io.Writer) error {
+			sent = time.Now()
+			return protocol.WriteRequestTo(w, req, &c.reqBuf)
+		},
+		func(r io.Reader) (err error) {
+			resp, err = protocol.ReadResponse(r)
+			received = time.Now()
+			return err
+		})
+	if err != nil {
+		return nil, err
+	}
+	// Version 2 servers echo the request ID; anything else means the stream is out of step
+	if resp.RequestID != ([16]byte{}) && resp.RequestID != req.RequestID {
+		return nil, fmt.Errorf("response is for request %x, expected %x", resp.RequestID, req.RequestID)
+	}
+	if resp.Status != clutcherrors.STATUS_SUCCESS {
+		statusErr := &StatusError{Status: resp.Status, LeaderHint: resp.LeaderHint, Message: resp.Message}
+		switch resp.Status {
+		case clutcherrors.STATUS_FENCED_OUT:
+			statusErr.FencingToken = resp.FencingToken
+		case clutcherrors.STATUS_HELD_BY_CALLER:
+			// Already ours, say from an acquire whose response was lost: adopt it so Renew works
+			statusErr.FencingToken = resp.FencingToken
+			c.setHeld(lockID, resp.FencingToken, resp.ExpiresAt)
+		case clutcherrors.STATUS_ACQUIRED_RECLAIMED:
+			// A grant all the same, stamped like any other
+			c.observeTiming(sent, received, resp.ExpiresAt, req.TTLMS)
+		}
+		return resp, statusErr
+	}
+	if startsHold(req.Cmd) {
+		c.observeTiming(sent, received, resp.ExpiresAt, req.TTLMS)
+	}
+	return resp, nil
+}
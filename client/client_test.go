@@ -0,0 +1,750 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mrdhat/clutchdb/clutcherrors"
+	"github.com/mrdhat/clutchdb/protocol"
+	"github.com/mrdhat/clutchdb/server"
+	"github.com/mrdhat/clutchdb/wal"
+)
+
+// fakeServer answers requests on conn with a minimal single-lock state machine
+func fakeServer(t *testing.T, conn net.Conn) {
+	t.Helper()
+	var token uint64
+	held := false
+	go func() {
+		for {
+			req, err := protocol.ReadRequest(conn)
+			if err != nil {
+				return
+			}
+			resp := &protocol.Response{Status: clutcherrors.STATUS_SUCCESS}
+			switch req.Cmd {
+			case protocol.ACQUIRE:
+				if held {
+					resp.Status = clutcherrors.STATUS_LOCK_HELD
+					break
+				}
+				held = true
+				token++
+				resp.FencingToken = token
+			case protocol.RENEW:
+				if !held || req.FencingToken != token {
+					resp.Status = clutcherrors.STATUS_LOCK_NOT_HELD
+					break
+				}
+				resp.FencingToken = token
+			case protocol.RELEASE:
+				if !held || req.FencingToken != token {
+					resp.Status = clutcherrors.STATUS_LOCK_NOT_HELD
+					break
+				}
+				held = false
+			}
+			if err := protocol.WriteResponse(conn, resp); err != nil {
+				return
+			}
+		}
+	}()
+}
+
+func newTestClient(t *testing.T) *Client {
+	t.Helper()
+	clientConn, serverConn := net.Pipe()
+	fakeServer(t, serverConn)
+	c := New(clientConn, uuid.New())
+	t.Cleanup(func() {
+		c.Close(context.Background())
+		serverConn.Close()
+	})
+	return c
+}
+
+func TestTokenTracksRenewAndReacquire(t *testing.T) {
+	c := newTestClient(t)
+	ctx := context.Background()
+
+	if _, ok := c.Token("lock1"); ok {
+		t.Fatal("Expected no token before acquire")
+	}
+
+	if _, err := c.Acquire(ctx, "lock1", time.Second); err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	first, ok := c.Token("lock1")
+	if !ok || first == 0 {
+		t.Fatalf("Expected token after acquire, got %d (ok=%v)", first, ok)
+	}
+
+	if _, err := c.Renew(ctx, "lock1", time.Second); err != nil {
+		t.Fatalf("Renew failed: %v", err)
+	}
+	renewed, _ := c.Token("lock1")
+	if renewed != first {
+		t.Errorf("Expected token to stay %d across renew, got %d", first, renewed)
+	}
+
+	if err := c.Release(ctx, "lock1"); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+	if _, ok := c.Token("lock1"); ok {
+		t.Error("Expected token to be cleared on release")
+	}
+
+	if _, err := c.Acquire(ctx, "lock1", time.Second); err != nil {
+		t.Fatalf("Second Acquire failed: %v", err)
+	}
+	second, _ := c.Token("lock1")
+	if second <= first {
+		t.Errorf("Expected token > %d after re-acquire, got %d", first, second)
+	}
+}
+
+func TestTokenClearedOnLostLock(t *testing.T) {
+	c := newTestClient(t)
+	ctx := context.Background()
+
+	if _, err := c.Acquire(ctx, "lock1", time.Second); err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+
+	// Corrupt the stored token so the server rejects the renew as not held
+	c.setHeld("lock1", 999, 0)
+
+	if _, err := c.Renew(ctx, "lock1", time.Second); err == nil {
+		t.Fatal("Expected renew to fail")
+	}
+	if _, ok := c.Token("lock1"); ok {
+		t.Error("Expected token to be cleared after lost lock")
+	}
+}
+
+func TestGeneratedOwnerID(t *testing.T) {
+	first := New(nil, [16]byte{})
+	second := New(nil, [16]byte{})
+	if first.OwnerID() == ([16]byte{}) {
+		t.Fatal("Expected a generated owner id")
+	}
+	if first.OwnerID() == second.OwnerID() {
+		t.Error("Expected distinct owner ids for separate clients")
+	}
+
+	explicit := NewOwnerID()
+	if c := New(nil, explicit); c.OwnerID() != explicit {
+		t.Error("Expected an explicit owner id to be kept")
+	}
+}
+
+func TestOwnerIDConsistentAcrossOperations(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	owners := make(chan [16]byte, 3)
+	go func() {
+		for {
+			req, err := protocol.ReadRequest(serverConn)
+			if err != nil {
+				return
+			}
+			owners <- req.OwnerID
+			if err := protocol.WriteResponse(serverConn, &protocol.Response{FencingToken: 1}); err != nil {
+				return
+			}
+		}
+	}()
+	c := New(clientConn, [16]byte{})
+	defer c.Close(context.Background())
+	defer serverConn.Close()
+
+	ctx := context.Background()
+	if _, err := c.Acquire(ctx, "lock1", time.Second); err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	if _, err := c.Renew(ctx, "lock1", time.Second); err != nil {
+		t.Fatalf("Renew failed: %v", err)
+	}
+	if err := c.Release(ctx, "lock1"); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if owner := <-owners; owner != c.OwnerID() {
+			t.Errorf("Request %d: expected owner %x, got %x", i, c.OwnerID(), owner)
+		}
+	}
+}
+
+func TestCloseReleasesHeldLocks(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.NewServer().Serve(ctx, ln)
+
+	c, err := Dial(ln.Addr().String(), [16]byte{})
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	for _, lockID := range []string{"lock1", "lock2"} {
+		if _, err := c.Acquire(ctx, lockID, time.Minute); err != nil {
+			t.Fatalf("Acquire %s failed: %v", lockID, err)
+		}
+	}
+	lease, err := c.KeepAlive("lock1", time.Minute)
+	if err != nil {
+		t.Fatalf("KeepAlive failed: %v", err)
+	}
+
+	closeCtx, closeCancel := context.WithTimeout(ctx, time.Second)
+	defer closeCancel()
+	if err := c.Close(closeCtx); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	select {
+	case <-lease.done:
+	default:
+		t.Error("Expected Close to stop the keepalive")
+	}
+
+	// Another client gets both locks straight away instead of waiting out the TTL
+	other, err := Dial(ln.Addr().String(), [16]byte{})
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer other.Close(ctx)
+	for _, lockID := range []string{"lock1", "lock2"} {
+		if _, err := other.Acquire(ctx, lockID, time.Minute); err != nil {
+			t.Errorf("Acquire %s after Close failed: %v", lockID, err)
+		}
+	}
+}
+
+func TestHeldLocks(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.NewServer().Serve(ctx, ln)
+
+	c, err := Dial(ln.Addr().String(), [16]byte{})
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer c.Close(ctx)
+
+	if held := c.HeldLocks(); len(held) != 0 {
+		t.Fatalf("Expected no held locks, got %+v", held)
+	}
+
+	resps := make(map[string]*protocol.Response)
+	for _, lockID := range []string{"lock2", "lock1"} {
+		resp, err := c.Acquire(ctx, lockID, time.Minute)
+		if err != nil {
+			t.Fatalf("Acquire %s failed: %v", lockID, err)
+		}
+		resps[lockID] = resp
+	}
+
+	held := c.HeldLocks()
+	if len(held) != 2 || held[0].LockID != "lock1" || held[1].LockID != "lock2" {
+		t.Fatalf("Expected lock1 and lock2 in order, got %+v", held)
+	}
+	for _, h := range held {
+		resp := resps[h.LockID]
+		if h.FencingToken != resp.FencingToken || h.ExpiresAt != resp.ExpiresAt {
+			t.Errorf("Expected %s to carry token %d and expiry %d, got %+v", h.LockID, resp.FencingToken, resp.ExpiresAt, h)
+		}
+	}
+
+	if err := c.Release(ctx, "lock1"); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+	held = c.HeldLocks()
+	if len(held) != 1 || held[0].LockID != "lock2" {
+		t.Errorf("Expected only lock2 after release, got %+v", held)
+	}
+}
+
+func TestRenewAll(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.NewServer().Serve(ctx, ln)
+
+	c, err := Dial(ln.Addr().String(), [16]byte{})
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer c.Close(ctx)
+
+	for _, lockID := range []string{"lock1", "lock2"} {
+		if _, err := c.Acquire(ctx, lockID, time.Second); err != nil {
+			t.Fatalf("Acquire %s failed: %v", lockID, err)
+		}
+	}
+	before := c.HeldLocks()
+
+	// A stale token makes the server report lock2 as lost
+	c.setHeld("lock2", 999, 0)
+	results, err := c.RenewAll(ctx, time.Minute)
+	if err != nil {
+		t.Fatalf("RenewAll failed: %v", err)
+	}
+	if len(results) != 2 || results[0].Status != clutcherrors.STATUS_SUCCESS || results[1].Status != clutcherrors.STATUS_LOCK_NOT_HELD {
+		t.Fatalf("Expected lock1 renewed and lock2 lost, got %+v", results)
+	}
+
+	held := c.HeldLocks()
+	if len(held) != 1 || held[0].LockID != "lock1" || held[0].ExpiresAt <= before[0].ExpiresAt {
+		t.Errorf("Expected only lock1 held with a later expiry, got %+v", held)
+	}
+}
+
+func TestDialNegotiatesVersion(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.NewServer(server.WithProtocolVersions(1, 2)).Serve(ctx, ln)
+
+	c, err := DialVersion(ln.Addr().String(), [16]byte{}, 5)
+	if err != nil {
+		t.Fatalf("DialVersion failed: %v", err)
+	}
+	defer c.Close(ctx)
+	if c.Version() != 2 {
+		t.Errorf("Expected version 2, got %d", c.Version())
+	}
+	if _, err := c.Acquire(ctx, "lock1", time.Second); err != nil {
+		t.Errorf("Acquire after handshake failed: %v", err)
+	}
+
+	// No version in common
+	_, err = DialVersion(ln.Addr().String(), [16]byte{}, 0)
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.Status != clutcherrors.STATUS_UNSUPPORTED_VERSION {
+		t.Errorf("Expected STATUS_UNSUPPORTED_VERSION, got %v", err)
+	}
+}
+
+func TestDialFallsBackWithoutHandshake(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	defer ln.Close()
+
+	// An old server rejects HELLO as a malformed request and hangs up
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		if _, err := protocol.ReadRequest(conn); err != nil {
+			protocol.WriteResponse(conn, &protocol.Response{Status: clutcherrors.STATUS_INVALID_REQUEST})
+		}
+		conn.Close()
+
+		conn, err = ln.Accept()
+		if err != nil {
+			return
+		}
+		fakeServer(t, conn)
+	}()
+
+	c, err := Dial(ln.Addr().String(), [16]byte{})
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer c.Close(context.Background())
+	if c.Version() != 1 {
+		t.Errorf("Expected fallback to version 1, got %d", c.Version())
+	}
+	if _, err := c.Acquire(context.Background(), "lock1", time.Second); err != nil {
+		t.Errorf("Acquire after fallback failed: %v", err)
+	}
+}
+
+func TestStats(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.NewServer(server.WithCommitLog(wal.NewWALWithStorage(wal.NewMemoryStorage()))).Serve(ctx, ln)
+
+	c, err := Dial(ln.Addr().String(), [16]byte{})
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer c.Close(ctx)
+
+	for _, lockID := range []string{"lock1", "lock2"} {
+		if _, err := c.Acquire(ctx, lockID, time.Minute); err != nil {
+			t.Fatalf("Acquire %s failed: %v", lockID, err)
+		}
+	}
+	if _, err := c.Renew(ctx, "lock1", time.Minute); err != nil {
+		t.Fatalf("Renew failed: %v", err)
+	}
+	if err := c.Release(ctx, "lock2"); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+
+	stats, err := c.Stats(ctx)
+	if err != nil {
+		t.Fatalf("Stats failed: %v", err)
+	}
+	if stats.ActiveLocks != 1 || stats.Acquires != 2 || stats.Renews != 1 || stats.Releases != 1 {
+		t.Errorf("Expected 1 active lock after 2 acquires, 1 renew and 1 release, got %+v", stats)
+	}
+	if stats.WALBytes == 0 {
+		t.Error("Expected the commit log size to be reported")
+	}
+
+	// The connection stays usable afterwards
+	if _, err := c.Acquire(ctx, "lock2", time.Minute); err != nil {
+		t.Errorf("Acquire after Stats failed: %v", err)
+	}
+}
+
+func TestTombstones(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.NewServer(server.WithTombstones(10, time.Minute), server.WithAdminCommands()).Serve(ctx, ln)
+
+	c, err := Dial(ln.Addr().String(), [16]byte{1})
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer c.Close(ctx)
+
+	resp, err := c.Acquire(ctx, "lock1", time.Minute)
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	if err := c.Release(ctx, "lock1"); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+
+	tombstones, total, err := c.Tombstones(ctx)
+	if err != nil {
+		t.Fatalf("Tombstones failed: %v", err)
+	}
+	if total != 1 || len(tombstones) != 1 || tombstones[0].LockID != "lock1" || tombstones[0].FencingToken != resp.FencingToken {
+		t.Errorf("Expected a tombstone for lock1 with token %d, got %+v of %d", resp.FencingToken, tombstones, total)
+	}
+}
+
+func TestSharedSecret(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	secret := []byte("shared secret")
+	go server.NewServer(server.WithMiddleware(server.HMACAuth(secret))).Serve(ctx, ln)
+
+	c, err := Dial(ln.Addr().String(), [16]byte{}, WithSharedSecret(secret))
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer c.Close(ctx)
+	if _, err := c.Acquire(ctx, "lock1", time.Minute); err != nil {
+		t.Fatalf("Acquire with the secret failed: %v", err)
+	}
+
+	// Requests in their own frame layout are signed too
+	if results, err := c.RenewAll(ctx, time.Minute); err != nil || len(results) != 1 || results[0].Status != clutcherrors.STATUS_SUCCESS {
+		t.Fatalf("RenewAll with the secret failed: %v %+v", err, results)
+	}
+	if _, err := c.LockInfos(ctx, []string{"lock1"}); err != nil {
+		t.Fatalf("LockInfos with the secret failed: %v", err)
+	}
+	if _, err := c.ServerLimits(ctx); err != nil {
+		t.Fatalf("ServerLimits with the secret failed: %v", err)
+	}
+	if _, err := c.Stats(ctx); err != nil {
+		t.Fatalf("Stats with the secret failed: %v", err)
+	}
+	if _, _, err := c.ListOwners(ctx); err != nil {
+		t.Fatalf("ListOwners with the secret failed: %v", err)
+	}
+	if _, err := c.CountByPrefix(ctx, "lock"); err != nil {
+		t.Fatalf("CountByPrefix with the secret failed: %v", err)
+	}
+
+	for name, opts := range map[string][]Option{"no secret": nil, "wrong secret": {WithSharedSecret([]byte("guessed secret"))}} {
+		other, err := Dial(ln.Addr().String(), [16]byte{}, opts...)
+		if err != nil {
+			t.Fatalf("Dial failed: %v", err)
+		}
+		_, err = other.Acquire(ctx, "lock2", time.Minute)
+		var statusErr *StatusError
+		if !errors.As(err, &statusErr) || statusErr.Status != clutcherrors.STATUS_FORBIDDEN {
+			t.Errorf("Expected acquire with %s to be forbidden, got %v", name, err)
+		}
+		_, err = other.Stats(ctx)
+		if !errors.As(err, &statusErr) || statusErr.Status != clutcherrors.STATUS_FORBIDDEN {
+			t.Errorf("Expected stats with %s to be forbidden, got %v", name, err)
+		}
+		other.Close(ctx)
+	}
+}
+
+func TestAcquireAdoptsSelfHeldLock(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.NewServer(server.WithSelfHeldStatus()).Serve(ctx, ln)
+
+	// Two connections sharing an owner stand in for a client whose acquire response was lost
+	owner := NewOwnerID()
+	first, err := Dial(ln.Addr().String(), owner)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer first.Close(ctx)
+	retry, err := Dial(ln.Addr().String(), owner)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer retry.Close(ctx)
+
+	granted, err := first.Acquire(ctx, "lock1", time.Minute)
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	_, err = retry.Acquire(ctx, "lock1", time.Minute)
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.Status != clutcherrors.STATUS_HELD_BY_CALLER {
+		t.Fatalf("Expected STATUS_HELD_BY_CALLER, got %v", err)
+	}
+	if statusErr.FencingToken != granted.FencingToken {
+		t.Errorf("Expected the error to carry token %d, got %d", granted.FencingToken, statusErr.FencingToken)
+	}
+	if token, ok := retry.Token("lock1"); !ok || token != granted.FencingToken {
+		t.Errorf("Expected the client to adopt token %d, got %d (ok=%v)", granted.FencingToken, token, ok)
+	}
+	if _, err := retry.Renew(ctx, "lock1", time.Minute); err != nil {
+		t.Errorf("Renew of the adopted hold failed: %v", err)
+	}
+}
+
+func TestReleaseByPrefix(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.NewServer().Serve(ctx, ln)
+
+	c, err := Dial(ln.Addr().String(), NewOwnerID())
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer c.Close(ctx)
+
+	for _, lockID := range []string{"t/42/a", "t/42/b", "t/420/a"} {
+		if _, err := c.Acquire(ctx, lockID, time.Minute); err != nil {
+			t.Fatalf("Acquire failed: %v", err)
+		}
+	}
+	if n, err := c.CountByPrefix(ctx, "t/42/"); err != nil || n != 2 {
+		t.Fatalf("Expected 2 locks under t/42/, got %d (%v)", n, err)
+	}
+	if n, err := c.ReleaseByPrefix(ctx, "t/42/"); err != nil || n != 2 {
+		t.Fatalf("Expected 2 locks released, got %d (%v)", n, err)
+	}
+	if _, ok := c.Token("t/42/a"); ok {
+		t.Errorf("Expected the released lock's token to be forgotten")
+	}
+	if _, ok := c.Token("t/420/a"); !ok {
+		t.Errorf("Expected t/420/a still held")
+	}
+	if n, err := c.CountByPrefix(ctx, "t/"); err != nil || n != 1 {
+		t.Errorf("Expected 1 lock left, got %d (%v)", n, err)
+	}
+}
+
+// steppedClock is a server clock that only moves when told to
+type steppedClock struct {
+	now atomic.Uint64
+}
+
+func (c *steppedClock) NowMillis() uint64 {
+	return c.now.Load()
+}
+
+func TestCheckAcquire(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	clock := &steppedClock{}
+	clock.now.Store(uint64(time.Now().UnixMilli()))
+	s := server.NewServer(server.WithClock(clock))
+	// The reaper would drop the lapsed hold, leaving nothing to tell it from a released one
+	s.PauseReaper()
+	go s.Serve(ctx, ln)
+
+	crashed, err := Dial(ln.Addr().String(), NewOwnerID())
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer crashed.Close(ctx)
+	c, err := Dial(ln.Addr().String(), NewOwnerID())
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer c.Close(ctx)
+
+	// A free lock is a plain grant
+	first, reclaimed, err := crashed.CheckAcquire(ctx, "lock1", time.Second)
+	if err != nil {
+		t.Fatalf("CheckAcquire failed: %v", err)
+	}
+	if reclaimed || first.Status != clutcherrors.STATUS_SUCCESS {
+		t.Fatalf("Expected a fresh grant, got status %d (reclaimed=%v)", first.Status, reclaimed)
+	}
+
+	// The holder goes quiet until its hold lapses, so the next grant reclaims it
+	clock.now.Add(2000)
+	resp, reclaimed, err := c.CheckAcquire(ctx, "lock1", time.Second)
+	if err != nil {
+		t.Fatalf("CheckAcquire failed: %v", err)
+	}
+	if !reclaimed || resp.Status != clutcherrors.STATUS_ACQUIRED_RECLAIMED {
+		t.Fatalf("Expected a reclaimed grant, got status %d (reclaimed=%v)", resp.Status, reclaimed)
+	}
+	if token, ok := c.Token("lock1"); !ok || token != resp.FencingToken || token <= first.FencingToken {
+		t.Errorf("Expected the client to hold token %d above %d, got %d (ok=%v)", resp.FencingToken, first.FencingToken, token, ok)
+	}
+	if _, _, ok := c.ClockSkew(); !ok {
+		t.Error("Expected the reclaimed grant to feed the clock estimate")
+	}
+}
+
+func TestCompareAndRenew(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	clock := &steppedClock{}
+	clock.now.Store(uint64(time.Now().UnixMilli()))
+	go server.NewServer(server.WithClock(clock)).Serve(ctx, ln)
+
+	c, err := Dial(ln.Addr().String(), NewOwnerID())
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer c.Close(ctx)
+	acquired, err := c.Acquire(ctx, "lock1", 10*time.Second)
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+
+	// Most of the hold is left, so it is kept as it is
+	resp, renewed, err := c.CompareAndRenew(ctx, "lock1", 10*time.Second, 2*time.Second)
+	if err != nil {
+		t.Fatalf("CompareAndRenew failed: %v", err)
+	}
+	if renewed || resp.Status != clutcherrors.STATUS_RENEW_NOT_NEEDED || resp.ExpiresAt != acquired.ExpiresAt {
+		t.Fatalf("Expected the hold left alone until %d, got status %d until %d (renewed=%v)", acquired.ExpiresAt, resp.Status, resp.ExpiresAt, renewed)
+	}
+
+	// Under the threshold it is renewed
+	clock.now.Add(9000)
+	resp, renewed, err = c.CompareAndRenew(ctx, "lock1", 10*time.Second, 2*time.Second)
+	if err != nil {
+		t.Fatalf("CompareAndRenew failed: %v", err)
+	}
+	if !renewed || resp.Status != clutcherrors.STATUS_SUCCESS || resp.ExpiresAt <= acquired.ExpiresAt {
+		t.Fatalf("Expected the hold extended past %d, got status %d until %d (renewed=%v)", acquired.ExpiresAt, resp.Status, resp.ExpiresAt, renewed)
+	}
+	if token, ok := c.Token("lock1"); !ok || token != acquired.FencingToken {
+		t.Errorf("Expected the client to keep token %d, got %d (ok=%v)", acquired.FencingToken, token, ok)
+	}
+}
+
+func TestAcquireWithValueAndWaitFree(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.NewServer().Serve(ctx, ln)
+
+	holder, err := Dial(ln.Addr().String(), NewOwnerID())
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer holder.Close(ctx)
+	watcher, err := Dial(ln.Addr().String(), NewOwnerID())
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer watcher.Close(ctx)
+
+	if _, err := holder.AcquireWithValue(ctx, "leader", time.Minute, "10.0.0.7:7000"); err != nil {
+		t.Fatalf("AcquireWithValue failed: %v", err)
+	}
+	infos, err := watcher.LockInfos(ctx, []string{"leader"})
+	if err != nil {
+		t.Fatalf("LockInfos failed: %v", err)
+	}
+	if len(infos) != 1 || infos[0].Value != "10.0.0.7:7000" {
+		t.Errorf("Expected value %q, got %+v", "10.0.0.7:7000", infos)
+	}
+	if _, err := holder.AcquireWithValue(ctx, "other", time.Minute, strings.Repeat("x", 17)); err == nil {
+		t.Error("Expected a value over 16 bytes to be refused")
+	}
+
+	// Still held when the wait runs out
+	var statusErr *StatusError
+	if err := watcher.WaitFree(ctx, "leader", 20*time.Millisecond); !errors.As(err, &statusErr) || statusErr.Status != clutcherrors.STATUS_LOCK_HELD {
+		t.Fatalf("Expected STATUS_LOCK_HELD, got %v", err)
+	}
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		holder.Release(ctx, "leader")
+	}()
+	if err := watcher.WaitFree(ctx, "leader", 0); err != nil {
+		t.Fatalf("WaitFree failed: %v", err)
+	}
+	if _, err := watcher.Acquire(ctx, "leader", time.Minute); err != nil {
+		t.Errorf("Expected the freed lock to be acquirable, got %v", err)
+	}
+}
@@ -0,0 +1,238 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mrdhat/clutchdb/clutcherrors"
+)
+
+// Leadership is a running campaign for a leader key. Changes reports true when the client
+// becomes leader and false when it loses leadership; it is closed when the campaign ends.
+type Leadership struct {
+	client *Client
+	key    string
+	ttl    time.Duration
+	value  string
+	retry  time.Duration // longest single wait for the key to free, and the pause after a failed attempt
+
+	changes  chan bool
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+
+	mu     sync.Mutex
+	leader bool
+	lease  *Lease
+	err    error
+}
+
+// CampaignForLeadership campaigns for key in the background: it acquires it with value attached,
+// typically the client's address so the others can find the leader, and keeps it alive once won.
+// While someone else leads it waits for the key to free rather than polling for it, and it
+// campaigns again whenever its own lease is lost. Waits take up the client's connection ttl/3 at a
+// time. The campaign runs until ctx is done, Resign is called or the key can't be kept alive; see
+// Err.
+func (c *Client) CampaignForLeadership(ctx context.Context, key string, ttl time.Duration, value string) (*Leadership, error) {
+	retry, err := keepAliveInterval(ttl)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Leadership{
+		client:  c,
+		key:     key,
+		ttl:     ttl,
+		value:   value,
+		retry:   retry,
+		changes: make(chan bool, 1),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go l.run(ctx)
+	return l, nil
+}
+
+// Changes returns the channel leadership transitions are reported on
+func (l *Leadership) Changes() <-chan bool {
+	return l.changes
+}
+
+// IsLeader reports whether the client currently holds the leader key
+func (l *Leadership) IsLeader() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.leader
+}
+
+// Leader returns the value the current leader campaigned with, and false if nobody leads
+func (l *Leadership) Leader(ctx context.Context) (string, bool, error) {
+	infos, err := l.client.LockInfos(ctx, []string{l.key})
+	if err != nil {
+		return "", false, err
+	}
+	if len(infos) != 1 || infos[0].FencingToken == 0 {
+		return "", false, nil
+	}
+	return infos[0].Value, true, nil
+}
+
+// Err returns why the campaign gave up on its own, once Changes is closed. It is nil for a
+// campaign ended by Resign or ctx.
+func (l *Leadership) Err() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.err
+}
+
+// Resign ends the campaign and releases the leader key if the client holds it
+func (l *Leadership) Resign(ctx context.Context) error {
+	l.stopOnce.Do(func() { close(l.stop) })
+	<-l.done
+
+	l.mu.Lock()
+	leader, lease := l.leader, l.lease
+	l.leader, l.lease = false, nil
+	l.mu.Unlock()
+
+	if !leader {
+		return nil
+	}
+	lease.Stop()
+	return l.client.Release(ctx, l.key)
+}
+
+func (l *Leadership) run(ctx context.Context) {
+	defer close(l.done)
+	defer close(l.changes)
+	defer l.abandon()
+
+	for {
+		if !l.acquire(ctx) {
+			return
+		}
+
+		lost := make(chan struct{})
+		lease, err := l.client.KeepAlive(l.key, l.ttl)
+		if err != nil {
+			// Won but can't be kept, as when the client is closing: hand the key back rather than
+			// hold it unrenewed, and stop, since another attempt would fail the same way
+			err = errors.Join(err, l.client.Release(ctx, l.key))
+			l.mu.Lock()
+			l.err = fmt.Errorf("failed to keep leader key alive: %w", err)
+			l.mu.Unlock()
+			return
+		}
+		lease.OnExpire(func() { close(lost) })
+
+		l.setLeader(true, lease)
+		if !l.report(ctx, true) {
+			return
+		}
+
+		select {
+		case <-lost:
+			l.setLeader(false, nil)
+			if !l.report(ctx, false) {
+				return
+			}
+		case <-l.stop:
+			// Resign releases the key
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// acquire campaigns until the client holds the key, reporting false if the campaign ends first
+func (l *Leadership) acquire(ctx context.Context) bool {
+	for {
+		_, err := l.client.AcquireWithValue(ctx, l.key, l.ttl, l.value)
+		var statusErr *StatusError
+		if err == nil || errors.As(err, &statusErr) && statusErr.Status == clutcherrors.STATUS_HELD_BY_CALLER {
+			// Held by us already, as when the answer to an earlier attempt was lost
+			return true
+		}
+
+		if statusErr != nil && statusErr.Status == clutcherrors.STATUS_LOCK_HELD {
+			// Someone else leads: wait for the key to free, then race for it again
+			if err := l.client.WaitFree(ctx, l.key, l.retry); err == nil || errors.As(err, &statusErr) {
+				if l.stopped(ctx) {
+					return false
+				}
+				continue
+			}
+		}
+		if !l.sleep(ctx, l.retry) {
+			return false
+		}
+	}
+}
+
+// abandon stops keeping the key alive when the campaign ends without Resign. The key is
+// left to expire rather than released, since ctx is already done.
+func (l *Leadership) abandon() {
+	select {
+	case <-l.stop:
+		// Resign stops the lease and releases the key
+		return
+	default:
+	}
+
+	l.mu.Lock()
+	lease := l.lease
+	l.leader, l.lease = false, nil
+	l.mu.Unlock()
+
+	if lease != nil {
+		lease.Stop()
+	}
+}
+
+func (l *Leadership) setLeader(leader bool, lease *Lease) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.leader = leader
+	l.lease = lease
+}
+
+// report delivers a transition on Changes, giving up if the campaign is stopped
+func (l *Leadership) report(ctx context.Context, leader bool) bool {
+	select {
+	case l.changes <- leader:
+		return true
+	case <-l.stop:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// stopped reports whether the campaign has been stopped or ctx is done
+func (l *Leadership) stopped(ctx context.Context) bool {
+	select {
+	case <-l.stop:
+		return true
+	case <-ctx.Done():
+		return true
+	default:
+		return false
+	}
+}
+
+func (l *Leadership) sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-l.stop:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,149 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mrdhat/clutchdb/server"
+)
+
+func TestCampaignForLeadership(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.NewServer().Serve(ctx, ln)
+
+	type change struct {
+		candidate int
+		leader    bool
+	}
+	changes := make(chan change, 16)
+	campaigns := make([]*Leadership, 3)
+
+	for i := range campaigns {
+		c, err := Dial(ln.Addr().String(), NewOwnerID())
+		if err != nil {
+			t.Fatalf("Dial failed: %v", err)
+		}
+		defer c.Close(context.Background())
+
+		campaigns[i], err = c.CampaignForLeadership(ctx, "election", 300*time.Millisecond, fmt.Sprintf("candidate-%d", i))
+		if err != nil {
+			t.Fatalf("CampaignForLeadership failed: %v", err)
+		}
+		go func(i int, l *Leadership) {
+			for leader := range l.Changes() {
+				changes <- change{candidate: i, leader: leader}
+			}
+		}(i, campaigns[i])
+	}
+
+	waitForLeader := func() int {
+		t.Helper()
+		select {
+		case ch := <-changes:
+			if !ch.leader {
+				t.Fatalf("Candidate %d lost leadership it never reported winning", ch.candidate)
+			}
+			return ch.candidate
+		case <-time.After(2 * time.Second):
+			t.Fatal("Timed out waiting for a leader")
+			return -1
+		}
+	}
+
+	first := waitForLeader()
+	if !campaigns[first].IsLeader() {
+		t.Errorf("Expected candidate %d to report itself as leader", first)
+	}
+	for i, l := range campaigns {
+		value, ok, err := l.Leader(ctx)
+		if err != nil {
+			t.Fatalf("Leader failed: %v", err)
+		}
+		if want := fmt.Sprintf("candidate-%d", first); !ok || value != want {
+			t.Errorf("Expected candidate %d to see leader %q, got %q (ok=%v)", i, want, value, ok)
+		}
+	}
+
+	// The leader keeps the key alive well past its ttl; nobody else wins in the meantime
+	select {
+	case ch := <-changes:
+		t.Fatalf("Unexpected leadership change while leader was healthy: %+v", ch)
+	case <-time.After(600 * time.Millisecond):
+	}
+
+	if err := campaigns[first].Resign(ctx); err != nil {
+		t.Fatalf("Resign failed: %v", err)
+	}
+	if campaigns[first].IsLeader() {
+		t.Error("Expected resigned candidate not to be leader")
+	}
+
+	// The others were waiting on the key, so one takes over as soon as it is released rather
+	// than on its next poll
+	resigned := time.Now()
+	second := waitForLeader()
+	if second == first {
+		t.Errorf("Expected a different candidate to take over from %d", first)
+	}
+	if elapsed := time.Since(resigned); elapsed > 50*time.Millisecond {
+		t.Errorf("Expected a takeover right after the release, took %v", elapsed)
+	}
+
+	for i, l := range campaigns {
+		if i != first {
+			if err := l.Resign(ctx); err != nil {
+				t.Errorf("Resign of candidate %d failed: %v", i, err)
+			}
+		}
+	}
+}
+
+func TestCampaignReleasesKeyItCannotKeepAlive(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	srv := server.NewServer()
+	go srv.Serve(ctx, ln)
+
+	c, err := Dial(ln.Addr().String(), NewOwnerID())
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer c.Close(context.Background())
+
+	// Requests still go through, but no lease can be started
+	c.leasesMu.Lock()
+	c.closed = true
+	c.leasesMu.Unlock()
+
+	l, err := c.CampaignForLeadership(ctx, "election", time.Minute, "candidate")
+	if err != nil {
+		t.Fatalf("CampaignForLeadership failed: %v", err)
+	}
+	select {
+	case leader, ok := <-l.Changes():
+		if ok {
+			t.Fatalf("Expected the campaign to end without a change, got %v", leader)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for the campaign to end")
+	}
+	if err := l.Err(); err == nil || !strings.Contains(err.Error(), "keep leader key alive") {
+		t.Errorf("Expected the keepalive failure, got %v", err)
+	}
+	if _, ok := srv.LockInfo("election"); ok {
+		t.Error("Expected the key to be released rather than left to expire")
+	}
+}
@@ -0,0 +1,140 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Lease keeps a held lock renewed in the background until it is stopped or lost
+type Lease struct {
+	client *Client
+	lockID string
+	ttl    time.Duration
+	every  time.Duration
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+
+	mu       sync.Mutex
+	expired  bool
+	onExpire []func()
+}
+
+// KeepAlive renews lockID, which the client must already hold, every ttl/3 until
+// Stop is called or the lock is lost. Renewals come sooner when the server's clock says the
+// lock will run out earlier than the local one does; see RenewAt.
+func (c *Client) KeepAlive(lockID string, ttl time.Duration) (*Lease, error) {
+	if _, ok := c.Token(lockID); !ok {
+		return nil, errors.New("lock not held by client")
+	}
+	interval, err := keepAliveInterval(ttl)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Lease{
+		client: c,
+		lockID: lockID,
+		ttl:    ttl,
+		every:  interval,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+
+	c.leasesMu.Lock()
+	defer c.leasesMu.Unlock()
+	if c.closed {
+		return nil, errors.New("client closed")
+	}
+	c.leases[l] = struct{}{}
+	go l.run()
+	return l, nil
+}
+
+// keepAliveInterval returns how often a lease with ttl is renewed
+func keepAliveInterval(ttl time.Duration) (time.Duration, error) {
+	if ttl < 3*time.Millisecond {
+		return 0, errors.New("ttl too short to keep alive")
+	}
+	return ttl / 3, nil
+}
+
+// OnExpire registers fn to run once if the lease is lost, either because the server
+// reported the hold gone or because renewals kept failing until the lease ran out.
+// fn runs immediately if the lease has already been lost. It is not run after Stop.
+func (l *Lease) OnExpire(fn func()) {
+	l.mu.Lock()
+	if !l.expired {
+		l.onExpire = append(l.onExpire, fn)
+		l.mu.Unlock()
+		return
+	}
+	l.mu.Unlock()
+	fn()
+}
+
+// Stop stops renewing the lease. It does not release the lock.
+func (l *Lease) Stop() {
+	l.stopOnce.Do(func() { close(l.stop) })
+	<-l.done
+}
+
+func (l *Lease) run() {
+	defer close(l.done)
+	defer l.client.forgetLease(l)
+
+	timer := time.NewTimer(l.every)
+	defer timer.Stop()
+
+	deadline := time.Now().Add(l.ttl)
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-timer.C:
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), l.every)
+		_, err := l.client.Renew(ctx, l.lockID, l.ttl)
+		cancel()
+
+		// A failed renewal, including one the server turned away as rate limited, overloaded or
+		// not its leader, is retried after the regular interval
+		wait := l.every
+		switch {
+		case err == nil:
+			deadline = time.Now().Add(l.ttl)
+			wait = l.nextRenewal()
+		case lostHold(err), time.Now().After(deadline):
+			// The server says we lost it, or we couldn't reach it before it ran out
+			l.expire()
+			return
+		}
+		timer.Reset(wait)
+	}
+}
+
+// nextRenewal returns how long to wait before the next renewal: the regular interval, or less
+// if RenewAt says the lock runs out sooner by the server's clock
+func (l *Lease) nextRenewal() time.Duration {
+	at, ok := l.client.RenewAt(l.lockID, l.ttl)
+	if !ok {
+		return l.every
+	}
+	return max(min(time.Until(at), l.every), 0)
+}
+
+func (l *Lease) expire() {
+	l.mu.Lock()
+	l.expired = true
+	fns := l.onExpire
+	l.onExpire = nil
+	l.mu.Unlock()
+
+	for _, fn := range fns {
+		fn()
+	}
+}
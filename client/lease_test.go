@@ -0,0 +1,138 @@
+package client
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mrdhat/clutchdb/clutcherrors"
+	"github.com/mrdhat/clutchdb/protocol"
+)
+
+func TestLeaseOnExpireFiresWhenRenewRejected(t *testing.T) {
+	c := newTestClient(t)
+
+	if _, err := c.Acquire(context.Background(), "lock1", time.Second); err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+
+	lease, err := c.KeepAlive("lock1", 30*time.Millisecond)
+	if err != nil {
+		t.Fatalf("KeepAlive failed: %v", err)
+	}
+	defer lease.Stop()
+
+	var fired int32
+	done := make(chan struct{})
+	lease.OnExpire(func() {
+		if atomic.AddInt32(&fired, 1) == 1 {
+			close(done)
+		}
+	})
+
+	// Make the server reject the next renewal as not held
+	c.setHeld("lock1", 999, 0)
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected OnExpire to fire after rejected renew")
+	}
+
+	// Registering after the loss runs immediately
+	late := make(chan struct{})
+	lease.OnExpire(func() { close(late) })
+	select {
+	case <-late:
+	default:
+		t.Error("Expected OnExpire registered after loss to run immediately")
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if n := atomic.LoadInt32(&fired); n != 1 {
+		t.Errorf("Expected callback to fire once, fired %d times", n)
+	}
+}
+
+func TestLeaseOnExpireNotFiredOnStop(t *testing.T) {
+	c := newTestClient(t)
+	ctx := context.Background()
+
+	if _, err := c.Acquire(ctx, "lock1", time.Second); err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+
+	lease, err := c.KeepAlive("lock1", 30*time.Millisecond)
+	if err != nil {
+		t.Fatalf("KeepAlive failed: %v", err)
+	}
+
+	var fired int32
+	lease.OnExpire(func() { atomic.AddInt32(&fired, 1) })
+
+	// Let a few renewals go through
+	time.Sleep(50 * time.Millisecond)
+	lease.Stop()
+
+	if err := c.Release(ctx, "lock1"); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+	time.Sleep(30 * time.Millisecond)
+
+	if n := atomic.LoadInt32(&fired); n != 0 {
+		t.Errorf("Expected no callback after clean stop and release, fired %d times", n)
+	}
+}
+
+func TestLeaseSurvivesTransientRenewFailures(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer serverConn.Close()
+
+	// The server turns away the first renewals with statuses that say nothing about the hold
+	var renews atomic.Int32
+	go func() {
+		transient := []clutcherrors.StatusCode{clutcherrors.STATUS_RATE_LIMITED, clutcherrors.STATUS_OVERLOADED}
+		for {
+			req, err := protocol.ReadRequest(serverConn)
+			if err != nil {
+				return
+			}
+			resp := &protocol.Response{Status: clutcherrors.STATUS_SUCCESS, FencingToken: 1}
+			if req.Cmd == protocol.RENEW {
+				if n := int(renews.Add(1)); n <= len(transient) {
+					resp.Status = transient[n-1]
+				}
+			}
+			if err := protocol.WriteResponse(serverConn, resp); err != nil {
+				return
+			}
+		}
+	}()
+
+	c := New(clientConn, uuid.New())
+	if _, err := c.Acquire(context.Background(), "lock1", time.Second); err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	lease, err := c.KeepAlive("lock1", 90*time.Millisecond)
+	if err != nil {
+		t.Fatalf("KeepAlive failed: %v", err)
+	}
+	defer lease.Stop()
+
+	var fired atomic.Int32
+	lease.OnExpire(func() { fired.Add(1) })
+
+	time.Sleep(200 * time.Millisecond)
+	if n := fired.Load(); n != 0 {
+		t.Errorf("Expected the lease to survive throttled renewals, expired %d times", n)
+	}
+	if n := renews.Load(); n <= 2 {
+		t.Errorf("Expected renewals to be retried, got %d", n)
+	}
+	if _, ok := c.Token("lock1"); !ok {
+		t.Error("Expected the client to still hold lock1")
+	}
+}
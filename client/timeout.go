@@ -0,0 +1,103 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// DefaultRequestTimeout bounds a request whose context has no deadline, unless changed with
+// WithRequestTimeout. It doesn't apply to AcquireWait, whose wait has no natural bound.
+const DefaultRequestTimeout = 30 * time.Second
+
+// ErrConnBroken is returned without contacting the server once a request on the client's
+// connection has timed out. The connection may still deliver the late response, which would be
+// read as the answer to the next request, so the client must be closed and a new one dialed.
+var ErrConnBroken = errors.New("connection broken by an earlier timeout")
+
+// WithRequestTimeout bounds each request whose context has no deadline by timeout instead of
+// DefaultRequestTimeout. Zero leaves such requests unbounded. AcquireWait is never bounded by it.
+func WithRequestTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		c.requestTimeout = timeout
+	}
+}
+
+// Broken reports whether a timed out request has left the connection unusable, so a caller
+// keeping clients around knows to drop this one. Every request on it fails with ErrConnBroken.
+// It doesn't wait for a request in flight, such as a queued AcquireWait.
+func (c *Client) Broken() bool {
+	return c.broken.Load()
+}
+
+// roundTrip sends one request and reads its answer on the connection, holding it throughout:
+// write encodes the request to it and read decodes the response from it. It applies the
+// request deadline and the circuit breaker, and wraps write and read failures.
+func (c *Client) roundTrip(ctx context.Context, write func(w io.Writer) error, read func(r io.Reader) error) error {
+	return c.roundTripWithin(ctx, c.requestTimeout, write, read)
+}
+
+// roundTripWithin is roundTrip bounding a request whose ctx has no deadline by timeout instead of
+// the request timeout, zero for no bound
+func (c *Client) roundTripWithin(ctx context.Context, timeout time.Duration, write func(w io.Writer) error, read func(r io.Reader) error) error {
+	if err := c.lockConn(ctx, timeout); err != nil {
+		return err
+	}
+	if err := c.breaker.allow(); err != nil {
+		c.unlockConn(nil)
+		return err
+	}
+	if err := write(c.conn); err != nil {
+		c.unlockConn(err)
+		c.breaker.record(false)
+		return fmt.Errorf("failed to write request: %w", err)
+	}
+	err := read(c.conn)
+	c.unlockConn(err)
+	c.breaker.record(err == nil)
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+	return nil
+}
+
+// lockConn takes connMu for one request, with conn's deadline set to ctx's, or timeout from now
+// if ctx has none. Cancelling ctx cuts the request short as if the deadline had passed. Every
+// successful lockConn must be followed by unlockConn.
+func (c *Client) lockConn(ctx context.Context, timeout time.Duration) error {
+	c.connMu.Lock()
+	if c.broken.Load() {
+		c.connMu.Unlock()
+		return ErrConnBroken
+	}
+	if err := ctx.Err(); err != nil {
+		// Don't let a request that never started break the connection
+		c.connMu.Unlock()
+		return err
+	}
+
+	deadline, ok := ctx.Deadline()
+	if !ok && timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	c.conn.SetDeadline(deadline)
+	c.stopCancel = context.AfterFunc(ctx, func() {
+		c.conn.SetDeadline(time.Unix(1, 0))
+	})
+	return nil
+}
+
+// unlockConn releases connMu after a request that failed with err, nil if it succeeded. A request
+// that timed out, or whose cancellation raced with it, breaks the connection.
+func (c *Client) unlockConn(err error) {
+	// A cancellation that already fired may still be setting the deadline, too late to undo
+	cancelled := !c.stopCancel()
+	var netErr net.Error
+	if cancelled || errors.As(err, &netErr) && netErr.Timeout() {
+		c.broken.Store(true)
+	}
+	c.connMu.Unlock()
+}
@@ -0,0 +1,174 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mrdhat/clutchdb/server"
+)
+
+// silentServer reads whatever is sent on conn and never replies
+func silentServer(conn net.Conn) {
+	go io.Copy(io.Discard, conn)
+}
+
+func TestRequestTimeoutBreaksConnection(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer serverConn.Close()
+	silentServer(serverConn)
+
+	c := New(clientConn, uuid.New(), WithRequestTimeout(50*time.Millisecond))
+	ctx := context.Background()
+
+	start := time.Now()
+	_, err := c.Acquire(ctx, "lock1", time.Minute)
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("Expected a timeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected the timeout after about 50ms, took %v", elapsed)
+	}
+	if !c.Broken() {
+		t.Fatal("Expected the timeout to break the connection")
+	}
+
+	// The late response could still arrive, so the connection is never used again
+	if _, err := c.Acquire(ctx, "lock1", time.Minute); !errors.Is(err, ErrConnBroken) {
+		t.Errorf("Expected ErrConnBroken, got %v", err)
+	}
+	if _, err := c.Stats(ctx); !errors.Is(err, ErrConnBroken) {
+		t.Errorf("Expected ErrConnBroken, got %v", err)
+	}
+}
+
+func TestBrokenDoesNotWaitForRequest(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer serverConn.Close()
+	silentServer(serverConn)
+
+	c := New(clientConn, uuid.New(), WithRequestTimeout(0))
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.Acquire(ctx, "lock1", time.Minute)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	// A health check answers while the request waits on the server
+	time.Sleep(20 * time.Millisecond)
+	checked := make(chan bool, 1)
+	go func() { checked <- c.Broken() }()
+	select {
+	case broken := <-checked:
+		if broken {
+			t.Error("Expected the connection not to be broken yet")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected Broken not to wait for the request in flight")
+	}
+}
+
+func TestContextDeadlineBoundsRequest(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer serverConn.Close()
+	silentServer(serverConn)
+
+	// The context's deadline wins over the client's request timeout
+	c := New(clientConn, uuid.New(), WithRequestTimeout(time.Minute))
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if _, err := c.ServerLimits(ctx); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("Expected a timeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected the timeout after about 50ms, took %v", elapsed)
+	}
+	if !c.Broken() {
+		t.Error("Expected the timeout to break the connection")
+	}
+}
+
+func TestCancelInterruptsRequest(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer serverConn.Close()
+	silentServer(serverConn)
+
+	c := New(clientConn, uuid.New(), WithRequestTimeout(0))
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	if _, err := c.Acquire(ctx, "lock1", time.Minute); err == nil {
+		t.Fatal("Expected the cancelled request to fail")
+	}
+	if !c.Broken() {
+		t.Error("Expected the cancellation to break the connection")
+	}
+}
+
+func TestCancelledContextLeavesConnection(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer serverConn.Close()
+	fakeServer(t, serverConn)
+
+	c := New(clientConn, uuid.New())
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := c.Acquire(ctx, "lock1", time.Minute); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+	if c.Broken() {
+		t.Fatal("Expected a request that never started to leave the connection usable")
+	}
+	if _, err := c.Acquire(context.Background(), "lock1", time.Minute); err != nil {
+		t.Errorf("Acquire failed: %v", err)
+	}
+}
+
+func TestAcquireWaitOutlastsRequestTimeout(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.NewServer().Serve(ctx, ln)
+
+	holder, err := Dial(ln.Addr().String(), NewOwnerID())
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer holder.Close(ctx)
+	if _, err := holder.Acquire(ctx, "lock1", time.Minute); err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+
+	waiter, err := Dial(ln.Addr().String(), NewOwnerID(), WithRequestTimeout(50*time.Millisecond))
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer waiter.Close(ctx)
+	time.AfterFunc(200*time.Millisecond, func() { holder.Release(ctx, "lock1") })
+
+	// The wait runs past the request timeout, which is meant for requests that answer at once
+	if _, err := waiter.AcquireWait(ctx, "lock1", time.Minute, 1); err != nil {
+		t.Fatalf("AcquireWait failed: %v", err)
+	}
+	if waiter.Broken() {
+		t.Error("Expected the wait to leave the connection usable")
+	}
+	if _, ok := waiter.Token("lock1"); !ok {
+		t.Error("Expected the waiter to hold the lock")
+	}
+}
@@ -0,0 +1,77 @@
+package client
+
+import (
+	"time"
+
+	"github.com/mrdhat/clutchdb/protocol"
+)
+
+// clockEstimate is what the client has learned about the server's clock from its responses
+type clockEstimate struct {
+	skew    time.Duration // server clock minus local clock
+	rtt     time.Duration // round-trip time of a request
+	samples int
+}
+
+// startsHold reports whether a successful cmd answers with an ExpiresAt of its commit time plus
+// the requested TTL. ACQUIRE_WAIT does too, but its time queued would count as round trip.
+func startsHold(cmd uint8) bool {
+	switch cmd {
+	case protocol.ACQUIRE, protocol.CHECK_ACQUIRE, protocol.RENEW, protocol.COMPARE_AND_RENEW, protocol.BUMP:
+		return true
+	default:
+		return false
+	}
+}
+
+// observeTiming folds one exchange into the clock estimate. The server stamped expiresAt as its
+// commit time plus ttlMS, and committed somewhere between sent and received; taking the midpoint
+// puts the skew estimate within half a round trip of the truth.
+func (c *Client) observeTiming(sent time.Time, received time.Time, expiresAt uint64, ttlMS uint64) {
+	if expiresAt < ttlMS {
+		return
+	}
+	rtt := received.Sub(sent)
+	committed := time.UnixMilli(int64(expiresAt - ttlMS))
+	skew := committed.Sub(sent.Add(rtt / 2))
+
+	c.clockMu.Lock()
+	defer c.clockMu.Unlock()
+	if c.clock.samples == 0 {
+		c.clock.skew = skew
+		c.clock.rtt = rtt
+	} else {
+		// Smooth like TCP's RTT estimator, so one delayed response doesn't swing the schedule
+		c.clock.skew += (skew - c.clock.skew) / 8
+		c.clock.rtt += (rtt - c.clock.rtt) / 8
+	}
+	c.clock.samples++
+}
+
+// ClockSkew returns how far the server's clock is estimated to run ahead of the local one, and
+// the round-trip time to it, learned from acquires, renewals and bumps. ok is false until one
+// of those has succeeded.
+func (c *Client) ClockSkew() (skew time.Duration, rtt time.Duration, ok bool) {
+	c.clockMu.Lock()
+	defer c.clockMu.Unlock()
+	return c.clock.skew, c.clock.rtt, c.clock.samples > 0
+}
+
+// RenewAt returns the local time by which lockID, held with ttl, should next be renewed: once a
+// third of its ttl has run on the server, as KeepAlive renews, leaving two thirds in hand, and
+// brought forward by a round trip for the renewal to arrive and half of one for the error in the
+// skew estimate. It is computed from the expiry
+// the server reported, translated to the local clock, so the schedule holds however far the two
+// clocks disagree. ok is false if the client doesn't hold lockID.
+func (c *Client) RenewAt(lockID string, ttl time.Duration) (time.Time, bool) {
+	c.tokensMu.Lock()
+	held, ok := c.tokens[lockID]
+	c.tokensMu.Unlock()
+	if !ok || held.ExpiresAt == 0 {
+		return time.Time{}, false
+	}
+
+	skew, rtt, _ := c.ClockSkew()
+	expires := time.UnixMilli(int64(held.ExpiresAt)).Add(-skew)
+	return expires.Add(-ttl*2/3 - rtt - rtt/2), true
+}
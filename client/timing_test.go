@@ -0,0 +1,73 @@
+package client
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mrdhat/clutchdb/clutcherrors"
+	"github.com/mrdhat/clutchdb/protocol"
+)
+
+// skewedServer grants every acquire and renew on conn, stamping expiries with a clock that runs
+// skew ahead of the local one
+func skewedServer(conn net.Conn, skew time.Duration) {
+	go func() {
+		for {
+			req, err := protocol.ReadRequest(conn)
+			if err != nil {
+				return
+			}
+			now := time.Now().Add(skew).UnixMilli()
+			resp := &protocol.Response{
+				Status:       clutcherrors.STATUS_SUCCESS,
+				FencingToken: 1,
+				ExpiresAt:    uint64(now) + req.TTLMS,
+			}
+			if err := protocol.WriteResponse(conn, resp); err != nil {
+				return
+			}
+		}
+	}()
+}
+
+func TestRenewAtUnderClockSkew(t *testing.T) {
+	ttl := 3 * time.Second
+	for _, skew := range []time.Duration{0, 5 * time.Minute, -5 * time.Minute} {
+		clientConn, serverConn := net.Pipe()
+		skewedServer(serverConn, skew)
+		c := New(clientConn, uuid.New())
+
+		acquired := time.Now()
+		if _, err := c.Acquire(context.Background(), "lock1", ttl); err != nil {
+			t.Fatalf("Acquire failed: %v", err)
+		}
+
+		estimate, rtt, ok := c.ClockSkew()
+		if !ok {
+			t.Fatalf("Expected a clock estimate after acquire")
+		}
+		if diff := (estimate - skew).Abs(); diff > rtt/2+time.Millisecond {
+			t.Errorf("skew %v: estimated %v, off by %v with rtt %v", skew, estimate, diff, rtt)
+		}
+
+		// The lock runs out ttl after the server committed, which was no earlier than acquired.
+		// The renewal must land by then, a third of the ttl ahead as KeepAlive would.
+		renewAt, ok := c.RenewAt("lock1", ttl)
+		if !ok {
+			t.Fatalf("Expected RenewAt for held lock")
+		}
+		latest := acquired.Add(ttl / 3).Add(time.Millisecond)
+		if renewAt.After(latest) {
+			t.Errorf("skew %v: renewal scheduled %v after the ttl/3 mark", skew, renewAt.Sub(latest))
+		}
+		if earliest := acquired.Add(ttl/3 - 50*time.Millisecond); renewAt.Before(earliest) {
+			t.Errorf("skew %v: renewal scheduled %v before the ttl/3 mark", skew, earliest.Sub(renewAt))
+		}
+
+		c.Close(context.Background())
+		serverConn.Close()
+	}
+}
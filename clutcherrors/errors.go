@@ -4,11 +4,20 @@ package clutcherrors
 type StatusCode uint8
 
 const (
-	STATUS_SUCCESS         StatusCode = 0 // Success
-	STATUS_LOCK_HELD       StatusCode = 1 // Lock already held (ACQUIRE failed)
-	STATUS_LOCK_NOT_HELD   StatusCode = 2 // Lock not held (for RENEW)
-	STATUS_INVALID_REQUEST StatusCode = 3 // Invalid request / malformed
-	STATUS_NOT_LEADER      StatusCode = 4 // Not leader / redirect to leader
-	STATUS_LOCK_EXPIRED    StatusCode = 5 // Lock expired (for RENEW/RELEASE)
-	// 6+ reserved for future errors
+	STATUS_SUCCESS             StatusCode = 0  // Success
+	STATUS_LOCK_HELD           StatusCode = 1  // Lock already held (ACQUIRE failed)
+	STATUS_LOCK_NOT_HELD       StatusCode = 2  // Lock not held (for RENEW)
+	STATUS_INVALID_REQUEST     StatusCode = 3  // Invalid request / malformed
+	STATUS_NOT_LEADER          StatusCode = 4  // Not leader / redirect to leader
+	STATUS_LOCK_EXPIRED        StatusCode = 5  // Lock expired (for RENEW/RELEASE)
+	STATUS_QUOTA_EXCEEDED      StatusCode = 6  // Owner or server lock quota exceeded (ACQUIRE failed)
+	STATUS_RATE_LIMITED        StatusCode = 7  // Too many requests from this owner
+	STATUS_OVERLOADED          StatusCode = 8  // Too many commits waiting on the WAL, retry later
+	STATUS_RENEW_NOT_NEEDED    StatusCode = 9  // Lease still has enough time left, not renewed (COMPARE_AND_RENEW)
+	STATUS_UNSUPPORTED_VERSION StatusCode = 10 // No protocol version both sides speak (HELLO failed)
+	STATUS_ACQUIRED_RECLAIMED  StatusCode = 11 // Acquired, but from a holder whose lease had lapsed (CHECK_ACQUIRE)
+	STATUS_FENCED_OUT          StatusCode = 12 // Lock now held under a newer fencing token (RENEW/RELEASE/BUMP)
+	STATUS_FORBIDDEN           StatusCode = 13 // Request not signed with the server's shared secret
+	STATUS_HELD_BY_CALLER      StatusCode = 14 // Lock already held by the requesting owner (ACQUIRE failed)
+	// 15+ reserved for future errors
 )
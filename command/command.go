@@ -3,9 +3,14 @@ package command
 type CommandType uint8
 
 const (
-	CmdAcquire CommandType = 1
-	CmdRenew   CommandType = 2
-	CmdRelease CommandType = 3
+	CmdAcquire  CommandType = 1
+	CmdRenew    CommandType = 2
+	CmdRelease  CommandType = 3
+	CmdBump     CommandType = 4 // FencingToken is the new token
+	CmdTransfer CommandType = 5 // Renew that hands the lock from OwnerID to NewOwnerID
+
+	CmdAdvanceFencing CommandType = 6 // Raises LockID's fencing counter to FencingToken, holds are untouched
+	CmdReset          CommandType = 7 // Frees every lock and zeroes every fencing counter; LockID is empty
 )
 
 type Command struct {
@@ -13,6 +18,8 @@ type Command struct {
 	RequestID        [16]byte
 	LockID           string
 	OwnerID          string
+	NewOwnerID       string // CmdTransfer only
+	Value            string // CmdAcquire only; the hold keeps it until it ends
 	FencingToken     uint64
 	CommitTimeMillis uint64
 	TTLMillis        uint64
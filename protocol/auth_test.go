@@ -0,0 +1,140 @@
+package protocol
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestSignedRequestRoundTrip(t *testing.T) {
+	secret := []byte("shared secret")
+	req := &Request{Cmd: ACQUIRE, RequestID: uuid.New(), OwnerID: uuid.New(), TTLMS: 1000}
+	copy(req.LockID[:], "lock1")
+	SignRequest(req, secret)
+
+	var buf bytes.Buffer
+	if err := WriteRequest(&buf, req); err != nil {
+		t.Fatalf("WriteRequest failed: %v", err)
+	}
+	if buf.Len() != RequestFrameSize+MACLength {
+		t.Fatalf("Expected %d bytes, got %d", RequestFrameSize+MACLength, buf.Len())
+	}
+	decoded, err := ReadRequest(&buf)
+	if err != nil {
+		t.Fatalf("ReadRequest failed: %v", err)
+	}
+	if *decoded != *req {
+		t.Errorf("Expected %+v, got %+v", req, decoded)
+	}
+	if !VerifyRequest(decoded, secret) {
+		t.Error("Expected the decoded request to verify")
+	}
+}
+
+func TestVerifyRequestRejects(t *testing.T) {
+	secret := []byte("shared secret")
+	signed := Request{Cmd: RELEASE, RequestID: uuid.New(), OwnerID: uuid.New(), FencingToken: 7}
+	SignRequest(&signed, secret)
+
+	unsigned := signed
+	unsigned.MAC = [MACLength]byte{}
+	tampered := signed
+	tampered.FencingToken = 8
+	corrupted := signed
+	corrupted.MAC[0] ^= 1
+
+	for name, req := range map[string]Request{"unsigned": unsigned, "tampered": tampered, "corrupted": corrupted} {
+		if VerifyRequest(&req, secret) {
+			t.Errorf("Expected %s request to fail verification", name)
+		}
+	}
+	if VerifyRequest(&signed, []byte("other secret")) {
+		t.Error("Expected request to fail verification under another secret")
+	}
+}
+
+func TestBatchMixesSignedRequests(t *testing.T) {
+	secret := []byte("shared secret")
+	reqs := []*Request{
+		{Cmd: ACQUIRE, RequestID: uuid.New(), TTLMS: 1000},
+		{Cmd: RENEW, RequestID: uuid.New(), TTLMS: 1000, FencingToken: 1},
+		{Cmd: RELEASE, RequestID: uuid.New(), FencingToken: 1},
+	}
+	SignRequest(reqs[0], secret)
+	SignRequest(reqs[2], secret)
+
+	var buf bytes.Buffer
+	if err := WriteBatchRequest(&buf, reqs); err != nil {
+		t.Fatalf("WriteBatchRequest failed: %v", err)
+	}
+	decoded, err := ReadBatchRequest(&buf)
+	if err != nil {
+		t.Fatalf("ReadBatchRequest failed: %v", err)
+	}
+	if len(decoded) != len(reqs) {
+		t.Fatalf("Expected %d requests, got %d", len(reqs), len(decoded))
+	}
+	for i := range reqs {
+		if *decoded[i] != *reqs[i] {
+			t.Errorf("Item %d: expected %+v, got %+v", i, reqs[i], decoded[i])
+		}
+	}
+}
+
+func TestSignedFrameRoundTrip(t *testing.T) {
+	secret := []byte("shared secret")
+	req := &RenewAllRequest{RequestID: uuid.New(), OwnerID: uuid.New(), TTLMS: 5000, Tokens: []LockToken{{LockID: "lock1", FencingToken: 3}}}
+	env := req.Envelope()
+	SignRequest(env, secret)
+	req.MAC = env.MAC
+
+	var buf bytes.Buffer
+	if err := WriteRenewAllRequest(&buf, req); err != nil {
+		t.Fatalf("WriteRenewAllRequest failed: %v", err)
+	}
+	decoded, err := ReadRenewAllRequest(&buf)
+	if err != nil {
+		t.Fatalf("ReadRenewAllRequest failed: %v", err)
+	}
+	if !reflect.DeepEqual(decoded, req) {
+		t.Errorf("Expected %+v, got %+v", req, decoded)
+	}
+	if !VerifyRequest(decoded.Envelope(), secret) {
+		t.Error("Expected the decoded request's envelope to verify")
+	}
+
+	// The MAC covers the whole frame, not just the envelope's fields
+	decoded.Tokens[0].FencingToken = 4
+	if VerifyRequest(decoded.Envelope(), secret) {
+		t.Error("Expected a tampered frame to fail verification")
+	}
+}
+
+func TestSignedReportRequestRoundTrip(t *testing.T) {
+	secret := []byte("shared secret")
+	for _, signed := range []bool{false, true} {
+		req := &ReportRequest{Cmd: SERVER_STATS, RequestID: uuid.New()}
+		if signed {
+			env := req.Envelope()
+			SignRequest(env, secret)
+			req.MAC = env.MAC
+		}
+
+		var buf bytes.Buffer
+		if err := WriteReportRequest(&buf, req); err != nil {
+			t.Fatalf("WriteReportRequest failed: %v", err)
+		}
+		decoded, err := ReadReportRequest(&buf, SERVER_STATS)
+		if err != nil {
+			t.Fatalf("ReadReportRequest failed: %v", err)
+		}
+		if *decoded != *req {
+			t.Errorf("Expected %+v, got %+v", req, decoded)
+		}
+		if VerifyRequest(decoded.Envelope(), secret) != signed {
+			t.Errorf("Expected verification of the signed=%v request to be %v", signed, signed)
+		}
+	}
+}
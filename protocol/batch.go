@@ -0,0 +1,124 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// MaxBatchSize is the largest number of sub-requests accepted in one BATCH frame
+const MaxBatchSize = 1024
+
+// WriteBatchRequest encodes reqs as a single BATCH frame and writes it to w.
+//
+//	| u32 length | u8 cmd = BATCH | u32 count | count × request frame |
+//
+// Each request frame may be signed or not, independently of the others.
+func WriteBatchRequest(w io.Writer, reqs []*Request) error {
+	if len(reqs) > MaxBatchSize {
+		return fmt.Errorf("batch too large: %d requests, max %d", len(reqs), MaxBatchSize)
+	}
+
+	size := 9
+	for _, req := range reqs {
+		size += RequestFrameSize
+		if req.signed() {
+			size += MACLength
+		}
+	}
+	buf := make([]byte, 9, size)
+	binary.BigEndian.PutUint32(buf[0:4], uint32(size-4))
+	buf[4] = BATCH
+	binary.BigEndian.PutUint32(buf[5:9], uint32(len(reqs)))
+
+	var frame [RequestFrameSize]byte
+	for _, req := range reqs {
+		encodeRequest(&frame, req)
+		buf = append(buf, frame[:]...)
+		if req.signed() {
+			buf = append(buf, req.MAC[:]...)
+		}
+	}
+
+	_, err := w.Write(buf)
+	return err
+}
+
+// ReadBatchRequest reads a BATCH frame from r and decodes its sub-requests
+func ReadBatchRequest(r io.Reader) ([]*Request, error) {
+	var header [9]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, frameReadError(err, true)
+	}
+	length := binary.BigEndian.Uint32(header[0:4])
+	if header[4] != BATCH {
+		return nil, fmt.Errorf("invalid batch command: %d", header[4])
+	}
+	count := binary.BigEndian.Uint32(header[5:9])
+	if count > MaxBatchSize {
+		return nil, fmt.Errorf("batch too large: %d requests, max %d", count, MaxBatchSize)
+	}
+	// Between every item unsigned and every item signed
+	minLength, maxLength := 5+count*RequestFrameSize, 5+count*(RequestFrameSize+MACLength)
+	if length < minLength || length > maxLength {
+		return nil, fmt.Errorf("%w: expected %d to %d, got %d", ErrBadLength, minLength, maxLength, length)
+	}
+
+	data := make([]byte, length-5)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, frameReadError(err, false)
+	}
+
+	reqs := make([]*Request, 0, count)
+	for offset := 0; offset < len(data); {
+		if len(reqs) == int(count) {
+			return nil, fmt.Errorf("%w: %d bytes left after %d items", ErrBadLength, len(data)-offset, count)
+		}
+		req, n, err := DecodeRequest(data[offset:])
+		if err != nil {
+			return nil, fmt.Errorf("batch item %d: %w", len(reqs), err)
+		}
+		reqs = append(reqs, req)
+		offset += n
+	}
+	if len(reqs) != int(count) {
+		return nil, fmt.Errorf("%w: frame holds %d items, expected %d", ErrBadLength, len(reqs), count)
+	}
+	return reqs, nil
+}
+
+// WriteResponseList writes a count-prefixed list of responses to w, in order.
+//
+//	| u32 count | count × response |
+func WriteResponseList(w io.Writer, resps []*Response) error {
+	if err := binary.Write(w, binary.BigEndian, uint32(len(resps))); err != nil {
+		return err
+	}
+	for _, resp := range resps {
+		if err := WriteResponse(w, resp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadResponseList reads a list written by WriteResponseList
+func ReadResponseList(r io.Reader) ([]*Response, error) {
+	var count uint32
+	if err := binary.Read(r, binary.BigEndian, &count); err != nil {
+		return nil, err
+	}
+	if count > MaxBatchSize {
+		return nil, fmt.Errorf("response list too large: %d, max %d", count, MaxBatchSize)
+	}
+
+	resps := make([]*Response, 0, count)
+	for i := uint32(0); i < count; i++ {
+		resp, err := ReadResponse(r)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read response %d: %w", i, err)
+		}
+		resps = append(resps, resp)
+	}
+	return resps, nil
+}
@@ -0,0 +1,89 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/mrdhat/clutchdb/clutcherrors"
+)
+
+func TestBatchRequestRoundTrip(t *testing.T) {
+	for _, size := range []int{0, 1, 3, MaxBatchSize} {
+		reqs := make([]*Request, size)
+		for i := range reqs {
+			reqs[i] = &Request{Cmd: ACQUIRE, RequestID: uuid.New(), TTLMS: uint64(i), FencingToken: uint64(i * 2)}
+			copy(reqs[i].LockID[:], "lock")
+		}
+
+		var buf bytes.Buffer
+		if err := WriteBatchRequest(&buf, reqs); err != nil {
+			t.Fatalf("WriteBatchRequest(%d) failed: %v", size, err)
+		}
+
+		decoded, err := ReadBatchRequest(&buf)
+		if err != nil {
+			t.Fatalf("ReadBatchRequest(%d) failed: %v", size, err)
+		}
+		if len(decoded) != size {
+			t.Fatalf("Expected %d requests, got %d", size, len(decoded))
+		}
+		for i := range reqs {
+			if *decoded[i] != *reqs[i] {
+				t.Errorf("Request %d mismatch: got %+v, want %+v", i, decoded[i], reqs[i])
+			}
+		}
+	}
+}
+
+func TestBatchRequestTooLarge(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteBatchRequest(&buf, make([]*Request, MaxBatchSize+1)); err == nil {
+		t.Fatal("Expected error writing oversized batch")
+	}
+
+	// A forged header claiming a huge count is rejected before allocating
+	binary.Write(&buf, binary.BigEndian, uint32(5))
+	buf.WriteByte(BATCH)
+	binary.Write(&buf, binary.BigEndian, uint32(1<<30))
+	if _, err := ReadBatchRequest(&buf); err == nil {
+		t.Fatal("Expected error reading oversized batch")
+	}
+}
+
+func TestBatchRequestBadLength(t *testing.T) {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, uint32(999))
+	buf.WriteByte(BATCH)
+	binary.Write(&buf, binary.BigEndian, uint32(1))
+	if _, err := ReadBatchRequest(&buf); err == nil {
+		t.Fatal("Expected error for mismatched batch length")
+	}
+}
+
+func TestResponseListRoundTrip(t *testing.T) {
+	resps := []*Response{
+		{Status: clutcherrors.STATUS_SUCCESS, FencingToken: 1, ExpiresAt: 100},
+		{Status: clutcherrors.STATUS_LOCK_HELD},
+		{Status: clutcherrors.STATUS_SUCCESS, FencingToken: 7, ExpiresAt: 200},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteResponseList(&buf, resps); err != nil {
+		t.Fatalf("WriteResponseList failed: %v", err)
+	}
+
+	decoded, err := ReadResponseList(&buf)
+	if err != nil {
+		t.Fatalf("ReadResponseList failed: %v", err)
+	}
+	if len(decoded) != len(resps) {
+		t.Fatalf("Expected %d responses, got %d", len(resps), len(decoded))
+	}
+	for i := range resps {
+		if *decoded[i] != *resps[i] {
+			t.Errorf("Response %d mismatch: got %+v, want %+v", i, decoded[i], resps[i])
+		}
+	}
+}
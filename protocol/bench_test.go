@@ -0,0 +1,56 @@
+package protocol
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func BenchmarkWriteRequest(b *testing.B) {
+	req := &Request{Cmd: ACQUIRE, TTLMS: 1000}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := WriteRequest(io.Discard, req); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkWriteRequestTo(b *testing.B) {
+	req := &Request{Cmd: ACQUIRE, TTLMS: 1000}
+	var buf [RequestFrameSize]byte
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := WriteRequestTo(io.Discard, req, &buf); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkReadRequest(b *testing.B) {
+	var frame bytes.Buffer
+	WriteRequest(&frame, &Request{Cmd: ACQUIRE, TTLMS: 1000})
+	r := bytes.NewReader(frame.Bytes())
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		r.Reset(frame.Bytes())
+		if _, err := ReadRequest(r); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkReadRequestFrom(b *testing.B) {
+	var frame bytes.Buffer
+	WriteRequest(&frame, &Request{Cmd: ACQUIRE, TTLMS: 1000})
+	r := bytes.NewReader(frame.Bytes())
+	var req Request
+	var buf [RequestFrameSize]byte
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		r.Reset(frame.Bytes())
+		if err := ReadRequestFrom(r, &req, &buf); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package protocol
+
+import (
+	"math"
+	"time"
+)
+
+// MaxTTLMillis is the largest TTL, in milliseconds, that converts to a time.Duration without overflow
+const MaxTTLMillis = uint64(math.MaxInt64 / int64(time.Millisecond))
+
+// DurationToMillis converts d to whole milliseconds for the wire. It rounds up, so a TTL is
+// never shortened: any positive duration under a millisecond becomes 1. Negative durations
+// become 0.
+func DurationToMillis(d time.Duration) uint64 {
+	if d <= 0 {
+		return 0
+	}
+	ms := uint64(d / time.Millisecond)
+	if d%time.Millisecond != 0 {
+		ms++
+	}
+	return ms
+}
+
+// MillisToDuration converts wire milliseconds to a Duration. Values above MaxTTLMillis
+// saturate at MaxTTLMillis rather than wrapping negative.
+func MillisToDuration(ms uint64) time.Duration {
+	if ms > MaxTTLMillis {
+		ms = MaxTTLMillis
+	}
+	return time.Duration(ms) * time.Millisecond
+}
@@ -0,0 +1,55 @@
+package protocol
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestDurationToMillis(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want uint64
+	}{
+		{-time.Second, 0},
+		{0, 0},
+		{time.Nanosecond, 1},
+		{time.Millisecond - time.Nanosecond, 1},
+		{time.Millisecond, 1},
+		{time.Millisecond + time.Nanosecond, 2},
+		{1500 * time.Microsecond, 2},
+		{time.Second, 1000},
+		{math.MaxInt64, MaxTTLMillis + 1},
+	}
+	for _, tt := range tests {
+		if got := DurationToMillis(tt.d); got != tt.want {
+			t.Errorf("DurationToMillis(%v) = %d, expected %d", tt.d, got, tt.want)
+		}
+	}
+}
+
+func TestMillisToDuration(t *testing.T) {
+	tests := []struct {
+		ms   uint64
+		want time.Duration
+	}{
+		{0, 0},
+		{1, time.Millisecond},
+		{1000, time.Second},
+		{MaxTTLMillis, time.Duration(MaxTTLMillis) * time.Millisecond},
+		{MaxTTLMillis + 1, time.Duration(MaxTTLMillis) * time.Millisecond},
+		{math.MaxUint64, time.Duration(MaxTTLMillis) * time.Millisecond},
+	}
+	for _, tt := range tests {
+		if got := MillisToDuration(tt.ms); got != tt.want {
+			t.Errorf("MillisToDuration(%d) = %v, expected %v", tt.ms, got, tt.want)
+		}
+	}
+
+	// Whole milliseconds survive the round trip
+	for _, ms := range []uint64{0, 1, 999, MaxTTLMillis} {
+		if got := DurationToMillis(MillisToDuration(ms)); got != ms {
+			t.Errorf("Round trip of %d ms gave %d", ms, got)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package protocol
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Decode errors returned by the frame readers. The underlying error, if any, is wrapped
+// alongside them, so errors.Is works for both.
+var (
+	// ErrClosed means the peer closed the connection cleanly between frames
+	ErrClosed = errors.New("connection closed")
+	// ErrShortFrame means the stream ended partway through a frame
+	ErrShortFrame = errors.New("short frame")
+	// ErrBadLength means a frame's length prefix doesn't match its command
+	ErrBadLength = errors.New("bad frame length")
+)
+
+// frameReadError classifies an error from reading part of a frame. atBoundary reports
+// whether no bytes of the frame had been read before the read started.
+func frameReadError(err error, atBoundary bool) error {
+	switch {
+	case errors.Is(err, io.EOF) && atBoundary:
+		return fmt.Errorf("%w: %w", ErrClosed, err)
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return fmt.Errorf("%w: %w", ErrShortFrame, err)
+	default:
+		return err
+	}
+}
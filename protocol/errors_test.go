@@ -0,0 +1,58 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestReadRequestErrors(t *testing.T) {
+	var frame bytes.Buffer
+	if err := WriteRequest(&frame, &Request{Cmd: ACQUIRE}); err != nil {
+		t.Fatalf("WriteRequest failed: %v", err)
+	}
+	data := frame.Bytes()
+
+	badLength := make([]byte, RequestFrameSize)
+	binary.BigEndian.PutUint32(badLength[0:4], requestLength+1)
+
+	tests := []struct {
+		name  string
+		input []byte
+		want  error
+		cause error
+	}{
+		{"clean eof", nil, ErrClosed, io.EOF},
+		{"eof inside length", data[:2], ErrShortFrame, io.ErrUnexpectedEOF},
+		{"eof after length", data[:4], ErrShortFrame, io.EOF},
+		{"eof inside body", data[:RequestFrameSize-1], ErrShortFrame, io.ErrUnexpectedEOF},
+		{"bad length", badLength, ErrBadLength, nil},
+	}
+
+	for _, tt := range tests {
+		_, err := ReadRequest(bytes.NewReader(tt.input))
+		if !errors.Is(err, tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, err)
+		}
+		if tt.cause != nil && !errors.Is(err, tt.cause) {
+			t.Errorf("%s: expected error to wrap %v, got %v", tt.name, tt.cause, err)
+		}
+	}
+}
+
+func TestReadBatchRequestErrors(t *testing.T) {
+	var frame bytes.Buffer
+	if err := WriteBatchRequest(&frame, []*Request{{Cmd: ACQUIRE}}); err != nil {
+		t.Fatalf("WriteBatchRequest failed: %v", err)
+	}
+	data := frame.Bytes()
+
+	if _, err := ReadBatchRequest(bytes.NewReader(nil)); !errors.Is(err, ErrClosed) {
+		t.Errorf("Expected ErrClosed on clean eof, got %v", err)
+	}
+	if _, err := ReadBatchRequest(bytes.NewReader(data[:len(data)-1])); !errors.Is(err, ErrShortFrame) {
+		t.Errorf("Expected ErrShortFrame on truncated batch, got %v", err)
+	}
+}
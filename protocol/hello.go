@@ -0,0 +1,103 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/mrdhat/clutchdb/clutcherrors"
+)
+
+// Protocol versions this package speaks. A connection that never sends HELLO speaks version 1.
+// Version 2 responses echo their request's ID, and a server may answer pipelined requests out
+// of order.
+const (
+	MinVersion uint16 = 1
+	MaxVersion uint16 = 2
+)
+
+// helloLength is the number of HELLO request bytes following the length field
+const helloLength = 3
+
+// helloResponseLength is the number of HELLO response bytes following the length field
+const helloResponseLength = 3
+
+// WriteHello writes a HELLO frame offering every version up to maxVersion.
+//
+//	| u32 length | u8 cmd = HELLO | u16 max_version |
+func WriteHello(w io.Writer, maxVersion uint16) error {
+	var buf [4 + helloLength]byte
+	binary.BigEndian.PutUint32(buf[0:4], helloLength)
+	buf[4] = HELLO
+	binary.BigEndian.PutUint16(buf[5:7], maxVersion)
+	_, err := w.Write(buf[:])
+	return err
+}
+
+// ReadHello reads a HELLO frame from r and returns the client's highest version
+func ReadHello(r io.Reader) (uint16, error) {
+	var buf [4 + helloLength]byte
+	if _, err := io.ReadFull(r, buf[0:4]); err != nil {
+		return 0, frameReadError(err, true)
+	}
+	length := binary.BigEndian.Uint32(buf[0:4])
+	if length != helloLength {
+		return 0, fmt.Errorf("%w: expected %d, got %d", ErrBadLength, helloLength, length)
+	}
+	if _, err := io.ReadFull(r, buf[4:]); err != nil {
+		return 0, frameReadError(err, false)
+	}
+	if buf[4] != HELLO {
+		return 0, fmt.Errorf("invalid hello command: %d", buf[4])
+	}
+	return binary.BigEndian.Uint16(buf[5:7]), nil
+}
+
+// WriteHelloResponse answers a HELLO with status and, on success, the chosen version.
+//
+//	| u32 length | u8 status | u16 version |
+func WriteHelloResponse(w io.Writer, status clutcherrors.StatusCode, version uint16) error {
+	var buf [4 + helloResponseLength]byte
+	binary.BigEndian.PutUint32(buf[0:4], helloResponseLength)
+	buf[4] = byte(status)
+	binary.BigEndian.PutUint16(buf[5:7], version)
+	_, err := w.Write(buf[:])
+	return err
+}
+
+// ReadHelloResponse reads the answer to a HELLO. A server that predates HELLO rejects it with
+// an ordinary response; that reads as STATUS_INVALID_REQUEST with version 0.
+func ReadHelloResponse(r io.Reader) (clutcherrors.StatusCode, uint16, error) {
+	var lengthBuf [4]byte
+	if _, err := io.ReadFull(r, lengthBuf[:]); err != nil {
+		return 0, 0, frameReadError(err, true)
+	}
+	length := binary.BigEndian.Uint32(lengthBuf[:])
+	if length < 1 || length > maxResponseLength {
+		return 0, 0, fmt.Errorf("%w: expected 1 to %d, got %d", ErrBadLength, maxResponseLength, length)
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return 0, 0, frameReadError(err, false)
+	}
+
+	status := clutcherrors.StatusCode(data[0])
+	if status != clutcherrors.STATUS_SUCCESS {
+		return status, 0, nil
+	}
+	if length < helloResponseLength {
+		return 0, 0, fmt.Errorf("%w: expected %d, got %d", ErrBadLength, helloResponseLength, length)
+	}
+	return status, binary.BigEndian.Uint16(data[1:3]), nil
+}
+
+// NegotiateVersion picks the highest version in [minVersion, maxVersion] that does not
+// exceed the client's offer. ok is false if the ranges don't overlap.
+func NegotiateVersion(offered, minVersion, maxVersion uint16) (version uint16, ok bool) {
+	version = min(offered, maxVersion)
+	if version < minVersion {
+		return 0, false
+	}
+	return version, true
+}
@@ -0,0 +1,66 @@
+package protocol
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/mrdhat/clutchdb/clutcherrors"
+)
+
+func TestHelloRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteHello(&buf, 3); err != nil {
+		t.Fatalf("WriteHello failed: %v", err)
+	}
+	offered, err := ReadHello(&buf)
+	if err != nil {
+		t.Fatalf("ReadHello failed: %v", err)
+	}
+	if offered != 3 {
+		t.Errorf("Expected offered version 3, got %d", offered)
+	}
+
+	if err := WriteHelloResponse(&buf, clutcherrors.STATUS_SUCCESS, 2); err != nil {
+		t.Fatalf("WriteHelloResponse failed: %v", err)
+	}
+	status, version, err := ReadHelloResponse(&buf)
+	if err != nil {
+		t.Fatalf("ReadHelloResponse failed: %v", err)
+	}
+	if status != clutcherrors.STATUS_SUCCESS || version != 2 {
+		t.Errorf("Expected version 2, got status %d version %d", status, version)
+	}
+
+	// A server without HELLO answers with an ordinary error response
+	if err := WriteResponse(&buf, &Response{Status: clutcherrors.STATUS_INVALID_REQUEST}); err != nil {
+		t.Fatalf("WriteResponse failed: %v", err)
+	}
+	status, _, err = ReadHelloResponse(&buf)
+	if err != nil {
+		t.Fatalf("ReadHelloResponse failed: %v", err)
+	}
+	if status != clutcherrors.STATUS_INVALID_REQUEST {
+		t.Errorf("Expected status %d, got %d", clutcherrors.STATUS_INVALID_REQUEST, status)
+	}
+}
+
+func TestNegotiateVersion(t *testing.T) {
+	tests := []struct {
+		name     string
+		offered  uint16
+		min, max uint16
+		want     uint16
+		ok       bool
+	}{
+		{"matched", 2, 1, 2, 2, true},
+		{"downgraded", 5, 1, 2, 2, true},
+		{"client older", 1, 1, 2, 1, true},
+		{"unsupported", 1, 2, 3, 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := NegotiateVersion(tt.offered, tt.min, tt.max)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("%s: expected (%d, %v), got (%d, %v)", tt.name, tt.want, tt.ok, got, ok)
+		}
+	}
+}
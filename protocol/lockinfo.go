@@ -0,0 +1,104 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// LockInfo describes the current state of a single lock
+type LockInfo struct {
+	LockID       string // Lock identifier
+	OwnerID      string // Current owner
+	FencingToken uint64 // Fencing token of the current hold
+	ExpiresAt    uint64 // Expiration timestamp in milliseconds
+	AcquiredAt   uint64 // When the current owner was granted the lock, in milliseconds
+	Value        string // Value the holder attached when acquiring, empty if none
+}
+
+// WriteLockInfoList encodes infos and writes them to w.
+//
+//	| u32 count | count × ( u16 lock_id_len | lock_id | u16 owner_id_len | owner_id | u64 fencing_token | u64 expires_at | u64 acquired_at | u16 value_len | value ) |
+func WriteLockInfoList(w io.Writer, infos []LockInfo) error {
+	buf := new(bytes.Buffer)
+
+	binary.Write(buf, binary.BigEndian, uint32(len(infos)))
+	for _, info := range infos {
+		if err := writeString(buf, info.LockID); err != nil {
+			return fmt.Errorf("lock id: %w", err)
+		}
+		if err := writeString(buf, info.OwnerID); err != nil {
+			return fmt.Errorf("owner id: %w", err)
+		}
+		binary.Write(buf, binary.BigEndian, info.FencingToken)
+		binary.Write(buf, binary.BigEndian, info.ExpiresAt)
+		binary.Write(buf, binary.BigEndian, info.AcquiredAt)
+		if err := writeString(buf, info.Value); err != nil {
+			return fmt.Errorf("value: %w", err)
+		}
+	}
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// ReadLockInfoList reads from r and decodes a list written by WriteLockInfoList
+func ReadLockInfoList(r io.Reader) ([]LockInfo, error) {
+	var count uint32
+	if err := binary.Read(r, binary.BigEndian, &count); err != nil {
+		return nil, err
+	}
+
+	// count comes off the wire, so grow as entries arrive rather than trusting it up front
+	var infos []LockInfo
+	for i := uint32(0); i < count; i++ {
+		var info LockInfo
+		var err error
+		if info.LockID, err = readString(r); err != nil {
+			return nil, fmt.Errorf("failed to read lock id: %w", err)
+		}
+		if info.OwnerID, err = readString(r); err != nil {
+			return nil, fmt.Errorf("failed to read owner id: %w", err)
+		}
+		if err := binary.Read(r, binary.BigEndian, &info.FencingToken); err != nil {
+			return nil, fmt.Errorf("failed to read fencing token: %w", err)
+		}
+		if err := binary.Read(r, binary.BigEndian, &info.ExpiresAt); err != nil {
+			return nil, fmt.Errorf("failed to read expires at: %w", err)
+		}
+		if err := binary.Read(r, binary.BigEndian, &info.AcquiredAt); err != nil {
+			return nil, fmt.Errorf("failed to read acquired at: %w", err)
+		}
+		if info.Value, err = readString(r); err != nil {
+			return nil, fmt.Errorf("failed to read value: %w", err)
+		}
+		infos = append(infos, info)
+	}
+
+	return infos, nil
+}
+
+// writeString writes s as a u16 length followed by its bytes
+func writeString(buf *bytes.Buffer, s string) error {
+	if len(s) > math.MaxUint16 {
+		return fmt.Errorf("string too long: %d bytes", len(s))
+	}
+	binary.Write(buf, binary.BigEndian, uint16(len(s)))
+	buf.WriteString(s)
+	return nil
+}
+
+// readString reads a string written by writeString
+func readString(r io.Reader) (string, error) {
+	var length uint16
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return "", err
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
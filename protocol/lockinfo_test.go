@@ -0,0 +1,79 @@
+package protocol
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestLockInfoListRoundTrip(t *testing.T) {
+	many := make([]LockInfo, 100)
+	for i := range many {
+		many[i] = LockInfo{
+			LockID:       fmt.Sprintf("lock-%d", i),
+			OwnerID:      fmt.Sprintf("owner-%d", i%7),
+			FencingToken: uint64(i + 1),
+			ExpiresAt:    uint64(1700000000000 + i),
+			AcquiredAt:   uint64(1699999990000 + i),
+		}
+	}
+
+	testCases := []struct {
+		name  string
+		infos []LockInfo
+	}{
+		{"empty", nil},
+		{"single", []LockInfo{{LockID: "lock1", OwnerID: "owner1", FencingToken: 5, ExpiresAt: 1700000000000, AcquiredAt: 1699999990000}}},
+		{"many", many},
+		{"value", []LockInfo{{LockID: "leader", OwnerID: "owner1", FencingToken: 2, ExpiresAt: 1700000000000, Value: "10.0.0.7:7000"}}},
+		{"long lock id", []LockInfo{{LockID: strings.Repeat("x", 4096), OwnerID: "owner1", FencingToken: 1, ExpiresAt: 1}}},
+		{"empty fields", []LockInfo{{}}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := WriteLockInfoList(&buf, tc.infos); err != nil {
+				t.Fatalf("WriteLockInfoList failed: %v", err)
+			}
+
+			decoded, err := ReadLockInfoList(&buf)
+			if err != nil {
+				t.Fatalf("ReadLockInfoList failed: %v", err)
+			}
+
+			if len(decoded) != len(tc.infos) {
+				t.Fatalf("Length mismatch: got %d, want %d", len(decoded), len(tc.infos))
+			}
+			for i := range tc.infos {
+				if decoded[i] != tc.infos[i] {
+					t.Errorf("Entry %d mismatch: got %+v, want %+v", i, decoded[i], tc.infos[i])
+				}
+			}
+			if buf.Len() != 0 {
+				t.Errorf("Expected all bytes consumed, %d left", buf.Len())
+			}
+		})
+	}
+}
+
+func TestLockInfoListTooLong(t *testing.T) {
+	var buf bytes.Buffer
+	err := WriteLockInfoList(&buf, []LockInfo{{LockID: strings.Repeat("x", 1<<16)}})
+	if err == nil {
+		t.Fatal("Expected error for lock id longer than 65535 bytes")
+	}
+}
+
+func TestLockInfoListTruncated(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteLockInfoList(&buf, []LockInfo{{LockID: "lock1", OwnerID: "owner1"}}); err != nil {
+		t.Fatalf("WriteLockInfoList failed: %v", err)
+	}
+
+	truncated := bytes.NewReader(buf.Bytes()[:buf.Len()-3])
+	if _, err := ReadLockInfoList(truncated); err == nil {
+		t.Fatal("Expected error for truncated list")
+	}
+}
@@ -0,0 +1,125 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/mrdhat/clutchdb/clutcherrors"
+)
+
+// LockInfoMultiRequest asks for the current hold on each of LockIDs
+type LockInfoMultiRequest struct {
+	RequestID [16]byte
+	LockIDs   []string
+	MAC       [MACLength]byte // MAC of the envelope under a shared secret, zero if unsigned; see Envelope
+}
+
+// WriteLockInfoMultiRequest encodes req as a GET_LOCK_INFO_MULTI frame and writes it to w.
+//
+//	| u32 length | u8 cmd = GET_LOCK_INFO_MULTI | u128 request_id | u32 count | count × ( u16 lock_id_len | lock_id ) | [mac] |
+//
+// The MAC is only written for a signed request.
+func WriteLockInfoMultiRequest(w io.Writer, req *LockInfoMultiRequest) error {
+	body, err := req.encode()
+	if err != nil {
+		return err
+	}
+	return writeFrame(w, appendFrameMAC(body, req.MAC))
+}
+
+// encode returns req's frame body, everything after the length prefix bar the MAC
+func (req *LockInfoMultiRequest) encode() ([]byte, error) {
+	if len(req.LockIDs) > MaxBatchSize {
+		return nil, fmt.Errorf("too many locks to look up: %d, max %d", len(req.LockIDs), MaxBatchSize)
+	}
+
+	body := new(bytes.Buffer)
+	body.WriteByte(GET_LOCK_INFO_MULTI)
+	body.Write(req.RequestID[:])
+	binary.Write(body, binary.BigEndian, uint32(len(req.LockIDs)))
+	for _, lockID := range req.LockIDs {
+		if err := writeString(body, lockID); err != nil {
+			return nil, fmt.Errorf("lock id: %w", err)
+		}
+	}
+	return body.Bytes(), nil
+}
+
+// Envelope returns the standard request standing for req in a server's middleware chain: a
+// GET_LOCK_INFO_MULTI with req's request ID and MAC, and req's encoded body as its Frame. Frame
+// is empty if req can't be encoded. Sign req as RenewAllRequest.Envelope describes.
+func (req *LockInfoMultiRequest) Envelope() *Request {
+	body, _ := req.encode()
+	return &Request{Cmd: GET_LOCK_INFO_MULTI, RequestID: req.RequestID, MAC: req.MAC, Frame: string(body)}
+}
+
+// ReadLockInfoMultiRequest reads a GET_LOCK_INFO_MULTI frame from r
+func ReadLockInfoMultiRequest(r io.Reader) (*LockInfoMultiRequest, error) {
+	data, err := readFrame(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 21 {
+		return nil, fmt.Errorf("%w: expected at least 21, got %d", ErrBadLength, len(data))
+	}
+	if data[0] != GET_LOCK_INFO_MULTI {
+		return nil, fmt.Errorf("invalid lock info command: %d", data[0])
+	}
+
+	req := &LockInfoMultiRequest{}
+	copy(req.RequestID[:], data[1:17])
+	count := binary.BigEndian.Uint32(data[17:21])
+	if count > MaxBatchSize {
+		return nil, fmt.Errorf("too many locks to look up: %d, max %d", count, MaxBatchSize)
+	}
+
+	body := bytes.NewReader(data[21:])
+	for i := uint32(0); i < count; i++ {
+		lockID, err := readString(body)
+		if err != nil {
+			return nil, fmt.Errorf("%w: lock %d: %v", ErrBadLength, i, err)
+		}
+		req.LockIDs = append(req.LockIDs, lockID)
+	}
+	if err := readFrameMAC(body, &req.MAC); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// WriteLockInfoMultiResponse writes the answer to a GET_LOCK_INFO_MULTI: an overall status, then
+// one LockInfo per requested lock, in request order. A lock that isn't held has only its LockID
+// set; since tokens start at 1, a FencingToken of 0 marks it.
+//
+//	| u32 length | u8 status | lock info list |
+func WriteLockInfoMultiResponse(w io.Writer, status clutcherrors.StatusCode, infos []LockInfo) error {
+	body := new(bytes.Buffer)
+	body.WriteByte(byte(status))
+	if err := WriteLockInfoList(body, infos); err != nil {
+		return err
+	}
+	return writeFrame(w, body.Bytes())
+}
+
+// ReadLockInfoMultiResponse reads a response written by WriteLockInfoMultiResponse
+func ReadLockInfoMultiResponse(r io.Reader) (clutcherrors.StatusCode, []LockInfo, error) {
+	data, err := readFrame(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(data) < 5 {
+		return 0, nil, fmt.Errorf("%w: expected at least 5, got %d", ErrBadLength, len(data))
+	}
+
+	body := bytes.NewReader(data[1:])
+	infos, err := ReadLockInfoList(body)
+	if err != nil {
+		return 0, nil, fmt.Errorf("%w: %v", ErrBadLength, err)
+	}
+	if body.Len() != 0 {
+		return 0, nil, fmt.Errorf("%w: %d trailing bytes", ErrBadLength, body.Len())
+	}
+	return clutcherrors.StatusCode(data[0]), infos, nil
+}
@@ -0,0 +1,66 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/mrdhat/clutchdb/clutcherrors"
+)
+
+func TestLockInfoMultiRequestRoundTrip(t *testing.T) {
+	for _, lockIDs := range [][]string{nil, {"lock1", "lock2", ""}} {
+		req := &LockInfoMultiRequest{RequestID: uuid.New(), LockIDs: lockIDs}
+
+		var buf bytes.Buffer
+		if err := WriteLockInfoMultiRequest(&buf, req); err != nil {
+			t.Fatalf("WriteLockInfoMultiRequest failed: %v", err)
+		}
+		decoded, err := ReadLockInfoMultiRequest(&buf)
+		if err != nil {
+			t.Fatalf("ReadLockInfoMultiRequest failed: %v", err)
+		}
+		if !reflect.DeepEqual(decoded, req) {
+			t.Errorf("Expected %+v, got %+v", req, decoded)
+		}
+	}
+}
+
+func TestLockInfoMultiRequestBadLength(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteLockInfoMultiRequest(&buf, &LockInfoMultiRequest{LockIDs: []string{"lock1"}}); err != nil {
+		t.Fatalf("WriteLockInfoMultiRequest failed: %v", err)
+	}
+
+	// Claim one more lock than the frame holds
+	data := buf.Bytes()
+	binary.BigEndian.PutUint32(data[4+17:4+21], 2)
+	if _, err := ReadLockInfoMultiRequest(bytes.NewReader(data)); !errors.Is(err, ErrBadLength) {
+		t.Errorf("Expected ErrBadLength, got %v", err)
+	}
+}
+
+func TestLockInfoMultiResponseRoundTrip(t *testing.T) {
+	infos := []LockInfo{
+		{LockID: "lock1", OwnerID: "owner1", FencingToken: 3, ExpiresAt: 2000, AcquiredAt: 1000},
+		{LockID: "lock2"},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteLockInfoMultiResponse(&buf, clutcherrors.STATUS_SUCCESS, infos); err != nil {
+		t.Fatalf("WriteLockInfoMultiResponse failed: %v", err)
+	}
+	status, decoded, err := ReadLockInfoMultiResponse(&buf)
+	if err != nil {
+		t.Fatalf("ReadLockInfoMultiResponse failed: %v", err)
+	}
+	if status != clutcherrors.STATUS_SUCCESS {
+		t.Errorf("Expected status %d, got %d", clutcherrors.STATUS_SUCCESS, status)
+	}
+	if !reflect.DeepEqual(decoded, infos) {
+		t.Errorf("Expected %+v, got %+v", infos, decoded)
+	}
+}
@@ -0,0 +1,86 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/mrdhat/clutchdb/clutcherrors"
+)
+
+// OwnerSummary is how many live locks one owner holds
+type OwnerSummary struct {
+	OwnerID string
+	Locks   uint32
+}
+
+// MaxOwnerSummaries is the most owners a LIST_OWNERS response carries. Servers send the first
+// ones in their order, which puts the owners holding the most locks first.
+const MaxOwnerSummaries = 4096
+
+// WriteListOwnersRequest writes a LIST_OWNERS frame.
+//
+//	| u32 length | u8 cmd = LIST_OWNERS | u128 request_id |
+func WriteListOwnersRequest(w io.Writer, requestID [16]byte) error {
+	return writeBareRequest(w, LIST_OWNERS, requestID)
+}
+
+// ReadListOwnersRequest reads a LIST_OWNERS frame from r and returns its request ID
+func ReadListOwnersRequest(r io.Reader) ([16]byte, error) {
+	return readBareRequest(r, LIST_OWNERS)
+}
+
+// WriteListOwnersResponse answers a LIST_OWNERS with status and up to MaxOwnerSummaries of
+// owners, along with how many there are in all.
+//
+//	| u32 length | u8 status | u32 total | u32 count | count × ( u16 owner_id_len | owner_id | u32 locks ) |
+func WriteListOwnersResponse(w io.Writer, status clutcherrors.StatusCode, owners []OwnerSummary) error {
+	total := len(owners)
+	owners = owners[:min(total, MaxOwnerSummaries)]
+
+	body := new(bytes.Buffer)
+	body.WriteByte(byte(status))
+	binary.Write(body, binary.BigEndian, uint32(total))
+	binary.Write(body, binary.BigEndian, uint32(len(owners)))
+	for _, owner := range owners {
+		if err := writeString(body, owner.OwnerID); err != nil {
+			return fmt.Errorf("owner id: %w", err)
+		}
+		binary.Write(body, binary.BigEndian, owner.Locks)
+	}
+	return writeFrame(w, body.Bytes())
+}
+
+// ReadListOwnersResponse reads a response written by WriteListOwnersResponse. total is how many
+// owners the server has, which exceeds len(owners) when the list was cut short.
+func ReadListOwnersResponse(r io.Reader) (status clutcherrors.StatusCode, owners []OwnerSummary, total uint32, err error) {
+	data, err := readFrame(r)
+	if err != nil {
+		return 0, nil, 0, err
+	}
+	if len(data) < 9 {
+		return 0, nil, 0, fmt.Errorf("%w: expected at least 9, got %d", ErrBadLength, len(data))
+	}
+	total = binary.BigEndian.Uint32(data[1:5])
+	count := binary.BigEndian.Uint32(data[5:9])
+	if count > MaxOwnerSummaries {
+		return 0, nil, 0, fmt.Errorf("too many owners: %d, max %d", count, MaxOwnerSummaries)
+	}
+
+	body := bytes.NewReader(data[9:])
+	for i := uint32(0); i < count; i++ {
+		var owner OwnerSummary
+		if owner.OwnerID, err = readString(body); err != nil {
+			return 0, nil, 0, fmt.Errorf("%w: owner %d: %v", ErrBadLength, i, err)
+		}
+		if err := binary.Read(body, binary.BigEndian, &owner.Locks); err != nil {
+			return 0, nil, 0, fmt.Errorf("%w: owner %d: %v", ErrBadLength, i, err)
+		}
+		owners = append(owners, owner)
+	}
+	if body.Len() != 0 {
+		return 0, nil, 0, fmt.Errorf("%w: %d trailing bytes", ErrBadLength, body.Len())
+	}
+	return clutcherrors.StatusCode(data[0]), owners, total, nil
+}
@@ -0,0 +1,57 @@
+package protocol
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/mrdhat/clutchdb/clutcherrors"
+)
+
+func TestListOwnersRoundTrip(t *testing.T) {
+	requestID := uuid.New()
+	var buf bytes.Buffer
+	if err := WriteListOwnersRequest(&buf, requestID); err != nil {
+		t.Fatalf("WriteListOwnersRequest failed: %v", err)
+	}
+	decodedID, err := ReadListOwnersRequest(&buf)
+	if err != nil {
+		t.Fatalf("ReadListOwnersRequest failed: %v", err)
+	}
+	if decodedID != requestID {
+		t.Errorf("Expected request ID %x, got %x", requestID, decodedID)
+	}
+
+	owners := []OwnerSummary{{OwnerID: "owner2", Locks: 3}, {OwnerID: "owner1", Locks: 1}}
+	if err := WriteListOwnersResponse(&buf, clutcherrors.STATUS_SUCCESS, owners); err != nil {
+		t.Fatalf("WriteListOwnersResponse failed: %v", err)
+	}
+	status, decoded, total, err := ReadListOwnersResponse(&buf)
+	if err != nil {
+		t.Fatalf("ReadListOwnersResponse failed: %v", err)
+	}
+	if status != clutcherrors.STATUS_SUCCESS || total != 2 || !reflect.DeepEqual(decoded, owners) {
+		t.Errorf("Expected %+v of 2, got status %d and %+v of %d", owners, status, decoded, total)
+	}
+}
+
+func TestListOwnersResponseTruncated(t *testing.T) {
+	owners := make([]OwnerSummary, MaxOwnerSummaries+10)
+	for i := range owners {
+		owners[i] = OwnerSummary{OwnerID: fmt.Sprintf("owner%d", i), Locks: 1}
+	}
+
+	var buf bytes.Buffer
+	if err := WriteListOwnersResponse(&buf, clutcherrors.STATUS_SUCCESS, owners); err != nil {
+		t.Fatalf("WriteListOwnersResponse failed: %v", err)
+	}
+	_, decoded, total, err := ReadListOwnersResponse(&buf)
+	if err != nil {
+		t.Fatalf("ReadListOwnersResponse failed: %v", err)
+	}
+	if len(decoded) != MaxOwnerSummaries || total != uint32(len(owners)) {
+		t.Errorf("Expected %d owners of %d, got %d of %d", MaxOwnerSummaries, len(owners), len(decoded), total)
+	}
+}
@@ -0,0 +1,108 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/mrdhat/clutchdb/clutcherrors"
+)
+
+// PrefixRequest is a RELEASE_BY_PREFIX or COUNT_BY_PREFIX: an operation on every lock whose ID
+// starts with Prefix
+type PrefixRequest struct {
+	Cmd       uint8 // RELEASE_BY_PREFIX or COUNT_BY_PREFIX
+	RequestID [16]byte
+	OwnerID   [16]byte // whose locks to release (RELEASE_BY_PREFIX only, zero otherwise)
+	Prefix    string
+	MAC       [MACLength]byte // MAC of the envelope under a shared secret, zero if unsigned; see Envelope
+}
+
+// WritePrefixRequest encodes req as a RELEASE_BY_PREFIX or COUNT_BY_PREFIX frame and writes it to w.
+//
+//	| u32 length | u8 cmd | u128 request_id | u128 owner_id | u16 prefix_len | prefix | [mac] |
+//
+// The MAC is only written for a signed request.
+func WritePrefixRequest(w io.Writer, req *PrefixRequest) error {
+	body, err := req.encode()
+	if err != nil {
+		return err
+	}
+	return writeFrame(w, appendFrameMAC(body, req.MAC))
+}
+
+// encode returns req's frame body, everything after the length prefix bar the MAC
+func (req *PrefixRequest) encode() ([]byte, error) {
+	if !isPrefixCommand(req.Cmd) {
+		return nil, fmt.Errorf("invalid prefix command: %d", req.Cmd)
+	}
+
+	body := new(bytes.Buffer)
+	body.WriteByte(req.Cmd)
+	body.Write(req.RequestID[:])
+	body.Write(req.OwnerID[:])
+	if err := writeString(body, req.Prefix); err != nil {
+		return nil, fmt.Errorf("prefix: %w", err)
+	}
+	return body.Bytes(), nil
+}
+
+// Envelope returns the standard request standing for req in a server's middleware chain: a
+// request with req's command, request ID, owner and MAC, and req's encoded body as its Frame.
+// Frame is empty if req can't be encoded. Sign req as RenewAllRequest.Envelope describes.
+func (req *PrefixRequest) Envelope() *Request {
+	body, _ := req.encode()
+	return &Request{Cmd: req.Cmd, RequestID: req.RequestID, OwnerID: req.OwnerID, MAC: req.MAC, Frame: string(body)}
+}
+
+// ReadPrefixRequest reads a RELEASE_BY_PREFIX or COUNT_BY_PREFIX frame from r
+func ReadPrefixRequest(r io.Reader) (*PrefixRequest, error) {
+	data, err := readFrame(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 35 {
+		return nil, fmt.Errorf("%w: expected at least 35, got %d", ErrBadLength, len(data))
+	}
+	if !isPrefixCommand(data[0]) {
+		return nil, fmt.Errorf("invalid prefix command: %d", data[0])
+	}
+
+	req := &PrefixRequest{Cmd: data[0]}
+	copy(req.RequestID[:], data[1:17])
+	copy(req.OwnerID[:], data[17:33])
+	body := bytes.NewReader(data[33:])
+	if req.Prefix, err = readString(body); err != nil {
+		return nil, fmt.Errorf("%w: prefix: %v", ErrBadLength, err)
+	}
+	if err := readFrameMAC(body, &req.MAC); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// isPrefixCommand reports whether cmd is sent in a PrefixRequest frame
+func isPrefixCommand(cmd uint8) bool {
+	return cmd == RELEASE_BY_PREFIX || cmd == COUNT_BY_PREFIX
+}
+
+// WritePrefixResponse answers a RELEASE_BY_PREFIX with how many locks were released, or a
+// COUNT_BY_PREFIX with how many are held.
+//
+//	| u32 length | u8 status | u32 count |
+func WritePrefixResponse(w io.Writer, status clutcherrors.StatusCode, count uint32) error {
+	var body [5]byte
+	body[0] = byte(status)
+	binary.BigEndian.PutUint32(body[1:5], count)
+	return writeFrame(w, body[:])
+}
+
+// ReadPrefixResponse reads a response written by WritePrefixResponse
+func ReadPrefixResponse(r io.Reader) (clutcherrors.StatusCode, uint32, error) {
+	data, err := readExtensibleResponse(r, 5)
+	if err != nil {
+		return 0, 0, err
+	}
+	return clutcherrors.StatusCode(data[0]), binary.BigEndian.Uint32(data[1:5]), nil
+}
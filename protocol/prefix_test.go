@@ -0,0 +1,42 @@
+package protocol
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/mrdhat/clutchdb/clutcherrors"
+)
+
+func TestPrefixRoundTrip(t *testing.T) {
+	req := &PrefixRequest{Cmd: RELEASE_BY_PREFIX, RequestID: uuid.New(), OwnerID: [16]byte{'o', 'w', 'n'}, Prefix: "tenant/42/"}
+	var buf bytes.Buffer
+	if err := WritePrefixRequest(&buf, req); err != nil {
+		t.Fatalf("WritePrefixRequest failed: %v", err)
+	}
+	decoded, err := ReadPrefixRequest(&buf)
+	if err != nil {
+		t.Fatalf("ReadPrefixRequest failed: %v", err)
+	}
+	if *decoded != *req {
+		t.Errorf("Expected %+v, got %+v", req, decoded)
+	}
+
+	if err := WritePrefixResponse(&buf, clutcherrors.STATUS_SUCCESS, 7); err != nil {
+		t.Fatalf("WritePrefixResponse failed: %v", err)
+	}
+	status, count, err := ReadPrefixResponse(&buf)
+	if err != nil {
+		t.Fatalf("ReadPrefixResponse failed: %v", err)
+	}
+	if status != clutcherrors.STATUS_SUCCESS || count != 7 {
+		t.Errorf("Expected status %d and count 7, got %d and %d", clutcherrors.STATUS_SUCCESS, status, count)
+	}
+}
+
+func TestPrefixRequestWrongCommand(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WritePrefixRequest(&buf, &PrefixRequest{Cmd: ACQUIRE, Prefix: "a/"}); err == nil {
+		t.Errorf("Expected an error writing an ACQUIRE as a prefix request")
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
+	"net"
+	"sync"
 
 	"github.com/mrdhat/clutchdb/clutcherrors"
 )
@@ -13,100 +15,320 @@ const (
 	ACQUIRE = 1 // Acquire lock
 	RENEW   = 2 // Renew lock
 	RELEASE = 3 // Release lock
+	BUMP    = 4 // Rotate fencing token and reset TTL of a held lock
+	BATCH   = 5 // Execute several requests in one round-trip
+	HELLO   = 6 // Negotiate a protocol version, first frame on a connection only
+
+	// Admin commands, refused unless the server enables them
+	GET_FENCING_COUNTER     = 7 // Report the last fencing token issued for a lock
+	ADVANCE_FENCING_COUNTER = 8 // Raise a lock's fencing counter to FencingToken, never lower it
+
+	RENEW_ALL    = 9  // Extend every lock an owner holds in one round-trip
+	ACQUIRE_WAIT = 10 // Acquire, queueing until the lock frees if no more than FencingToken callers are waiting
+
+	GET_LOCK_INFO_MULTI = 11 // Report the current hold on each of a list of locks
+	CHECK_ACQUIRE       = 12 // Acquire, reporting whether an expired hold had to be reclaimed
+	SERVER_INFO         = 13 // Report the server's configured limits
+	SERVER_STATS        = 14 // Report the server's live counters
+	LIST_OWNERS         = 15 // Report how many live locks each owner holds
+	RELEASE_BY_PREFIX   = 16 // Release every lock an owner holds under an ID prefix
+	COUNT_BY_PREFIX     = 17 // Report how many live locks there are under an ID prefix
+	COMPARE_AND_RENEW   = 18 // Renew, but only if less than ThresholdMS of the hold remains
+	WATCH               = 19 // Wait up to TTLMS, or indefinitely if zero, for a lock to be free
+	TOMBSTONES          = 20 // Report recently released locks (admin)
 )
 
+// requestLength is the number of request bytes following the length field
+const requestLength = 81
+
 // Request represents the wire protocol request
 type Request struct {
-	Cmd       uint8    // Command type (ACQUIRE, RENEW, RELEASE)
-	RequestID [16]byte // Unique request identifier
-	LockID    [16]byte // Lock identifier
-	OwnerID   [16]byte // Owner/client identifier
-	TTLMS     uint64   // Time-to-live in milliseconds (used by ACQUIRE and RENEW)
+	Cmd          uint8           // Command type (ACQUIRE, RENEW, RELEASE, BUMP)
+	RequestID    [16]byte        // Unique request identifier
+	LockID       [16]byte        // Lock identifier
+	OwnerID      [16]byte        // Owner/client identifier
+	TTLMS        uint64          // Time-to-live in milliseconds (used by ACQUIRE, RENEW and BUMP)
+	FencingToken uint64          // Fencing token of the current hold (used by RENEW, RELEASE and BUMP), max queue depth for ACQUIRE_WAIT
+	NewOwnerID   [16]byte        // Owner to hand the lock to on success (RENEW only, zero for none)
+	Priority     uint32          // Place in the wait queue, higher first (ACQUIRE_WAIT only, sent in NewOwnerID's place)
+	ThresholdMS  uint64          // Renew only with less than this left in milliseconds (COMPARE_AND_RENEW only, sent in NewOwnerID's place)
+	Value        [16]byte        // Value to attach to the hold, such as the holder's address (ACQUIRE only, sent in NewOwnerID's place)
+	MAC          [MACLength]byte // HMAC of the fields above under a shared secret, zero if unsigned; see SignRequest
+
+	// Frame is the encoded body of the request in its own frame layout, such as RENEW_ALL, that
+	// this request stands for in the server's middleware chain; see RenewAllRequest.Envelope.
+	// It is never written to the wire, and empty for a request in the standard layout. It is a
+	// string so that requests stay comparable.
+	Frame string
 }
 
 // Response represents the wire protocol response
 type Response struct {
 	Status       clutcherrors.StatusCode // Response status code
-	FencingToken uint64                  // Fencing token (used by ACQUIRE and RENEW)
-	ExpiresAt    uint64                  // Expiration timestamp in milliseconds (used by ACQUIRE and RENEW)
+	FencingToken uint64                  // Fencing token (used by ACQUIRE, RENEW and BUMP)
+	ExpiresAt    uint64                  // Expiration timestamp in milliseconds (used by ACQUIRE, RENEW and BUMP)
+	LeaderHint   string                  // Address of the current leader, if known (with STATUS_NOT_LEADER)
+	RequestID    [16]byte                // ID of the request answered (protocol version 2 and later, zero otherwise)
+	Message      string                  // Human-readable failure reason, if the server sends them
+}
+
+// RequestFrameSize is the size of an encoded request, including the length prefix. A signed
+// request is followed by its MAC, MACLength more bytes in the same frame.
+const RequestFrameSize = 4 + requestLength
+
+// requestBufPool recycles frame buffers for WriteRequest and ReadRequest
+var requestBufPool = sync.Pool{
+	New: func() any { return new([RequestFrameSize]byte) },
 }
 
 // WriteRequest encodes a Request to the wire format and writes it to w
 func WriteRequest(w io.Writer, req *Request) error {
-	var buf [61]byte
+	buf := requestBufPool.Get().(*[RequestFrameSize]byte)
+	defer requestBufPool.Put(buf)
+	return WriteRequestTo(w, req, buf)
+}
 
-	binary.BigEndian.PutUint32(buf[0:4], 57)
+// WriteRequestTo is like WriteRequest but encodes into a caller-owned buffer, avoiding per-call allocation
+func WriteRequestTo(w io.Writer, req *Request, buf *[RequestFrameSize]byte) error {
+	encodeRequest(buf, req)
+	if !req.signed() {
+		_, err := w.Write(buf[:])
+		return err
+	}
+	// Gathered so a signed request still goes out in one write
+	frame := net.Buffers{buf[:], req.MAC[:]}
+	_, err := frame.WriteTo(w)
+	return err
+}
+
+// encodeRequest encodes req, including the length prefix, into buf. A signed request's MAC
+// follows buf on the wire and is not written to it.
+func encodeRequest(buf *[RequestFrameSize]byte, req *Request) {
+	length := uint32(requestLength)
+	if req.signed() {
+		length = signedRequestLength
+	}
+	binary.BigEndian.PutUint32(buf[0:4], length)
 	buf[4] = req.Cmd
 	copy(buf[5:21], req.RequestID[:])
 	copy(buf[21:37], req.LockID[:])
 	copy(buf[37:53], req.OwnerID[:])
 	binary.BigEndian.PutUint64(buf[53:61], req.TTLMS)
-
-	_, err := w.Write(buf[:])
-	return err
+	binary.BigEndian.PutUint64(buf[61:69], req.FencingToken)
+	switch req.Cmd {
+	case ACQUIRE_WAIT:
+		// ACQUIRE_WAIT never hands off, so its priority travels in the new owner slot
+		binary.BigEndian.PutUint32(buf[69:73], req.Priority)
+		clear(buf[73:85])
+	case COMPARE_AND_RENEW:
+		// As does COMPARE_AND_RENEW's threshold
+		binary.BigEndian.PutUint64(buf[69:77], req.ThresholdMS)
+		clear(buf[77:85])
+	case ACQUIRE:
+		// And ACQUIRE's value
+		copy(buf[69:85], req.Value[:])
+	default:
+		copy(buf[69:85], req.NewOwnerID[:])
+	}
 }
 
 // ReadRequest reads from r and decodes into a Request
 func ReadRequest(r io.Reader) (*Request, error) {
-	var length uint32
-	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+	buf := requestBufPool.Get().(*[RequestFrameSize]byte)
+	defer requestBufPool.Put(buf)
+
+	req := &Request{}
+	if err := ReadRequestFrom(r, req, buf); err != nil {
 		return nil, err
 	}
-	if length != 57 {
-		return nil, fmt.Errorf("invalid request length: expected 57, got %d", length)
+	return req, nil
+}
+
+// ReadRequestFrom is like ReadRequest but decodes into a caller-owned Request using a
+// caller-owned buffer, avoiding per-call allocation
+func ReadRequestFrom(r io.Reader, req *Request, buf *[RequestFrameSize]byte) error {
+	if _, err := io.ReadFull(r, buf[0:4]); err != nil {
+		return frameReadError(err, true)
+	}
+	length := binary.BigEndian.Uint32(buf[0:4])
+	if err := checkRequestLength(length); err != nil {
+		return err
 	}
 
-	var data [57]byte
-	if _, err := io.ReadFull(r, data[:]); err != nil {
-		return nil, err
+	data := buf[4:]
+	if _, err := io.ReadFull(r, data); err != nil {
+		return frameReadError(err, false)
+	}
+	decodeRequestBody(req, data)
+
+	req.MAC = [MACLength]byte{}
+	if length == signedRequestLength {
+		if _, err := io.ReadFull(r, req.MAC[:]); err != nil {
+			return frameReadError(err, false)
+		}
+	}
+	return nil
+}
+
+// checkRequestLength returns ErrBadLength unless length is that of an unsigned or signed request
+func checkRequestLength(length uint32) error {
+	if length != requestLength && length != signedRequestLength {
+		return fmt.Errorf("%w: expected %d or %d, got %d", ErrBadLength, requestLength, signedRequestLength, length)
+	}
+	return nil
+}
+
+// DecodeRequest decodes the first request frame in data and returns it with the number
+// of bytes consumed, so callers can walk a buffer holding several frames back to back.
+// An incomplete frame returns io.ErrUnexpectedEOF.
+func DecodeRequest(data []byte) (*Request, int, error) {
+	if len(data) < 4 {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+	length := binary.BigEndian.Uint32(data[0:4])
+	if err := checkRequestLength(length); err != nil {
+		return nil, 0, err
+	}
+	n := 4 + int(length)
+	if len(data) < n {
+		return nil, 0, io.ErrUnexpectedEOF
 	}
 
-	cmd := data[0]
-	var requestID [16]byte
-	copy(requestID[:], data[1:17])
-	var lockID [16]byte
-	copy(lockID[:], data[17:33])
-	var ownerID [16]byte
-	copy(ownerID[:], data[33:49])
-	ttlMS := binary.BigEndian.Uint64(data[49:57])
-
-	return &Request{
-		Cmd:       cmd,
-		RequestID: requestID,
-		LockID:    lockID,
-		OwnerID:   ownerID,
-		TTLMS:     ttlMS,
-	}, nil
+	req := &Request{}
+	decodeRequestBody(req, data[4:RequestFrameSize])
+	copy(req.MAC[:], data[RequestFrameSize:n])
+	return req, n, nil
 }
 
-// WriteResponse encodes a Response to the wire format and writes it to w
+// decodeRequestBody fills req from the request bytes following the length field
+func decodeRequestBody(req *Request, data []byte) {
+	req.Cmd = data[0]
+	copy(req.RequestID[:], data[1:17])
+	copy(req.LockID[:], data[17:33])
+	copy(req.OwnerID[:], data[33:49])
+	req.TTLMS = binary.BigEndian.Uint64(data[49:57])
+	req.FencingToken = binary.BigEndian.Uint64(data[57:65])
+	req.NewOwnerID, req.Priority, req.ThresholdMS, req.Value = [16]byte{}, 0, 0, [16]byte{}
+	switch req.Cmd {
+	case ACQUIRE_WAIT:
+		req.Priority = binary.BigEndian.Uint32(data[65:69])
+	case COMPARE_AND_RENEW:
+		req.ThresholdMS = binary.BigEndian.Uint64(data[65:73])
+	case ACQUIRE:
+		copy(req.Value[:], data[65:81])
+	default:
+		copy(req.NewOwnerID[:], data[65:81])
+	}
+}
+
+// responseLength is the number of response bytes following the length field that this
+// version writes. Readers accept longer frames and skip the fields they don't know.
+const responseLength = 17
+
+// maxResponseLength bounds the length prefix a reader will accept
+const maxResponseLength = 4096
+
+// MaxMessageLength is the longest Response.Message, in bytes, that WriteResponse accepts
+const MaxMessageLength = 512
+
+// WriteResponse encodes a Response to the wire format and writes it to w. The optional trailing
+// fields are only written up to the last one that is set, so most version 1 frames stay at
+// responseLength. Fields before a set one are padded: an empty hint or a zero request ID.
 func WriteResponse(w io.Writer, resp *Response) error {
-	var buf [17]byte
+	if len(resp.Message) > MaxMessageLength {
+		return fmt.Errorf("message too long: %d bytes, max %d", len(resp.Message), MaxMessageLength)
+	}
+	withMessage := resp.Message != ""
+	echo := resp.RequestID != [16]byte{} || withMessage
+	length := responseLength
+	if resp.LeaderHint != "" || echo {
+		length += 2 + len(resp.LeaderHint)
+		if length > maxResponseLength {
+			return fmt.Errorf("leader hint too long: %d bytes", len(resp.LeaderHint))
+		}
+	}
+	if echo {
+		length += 16
+	}
+	if withMessage {
+		length += 2 + len(resp.Message)
+		if length > maxResponseLength {
+			return fmt.Errorf("response too long: %d bytes", length)
+		}
+	}
+
+	var fixed [4 + responseLength]byte
+	buf := fixed[:]
+	if length > responseLength {
+		buf = make([]byte, 4+length)
+	}
 
-	buf[0] = byte(resp.Status)
-	binary.BigEndian.PutUint64(buf[1:9], resp.FencingToken)
-	binary.BigEndian.PutUint64(buf[9:17], resp.ExpiresAt)
+	binary.BigEndian.PutUint32(buf[0:4], uint32(length))
+	buf[4] = byte(resp.Status)
+	binary.BigEndian.PutUint64(buf[5:13], resp.FencingToken)
+	binary.BigEndian.PutUint64(buf[13:21], resp.ExpiresAt)
+	if length > responseLength {
+		binary.BigEndian.PutUint16(buf[21:23], uint16(len(resp.LeaderHint)))
+		n := 23 + copy(buf[23:], resp.LeaderHint)
+		if echo {
+			n += copy(buf[n:], resp.RequestID[:])
+		}
+		if withMessage {
+			binary.BigEndian.PutUint16(buf[n:n+2], uint16(len(resp.Message)))
+			copy(buf[n+2:], resp.Message)
+		}
+	}
 
-	_, err := w.Write(buf[:])
+	_, err := w.Write(buf)
 	return err
 }
 
-// ReadResponse reads from r and decodes into a Response
+// ReadResponse reads from r and decodes into a Response. Trailing fields added by newer
+// servers are read and discarded so the stream stays aligned on the next frame.
 func ReadResponse(r io.Reader) (*Response, error) {
-	var buf [17]byte
-	if _, err := io.ReadFull(r, buf[:]); err != nil {
-		return nil, err
+	var buf [4 + responseLength]byte
+	if _, err := io.ReadFull(r, buf[0:4]); err != nil {
+		return nil, frameReadError(err, true)
+	}
+	length := binary.BigEndian.Uint32(buf[0:4])
+	if length < responseLength || length > maxResponseLength {
+		return nil, fmt.Errorf("%w: expected %d to %d, got %d", ErrBadLength, responseLength, maxResponseLength, length)
 	}
 
-	status := clutcherrors.StatusCode(buf[0])
-	fencingToken := binary.BigEndian.Uint64(buf[1:9])
-	expiresAt := binary.BigEndian.Uint64(buf[9:17])
+	if _, err := io.ReadFull(r, buf[4:]); err != nil {
+		return nil, frameReadError(err, false)
+	}
+	resp := &Response{
+		Status:       clutcherrors.StatusCode(buf[4]),
+		FencingToken: binary.BigEndian.Uint64(buf[5:13]),
+		ExpiresAt:    binary.BigEndian.Uint64(buf[13:21]),
+	}
+
+	extra := make([]byte, length-responseLength)
+	if _, err := io.ReadFull(r, extra); err != nil {
+		return nil, frameReadError(err, false)
+	}
+	// u16 leader_hint_len | leader_hint | u128 request_id | u16 message_len | message, then
+	// anything newer, which is skipped
+	if len(extra) >= 2 {
+		hintLen := int(binary.BigEndian.Uint16(extra[0:2]))
+		if 2+hintLen > len(extra) {
+			return nil, fmt.Errorf("%w: leader hint of %d bytes overruns frame", ErrBadLength, hintLen)
+		}
+		resp.LeaderHint = string(extra[2 : 2+hintLen])
+		if rest := extra[2+hintLen:]; len(rest) >= 16 {
+			copy(resp.RequestID[:], rest[:16])
+			if rest = rest[16:]; len(rest) >= 2 {
+				msgLen := int(binary.BigEndian.Uint16(rest[0:2]))
+				if 2+msgLen > len(rest) {
+					return nil, fmt.Errorf("%w: message of %d bytes overruns frame", ErrBadLength, msgLen)
+				}
+				resp.Message = string(rest[2 : 2+msgLen])
+			}
+		}
+	}
 
-	return &Response{
-		Status:       status,
-		FencingToken: fencingToken,
-		ExpiresAt:    expiresAt,
-	}, nil
+	return resp, nil
 }
 
 // ReadRequestOrErrorResponse attempts to read a Request, returning an error Response if malformed
@@ -3,6 +3,9 @@ package protocol
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
 	"testing"
 	"time"
 
@@ -58,6 +61,198 @@ func TestRequestRoundTrip(t *testing.T) {
 	}
 }
 
+func TestRequestFencingToken(t *testing.T) {
+	original := &Request{Cmd: RENEW, TTLMS: 1000, FencingToken: 42, NewOwnerID: uuid.New()}
+	copy(original.LockID[:], "mylock")
+
+	var buf bytes.Buffer
+	if err := WriteRequest(&buf, original); err != nil {
+		t.Fatalf("WriteRequest failed: %v", err)
+	}
+	decoded, err := ReadRequest(&buf)
+	if err != nil {
+		t.Fatalf("ReadRequest failed: %v", err)
+	}
+	if decoded.FencingToken != original.FencingToken {
+		t.Errorf("FencingToken mismatch: got %d, want %d", decoded.FencingToken, original.FencingToken)
+	}
+	if decoded.NewOwnerID != original.NewOwnerID {
+		t.Errorf("NewOwnerID mismatch")
+	}
+}
+
+func TestRequestPriority(t *testing.T) {
+	original := &Request{Cmd: ACQUIRE_WAIT, TTLMS: 1000, FencingToken: 3, Priority: 7}
+	copy(original.LockID[:], "mylock")
+
+	var buf bytes.Buffer
+	if err := WriteRequest(&buf, original); err != nil {
+		t.Fatalf("WriteRequest failed: %v", err)
+	}
+	decoded, err := ReadRequest(&buf)
+	if err != nil {
+		t.Fatalf("ReadRequest failed: %v", err)
+	}
+	if *decoded != *original {
+		t.Errorf("decoded %+v, want %+v", decoded, original)
+	}
+
+	// Other commands don't carry a priority, even when it is set
+	original.Cmd = ACQUIRE
+	buf.Reset()
+	if err := WriteRequest(&buf, original); err != nil {
+		t.Fatalf("WriteRequest failed: %v", err)
+	}
+	decoded, err = ReadRequest(&buf)
+	if err != nil {
+		t.Fatalf("ReadRequest failed: %v", err)
+	}
+	if decoded.Priority != 0 || decoded.NewOwnerID != ([16]byte{}) {
+		t.Errorf("ACQUIRE decoded with priority %d and new owner %x", decoded.Priority, decoded.NewOwnerID)
+	}
+}
+
+func TestRequestThreshold(t *testing.T) {
+	original := &Request{Cmd: COMPARE_AND_RENEW, TTLMS: 1000, FencingToken: 3, ThresholdMS: 250}
+	copy(original.LockID[:], "mylock")
+
+	var buf bytes.Buffer
+	if err := WriteRequest(&buf, original); err != nil {
+		t.Fatalf("WriteRequest failed: %v", err)
+	}
+	decoded, err := ReadRequest(&buf)
+	if err != nil {
+		t.Fatalf("ReadRequest failed: %v", err)
+	}
+	if *decoded != *original {
+		t.Errorf("decoded %+v, want %+v", decoded, original)
+	}
+
+	// Other commands don't carry a threshold, even when it is set
+	original.Cmd = RENEW
+	buf.Reset()
+	if err := WriteRequest(&buf, original); err != nil {
+		t.Fatalf("WriteRequest failed: %v", err)
+	}
+	decoded, err = ReadRequest(&buf)
+	if err != nil {
+		t.Fatalf("ReadRequest failed: %v", err)
+	}
+	if decoded.ThresholdMS != 0 || decoded.NewOwnerID != ([16]byte{}) {
+		t.Errorf("RENEW decoded with threshold %d and new owner %x", decoded.ThresholdMS, decoded.NewOwnerID)
+	}
+}
+
+func TestRequestValue(t *testing.T) {
+	original := &Request{Cmd: ACQUIRE, TTLMS: 1000}
+	copy(original.LockID[:], "leader")
+	copy(original.Value[:], "10.0.0.7:7000")
+
+	var buf bytes.Buffer
+	if err := WriteRequest(&buf, original); err != nil {
+		t.Fatalf("WriteRequest failed: %v", err)
+	}
+	decoded, err := ReadRequest(&buf)
+	if err != nil {
+		t.Fatalf("ReadRequest failed: %v", err)
+	}
+	if *decoded != *original {
+		t.Errorf("decoded %+v, want %+v", decoded, original)
+	}
+
+	// Other commands don't carry a value, even when it is set
+	original.Cmd = CHECK_ACQUIRE
+	buf.Reset()
+	if err := WriteRequest(&buf, original); err != nil {
+		t.Fatalf("WriteRequest failed: %v", err)
+	}
+	decoded, err = ReadRequest(&buf)
+	if err != nil {
+		t.Fatalf("ReadRequest failed: %v", err)
+	}
+	if decoded.Value != ([16]byte{}) || decoded.NewOwnerID != ([16]byte{}) {
+		t.Errorf("CHECK_ACQUIRE decoded with value %x and new owner %x", decoded.Value, decoded.NewOwnerID)
+	}
+}
+
+func TestRequestBufferReuse(t *testing.T) {
+	var writeBuf, readBuf [RequestFrameSize]byte
+	var decoded Request
+
+	for i := 0; i < 3; i++ {
+		original := &Request{
+			Cmd:          RENEW,
+			RequestID:    uuid.New(),
+			OwnerID:      uuid.New(),
+			TTLMS:        uint64(1000 * (i + 1)),
+			FencingToken: uint64(i + 1),
+		}
+		copy(original.LockID[:], "mylock")
+
+		var buf bytes.Buffer
+		if err := WriteRequestTo(&buf, original, &writeBuf); err != nil {
+			t.Fatalf("WriteRequestTo failed: %v", err)
+		}
+		if buf.Len() != RequestFrameSize {
+			t.Fatalf("Expected %d bytes, got %d", RequestFrameSize, buf.Len())
+		}
+
+		// Reusing the same Request must fully overwrite the previous decode
+		if err := ReadRequestFrom(&buf, &decoded, &readBuf); err != nil {
+			t.Fatalf("ReadRequestFrom failed: %v", err)
+		}
+		if decoded != *original {
+			t.Errorf("Round trip %d mismatch: got %+v, want %+v", i, decoded, *original)
+		}
+	}
+}
+
+func TestDecodeRequestConcatenated(t *testing.T) {
+	cmds := []uint8{ACQUIRE, RENEW, RELEASE}
+
+	var buf bytes.Buffer
+	for i, cmd := range cmds {
+		req := &Request{Cmd: cmd, RequestID: uuid.New(), TTLMS: uint64(i * 100), FencingToken: uint64(i)}
+		copy(req.LockID[:], "lock1")
+		if err := WriteRequest(&buf, req); err != nil {
+			t.Fatalf("WriteRequest failed: %v", err)
+		}
+	}
+
+	data := buf.Bytes()
+	offset := 0
+	for i, cmd := range cmds {
+		req, n, err := DecodeRequest(data[offset:])
+		if err != nil {
+			t.Fatalf("DecodeRequest %d failed: %v", i, err)
+		}
+		if n != RequestFrameSize {
+			t.Errorf("Expected %d bytes consumed, got %d", RequestFrameSize, n)
+		}
+		if req.Cmd != cmd || req.FencingToken != uint64(i) {
+			t.Errorf("Request %d mismatch: %+v", i, req)
+		}
+		offset += n
+	}
+	if offset != len(data) {
+		t.Errorf("Expected to consume all %d bytes, consumed %d", len(data), offset)
+	}
+}
+
+func TestDecodeRequestIncomplete(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteRequest(&buf, &Request{Cmd: ACQUIRE}); err != nil {
+		t.Fatalf("WriteRequest failed: %v", err)
+	}
+	data := buf.Bytes()
+
+	for _, size := range []int{0, 3, RequestFrameSize - 1} {
+		if _, n, err := DecodeRequest(data[:size]); err != io.ErrUnexpectedEOF || n != 0 {
+			t.Errorf("DecodeRequest of %d bytes: expected (0, ErrUnexpectedEOF), got (%d, %v)", size, n, err)
+		}
+	}
+}
+
 func TestResponseRoundTrip(t *testing.T) {
 	// Test round-trip encoding/decoding for Response
 	expiresAt := time.Now().UnixMilli() + 10000 // 10 seconds from now in milliseconds
@@ -89,6 +284,135 @@ func TestResponseRoundTrip(t *testing.T) {
 	}
 }
 
+func TestResponseExtendedFrame(t *testing.T) {
+	// A newer server appends fields after expires_at; they must be skipped, not left in the stream
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, uint32(responseLength+12))
+	buf.WriteByte(byte(clutcherrors.STATUS_LOCK_HELD))
+	binary.Write(&buf, binary.BigEndian, uint64(7))
+	binary.Write(&buf, binary.BigEndian, uint64(1000))
+	binary.Write(&buf, binary.BigEndian, uint64(500)) // e.g. remaining_ms
+	binary.Write(&buf, binary.BigEndian, uint32(9))   // e.g. something newer still
+
+	next := &Response{Status: clutcherrors.STATUS_SUCCESS, FencingToken: 8, ExpiresAt: 2000}
+	if err := WriteResponse(&buf, next); err != nil {
+		t.Fatalf("WriteResponse failed: %v", err)
+	}
+
+	first, err := ReadResponse(&buf)
+	if err != nil {
+		t.Fatalf("ReadResponse of extended frame failed: %v", err)
+	}
+	if first.Status != clutcherrors.STATUS_LOCK_HELD || first.FencingToken != 7 || first.ExpiresAt != 1000 {
+		t.Errorf("Extended response mismatch: %+v", first)
+	}
+
+	second, err := ReadResponse(&buf)
+	if err != nil {
+		t.Fatalf("ReadResponse after extended frame failed: %v", err)
+	}
+	if *second != *next {
+		t.Errorf("Expected %+v after extended frame, got %+v", next, second)
+	}
+}
+
+func TestResponseLeaderHint(t *testing.T) {
+	var buf bytes.Buffer
+	redirect := &Response{Status: clutcherrors.STATUS_NOT_LEADER, LeaderHint: "10.0.0.7:7000"}
+	if err := WriteResponse(&buf, redirect); err != nil {
+		t.Fatalf("WriteResponse failed: %v", err)
+	}
+	// Without a hint the frame keeps its original size
+	plain := &Response{Status: clutcherrors.STATUS_NOT_LEADER}
+	if err := WriteResponse(&buf, plain); err != nil {
+		t.Fatalf("WriteResponse failed: %v", err)
+	}
+	if want := 2*(4+responseLength) + 2 + len(redirect.LeaderHint); buf.Len() != want {
+		t.Errorf("Expected %d bytes, got %d", want, buf.Len())
+	}
+
+	for _, want := range []*Response{redirect, plain} {
+		got, err := ReadResponse(&buf)
+		if err != nil {
+			t.Fatalf("ReadResponse failed: %v", err)
+		}
+		if *got != *want {
+			t.Errorf("Expected %+v, got %+v", want, got)
+		}
+	}
+
+	// A hint that runs past the frame is rejected
+	buf.Reset()
+	binary.Write(&buf, binary.BigEndian, uint32(responseLength+4))
+	buf.Write(make([]byte, responseLength))
+	binary.Write(&buf, binary.BigEndian, uint16(10))
+	buf.Write([]byte("ab"))
+	if _, err := ReadResponse(&buf); !errors.Is(err, ErrBadLength) {
+		t.Errorf("Expected ErrBadLength, got %v", err)
+	}
+}
+
+func TestResponseRequestIDEcho(t *testing.T) {
+	var buf bytes.Buffer
+	resps := []*Response{
+		{Status: clutcherrors.STATUS_SUCCESS, FencingToken: 3, RequestID: [16]byte{1, 2, 3}},
+		{Status: clutcherrors.STATUS_NOT_LEADER, LeaderHint: "10.0.0.7:7000", RequestID: [16]byte{4}},
+	}
+	for _, resp := range resps {
+		if err := WriteResponse(&buf, resp); err != nil {
+			t.Fatalf("WriteResponse failed: %v", err)
+		}
+	}
+
+	for _, want := range resps {
+		got, err := ReadResponse(&buf)
+		if err != nil {
+			t.Fatalf("ReadResponse failed: %v", err)
+		}
+		if *got != *want {
+			t.Errorf("Expected %+v, got %+v", want, got)
+		}
+	}
+}
+
+func TestResponseMessage(t *testing.T) {
+	var buf bytes.Buffer
+	resps := []*Response{
+		{Status: clutcherrors.STATUS_LOCK_NOT_HELD, Message: "owner mismatch"},
+		{Status: clutcherrors.STATUS_NOT_LEADER, LeaderHint: "10.0.0.7:7000", RequestID: [16]byte{4}, Message: "not leader"},
+		{Status: clutcherrors.STATUS_LOCK_NOT_HELD, Message: "lock expired 43ms ago \u2014 retry"},
+	}
+	for _, resp := range resps {
+		if err := WriteResponse(&buf, resp); err != nil {
+			t.Fatalf("WriteResponse failed: %v", err)
+		}
+	}
+	for _, want := range resps {
+		got, err := ReadResponse(&buf)
+		if err != nil {
+			t.Fatalf("ReadResponse failed: %v", err)
+		}
+		if *got != *want {
+			t.Errorf("Expected %+v, got %+v", want, got)
+		}
+	}
+
+	if err := WriteResponse(&buf, &Response{Message: strings.Repeat("x", MaxMessageLength+1)}); err == nil {
+		t.Error("Expected an oversized message to be rejected")
+	}
+}
+
+func TestResponseBadLength(t *testing.T) {
+	for _, length := range []uint32{0, responseLength - 1, maxResponseLength + 1} {
+		var buf bytes.Buffer
+		binary.Write(&buf, binary.BigEndian, length)
+		buf.Write(make([]byte, responseLength))
+		if _, err := ReadResponse(&buf); !errors.Is(err, ErrBadLength) {
+			t.Errorf("Length %d: expected ErrBadLength, got %v", length, err)
+		}
+	}
+}
+
 func TestAllCommands(t *testing.T) {
 	// Test that different commands can be encoded/decoded with appropriate inputs
 	testCases := []struct {
@@ -99,6 +423,7 @@ func TestAllCommands(t *testing.T) {
 		{"ACQUIRE", ACQUIRE, 1000}, // ACQUIRE requires TTLMS
 		{"RENEW", RENEW, 2000},     // RENEW requires TTLMS
 		{"RELEASE", RELEASE, 0},    // RELEASE ignores TTLMS (set to 0)
+		{"BUMP", BUMP, 3000},       // BUMP requires TTLMS
 	}
 
 	for _, tc := range testCases {
@@ -0,0 +1,195 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/mrdhat/clutchdb/clutcherrors"
+)
+
+// RenewAllRequest extends the locks held by OwnerID
+type RenewAllRequest struct {
+	RequestID [16]byte
+	OwnerID   [16]byte
+	TTLMS     uint64
+	Tokens    []LockToken     // locks to renew and the tokens the client expects; empty renews every lock held
+	MAC       [MACLength]byte // MAC of the envelope under a shared secret, zero if unsigned; see Envelope
+}
+
+// LockToken names one hold of a lock
+type LockToken struct {
+	LockID       string
+	FencingToken uint64
+}
+
+// RenewResult is the outcome of renewing one lock in a RENEW_ALL
+type RenewResult struct {
+	LockID       string
+	Status       clutcherrors.StatusCode
+	FencingToken uint64 // Fencing token of the hold (on success)
+	ExpiresAt    uint64 // New expiration timestamp in milliseconds (on success)
+}
+
+// maxListFrameLength bounds the length prefix of RENEW_ALL, GET_LOCK_INFO_MULTI, LIST_OWNERS and
+// TOMBSTONES frames a reader will accept
+const maxListFrameLength = 1 << 20
+
+// WriteRenewAllRequest encodes req as a RENEW_ALL frame and writes it to w.
+//
+//	| u32 length | u8 cmd = RENEW_ALL | u128 request_id | u128 owner_id | u64 ttl_ms | u32 count | count × ( u16 lock_id_len | lock_id | u64 fencing_token ) | [mac] |
+//
+// The MAC is only written for a signed request.
+func WriteRenewAllRequest(w io.Writer, req *RenewAllRequest) error {
+	body, err := req.encode()
+	if err != nil {
+		return err
+	}
+	return writeFrame(w, appendFrameMAC(body, req.MAC))
+}
+
+// encode returns req's frame body, everything after the length prefix bar the MAC
+func (req *RenewAllRequest) encode() ([]byte, error) {
+	if len(req.Tokens) > MaxBatchSize {
+		return nil, fmt.Errorf("too many locks to renew: %d, max %d", len(req.Tokens), MaxBatchSize)
+	}
+
+	body := new(bytes.Buffer)
+	body.WriteByte(RENEW_ALL)
+	body.Write(req.RequestID[:])
+	body.Write(req.OwnerID[:])
+	binary.Write(body, binary.BigEndian, req.TTLMS)
+	binary.Write(body, binary.BigEndian, uint32(len(req.Tokens)))
+	for _, token := range req.Tokens {
+		if err := writeString(body, token.LockID); err != nil {
+			return nil, fmt.Errorf("lock id: %w", err)
+		}
+		binary.Write(body, binary.BigEndian, token.FencingToken)
+	}
+	return body.Bytes(), nil
+}
+
+// Envelope returns the standard request standing for req in a server's middleware chain: a
+// RENEW_ALL with req's request ID, owner, TTL and MAC, and req's encoded body as its Frame.
+// Frame is empty if req can't be encoded. To sign req, sign its envelope with SignRequest and
+// copy the envelope's MAC to req.
+func (req *RenewAllRequest) Envelope() *Request {
+	body, _ := req.encode()
+	return &Request{Cmd: RENEW_ALL, RequestID: req.RequestID, OwnerID: req.OwnerID, TTLMS: req.TTLMS, MAC: req.MAC, Frame: string(body)}
+}
+
+// ReadRenewAllRequest reads a RENEW_ALL frame from r
+func ReadRenewAllRequest(r io.Reader) (*RenewAllRequest, error) {
+	data, err := readFrame(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 45 {
+		return nil, fmt.Errorf("%w: expected at least 45, got %d", ErrBadLength, len(data))
+	}
+	if data[0] != RENEW_ALL {
+		return nil, fmt.Errorf("invalid renew all command: %d", data[0])
+	}
+
+	req := &RenewAllRequest{TTLMS: binary.BigEndian.Uint64(data[33:41])}
+	copy(req.RequestID[:], data[1:17])
+	copy(req.OwnerID[:], data[17:33])
+	count := binary.BigEndian.Uint32(data[41:45])
+	if count > MaxBatchSize {
+		return nil, fmt.Errorf("too many locks to renew: %d, max %d", count, MaxBatchSize)
+	}
+
+	body := bytes.NewReader(data[45:])
+	for i := uint32(0); i < count; i++ {
+		var token LockToken
+		if token.LockID, err = readString(body); err != nil {
+			return nil, fmt.Errorf("%w: lock %d: %v", ErrBadLength, i, err)
+		}
+		if err := binary.Read(body, binary.BigEndian, &token.FencingToken); err != nil {
+			return nil, fmt.Errorf("%w: lock %d: %v", ErrBadLength, i, err)
+		}
+		req.Tokens = append(req.Tokens, token)
+	}
+	if err := readFrameMAC(body, &req.MAC); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// WriteRenewAllResponse writes the answer to a RENEW_ALL: an overall status, then one result
+// per lock.
+//
+//	| u32 length | u8 status | u32 count | count × ( u16 lock_id_len | lock_id | u8 status | u64 fencing_token | u64 expires_at ) |
+func WriteRenewAllResponse(w io.Writer, status clutcherrors.StatusCode, results []RenewResult) error {
+	body := new(bytes.Buffer)
+	body.WriteByte(byte(status))
+	binary.Write(body, binary.BigEndian, uint32(len(results)))
+	for _, result := range results {
+		if err := writeString(body, result.LockID); err != nil {
+			return fmt.Errorf("lock id: %w", err)
+		}
+		body.WriteByte(byte(result.Status))
+		binary.Write(body, binary.BigEndian, result.FencingToken)
+		binary.Write(body, binary.BigEndian, result.ExpiresAt)
+	}
+
+	return writeFrame(w, body.Bytes())
+}
+
+// ReadRenewAllResponse reads a response written by WriteRenewAllResponse
+func ReadRenewAllResponse(r io.Reader) (clutcherrors.StatusCode, []RenewResult, error) {
+	data, err := readFrame(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(data) < 5 {
+		return 0, nil, fmt.Errorf("%w: expected at least 5, got %d", ErrBadLength, len(data))
+	}
+
+	status := clutcherrors.StatusCode(data[0])
+	count := binary.BigEndian.Uint32(data[1:5])
+	body := bytes.NewReader(data[5:])
+
+	var results []RenewResult
+	for i := uint32(0); i < count; i++ {
+		var result RenewResult
+		if result.LockID, err = readString(body); err != nil {
+			return 0, nil, fmt.Errorf("%w: result %d: %v", ErrBadLength, i, err)
+		}
+		var fixed [17]byte
+		if _, err := io.ReadFull(body, fixed[:]); err != nil {
+			return 0, nil, fmt.Errorf("%w: result %d: %v", ErrBadLength, i, err)
+		}
+		result.Status = clutcherrors.StatusCode(fixed[0])
+		result.FencingToken = binary.BigEndian.Uint64(fixed[1:9])
+		result.ExpiresAt = binary.BigEndian.Uint64(fixed[9:17])
+		results = append(results, result)
+	}
+	return status, results, nil
+}
+
+// writeFrame writes body behind a u32 length prefix
+func writeFrame(w io.Writer, body []byte) error {
+	buf := make([]byte, 4, 4+len(body))
+	binary.BigEndian.PutUint32(buf, uint32(len(body)))
+	_, err := w.Write(append(buf, body...))
+	return err
+}
+
+// readFrame reads a u32 length prefix and the body it covers, up to maxListFrameLength bytes
+func readFrame(r io.Reader) ([]byte, error) {
+	var lengthBuf [4]byte
+	if _, err := io.ReadFull(r, lengthBuf[:]); err != nil {
+		return nil, frameReadError(err, true)
+	}
+	length := binary.BigEndian.Uint32(lengthBuf[:])
+	if length > maxListFrameLength {
+		return nil, fmt.Errorf("%w: expected at most %d, got %d", ErrBadLength, maxListFrameLength, length)
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, frameReadError(err, false)
+	}
+	return data, nil
+}
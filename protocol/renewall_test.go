@@ -0,0 +1,80 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/mrdhat/clutchdb/clutcherrors"
+)
+
+func TestRenewAllRequestRoundTrip(t *testing.T) {
+	for _, tokens := range [][]LockToken{nil, {{LockID: "lock1", FencingToken: 3}, {LockID: "lock2", FencingToken: 9}}} {
+		req := &RenewAllRequest{RequestID: uuid.New(), OwnerID: uuid.New(), TTLMS: 5000, Tokens: tokens}
+
+		var buf bytes.Buffer
+		if err := WriteRenewAllRequest(&buf, req); err != nil {
+			t.Fatalf("WriteRenewAllRequest failed: %v", err)
+		}
+		decoded, err := ReadRenewAllRequest(&buf)
+		if err != nil {
+			t.Fatalf("ReadRenewAllRequest failed: %v", err)
+		}
+		if !reflect.DeepEqual(decoded, req) {
+			t.Errorf("Expected %+v, got %+v", req, decoded)
+		}
+	}
+}
+
+func TestRenewAllEnvelope(t *testing.T) {
+	req := &RenewAllRequest{RequestID: uuid.New(), OwnerID: uuid.New(), TTLMS: 5000, Tokens: []LockToken{{LockID: "lock1", FencingToken: 3}}}
+	var buf bytes.Buffer
+	if err := WriteRenewAllRequest(&buf, req); err != nil {
+		t.Fatalf("WriteRenewAllRequest failed: %v", err)
+	}
+
+	env := req.Envelope()
+	if env.Cmd != RENEW_ALL || env.RequestID != req.RequestID || env.OwnerID != req.OwnerID || env.TTLMS != req.TTLMS {
+		t.Errorf("Expected the envelope to carry the request's fields, got %+v", env)
+	}
+	if env.Frame != string(buf.Bytes()[4:]) {
+		t.Errorf("Expected the envelope's frame to be the encoded body")
+	}
+}
+
+func TestRenewAllRequestBadLength(t *testing.T) {
+	var buf bytes.Buffer
+	req := &RenewAllRequest{Tokens: []LockToken{{LockID: "lock1", FencingToken: 3}}}
+	if err := WriteRenewAllRequest(&buf, req); err != nil {
+		t.Fatalf("WriteRenewAllRequest failed: %v", err)
+	}
+
+	// Claim one more lock than the frame holds
+	data := buf.Bytes()
+	binary.BigEndian.PutUint32(data[4+41:4+45], 2)
+	if _, err := ReadRenewAllRequest(bytes.NewReader(data)); !errors.Is(err, ErrBadLength) {
+		t.Errorf("Expected ErrBadLength, got %v", err)
+	}
+}
+
+func TestRenewAllResponseRoundTrip(t *testing.T) {
+	results := []RenewResult{
+		{LockID: "lock1", Status: clutcherrors.STATUS_SUCCESS, FencingToken: 3, ExpiresAt: 1000},
+		{LockID: "lock2", Status: clutcherrors.STATUS_LOCK_EXPIRED},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteRenewAllResponse(&buf, clutcherrors.STATUS_SUCCESS, results); err != nil {
+		t.Fatalf("WriteRenewAllResponse failed: %v", err)
+	}
+	status, decoded, err := ReadRenewAllResponse(&buf)
+	if err != nil {
+		t.Fatalf("ReadRenewAllResponse failed: %v", err)
+	}
+	if status != clutcherrors.STATUS_SUCCESS || !reflect.DeepEqual(decoded, results) {
+		t.Errorf("Expected %+v, got status %d %+v", results, status, decoded)
+	}
+}
@@ -0,0 +1,161 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/mrdhat/clutchdb/clutcherrors"
+)
+
+// ServerLimits are the limits a server enforces, so clients can validate requests before
+// sending them. A zero limit other than MaxTTLMS means none is configured.
+type ServerLimits struct {
+	MinTTLMS         uint64 // shortest TTL accepted
+	MaxTTLMS         uint64 // longest TTL accepted
+	MaxLocksPerOwner uint32 // live locks one owner may hold at once
+	MaxTotalLocks    uint32 // live locks the server holds at once
+	MaxConnections   uint32 // connections open at once
+	PipelineDepth    uint32 // requests a version 2 connection may have executing at once
+}
+
+// bareRequestLength is the number of bytes following the length field in a request that
+// carries nothing but its command and request ID, such as SERVER_INFO
+const bareRequestLength = 17
+
+// serverInfoResponseLength is the number of SERVER_INFO response bytes following the length
+// field that this version writes. Readers accept longer frames and skip limits they don't know.
+const serverInfoResponseLength = 33
+
+// WriteServerInfoRequest writes a SERVER_INFO frame.
+//
+//	| u32 length | u8 cmd = SERVER_INFO | u128 request_id |
+func WriteServerInfoRequest(w io.Writer, requestID [16]byte) error {
+	return writeBareRequest(w, SERVER_INFO, requestID)
+}
+
+// ReadServerInfoRequest reads a SERVER_INFO frame from r and returns its request ID
+func ReadServerInfoRequest(r io.Reader) ([16]byte, error) {
+	return readBareRequest(r, SERVER_INFO)
+}
+
+// ReportRequest is a request carrying nothing but its command, request ID and MAC: a
+// SERVER_INFO, SERVER_STATS, LIST_OWNERS or TOMBSTONES
+type ReportRequest struct {
+	Cmd       uint8
+	RequestID [16]byte
+	MAC       [MACLength]byte // MAC of the envelope under a shared secret, zero if unsigned; see Envelope
+}
+
+// Envelope returns the standard request standing for req in a server's middleware chain: a
+// request with req's command, request ID and MAC, and req's encoded body as its Frame. Sign req
+// as RenewAllRequest.Envelope describes.
+func (req *ReportRequest) Envelope() *Request {
+	body := append([]byte{req.Cmd}, req.RequestID[:]...)
+	return &Request{Cmd: req.Cmd, RequestID: req.RequestID, MAC: req.MAC, Frame: string(body)}
+}
+
+// WriteReportRequest writes req as a SERVER_INFO, SERVER_STATS, LIST_OWNERS or TOMBSTONES frame.
+//
+//	| u32 length | u8 cmd | u128 request_id | [mac] |
+//
+// The MAC is only written for a signed request.
+func WriteReportRequest(w io.Writer, req *ReportRequest) error {
+	buf := make([]byte, 4, 4+bareRequestLength+MACLength)
+	buf = append(buf, req.Cmd)
+	buf = appendFrameMAC(append(buf, req.RequestID[:]...), req.MAC)
+	binary.BigEndian.PutUint32(buf[0:4], uint32(len(buf)-4))
+	_, err := w.Write(buf)
+	return err
+}
+
+// ReadReportRequest reads a frame written by WriteReportRequest for cmd
+func ReadReportRequest(r io.Reader, cmd uint8) (*ReportRequest, error) {
+	var buf [4 + bareRequestLength + MACLength]byte
+	if _, err := io.ReadFull(r, buf[0:4]); err != nil {
+		return nil, frameReadError(err, true)
+	}
+	length := binary.BigEndian.Uint32(buf[0:4])
+	if length != bareRequestLength && length != bareRequestLength+MACLength {
+		return nil, fmt.Errorf("%w: expected %d or %d, got %d", ErrBadLength, bareRequestLength, bareRequestLength+MACLength, length)
+	}
+	if _, err := io.ReadFull(r, buf[4:4+length]); err != nil {
+		return nil, frameReadError(err, false)
+	}
+	if buf[4] != cmd {
+		return nil, fmt.Errorf("invalid command: expected %d, got %d", cmd, buf[4])
+	}
+	req := &ReportRequest{Cmd: cmd}
+	copy(req.RequestID[:], buf[5:21])
+	copy(req.MAC[:], buf[21:4+length])
+	return req, nil
+}
+
+// writeBareRequest writes an unsigned request frame holding only cmd and requestID
+func writeBareRequest(w io.Writer, cmd uint8, requestID [16]byte) error {
+	return WriteReportRequest(w, &ReportRequest{Cmd: cmd, RequestID: requestID})
+}
+
+// readBareRequest reads a frame written by WriteReportRequest for cmd and returns its request ID
+func readBareRequest(r io.Reader, cmd uint8) ([16]byte, error) {
+	req, err := ReadReportRequest(r, cmd)
+	if err != nil {
+		return [16]byte{}, err
+	}
+	return req.RequestID, nil
+}
+
+// WriteServerInfoResponse answers a SERVER_INFO with status and the server's limits.
+//
+//	| u32 length | u8 status | u64 min_ttl_ms | u64 max_ttl_ms | u32 max_locks_per_owner |
+//	| u32 max_total_locks | u32 max_connections | u32 pipeline_depth |
+func WriteServerInfoResponse(w io.Writer, status clutcherrors.StatusCode, limits *ServerLimits) error {
+	var buf [4 + serverInfoResponseLength]byte
+	binary.BigEndian.PutUint32(buf[0:4], serverInfoResponseLength)
+	buf[4] = byte(status)
+	binary.BigEndian.PutUint64(buf[5:13], limits.MinTTLMS)
+	binary.BigEndian.PutUint64(buf[13:21], limits.MaxTTLMS)
+	binary.BigEndian.PutUint32(buf[21:25], limits.MaxLocksPerOwner)
+	binary.BigEndian.PutUint32(buf[25:29], limits.MaxTotalLocks)
+	binary.BigEndian.PutUint32(buf[29:33], limits.MaxConnections)
+	binary.BigEndian.PutUint32(buf[33:37], limits.PipelineDepth)
+	_, err := w.Write(buf[:])
+	return err
+}
+
+// ReadServerInfoResponse reads a response written by WriteServerInfoResponse. Limits added by
+// newer servers are read and discarded so the stream stays aligned on the next frame.
+func ReadServerInfoResponse(r io.Reader) (clutcherrors.StatusCode, *ServerLimits, error) {
+	data, err := readExtensibleResponse(r, serverInfoResponseLength)
+	if err != nil {
+		return 0, nil, err
+	}
+	limits := &ServerLimits{
+		MinTTLMS:         binary.BigEndian.Uint64(data[1:9]),
+		MaxTTLMS:         binary.BigEndian.Uint64(data[9:17]),
+		MaxLocksPerOwner: binary.BigEndian.Uint32(data[17:21]),
+		MaxTotalLocks:    binary.BigEndian.Uint32(data[21:25]),
+		MaxConnections:   binary.BigEndian.Uint32(data[25:29]),
+		PipelineDepth:    binary.BigEndian.Uint32(data[29:33]),
+	}
+	return clutcherrors.StatusCode(data[0]), limits, nil
+}
+
+// readExtensibleResponse reads a response frame of at least minLength bytes after the length
+// field and returns those bytes, fields newer than the reader included
+func readExtensibleResponse(r io.Reader, minLength uint32) ([]byte, error) {
+	var lengthBuf [4]byte
+	if _, err := io.ReadFull(r, lengthBuf[:]); err != nil {
+		return nil, frameReadError(err, true)
+	}
+	length := binary.BigEndian.Uint32(lengthBuf[:])
+	if length < minLength || length > maxResponseLength {
+		return nil, fmt.Errorf("%w: expected %d to %d, got %d", ErrBadLength, minLength, maxResponseLength, length)
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, frameReadError(err, false)
+	}
+	return data, nil
+}
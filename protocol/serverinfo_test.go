@@ -0,0 +1,70 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/mrdhat/clutchdb/clutcherrors"
+)
+
+func TestServerInfoRoundTrip(t *testing.T) {
+	requestID := uuid.New()
+	var buf bytes.Buffer
+	if err := WriteServerInfoRequest(&buf, requestID); err != nil {
+		t.Fatalf("WriteServerInfoRequest failed: %v", err)
+	}
+	decodedID, err := ReadServerInfoRequest(&buf)
+	if err != nil {
+		t.Fatalf("ReadServerInfoRequest failed: %v", err)
+	}
+	if decodedID != requestID {
+		t.Errorf("Expected request ID %x, got %x", requestID, decodedID)
+	}
+
+	limits := &ServerLimits{
+		MinTTLMS:         100,
+		MaxTTLMS:         60_000,
+		MaxLocksPerOwner: 10,
+		MaxTotalLocks:    1000,
+		MaxConnections:   64,
+		PipelineDepth:    4,
+	}
+	if err := WriteServerInfoResponse(&buf, clutcherrors.STATUS_SUCCESS, limits); err != nil {
+		t.Fatalf("WriteServerInfoResponse failed: %v", err)
+	}
+	status, decoded, err := ReadServerInfoResponse(&buf)
+	if err != nil {
+		t.Fatalf("ReadServerInfoResponse failed: %v", err)
+	}
+	if status != clutcherrors.STATUS_SUCCESS {
+		t.Errorf("Expected status %d, got %d", clutcherrors.STATUS_SUCCESS, status)
+	}
+	if *decoded != *limits {
+		t.Errorf("Expected %+v, got %+v", limits, decoded)
+	}
+}
+
+func TestServerInfoResponseSkipsNewerLimits(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteServerInfoResponse(&buf, clutcherrors.STATUS_SUCCESS, &ServerLimits{MaxTTLMS: 5000}); err != nil {
+		t.Fatalf("WriteServerInfoResponse failed: %v", err)
+	}
+	// A newer server appends a limit this version doesn't know, then another frame follows
+	frame := buf.Bytes()
+	binary.BigEndian.PutUint32(frame[0:4], serverInfoResponseLength+8)
+	frame = append(frame, 0, 0, 0, 0, 0, 0, 0, 7)
+	stream := bytes.NewBuffer(frame)
+	WriteServerInfoResponse(stream, clutcherrors.STATUS_SUCCESS, &ServerLimits{MaxTTLMS: 6000})
+
+	for _, want := range []uint64{5000, 6000} {
+		_, limits, err := ReadServerInfoResponse(stream)
+		if err != nil {
+			t.Fatalf("ReadServerInfoResponse failed: %v", err)
+		}
+		if limits.MaxTTLMS != want {
+			t.Errorf("Expected max TTL %d, got %d", want, limits.MaxTTLMS)
+		}
+	}
+}
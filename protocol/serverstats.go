@@ -0,0 +1,70 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"io"
+
+	"github.com/mrdhat/clutchdb/clutcherrors"
+)
+
+// ServerStats are a server's live counters, for operators pulling them over the protocol
+type ServerStats struct {
+	ActiveLocks uint64 // holds live now
+	Acquires    uint64 // acquires committed since the server started
+	Renews      uint64 // renewals committed since the server started
+	Releases    uint64 // releases committed since the server started
+	WALBytes    uint64 // size of the commit log, 0 if it has none or can't tell
+	UptimeMS    uint64 // time since the server started
+}
+
+// serverStatsResponseLength is the number of SERVER_STATS response bytes following the length
+// field that this version writes. Readers accept longer frames and skip counters they don't know.
+const serverStatsResponseLength = 49
+
+// WriteServerStatsRequest writes a SERVER_STATS frame.
+//
+//	| u32 length | u8 cmd = SERVER_STATS | u128 request_id |
+func WriteServerStatsRequest(w io.Writer, requestID [16]byte) error {
+	return writeBareRequest(w, SERVER_STATS, requestID)
+}
+
+// ReadServerStatsRequest reads a SERVER_STATS frame from r and returns its request ID
+func ReadServerStatsRequest(r io.Reader) ([16]byte, error) {
+	return readBareRequest(r, SERVER_STATS)
+}
+
+// WriteServerStatsResponse answers a SERVER_STATS with status and the server's counters.
+//
+//	| u32 length | u8 status | u64 active_locks | u64 acquires | u64 renews | u64 releases |
+//	| u64 wal_bytes | u64 uptime_ms |
+func WriteServerStatsResponse(w io.Writer, status clutcherrors.StatusCode, stats *ServerStats) error {
+	var buf [4 + serverStatsResponseLength]byte
+	binary.BigEndian.PutUint32(buf[0:4], serverStatsResponseLength)
+	buf[4] = byte(status)
+	binary.BigEndian.PutUint64(buf[5:13], stats.ActiveLocks)
+	binary.BigEndian.PutUint64(buf[13:21], stats.Acquires)
+	binary.BigEndian.PutUint64(buf[21:29], stats.Renews)
+	binary.BigEndian.PutUint64(buf[29:37], stats.Releases)
+	binary.BigEndian.PutUint64(buf[37:45], stats.WALBytes)
+	binary.BigEndian.PutUint64(buf[45:53], stats.UptimeMS)
+	_, err := w.Write(buf[:])
+	return err
+}
+
+// ReadServerStatsResponse reads a response written by WriteServerStatsResponse. Counters added
+// by newer servers are read and discarded so the stream stays aligned on the next frame.
+func ReadServerStatsResponse(r io.Reader) (clutcherrors.StatusCode, *ServerStats, error) {
+	data, err := readExtensibleResponse(r, serverStatsResponseLength)
+	if err != nil {
+		return 0, nil, err
+	}
+	stats := &ServerStats{
+		ActiveLocks: binary.BigEndian.Uint64(data[1:9]),
+		Acquires:    binary.BigEndian.Uint64(data[9:17]),
+		Renews:      binary.BigEndian.Uint64(data[17:25]),
+		Releases:    binary.BigEndian.Uint64(data[25:33]),
+		WALBytes:    binary.BigEndian.Uint64(data[33:41]),
+		UptimeMS:    binary.BigEndian.Uint64(data[41:49]),
+	}
+	return clutcherrors.StatusCode(data[0]), stats, nil
+}
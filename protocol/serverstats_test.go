@@ -0,0 +1,56 @@
+package protocol
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/mrdhat/clutchdb/clutcherrors"
+)
+
+func TestServerStatsRoundTrip(t *testing.T) {
+	requestID := uuid.New()
+	var buf bytes.Buffer
+	if err := WriteServerStatsRequest(&buf, requestID); err != nil {
+		t.Fatalf("WriteServerStatsRequest failed: %v", err)
+	}
+	decodedID, err := ReadServerStatsRequest(&buf)
+	if err != nil {
+		t.Fatalf("ReadServerStatsRequest failed: %v", err)
+	}
+	if decodedID != requestID {
+		t.Errorf("Expected request ID %x, got %x", requestID, decodedID)
+	}
+
+	stats := &ServerStats{
+		ActiveLocks: 3,
+		Acquires:    10,
+		Renews:      20,
+		Releases:    7,
+		WALBytes:    4096,
+		UptimeMS:    86_400_000,
+	}
+	if err := WriteServerStatsResponse(&buf, clutcherrors.STATUS_SUCCESS, stats); err != nil {
+		t.Fatalf("WriteServerStatsResponse failed: %v", err)
+	}
+	status, decoded, err := ReadServerStatsResponse(&buf)
+	if err != nil {
+		t.Fatalf("ReadServerStatsResponse failed: %v", err)
+	}
+	if status != clutcherrors.STATUS_SUCCESS {
+		t.Errorf("Expected status %d, got %d", clutcherrors.STATUS_SUCCESS, status)
+	}
+	if *decoded != *stats {
+		t.Errorf("Expected %+v, got %+v", stats, decoded)
+	}
+}
+
+func TestServerStatsRequestWrongCommand(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteServerInfoRequest(&buf, uuid.New()); err != nil {
+		t.Fatalf("WriteServerInfoRequest failed: %v", err)
+	}
+	if _, err := ReadServerStatsRequest(&buf); err == nil {
+		t.Error("Expected a SERVER_INFO frame to be rejected as SERVER_STATS")
+	}
+}
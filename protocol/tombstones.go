@@ -0,0 +1,87 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/mrdhat/clutchdb/clutcherrors"
+)
+
+// Tombstone is a clean release a server kept a record of
+type Tombstone struct {
+	LockID       string
+	OwnerID      string // owner that released the lock
+	FencingToken uint64 // final token of the released hold
+	ReleasedAt   uint64 // release time in milliseconds
+}
+
+// MaxTombstones is the most tombstones a TOMBSTONES response carries. Servers send the most
+// recent ones, still oldest first.
+const MaxTombstones = 4096
+
+// WriteTombstonesResponse answers a TOMBSTONES with status and up to MaxTombstones of
+// tombstones, the most recent ones, along with how many there are in all.
+//
+//	| u32 length | u8 status | u32 total | u32 count |
+//	| count × ( u16 lock_id_len | lock_id | u16 owner_id_len | owner_id | u64 fencing_token | u64 released_at ) |
+func WriteTombstonesResponse(w io.Writer, status clutcherrors.StatusCode, tombstones []Tombstone) error {
+	total := len(tombstones)
+	tombstones = tombstones[max(0, total-MaxTombstones):]
+
+	body := new(bytes.Buffer)
+	body.WriteByte(byte(status))
+	binary.Write(body, binary.BigEndian, uint32(total))
+	binary.Write(body, binary.BigEndian, uint32(len(tombstones)))
+	for _, t := range tombstones {
+		if err := writeString(body, t.LockID); err != nil {
+			return fmt.Errorf("lock id: %w", err)
+		}
+		if err := writeString(body, t.OwnerID); err != nil {
+			return fmt.Errorf("owner id: %w", err)
+		}
+		binary.Write(body, binary.BigEndian, t.FencingToken)
+		binary.Write(body, binary.BigEndian, t.ReleasedAt)
+	}
+	return writeFrame(w, body.Bytes())
+}
+
+// ReadTombstonesResponse reads a response written by WriteTombstonesResponse. total is how many
+// tombstones the server has, which exceeds len(tombstones) when the oldest were left out.
+func ReadTombstonesResponse(r io.Reader) (status clutcherrors.StatusCode, tombstones []Tombstone, total uint32, err error) {
+	data, err := readFrame(r)
+	if err != nil {
+		return 0, nil, 0, err
+	}
+	if len(data) < 9 {
+		return 0, nil, 0, fmt.Errorf("%w: expected at least 9, got %d", ErrBadLength, len(data))
+	}
+	total = binary.BigEndian.Uint32(data[1:5])
+	count := binary.BigEndian.Uint32(data[5:9])
+	if count > MaxTombstones {
+		return 0, nil, 0, fmt.Errorf("too many tombstones: %d, max %d", count, MaxTombstones)
+	}
+
+	body := bytes.NewReader(data[9:])
+	for i := uint32(0); i < count; i++ {
+		var t Tombstone
+		if t.LockID, err = readString(body); err != nil {
+			return 0, nil, 0, fmt.Errorf("%w: tombstone %d: %v", ErrBadLength, i, err)
+		}
+		if t.OwnerID, err = readString(body); err != nil {
+			return 0, nil, 0, fmt.Errorf("%w: tombstone %d: %v", ErrBadLength, i, err)
+		}
+		if err := binary.Read(body, binary.BigEndian, &t.FencingToken); err != nil {
+			return 0, nil, 0, fmt.Errorf("%w: tombstone %d: %v", ErrBadLength, i, err)
+		}
+		if err := binary.Read(body, binary.BigEndian, &t.ReleasedAt); err != nil {
+			return 0, nil, 0, fmt.Errorf("%w: tombstone %d: %v", ErrBadLength, i, err)
+		}
+		tombstones = append(tombstones, t)
+	}
+	if body.Len() != 0 {
+		return 0, nil, 0, fmt.Errorf("%w: %d trailing bytes", ErrBadLength, body.Len())
+	}
+	return clutcherrors.StatusCode(data[0]), tombstones, total, nil
+}
@@ -0,0 +1,64 @@
+package protocol
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/mrdhat/clutchdb/clutcherrors"
+)
+
+func TestTombstonesRoundTrip(t *testing.T) {
+	req := &ReportRequest{Cmd: TOMBSTONES, RequestID: uuid.New()}
+	var buf bytes.Buffer
+	if err := WriteReportRequest(&buf, req); err != nil {
+		t.Fatalf("WriteReportRequest failed: %v", err)
+	}
+	decodedReq, err := ReadReportRequest(&buf, TOMBSTONES)
+	if err != nil {
+		t.Fatalf("ReadReportRequest failed: %v", err)
+	}
+	if *decodedReq != *req {
+		t.Errorf("Expected %+v, got %+v", req, decodedReq)
+	}
+
+	tombstones := []Tombstone{
+		{LockID: "lock1", OwnerID: "owner1", FencingToken: 3, ReleasedAt: 1000},
+		{LockID: "lock2", OwnerID: "owner2", FencingToken: 7, ReleasedAt: 2000},
+	}
+	if err := WriteTombstonesResponse(&buf, clutcherrors.STATUS_SUCCESS, tombstones); err != nil {
+		t.Fatalf("WriteTombstonesResponse failed: %v", err)
+	}
+	status, decoded, total, err := ReadTombstonesResponse(&buf)
+	if err != nil {
+		t.Fatalf("ReadTombstonesResponse failed: %v", err)
+	}
+	if status != clutcherrors.STATUS_SUCCESS || total != 2 || !reflect.DeepEqual(decoded, tombstones) {
+		t.Errorf("Expected %+v of 2, got status %d and %+v of %d", tombstones, status, decoded, total)
+	}
+}
+
+func TestTombstonesResponseTruncated(t *testing.T) {
+	tombstones := make([]Tombstone, MaxTombstones+10)
+	for i := range tombstones {
+		tombstones[i] = Tombstone{LockID: fmt.Sprintf("lock%d", i), OwnerID: "owner1", FencingToken: 1, ReleasedAt: uint64(i)}
+	}
+
+	var buf bytes.Buffer
+	if err := WriteTombstonesResponse(&buf, clutcherrors.STATUS_SUCCESS, tombstones); err != nil {
+		t.Fatalf("WriteTombstonesResponse failed: %v", err)
+	}
+	_, decoded, total, err := ReadTombstonesResponse(&buf)
+	if err != nil {
+		t.Fatalf("ReadTombstonesResponse failed: %v", err)
+	}
+	if len(decoded) != MaxTombstones || total != uint32(len(tombstones)) {
+		t.Fatalf("Expected %d tombstones of %d, got %d of %d", MaxTombstones, len(tombstones), len(decoded), total)
+	}
+	// The oldest are the ones left out
+	if decoded[0].LockID != "lock10" {
+		t.Errorf("Expected the first tombstone sent to be lock10, got %s", decoded[0].LockID)
+	}
+}
@@ -0,0 +1,34 @@
+package server
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/mrdhat/clutchdb/clutcherrors"
+	"github.com/mrdhat/clutchdb/protocol"
+)
+
+// HMACAuth returns middleware that answers STATUS_FORBIDDEN, without executing it, to any request
+// not signed with secret by protocol.SignRequest, such as one from a client.Client configured
+// with client.WithSharedSecret. It keeps clients that don't know the secret off a semi-trusted
+// network without certificates, but does not hide requests from eavesdroppers.
+//
+// Every request is checked, batched ones and those in their own frame layout included, whose
+// envelopes carry the MAC of the whole frame. Requests dispatched in process must be signed too;
+// see gateway.WithSecret for the HTTP gateway's.
+//
+// Request IDs are not recorded, so a captured request verifies every time it is sent again. A
+// replayed RELEASE or RENEW is fenced by its token once the hold changes, but within one hold,
+// or for a RENEW_ALL or RELEASE_BY_PREFIX, it takes effect again. Run it over TLS where that
+// matters.
+func HMACAuth(secret []byte) Middleware {
+	secret = bytes.Clone(secret)
+	return func(next Handler) Handler {
+		return func(ctx context.Context, req *protocol.Request) *protocol.Response {
+			if !protocol.VerifyRequest(req, secret) {
+				return &protocol.Response{Status: clutcherrors.STATUS_FORBIDDEN}
+			}
+			return next(ctx, req)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mrdhat/clutchdb/clutcherrors"
+	"github.com/mrdhat/clutchdb/protocol"
+)
+
+func TestHMACAuth(t *testing.T) {
+	secret := []byte("shared secret")
+	s := NewServer(WithMiddleware(HMACAuth(secret)))
+	ctx := context.Background()
+
+	// Unsigned
+	resp := s.Dispatch(ctx, newRequest(protocol.ACQUIRE, "lock1", "owner1", 1000, 0))
+	if resp.Status != clutcherrors.STATUS_FORBIDDEN {
+		t.Fatalf("Expected unsigned acquire to be forbidden, got %+v", resp)
+	}
+
+	// Signed, then altered in flight
+	tampered := newRequest(protocol.ACQUIRE, "lock1", "owner1", 1000, 0)
+	protocol.SignRequest(tampered, secret)
+	copy(tampered.OwnerID[:], "owner2")
+	resp = s.Dispatch(ctx, tampered)
+	if resp.Status != clutcherrors.STATUS_FORBIDDEN {
+		t.Fatalf("Expected tampered acquire to be forbidden, got %+v", resp)
+	}
+
+	// Signed with another secret
+	forged := newRequest(protocol.ACQUIRE, "lock1", "owner1", 1000, 0)
+	protocol.SignRequest(forged, []byte("guessed secret"))
+	resp = s.Dispatch(ctx, forged)
+	if resp.Status != clutcherrors.STATUS_FORBIDDEN {
+		t.Fatalf("Expected acquire signed with another secret to be forbidden, got %+v", resp)
+	}
+	if _, ok := s.activeLocks.Load("lock1"); ok {
+		t.Fatal("Expected forbidden requests to leave the lock free")
+	}
+
+	signed := newRequest(protocol.ACQUIRE, "lock1", "owner1", 1000, 0)
+	protocol.SignRequest(signed, secret)
+	resp = s.Dispatch(ctx, signed)
+	if resp.Status != clutcherrors.STATUS_SUCCESS {
+		t.Fatalf("Expected signed acquire to succeed, got %+v", resp)
+	}
+}
+
+func TestHMACAuthFrames(t *testing.T) {
+	secret := []byte("shared secret")
+	s := NewServer(WithMiddleware(HMACAuth(secret)))
+	ctx := context.Background()
+
+	signed := newRequest(protocol.ACQUIRE, "lock1", "owner1", 1000, 0)
+	protocol.SignRequest(signed, secret)
+	if resp := s.Dispatch(ctx, signed); resp.Status != clutcherrors.STATUS_SUCCESS {
+		t.Fatalf("Expected signed acquire to succeed, got %+v", resp)
+	}
+
+	renewAll := &protocol.RenewAllRequest{RequestID: [16]byte{1}, OwnerID: signed.OwnerID, TTLMS: 1000}
+	if status, _ := s.DispatchRenewAll(ctx, renewAll); status != clutcherrors.STATUS_FORBIDDEN {
+		t.Errorf("Expected unsigned renew all to be forbidden, got %v", status)
+	}
+	env := renewAll.Envelope()
+	protocol.SignRequest(env, secret)
+	renewAll.MAC = env.MAC
+	if status, results := s.DispatchRenewAll(ctx, renewAll); status != clutcherrors.STATUS_SUCCESS || len(results) != 1 {
+		t.Errorf("Expected signed renew all to renew the lock, got %v %+v", status, results)
+	}
+
+	// Signed, then altered in flight
+	renewAll.TTLMS = 2000
+	if status, _ := s.DispatchRenewAll(ctx, renewAll); status != clutcherrors.STATUS_FORBIDDEN {
+		t.Errorf("Expected tampered renew all to be forbidden, got %v", status)
+	}
+
+	lookup := &protocol.LockInfoMultiRequest{RequestID: [16]byte{2}, LockIDs: []string{"lock1"}}
+	if status, infos := s.DispatchLockInfoMulti(ctx, lookup); status != clutcherrors.STATUS_FORBIDDEN || infos != nil {
+		t.Errorf("Expected unsigned lock info lookup to be forbidden, got %v %+v", status, infos)
+	}
+}
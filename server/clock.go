@@ -0,0 +1,33 @@
+package server
+
+import "time"
+
+// Clock supplies the current time in Unix milliseconds for lease decisions
+type Clock interface {
+	NowMillis() uint64
+}
+
+// monotonicClock reads the wall clock once, at construction, and advances from
+// there using Go's monotonic clock. NTP steps or VM clock jumps afterwards don't
+// move lease expiry backwards or forwards, while readings stay close enough to
+// wall time to be shown to clients as timestamps.
+type monotonicClock struct {
+	anchorMillis uint64    // wall clock at construction
+	anchor       time.Time // carries the monotonic reading taken at construction
+}
+
+// NewMonotonicClock returns a Clock anchored to the current wall time
+func NewMonotonicClock() Clock {
+	return newMonotonicClock(time.Now)
+}
+
+func newMonotonicClock(wall func() time.Time) *monotonicClock {
+	return &monotonicClock{
+		anchorMillis: uint64(wall().UnixMilli()),
+		anchor:       time.Now(),
+	}
+}
+
+func (c *monotonicClock) NowMillis() uint64 {
+	return c.anchorMillis + uint64(time.Since(c.anchor).Milliseconds())
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mrdhat/clutchdb/clutcherrors"
+)
+
+// jumpingWall is a wall clock that can be stepped arbitrarily, like NTP or a VM migration would
+type jumpingWall struct {
+	now time.Time
+}
+
+func (w *jumpingWall) Now() time.Time {
+	return w.now
+}
+
+func TestMonotonicClockIgnoresWallJumps(t *testing.T) {
+	wall := &jumpingWall{now: time.Now()}
+	c := newMonotonicClock(wall.Now)
+	start := c.NowMillis()
+
+	// Step the wall clock an hour back; the lease clock must not follow
+	wall.now = wall.now.Add(-time.Hour)
+	time.Sleep(5 * time.Millisecond)
+
+	now := c.NowMillis()
+	if now < start {
+		t.Fatalf("Clock went backwards: %d -> %d", start, now)
+	}
+	if now-start > uint64(time.Minute.Milliseconds()) {
+		t.Fatalf("Clock jumped forward: %d -> %d", start, now)
+	}
+}
+
+func TestExpiryAcrossBackwardWallJump(t *testing.T) {
+	wall := &jumpingWall{now: time.Now()}
+	s := NewServer(WithClock(newMonotonicClock(wall.Now)))
+
+	ctx := context.Background()
+	ttl := 20 * time.Millisecond
+
+	if _, _, err := s.Acquire(ctx, "owner1", "lock1", ttl); err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+
+	// A wall clock that jumps back an hour would keep the lock held for an hour
+	wall.now = wall.now.Add(-time.Hour)
+	time.Sleep(ttl + 10*time.Millisecond)
+
+	status, lock, err := s.Acquire(ctx, "owner2", "lock1", ttl)
+	if err != nil {
+		t.Fatalf("Acquire after expiry failed: %v", err)
+	}
+	if status != clutcherrors.STATUS_SUCCESS || lock.OwnerID != "owner2" {
+		t.Errorf("Expected owner2 to acquire the expired lock, got status %d", status)
+	}
+}
@@ -3,16 +3,15 @@ package server
 import (
 	"context"
 	"errors"
+	"fmt"
+	"math"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/mrdhat/clutchdb/clutcherrors"
-)
-
-var (
-	FencingTokens sync.Map
-	ActiveLocks   sync.Map
+	"github.com/mrdhat/clutchdb/command"
+	"github.com/mrdhat/clutchdb/protocol"
 )
 
 type Lock struct {
@@ -20,44 +19,306 @@ type Lock struct {
 	OwnerID      string
 	FencingToken uint64
 	ExpiresAt    uint64
+	AcquiredAt   uint64 // when the current owner was granted the lock; renewals keep it
+	Value        string // attached by the acquire that granted the hold, kept until it ends
 	mu           sync.Mutex
+	removed      bool // deleted from the lock table; callers that raced for mu must look the lock up again
 }
 
-func Acquire(ctx context.Context, ownerID string, lockID string, ttl time.Duration) (clutcherrors.StatusCode, *Lock, error) {
-	now := uint64(time.Now().UnixMilli())
+func (s *Server) Acquire(ctx context.Context, ownerID string, lockID string, ttl time.Duration) (clutcherrors.StatusCode, *Lock, error) {
+	return s.acquire(ctx, ownerID, lockID, ttl, "", 0, false, false)
+}
 
-	lockIface, loaded := ActiveLocks.LoadOrStore(lockID, &Lock{ID: lockID})
-	lock := lockIface.(*Lock)
+// AcquireWithValue acquires lockID like Acquire and attaches value to the hold, such as the
+// address of the leader a lock elects, for anyone looking the lock up to see. The hold keeps it
+// through renewals, bumps and handoffs. A retried acquire by the holder keeps the first value.
+func (s *Server) AcquireWithValue(ctx context.Context, ownerID string, lockID string, ttl time.Duration, value string) (clutcherrors.StatusCode, *Lock, error) {
+	return s.acquire(ctx, ownerID, lockID, ttl, value, 0, false, false)
+}
 
-	lock.mu.Lock()
-	defer lock.mu.Unlock()
+// AcquireAbove acquires lockID like Acquire, but guarantees the granted fencing token is strictly
+// greater than minToken, advancing the counter past it if needed. A new leader can use it to make
+// sure tokens never go backwards when the counter was rebuilt from an older snapshot.
+func (s *Server) AcquireAbove(ctx context.Context, ownerID string, lockID string, ttl time.Duration, minToken uint64) (clutcherrors.StatusCode, *Lock, error) {
+	return s.acquireAbove(ctx, ownerID, lockID, ttl, "", minToken)
+}
+
+// acquireAbove is AcquireAbove attaching value to the hold, as AcquireWithValue does
+func (s *Server) acquireAbove(ctx context.Context, ownerID string, lockID string, ttl time.Duration, value string, minToken uint64) (clutcherrors.StatusCode, *Lock, error) {
+	if minToken == math.MaxUint64 {
+		return clutcherrors.STATUS_INVALID_REQUEST, nil, errors.New("no fencing token exceeds minimum")
+	}
+	return s.acquire(ctx, ownerID, lockID, ttl, value, minToken, false, false)
+}
+
+// CheckAcquire acquires lockID like Acquire, but tells a fresh grant from one that reclaimed a
+// hold whose lease lapsed without a release, which usually means the previous holder crashed. The
+// latter is granted with STATUS_ACQUIRED_RECLAIMED. A lapsed hold the reaper already removed
+// can't be told apart from a released one, so it is granted with STATUS_SUCCESS. It never
+// refreshes a live hold, even for its owner under WithIdempotentAcquire.
+func (s *Server) CheckAcquire(ctx context.Context, ownerID string, lockID string, ttl time.Duration) (clutcherrors.StatusCode, *Lock, error) {
+	return s.acquire(ctx, ownerID, lockID, ttl, "", 0, false, true)
+}
+
+// acquire grants lockID to ownerID. A free lock is only handed to a caller outside the
+// wait queue when nobody is queued for it, so AcquireWait callers can't be starved. With
+// reportReclaim, as for CheckAcquire, a grant that reclaimed a lapsed hold is answered with
+// STATUS_ACQUIRED_RECLAIMED and a live hold is never refreshed.
+func (s *Server) acquire(ctx context.Context, ownerID string, lockID string, ttl time.Duration, value string, minToken uint64, queueHead bool, reportReclaim bool) (clutcherrors.StatusCode, *Lock, error) {
+	s.resetMu.RLock()
+	defer s.resetMu.RUnlock()
+
+	for {
+		lockIface, loaded := s.activeLocks.LoadOrStore(lockID, &Lock{ID: lockID})
+		lock := lockIface.(*Lock)
+
+		lock.mu.Lock()
+		if !lock.removed {
+			defer lock.mu.Unlock()
+			// Sample the clock only once we own the mutex: a time read before waiting on it could
+			// call a hold expired that is fresh by now, or grant one that is born expired
+			now := s.clock.NowMillis()
+			if reportReclaim && loaded && !lapsed(lock.ExpiresAt, now) {
+				return s.heldStatus(lock, ownerID)
+			}
+			// Release removes the entry, so a granted one still in the table ended by expiring
+			reclaimed := loaded && lock.FencingToken != 0
+			status, granted, err := s.acquireLocked(lock, loaded, ownerID, lockID, ttl, value, now, minToken, queueHead)
+			if err != nil {
+				s.dropUnheld(lockID, lock, now)
+			}
+			if reportReclaim && status == clutcherrors.STATUS_SUCCESS && reclaimed {
+				status = clutcherrors.STATUS_ACQUIRED_RECLAIMED
+			}
+			return status, granted, err
+		}
+		// Removed from the lock table while we waited on its mutex; retry with the current entry
+		lock.mu.Unlock()
+	}
+}
+
+// dropUnheld removes lock from the table after a rejected acquire left it without a hold, so
+// acquires turned away at a quota don't grow the table with empty entries. Entries still in their
+// expiry grace stay. Must be called with lock.mu held.
+func (s *Server) dropUnheld(lockID string, lock *Lock, now uint64) {
+	if lock.OwnerID != "" {
+		return
+	}
+	// A zero token means the entry was never granted, as for one just stored by this acquire
+	if lock.FencingToken == 0 || lock.ExpiresAt+uint64(s.expiryGrace.Milliseconds()) <= now {
+		s.removeLock(lockID, lock)
+	}
+}
+
+// heldStatus is the answer to ownerID's acquire of lock while it is live. For
+// STATUS_HELD_BY_CALLER it returns a copy of the caller's hold, taken now because once lock.mu is
+// released the hold may lapse and pass to another owner. Must be called with lock.mu held.
+func (s *Server) heldStatus(lock *Lock, ownerID string) (clutcherrors.StatusCode, *Lock, error) {
+	if s.selfHeldStatus && lock.OwnerID == ownerID {
+		hold := &Lock{ID: lock.ID, OwnerID: lock.OwnerID, FencingToken: lock.FencingToken, ExpiresAt: lock.ExpiresAt, AcquiredAt: lock.AcquiredAt, Value: lock.Value}
+		return clutcherrors.STATUS_HELD_BY_CALLER, hold, errors.New("lock already held by caller")
+	}
+	return clutcherrors.STATUS_LOCK_HELD, nil, errors.New("lock already held")
+}
 
+// acquireLocked is the body of acquire. Must be called with lock.mu held.
+func (s *Server) acquireLocked(lock *Lock, loaded bool, ownerID string, lockID string, ttl time.Duration, value string, now uint64, minToken uint64, queueHead bool) (clutcherrors.StatusCode, *Lock, error) {
 	if loaded {
-		if lock.ExpiresAt > now {
+		if !lapsed(lock.ExpiresAt, now) && s.idempotentAcquire && lock.OwnerID == ownerID && lock.FencingToken > minToken {
+			// A retried acquire by the holder: refresh the hold rather than fail against itself
+			return s.refreshLocked(lock, ttl, now)
+		}
+		if !lapsed(lock.ExpiresAt, now) {
 			// Lock is still valid, reject the acquire
-			return clutcherrors.STATUS_LOCK_HELD, nil, errors.New("lock already held")
+			return s.heldStatus(lock, ownerID)
+		}
+		if lock.ExpiresAt+uint64(s.expiryGrace.Milliseconds()) > now {
+			// Expired, but the previous holder may not have noticed yet
+			return clutcherrors.STATUS_LOCK_HELD, nil, errors.New("lock in expiry grace period")
 		}
 		// Lock expired, allow re-acquire by reusing this lock object
 	}
 
+	if !queueHead && s.hasWaiters(lockID) {
+		return clutcherrors.STATUS_LOCK_HELD, nil, errors.New("lock has queued waiters")
+	}
+
+	// The previous holder (if any) has expired
+	s.expireHold(lock)
+
+	if !s.reserveLiveLock(lock, now) {
+		return clutcherrors.STATUS_QUOTA_EXCEEDED, nil, errors.New("server lock quota exceeded")
+	}
+
+	if !s.reserveOwnership(ownerID) {
+		atomic.AddInt64(&s.liveLockCount, -1)
+		return clutcherrors.STATUS_QUOTA_EXCEEDED, nil, errors.New("owner lock quota exceeded")
+	}
+
 	// Increment fencing token atomically
 	var zero uint64
-	tokenPtrIface, _ := FencingTokens.LoadOrStore(lockID, &zero)
+	tokenPtrIface, _ := s.fencingTokens.LoadOrStore(lockID, &zero)
 	tokenPtr := tokenPtrIface.(*uint64)
+	raiseToken(tokenPtr, minToken)
 	fencingToken := atomic.AddUint64(tokenPtr, 1)
 
+	if status, err := s.commit(command.Command{
+		Type:             command.CmdAcquire,
+		LockID:           lockID,
+		OwnerID:          ownerID,
+		Value:            value,
+		FencingToken:     fencingToken,
+		CommitTimeMillis: now,
+		TTLMillis:        protocol.DurationToMillis(ttl),
+	}); err != nil {
+		// The burned token is never handed out; tokens only need to be monotonic
+		s.unreserve(ownerID)
+		return status, nil, err
+	}
+
 	lock.OwnerID = ownerID
 	lock.FencingToken = fencingToken
-	lock.ExpiresAt = now + uint64(ttl.Milliseconds())
+	lock.ExpiresAt = now + protocol.DurationToMillis(ttl)
+	lock.AcquiredAt = now
+	lock.Value = value
+	s.checkInvariants("acquire", lock)
+
+	return clutcherrors.STATUS_SUCCESS, lock, nil
+}
+
+// refreshLocked resets the expiry of lock's current hold to a full ttl, keeping its owner and
+// token, for an acquire by the owner that already holds it. Must be called with lock.mu held.
+func (s *Server) refreshLocked(lock *Lock, ttl time.Duration, now uint64) (clutcherrors.StatusCode, *Lock, error) {
+	if status, err := s.commit(command.Command{
+		Type:             command.CmdRenew,
+		LockID:           lock.ID,
+		OwnerID:          lock.OwnerID,
+		FencingToken:     lock.FencingToken,
+		CommitTimeMillis: now,
+		TTLMillis:        protocol.DurationToMillis(ttl),
+	}); err != nil {
+		return status, nil, err
+	}
+
+	lock.ExpiresAt = now + protocol.DurationToMillis(ttl)
+	s.checkInvariants("acquire", lock)
+
+	return clutcherrors.STATUS_SUCCESS, lock, nil
+}
+
+func (s *Server) Renew(ctx context.Context, ownerID string, lockID string, fencingToken uint64, ttl time.Duration) (clutcherrors.StatusCode, *Lock, error) {
+	return s.renew(ctx, ownerID, lockID, fencingToken, ttl, 0, false, "")
+}
+
+// RenewHandoff renews the lock like Renew and, on success, rebinds it to newOwnerID while keeping
+// the same fencing token. The current owner must still present the correct token. Since this
+// weakens the owner check, it is rejected unless the server was built WithRenewHandoff.
+func (s *Server) RenewHandoff(ctx context.Context, ownerID string, lockID string, fencingToken uint64, ttl time.Duration, newOwnerID string) (clutcherrors.StatusCode, *Lock, error) {
+	if !s.allowRenewHandoff {
+		return clutcherrors.STATUS_INVALID_REQUEST, nil, errors.New("renew handoff disabled")
+	}
+	if newOwnerID == "" {
+		return clutcherrors.STATUS_INVALID_REQUEST, nil, errors.New("missing new owner id")
+	}
+	return s.renew(ctx, ownerID, lockID, fencingToken, ttl, 0, false, newOwnerID)
+}
+
+// CompareAndRenew renews the lock like Renew, but only if less than threshold of it remains.
+// Otherwise the lock is left untouched and STATUS_RENEW_NOT_NEEDED is returned with it, so
+// clients can renew lazily without tracking expiry themselves.
+func (s *Server) CompareAndRenew(ctx context.Context, ownerID string, lockID string, fencingToken uint64, ttl time.Duration, threshold time.Duration) (clutcherrors.StatusCode, *Lock, error) {
+	return s.renew(ctx, ownerID, lockID, fencingToken, ttl, threshold, true, "")
+}
+
+// renew extends a held lock by ttl. When lazy is set, a lock with at least threshold
+// remaining is not extended. A non-empty newOwnerID hands the lock over on success.
+func (s *Server) renew(ctx context.Context, ownerID string, lockID string, fencingToken uint64, ttl time.Duration, threshold time.Duration, lazy bool, newOwnerID string) (clutcherrors.StatusCode, *Lock, error) {
+	s.resetMu.RLock()
+	defer s.resetMu.RUnlock()
+
+	now := s.clock.NowMillis()
+
+	lockIface, ok := s.activeLocks.Load(lockID)
+	if !ok {
+		return clutcherrors.STATUS_LOCK_NOT_HELD, nil, errors.New("lock not held")
+	}
+	lock := lockIface.(*Lock)
+
+	lock.mu.Lock()
+	defer lock.mu.Unlock()
+
+	if lock.removed {
+		return clutcherrors.STATUS_LOCK_NOT_HELD, nil, errors.New("lock not held")
+	}
+
+	if lapsed(lock.ExpiresAt, now) {
+		s.forgetExpired(lockID, lock, now)
+		return clutcherrors.STATUS_LOCK_NOT_HELD, nil, fmt.Errorf("lock expired %dms ago", now-lock.ExpiresAt)
+	}
+
+	if s.fencedOut(lock, fencingToken) {
+		return clutcherrors.STATUS_FENCED_OUT, nil, fmt.Errorf("fenced out by token %d", lock.FencingToken)
+	}
+
+	if lock.OwnerID != ownerID {
+		return clutcherrors.STATUS_LOCK_NOT_HELD, nil, errors.New("owner mismatch")
+	}
+
+	if !s.tokenMatches(lock, fencingToken) {
+		return clutcherrors.STATUS_LOCK_NOT_HELD, nil, errors.New("fencing token mismatch")
+	}
+	fencingToken = lock.FencingToken
+
+	if lazy && lock.ExpiresAt-now >= uint64(threshold.Milliseconds()) {
+		return clutcherrors.STATUS_RENEW_NOT_NEEDED, lock, nil
+	}
+
+	handoff := newOwnerID != "" && newOwnerID != ownerID
+	if handoff && !s.reserveOwnership(newOwnerID) {
+		return clutcherrors.STATUS_QUOTA_EXCEEDED, nil, errors.New("owner lock quota exceeded")
+	}
+
+	cmd := command.Command{
+		Type:             command.CmdRenew,
+		LockID:           lockID,
+		OwnerID:          ownerID,
+		FencingToken:     fencingToken,
+		CommitTimeMillis: now,
+		TTLMillis:        protocol.DurationToMillis(ttl),
+	}
+	if handoff {
+		cmd.Type = command.CmdTransfer
+		cmd.NewOwnerID = newOwnerID
+	}
+	if status, err := s.commit(cmd); err != nil {
+		if handoff {
+			s.releaseOwnerCount(newOwnerID)
+		}
+		return status, nil, err
+	}
+
+	lock.ExpiresAt = now + protocol.DurationToMillis(ttl) // TODO: in a distributed system, time can be a problem
+	if handoff {
+		// The old owner's hold ends here; the new owner's starts
+		s.observeHeld(lock, now)
+		s.releaseOwnerCount(ownerID)
+		lock.OwnerID = newOwnerID
+		lock.AcquiredAt = now
+	}
+	s.checkInvariants("renew", lock)
 
-	// TODO: persist lock & token
 	return clutcherrors.STATUS_SUCCESS, lock, nil
 }
 
-func Renew(ctx context.Context, ownerID string, lockID string, fencingToken uint64, ttl time.Duration) (clutcherrors.StatusCode, *Lock, error) {
-	now := uint64(time.Now().UnixMilli())
+// Bump atomically rotates the fencing token of a held lock and resets its expiry to a full ttl.
+// Unlike Renew, the token changes, invalidating any in-flight writes stamped with the old one.
+func (s *Server) Bump(ctx context.Context, lockID string, ownerID string, currentToken uint64, ttl time.Duration) (clutcherrors.StatusCode, *Lock, error) {
+	s.resetMu.RLock()
+	defer s.resetMu.RUnlock()
 
-	lockIface, ok := ActiveLocks.Load(lockID)
+	now := s.clock.NowMillis()
+
+	lockIface, ok := s.activeLocks.Load(lockID)
 	if !ok {
 		return clutcherrors.STATUS_LOCK_NOT_HELD, nil, errors.New("lock not held")
 	}
@@ -66,29 +327,62 @@ func Renew(ctx context.Context, ownerID string, lockID string, fencingToken uint
 	lock.mu.Lock()
 	defer lock.mu.Unlock()
 
-	if lock.ExpiresAt < now {
-		ActiveLocks.Delete(lockID)
-		return clutcherrors.STATUS_LOCK_NOT_HELD, nil, errors.New("lock expired")
+	if lock.removed {
+		return clutcherrors.STATUS_LOCK_NOT_HELD, nil, errors.New("lock not held")
+	}
+
+	if lapsed(lock.ExpiresAt, now) {
+		s.forgetExpired(lockID, lock, now)
+		return clutcherrors.STATUS_LOCK_NOT_HELD, nil, fmt.Errorf("lock expired %dms ago", now-lock.ExpiresAt)
+	}
+
+	if s.fencedOut(lock, currentToken) {
+		return clutcherrors.STATUS_FENCED_OUT, nil, fmt.Errorf("fenced out by token %d", lock.FencingToken)
 	}
 
 	if lock.OwnerID != ownerID {
 		return clutcherrors.STATUS_LOCK_NOT_HELD, nil, errors.New("owner mismatch")
 	}
 
-	if lock.FencingToken != fencingToken {
+	if !s.tokenMatches(lock, currentToken) {
 		return clutcherrors.STATUS_LOCK_NOT_HELD, nil, errors.New("fencing token mismatch")
 	}
+	currentToken = lock.FencingToken
+
+	var zero uint64
+	tokenPtrIface, _ := s.fencingTokens.LoadOrStore(lockID, &zero)
+	tokenPtr := tokenPtrIface.(*uint64)
+	raiseToken(tokenPtr, currentToken)
+	newToken := atomic.AddUint64(tokenPtr, 1)
+
+	if status, err := s.commit(command.Command{
+		Type:             command.CmdBump,
+		LockID:           lockID,
+		OwnerID:          ownerID,
+		FencingToken:     newToken,
+		CommitTimeMillis: now,
+		TTLMillis:        protocol.DurationToMillis(ttl),
+	}); err != nil {
+		// The burned token is never handed out; tokens only need to be monotonic
+		return status, nil, err
+	}
 
-	lock.ExpiresAt = now + uint64(ttl.Milliseconds()) // TODO: in a distributed system, time can be a problem
+	lock.FencingToken = newToken
+	lock.ExpiresAt = now + protocol.DurationToMillis(ttl)
 
-	// TODO: persist lock
+	// Expiry callbacks follow the hold, not the token it happened to have
+	s.moveExpiryCallbacks(lockID, currentToken, lock.FencingToken)
+	s.checkInvariants("bump", lock)
 
 	return clutcherrors.STATUS_SUCCESS, lock, nil
 }
 
-func Release(ctx context.Context, lockID string, ownerID string, fencingToken uint64) (clutcherrors.StatusCode, error) {
-	now := uint64(time.Now().UnixMilli())
-	lockIface, ok := ActiveLocks.Load(lockID)
+func (s *Server) Release(ctx context.Context, lockID string, ownerID string, fencingToken uint64) (clutcherrors.StatusCode, error) {
+	s.resetMu.RLock()
+	defer s.resetMu.RUnlock()
+
+	now := s.clock.NowMillis()
+	lockIface, ok := s.activeLocks.Load(lockID)
 	if !ok {
 		return clutcherrors.STATUS_LOCK_NOT_HELD, errors.New("lock not held")
 	}
@@ -97,22 +391,209 @@ func Release(ctx context.Context, lockID string, ownerID string, fencingToken ui
 	lock.mu.Lock()
 	defer lock.mu.Unlock()
 
-	if lock.ExpiresAt < now {
-		ActiveLocks.Delete(lockID)
-		return clutcherrors.STATUS_LOCK_NOT_HELD, errors.New("lock expired")
+	if lock.removed {
+		return clutcherrors.STATUS_LOCK_NOT_HELD, errors.New("lock not held")
+	}
+
+	if lapsed(lock.ExpiresAt, now) {
+		s.forgetExpired(lockID, lock, now)
+		return clutcherrors.STATUS_LOCK_NOT_HELD, fmt.Errorf("lock expired %dms ago", now-lock.ExpiresAt)
+	}
+
+	if s.fencedOut(lock, fencingToken) {
+		return clutcherrors.STATUS_FENCED_OUT, fmt.Errorf("fenced out by token %d", lock.FencingToken)
 	}
 
 	if lock.OwnerID != ownerID {
 		return clutcherrors.STATUS_LOCK_NOT_HELD, errors.New("owner mismatch")
 	}
 
-	if lock.FencingToken != fencingToken {
+	if !s.tokenMatches(lock, fencingToken) {
 		return clutcherrors.STATUS_LOCK_NOT_HELD, errors.New("fencing token mismatch")
 	}
+	fencingToken = lock.FencingToken
 
-	ActiveLocks.Delete(lockID)
+	if status, err := s.commit(command.Command{
+		Type:             command.CmdRelease,
+		LockID:           lockID,
+		OwnerID:          ownerID,
+		FencingToken:     fencingToken,
+		CommitTimeMillis: now,
+	}); err != nil {
+		return status, err
+	}
 
-	// TODO: persist lock
+	// The hold being ended must have been consistent up to now
+	s.checkInvariants("release", lock)
+	s.observeHeld(lock, now)
+	s.recordRelease(lockID, ownerID, fencingToken, now)
+	s.removeLock(lockID, lock)
+	s.releaseOwnership(lock)
+	s.dropExpiryCallbacks(lockID, fencingToken)
+	s.notifyWaiters(lockID)
 
 	return clutcherrors.STATUS_SUCCESS, nil
 }
+
+// tokenMatches reports whether fencingToken identifies lock's current hold. In simple mode any
+// token does, and the owner check alone authenticates the caller. Must be called with lock.mu held.
+func (s *Server) tokenMatches(lock *Lock, fencingToken uint64) bool {
+	return s.simpleMode || lock.FencingToken == fencingToken
+}
+
+// fencedOut reports whether a caller presenting fencingToken should be told that lock's live hold
+// replaced theirs, under WithFencedOutStatus. Must be called with lock.mu held.
+func (s *Server) fencedOut(lock *Lock, fencingToken uint64) bool {
+	return s.fencedOutStatus && !s.simpleMode && lock.FencingToken > fencingToken
+}
+
+// forgetExpired drops an expired lock observed by a command. The entry stays registered during
+// the expiry grace period so a fresh acquire can't bypass it. Must be called with lock.mu held.
+func (s *Server) forgetExpired(lockID string, lock *Lock, now uint64) {
+	s.expireHold(lock)
+	if lock.ExpiresAt+uint64(s.expiryGrace.Milliseconds()) <= now {
+		s.removeLock(lockID, lock)
+	}
+}
+
+// removeLock deletes lock from the lock table. Must be called with lock.mu held.
+func (s *Server) removeLock(lockID string, lock *Lock) {
+	lock.removed = true
+	s.activeLocks.CompareAndDelete(lockID, lock)
+}
+
+// expireHold accounts for the end of an expired hold: its duration up to ExpiresAt is observed,
+// expiry callbacks fire and the owner's quota is returned. Must be called with lock.mu held;
+// repeated calls for the same hold are no-ops.
+func (s *Server) expireHold(lock *Lock) {
+	if lock.OwnerID != "" {
+		s.observeHeld(lock, lock.ExpiresAt)
+		s.fireExpiryCallbacks(lock.ID, lock.FencingToken)
+	}
+	s.releaseOwnership(lock)
+}
+
+// reserveOwnership counts a new lock against ownerID, failing if the per-owner cap would be exceeded
+func (s *Server) reserveOwnership(ownerID string) bool {
+	return s.addOwnerCount(ownerID, s.maxLocksPerOwner)
+}
+
+// addOwnerCount counts one more lock against ownerID unless it already has limit (0 for no limit).
+// An owner's entry is deleted once its count drops to zero, and a count of -1 marks one being
+// deleted; such entries are retired and retried rather than counted against.
+func (s *Server) addOwnerCount(ownerID string, limit int) bool {
+	for {
+		var zero int64
+		countIface, _ := s.ownerLockCounts.LoadOrStore(ownerID, &zero)
+		count := countIface.(*int64)
+
+		n := atomic.LoadInt64(count)
+		if n < 0 {
+			s.ownerLockCounts.CompareAndDelete(ownerID, count)
+			continue
+		}
+		if limit > 0 && n >= int64(limit) {
+			return false
+		}
+		if atomic.CompareAndSwapInt64(count, n, n+1) {
+			return true
+		}
+	}
+}
+
+// raiseToken advances the fencing token counter at tokenPtr to at least floor
+func raiseToken(tokenPtr *uint64, floor uint64) {
+	for {
+		current := atomic.LoadUint64(tokenPtr)
+		if current >= floor || atomic.CompareAndSwapUint64(tokenPtr, current, floor) {
+			return
+		}
+	}
+}
+
+// unreserve gives back the reservations taken for an acquire by ownerID that did not go through
+func (s *Server) unreserve(ownerID string) {
+	s.releaseOwnerCount(ownerID)
+	atomic.AddInt64(&s.liveLockCount, -1)
+}
+
+// releaseOwnerCount stops counting one lock against ownerID, deleting its entry at zero so the
+// quota table doesn't grow with every owner ever seen
+func (s *Server) releaseOwnerCount(ownerID string) {
+	countIface, ok := s.ownerLockCounts.Load(ownerID)
+	if !ok {
+		return
+	}
+	count := countIface.(*int64)
+	if atomic.AddInt64(count, -1) == 0 && atomic.CompareAndSwapInt64(count, 0, -1) {
+		s.ownerLockCounts.CompareAndDelete(ownerID, count)
+	}
+}
+
+// reserveLiveLock counts a new lock against the server-wide cap. When it is reached, a sample of
+// expired holds that nobody has touched yet is swept first so they don't count against the live
+// limit. Must be called with lock.mu held.
+func (s *Server) reserveLiveLock(lock *Lock, now uint64) bool {
+	n := atomic.AddInt64(&s.liveLockCount, 1)
+	if s.maxTotalLocks <= 0 || n <= int64(s.maxTotalLocks) {
+		return true
+	}
+	atomic.AddInt64(&s.liveLockCount, -1)
+
+	s.sweepExpiredOwnership(lock, now)
+
+	n = atomic.AddInt64(&s.liveLockCount, 1)
+	if n > int64(s.maxTotalLocks) {
+		atomic.AddInt64(&s.liveLockCount, -1)
+		return false
+	}
+	return true
+}
+
+// quotaSweepLimit bounds how many locks one rejected acquire at the server-wide cap examines, so
+// its cost doesn't grow with the lock table
+const quotaSweepLimit = 64
+
+// sweepExpiredOwnership releases the quota held by expired locks other than held, which the caller
+// has already locked. Like reaper passes, sweeps work through rounds over a listing of the table,
+// each taking the next quotaSweepLimit IDs. Busy locks are skipped rather than waited on to avoid
+// lock-order deadlocks.
+func (s *Server) sweepExpiredOwnership(held *Lock, now uint64) {
+	s.sweepMu.Lock()
+	if len(s.sweepPending) == 0 {
+		s.sweepPending = s.lockIDs()
+	}
+	n := min(len(s.sweepPending), quotaSweepLimit)
+	lockIDs := s.sweepPending[:n]
+	s.sweepPending = s.sweepPending[n:]
+	if len(s.sweepPending) == 0 {
+		s.sweepPending = nil
+	}
+	s.sweepMu.Unlock()
+
+	for _, lockID := range lockIDs {
+		lockIface, ok := s.activeLocks.Load(lockID)
+		if !ok {
+			continue
+		}
+		lock := lockIface.(*Lock)
+		if lock == held || !lock.mu.TryLock() {
+			continue
+		}
+		if lapsed(lock.ExpiresAt, now) {
+			s.expireHold(lock)
+		}
+		lock.mu.Unlock()
+	}
+}
+
+// releaseOwnership stops counting lock against its current owner and the live total.
+// Must be called with lock.mu held. Clearing OwnerID makes it safe to call more than once for the same hold.
+func (s *Server) releaseOwnership(lock *Lock) {
+	if lock.OwnerID == "" {
+		return
+	}
+	s.releaseOwnerCount(lock.OwnerID)
+	atomic.AddInt64(&s.liveLockCount, -1)
+	lock.OwnerID = ""
+}
@@ -2,33 +2,25 @@ package server
 
 import (
 	"context"
+	"fmt"
+	"math"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/mrdhat/clutchdb/clutcherrors"
+	"github.com/mrdhat/clutchdb/protocol"
 )
 
-// resetState clears all locks and fencing tokens for test isolation
-func resetState() {
-	ActiveLocks.Range(func(key, value any) bool {
-		ActiveLocks.Delete(key)
-		return true
-	})
-	FencingTokens.Range(func(key, value any) bool {
-		FencingTokens.Delete(key)
-		return true
-	})
-}
-
 func TestAcquire(t *testing.T) {
-	resetState()
+	s := NewServer()
 	ctx := context.Background()
 	ownerID := "owner1"
 	lockID := "lock1"
 	ttl := 100 * time.Millisecond
 
-	status, lock, err := Acquire(ctx, ownerID, lockID, ttl)
+	status, lock, err := s.Acquire(ctx, ownerID, lockID, ttl)
 	if err != nil {
 		t.Fatalf("Acquire failed: %v", err)
 	}
@@ -50,7 +42,7 @@ func TestAcquire(t *testing.T) {
 }
 
 func TestAcquireConflict(t *testing.T) {
-	resetState()
+	s := NewServer()
 	ctx := context.Background()
 	ownerID := "owner1"
 	ownerID2 := "owner2"
@@ -58,7 +50,7 @@ func TestAcquireConflict(t *testing.T) {
 	ttl := 100 * time.Millisecond
 
 	// First acquire should succeed
-	status1, lock1, err1 := Acquire(ctx, ownerID, lockID, ttl)
+	status1, lock1, err1 := s.Acquire(ctx, ownerID, lockID, ttl)
 	if err1 != nil {
 		t.Fatalf("First Acquire failed: %v", err1)
 	}
@@ -67,7 +59,7 @@ func TestAcquireConflict(t *testing.T) {
 	}
 
 	// Second acquire should fail
-	status2, lock2, err2 := Acquire(ctx, ownerID2, lockID, ttl)
+	status2, lock2, err2 := s.Acquire(ctx, ownerID2, lockID, ttl)
 	if err2 == nil {
 		t.Fatal("Expected error for second Acquire, got nil")
 	}
@@ -84,8 +76,27 @@ func TestAcquireConflict(t *testing.T) {
 	}
 }
 
+func TestAcquireSubMillisecondTTL(t *testing.T) {
+	clock := &manualClock{}
+	clock.now.Store(1_000_000)
+	s := NewServer(WithClock(clock))
+	ctx := context.Background()
+
+	// Rounds up to a whole millisecond rather than granting an already expired hold
+	_, lock, err := s.Acquire(ctx, "owner1", "lock1", 500*time.Microsecond)
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	if lock.ExpiresAt != 1_000_001 {
+		t.Errorf("Expected expiry 1000001, got %d", lock.ExpiresAt)
+	}
+	if status, _, _ := s.Acquire(ctx, "owner2", "lock1", time.Second); status != clutcherrors.STATUS_LOCK_HELD {
+		t.Errorf("Expected status %d, got %d", clutcherrors.STATUS_LOCK_HELD, status)
+	}
+}
+
 func TestAcquireExpired(t *testing.T) {
-	resetState()
+	s := NewServer()
 	ctx := context.Background()
 	ownerID := "owner1"
 	ownerID2 := "owner2"
@@ -93,7 +104,7 @@ func TestAcquireExpired(t *testing.T) {
 	ttl := 10 * time.Millisecond // Very short TTL
 
 	// First acquire
-	status1, lock1, err1 := Acquire(ctx, ownerID, lockID, ttl)
+	status1, lock1, err1 := s.Acquire(ctx, ownerID, lockID, ttl)
 	if err1 != nil {
 		t.Fatalf("First Acquire failed: %v", err1)
 	}
@@ -107,7 +118,7 @@ func TestAcquireExpired(t *testing.T) {
 	time.Sleep(ttl + 10*time.Millisecond)
 
 	// Second acquire should succeed (lock expired)
-	status2, lock2, err2 := Acquire(ctx, ownerID2, lockID, ttl)
+	status2, lock2, err2 := s.Acquire(ctx, ownerID2, lockID, ttl)
 	if err2 != nil {
 		t.Fatalf("Second Acquire failed: %v", err2)
 	}
@@ -124,14 +135,14 @@ func TestAcquireExpired(t *testing.T) {
 }
 
 func TestRenew(t *testing.T) {
-	resetState()
+	s := NewServer()
 	ctx := context.Background()
 	ownerID := "owner1"
 	lockID := "lock1"
 	ttl := 100 * time.Millisecond
 
 	// First acquire
-	status1, lock1, err1 := Acquire(ctx, ownerID, lockID, ttl)
+	status1, lock1, err1 := s.Acquire(ctx, ownerID, lockID, ttl)
 	if err1 != nil {
 		t.Fatalf("Acquire failed: %v", err1)
 	}
@@ -145,7 +156,7 @@ func TestRenew(t *testing.T) {
 	// Wait a bit then renew
 	time.Sleep(10 * time.Millisecond)
 	newTTL := 200 * time.Millisecond
-	status2, lock2, err2 := Renew(ctx, ownerID, lockID, lock1.FencingToken, newTTL)
+	status2, lock2, err2 := s.Renew(ctx, ownerID, lockID, lock1.FencingToken, newTTL)
 	if err2 != nil {
 		t.Fatalf("Renew failed: %v", err2)
 	}
@@ -168,13 +179,13 @@ func TestRenew(t *testing.T) {
 }
 
 func TestRenewNotHeld(t *testing.T) {
-	resetState()
+	s := NewServer()
 	ctx := context.Background()
 	ownerID := "owner1"
 	lockID := "lock1"
 	ttl := 100 * time.Millisecond
 
-	status, lock, err := Renew(ctx, ownerID, lockID, 1, ttl)
+	status, lock, err := s.Renew(ctx, ownerID, lockID, 1, ttl)
 	if err == nil {
 		t.Fatal("Expected error for renewing non-held lock, got nil")
 	}
@@ -187,14 +198,14 @@ func TestRenewNotHeld(t *testing.T) {
 }
 
 func TestRenewExpired(t *testing.T) {
-	resetState()
+	s := NewServer()
 	ctx := context.Background()
 	ownerID := "owner1"
 	lockID := "lock1"
 	ttl := 10 * time.Millisecond
 
 	// First acquire
-	status1, lock1, err1 := Acquire(ctx, ownerID, lockID, ttl)
+	status1, lock1, err1 := s.Acquire(ctx, ownerID, lockID, ttl)
 	if err1 != nil {
 		t.Fatalf("Acquire failed: %v", err1)
 	}
@@ -206,7 +217,7 @@ func TestRenewExpired(t *testing.T) {
 	time.Sleep(ttl + 10*time.Millisecond)
 
 	// Try to renew expired lock
-	status2, lock2, err2 := Renew(ctx, ownerID, lockID, lock1.FencingToken, ttl)
+	status2, lock2, err2 := s.Renew(ctx, ownerID, lockID, lock1.FencingToken, ttl)
 	if err2 == nil {
 		t.Fatal("Expected error for renewing expired lock, got nil")
 	}
@@ -219,7 +230,7 @@ func TestRenewExpired(t *testing.T) {
 }
 
 func TestRenewOwnerMismatch(t *testing.T) {
-	resetState()
+	s := NewServer()
 	ctx := context.Background()
 	ownerID := "owner1"
 	wrongOwnerID := "owner2"
@@ -227,7 +238,7 @@ func TestRenewOwnerMismatch(t *testing.T) {
 	ttl := 100 * time.Millisecond
 
 	// First acquire
-	status1, lock1, err1 := Acquire(ctx, ownerID, lockID, ttl)
+	status1, lock1, err1 := s.Acquire(ctx, ownerID, lockID, ttl)
 	if err1 != nil {
 		t.Fatalf("Acquire failed: %v", err1)
 	}
@@ -236,7 +247,7 @@ func TestRenewOwnerMismatch(t *testing.T) {
 	}
 
 	// Try to renew with wrong owner
-	status2, lock2, err2 := Renew(ctx, wrongOwnerID, lockID, lock1.FencingToken, ttl)
+	status2, lock2, err2 := s.Renew(ctx, wrongOwnerID, lockID, lock1.FencingToken, ttl)
 	if err2 == nil {
 		t.Fatal("Expected error for renewing with wrong owner, got nil")
 	}
@@ -249,14 +260,14 @@ func TestRenewOwnerMismatch(t *testing.T) {
 }
 
 func TestRenewFencingTokenMismatch(t *testing.T) {
-	resetState()
+	s := NewServer()
 	ctx := context.Background()
 	ownerID := "owner1"
 	lockID := "lock1"
 	ttl := 100 * time.Millisecond
 
 	// First acquire
-	status1, lock1, err1 := Acquire(ctx, ownerID, lockID, ttl)
+	status1, lock1, err1 := s.Acquire(ctx, ownerID, lockID, ttl)
 	if err1 != nil {
 		t.Fatalf("Acquire failed: %v", err1)
 	}
@@ -265,7 +276,7 @@ func TestRenewFencingTokenMismatch(t *testing.T) {
 	}
 
 	// Try to renew with wrong fencing token
-	status2, lock2, err2 := Renew(ctx, ownerID, lockID, lock1.FencingToken+1, ttl)
+	status2, lock2, err2 := s.Renew(ctx, ownerID, lockID, lock1.FencingToken+1, ttl)
 	if err2 == nil {
 		t.Fatal("Expected error for renewing with wrong fencing token, got nil")
 	}
@@ -277,15 +288,58 @@ func TestRenewFencingTokenMismatch(t *testing.T) {
 	}
 }
 
+func TestCompareAndRenew(t *testing.T) {
+	s := NewServer()
+	ctx := context.Background()
+	ownerID := "owner1"
+	lockID := "lock1"
+
+	_, lock, err := s.Acquire(ctx, ownerID, lockID, time.Second)
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	originalExpiresAt := lock.ExpiresAt
+
+	// Most of the lease is left, so nothing changes
+	status, _, err := s.CompareAndRenew(ctx, ownerID, lockID, lock.FencingToken, 2*time.Second, 100*time.Millisecond)
+	if err != nil {
+		t.Fatalf("CompareAndRenew failed: %v", err)
+	}
+	if status != clutcherrors.STATUS_RENEW_NOT_NEEDED {
+		t.Errorf("Expected status %d, got %d", clutcherrors.STATUS_RENEW_NOT_NEEDED, status)
+	}
+	if lock.ExpiresAt != originalExpiresAt {
+		t.Errorf("Expected expiresAt to stay %d, got %d", originalExpiresAt, lock.ExpiresAt)
+	}
+
+	// Under the threshold, the lease is extended
+	status, _, err = s.CompareAndRenew(ctx, ownerID, lockID, lock.FencingToken, 2*time.Second, 5*time.Second)
+	if err != nil {
+		t.Fatalf("CompareAndRenew failed: %v", err)
+	}
+	if status != clutcherrors.STATUS_SUCCESS {
+		t.Errorf("Expected status %d, got %d", clutcherrors.STATUS_SUCCESS, status)
+	}
+	if lock.ExpiresAt <= originalExpiresAt {
+		t.Errorf("Expected expiresAt > %d, got %d", originalExpiresAt, lock.ExpiresAt)
+	}
+
+	// Ownership is still checked before deciding
+	status, _, err = s.CompareAndRenew(ctx, "owner2", lockID, lock.FencingToken, time.Second, 5*time.Second)
+	if err == nil || status != clutcherrors.STATUS_LOCK_NOT_HELD {
+		t.Errorf("Expected status %d for wrong owner, got %d (err=%v)", clutcherrors.STATUS_LOCK_NOT_HELD, status, err)
+	}
+}
+
 func TestRelease(t *testing.T) {
-	resetState()
+	s := NewServer()
 	ctx := context.Background()
 	ownerID := "owner1"
 	lockID := "lock1"
 	ttl := 100 * time.Millisecond
 
 	// First acquire
-	status1, lock1, err1 := Acquire(ctx, ownerID, lockID, ttl)
+	status1, lock1, err1 := s.Acquire(ctx, ownerID, lockID, ttl)
 	if err1 != nil {
 		t.Fatalf("Acquire failed: %v", err1)
 	}
@@ -294,7 +348,7 @@ func TestRelease(t *testing.T) {
 	}
 
 	// Release the lock
-	status2, err2 := Release(ctx, lockID, ownerID, lock1.FencingToken)
+	status2, err2 := s.Release(ctx, lockID, ownerID, lock1.FencingToken)
 	if err2 != nil {
 		t.Fatalf("Release failed: %v", err2)
 	}
@@ -303,7 +357,7 @@ func TestRelease(t *testing.T) {
 	}
 
 	// Verify lock is gone by trying to renew
-	status3, lock3, err3 := Renew(ctx, ownerID, lockID, lock1.FencingToken, ttl)
+	status3, lock3, err3 := s.Renew(ctx, ownerID, lockID, lock1.FencingToken, ttl)
 	if err3 == nil {
 		t.Fatal("Expected error for renewing released lock, got nil")
 	}
@@ -316,12 +370,12 @@ func TestRelease(t *testing.T) {
 }
 
 func TestReleaseNotHeld(t *testing.T) {
-	resetState()
+	s := NewServer()
 	ctx := context.Background()
 	ownerID := "owner1"
 	lockID := "lock1"
 
-	status, err := Release(ctx, lockID, ownerID, 1)
+	status, err := s.Release(ctx, lockID, ownerID, 1)
 	if err == nil {
 		t.Fatal("Expected error for releasing non-held lock, got nil")
 	}
@@ -331,14 +385,14 @@ func TestReleaseNotHeld(t *testing.T) {
 }
 
 func TestReleaseExpired(t *testing.T) {
-	resetState()
+	s := NewServer()
 	ctx := context.Background()
 	ownerID := "owner1"
 	lockID := "lock1"
 	ttl := 10 * time.Millisecond
 
 	// First acquire
-	status1, lock1, err1 := Acquire(ctx, ownerID, lockID, ttl)
+	status1, lock1, err1 := s.Acquire(ctx, ownerID, lockID, ttl)
 	if err1 != nil {
 		t.Fatalf("Acquire failed: %v", err1)
 	}
@@ -350,7 +404,7 @@ func TestReleaseExpired(t *testing.T) {
 	time.Sleep(ttl + 10*time.Millisecond)
 
 	// Try to release expired lock
-	status2, err2 := Release(ctx, lockID, ownerID, lock1.FencingToken)
+	status2, err2 := s.Release(ctx, lockID, ownerID, lock1.FencingToken)
 	if err2 == nil {
 		t.Fatal("Expected error for releasing expired lock, got nil")
 	}
@@ -360,7 +414,7 @@ func TestReleaseExpired(t *testing.T) {
 }
 
 func TestReleaseOwnerMismatch(t *testing.T) {
-	resetState()
+	s := NewServer()
 	ctx := context.Background()
 	ownerID := "owner1"
 	wrongOwnerID := "owner2"
@@ -368,7 +422,7 @@ func TestReleaseOwnerMismatch(t *testing.T) {
 	ttl := 100 * time.Millisecond
 
 	// First acquire
-	status1, lock1, err1 := Acquire(ctx, ownerID, lockID, ttl)
+	status1, lock1, err1 := s.Acquire(ctx, ownerID, lockID, ttl)
 	if err1 != nil {
 		t.Fatalf("Acquire failed: %v", err1)
 	}
@@ -377,7 +431,7 @@ func TestReleaseOwnerMismatch(t *testing.T) {
 	}
 
 	// Try to release with wrong owner
-	status2, err2 := Release(ctx, lockID, wrongOwnerID, lock1.FencingToken)
+	status2, err2 := s.Release(ctx, lockID, wrongOwnerID, lock1.FencingToken)
 	if err2 == nil {
 		t.Fatal("Expected error for releasing with wrong owner, got nil")
 	}
@@ -387,14 +441,14 @@ func TestReleaseOwnerMismatch(t *testing.T) {
 }
 
 func TestReleaseFencingTokenMismatch(t *testing.T) {
-	resetState()
+	s := NewServer()
 	ctx := context.Background()
 	ownerID := "owner1"
 	lockID := "lock1"
 	ttl := 100 * time.Millisecond
 
 	// First acquire
-	status1, lock1, err1 := Acquire(ctx, ownerID, lockID, ttl)
+	status1, lock1, err1 := s.Acquire(ctx, ownerID, lockID, ttl)
 	if err1 != nil {
 		t.Fatalf("Acquire failed: %v", err1)
 	}
@@ -403,7 +457,7 @@ func TestReleaseFencingTokenMismatch(t *testing.T) {
 	}
 
 	// Try to release with wrong fencing token
-	status2, err2 := Release(ctx, lockID, ownerID, lock1.FencingToken+1)
+	status2, err2 := s.Release(ctx, lockID, ownerID, lock1.FencingToken+1)
 	if err2 == nil {
 		t.Fatal("Expected error for releasing with wrong fencing token, got nil")
 	}
@@ -413,7 +467,7 @@ func TestReleaseFencingTokenMismatch(t *testing.T) {
 }
 
 func TestConcurrentAcquire(t *testing.T) {
-	resetState()
+	s := NewServer()
 	ctx := context.Background()
 	lockID := "lock1"
 	ttl := 100 * time.Millisecond
@@ -429,7 +483,7 @@ func TestConcurrentAcquire(t *testing.T) {
 			defer wg.Done()
 			ownerID := "owner" + string(rune('A'+id))
 
-			status, _, err := Acquire(ctx, ownerID, lockID, ttl)
+			status, _, err := s.Acquire(ctx, ownerID, lockID, ttl)
 			if err != nil {
 				if status != clutcherrors.STATUS_LOCK_HELD {
 					t.Errorf("Unexpected error: %v", err)
@@ -458,14 +512,14 @@ func TestConcurrentAcquire(t *testing.T) {
 }
 
 func TestFencingTokenIncrement(t *testing.T) {
-	resetState()
+	s := NewServer()
 	ctx := context.Background()
 	ownerID := "owner1"
 	lockID := "lock1"
 	ttl := 100 * time.Millisecond
 
 	// First acquire
-	status1, lock1, err1 := Acquire(ctx, ownerID, lockID, ttl)
+	status1, lock1, err1 := s.Acquire(ctx, ownerID, lockID, ttl)
 	if err1 != nil {
 		t.Fatalf("First Acquire failed: %v", err1)
 	}
@@ -476,7 +530,7 @@ func TestFencingTokenIncrement(t *testing.T) {
 	firstToken := lock1.FencingToken
 
 	// Release and acquire again
-	status2, err2 := Release(ctx, lockID, ownerID, lock1.FencingToken)
+	status2, err2 := s.Release(ctx, lockID, ownerID, lock1.FencingToken)
 	if err2 != nil {
 		t.Fatalf("Release failed: %v", err2)
 	}
@@ -485,7 +539,7 @@ func TestFencingTokenIncrement(t *testing.T) {
 	}
 
 	// Second acquire
-	status3, lock3, err3 := Acquire(ctx, ownerID, lockID, ttl)
+	status3, lock3, err3 := s.Acquire(ctx, ownerID, lockID, ttl)
 	if err3 != nil {
 		t.Fatalf("Second Acquire failed: %v", err3)
 	}
@@ -498,3 +552,659 @@ func TestFencingTokenIncrement(t *testing.T) {
 		t.Errorf("Expected fencing token > %d, got %d", firstToken, lock3.FencingToken)
 	}
 }
+
+func TestMaxLocksPerOwner(t *testing.T) {
+	s := NewServer(WithMaxLocksPerOwner(2))
+	ctx := context.Background()
+	ttl := 100 * time.Millisecond
+
+	for _, lockID := range []string{"lock1", "lock2"} {
+		status, _, err := s.Acquire(ctx, "owner1", lockID, ttl)
+		if err != nil {
+			t.Fatalf("Acquire %s failed: %v", lockID, err)
+		}
+		if status != clutcherrors.STATUS_SUCCESS {
+			t.Errorf("Expected status %d, got %d", clutcherrors.STATUS_SUCCESS, status)
+		}
+	}
+
+	// Third lock exceeds the cap
+	status, lock, err := s.Acquire(ctx, "owner1", "lock3", ttl)
+	if err == nil {
+		t.Fatal("Expected error for acquire past the cap, got nil")
+	}
+	if status != clutcherrors.STATUS_QUOTA_EXCEEDED {
+		t.Errorf("Expected status %d, got %d", clutcherrors.STATUS_QUOTA_EXCEEDED, status)
+	}
+	if lock != nil {
+		t.Error("Expected nil lock for failed acquire")
+	}
+
+	// Other owners are unaffected
+	status, _, err = s.Acquire(ctx, "owner2", "lock3", ttl)
+	if err != nil || status != clutcherrors.STATUS_SUCCESS {
+		t.Fatalf("Expected owner2 to acquire lock3, got status %d: %v", status, err)
+	}
+
+	// Releasing frees a slot
+	lockIface, _ := s.activeLocks.Load("lock1")
+	if _, err := s.Release(ctx, "lock1", "owner1", lockIface.(*Lock).FencingToken); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+	status, _, err = s.Acquire(ctx, "owner1", "lock4", ttl)
+	if err != nil || status != clutcherrors.STATUS_SUCCESS {
+		t.Fatalf("Expected acquire after release to succeed, got status %d: %v", status, err)
+	}
+}
+
+func TestMaxLocksPerOwnerExpiry(t *testing.T) {
+	s := NewServer(WithMaxLocksPerOwner(1))
+	ctx := context.Background()
+	ttl := 10 * time.Millisecond
+
+	if _, _, err := s.Acquire(ctx, "owner1", "lock1", ttl); err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+
+	// Wait for lock to expire, then let another owner take it over
+	time.Sleep(ttl + 10*time.Millisecond)
+	if _, _, err := s.Acquire(ctx, "owner2", "lock1", ttl); err != nil {
+		t.Fatalf("Acquire of expired lock failed: %v", err)
+	}
+
+	// owner1's expired hold no longer counts against its quota
+	status, _, err := s.Acquire(ctx, "owner1", "lock2", ttl)
+	if err != nil || status != clutcherrors.STATUS_SUCCESS {
+		t.Fatalf("Expected owner1 to acquire lock2, got status %d: %v", status, err)
+	}
+}
+
+func TestOwnerQuotaEntriesDeleted(t *testing.T) {
+	s := NewServer(WithMaxLocksPerOwner(1))
+	ctx := context.Background()
+
+	for i := range 10 {
+		ownerID := fmt.Sprintf("owner%d", i)
+		_, lock, err := s.Acquire(ctx, ownerID, "lock1", time.Second)
+		if err != nil {
+			t.Fatalf("Acquire by %s failed: %v", ownerID, err)
+		}
+		if _, err := s.Release(ctx, "lock1", ownerID, lock.FencingToken); err != nil {
+			t.Fatalf("Release by %s failed: %v", ownerID, err)
+		}
+	}
+
+	// Owners with nothing held leave no quota entry behind
+	n := 0
+	s.ownerLockCounts.Range(func(_, _ any) bool {
+		n++
+		return true
+	})
+	if n != 0 {
+		t.Errorf("Expected no owner quota entries, got %d", n)
+	}
+
+	// A returning owner is still held to the cap
+	if _, _, err := s.Acquire(ctx, "owner1", "lock1", time.Second); err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	if status, _, _ := s.Acquire(ctx, "owner1", "lock2", time.Second); status != clutcherrors.STATUS_QUOTA_EXCEEDED {
+		t.Errorf("Expected status %d, got %d", clutcherrors.STATUS_QUOTA_EXCEEDED, status)
+	}
+}
+
+func TestMaxTotalLocks(t *testing.T) {
+	s := NewServer(WithMaxTotalLocks(2))
+	ctx := context.Background()
+	ttl := 100 * time.Millisecond
+
+	_, lock1, err := s.Acquire(ctx, "owner1", "lock1", ttl)
+	if err != nil {
+		t.Fatalf("Acquire lock1 failed: %v", err)
+	}
+	if _, _, err := s.Acquire(ctx, "owner2", "lock2", ttl); err != nil {
+		t.Fatalf("Acquire lock2 failed: %v", err)
+	}
+
+	// Third lock exceeds the global cap regardless of owner
+	status, lock, err := s.Acquire(ctx, "owner3", "lock3", ttl)
+	if err == nil {
+		t.Fatal("Expected error for acquire past the global cap, got nil")
+	}
+	if status != clutcherrors.STATUS_QUOTA_EXCEEDED {
+		t.Errorf("Expected status %d, got %d", clutcherrors.STATUS_QUOTA_EXCEEDED, status)
+	}
+	if lock != nil {
+		t.Error("Expected nil lock for failed acquire")
+	}
+
+	// Releasing frees capacity
+	if _, err := s.Release(ctx, "lock1", "owner1", lock1.FencingToken); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+	status, _, err = s.Acquire(ctx, "owner3", "lock3", ttl)
+	if err != nil || status != clutcherrors.STATUS_SUCCESS {
+		t.Fatalf("Expected acquire after release to succeed, got status %d: %v", status, err)
+	}
+}
+
+func TestMaxTotalLocksIgnoresExpired(t *testing.T) {
+	s := NewServer(WithMaxTotalLocks(1))
+	ctx := context.Background()
+	ttl := 10 * time.Millisecond
+
+	if _, _, err := s.Acquire(ctx, "owner1", "lock1", ttl); err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+
+	// Wait for lock to expire without touching it again
+	time.Sleep(ttl + 10*time.Millisecond)
+
+	status, _, err := s.Acquire(ctx, "owner2", "lock2", ttl)
+	if err != nil || status != clutcherrors.STATUS_SUCCESS {
+		t.Fatalf("Expected expired lock not to count against the cap, got status %d: %v", status, err)
+	}
+}
+
+func TestMaxTotalLocksRejectedLeaveNoEntry(t *testing.T) {
+	s := NewServer(WithMaxTotalLocks(1), WithMaxLocksPerOwner(1))
+	ctx := context.Background()
+
+	if _, _, err := s.Acquire(ctx, "owner1", "lock1", time.Second); err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+
+	// Acquires turned away by either cap don't leave empty entries in the lock table
+	for i := range 100 {
+		lockID := fmt.Sprintf("rejected%d", i)
+		if status, _, _ := s.Acquire(ctx, "owner2", lockID, time.Second); status != clutcherrors.STATUS_QUOTA_EXCEEDED {
+			t.Fatalf("Expected status %d for %s, got %d", clutcherrors.STATUS_QUOTA_EXCEEDED, lockID, status)
+		}
+		if status, _, _ := s.Acquire(ctx, "owner1", lockID, time.Second); status != clutcherrors.STATUS_QUOTA_EXCEEDED {
+			t.Fatalf("Expected status %d for %s, got %d", clutcherrors.STATUS_QUOTA_EXCEEDED, lockID, status)
+		}
+	}
+	if ids := s.lockIDs(); len(ids) != 1 {
+		t.Errorf("Expected only lock1 in the lock table, got %d entries", len(ids))
+	}
+}
+
+func TestBump(t *testing.T) {
+	s := NewServer()
+	ctx := context.Background()
+	ownerID := "owner1"
+	lockID := "lock1"
+	ttl := 100 * time.Millisecond
+
+	_, lock1, err := s.Acquire(ctx, ownerID, lockID, ttl)
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	oldToken := lock1.FencingToken
+	oldExpiresAt := lock1.ExpiresAt
+
+	time.Sleep(10 * time.Millisecond)
+
+	status, lock2, err := s.Bump(ctx, lockID, ownerID, oldToken, 200*time.Millisecond)
+	if err != nil {
+		t.Fatalf("Bump failed: %v", err)
+	}
+	if status != clutcherrors.STATUS_SUCCESS {
+		t.Errorf("Expected status %d, got %d", clutcherrors.STATUS_SUCCESS, status)
+	}
+	if lock2.FencingToken <= oldToken {
+		t.Errorf("Expected fencing token > %d, got %d", oldToken, lock2.FencingToken)
+	}
+	if lock2.ExpiresAt <= oldExpiresAt {
+		t.Errorf("Expected expiresAt > %d, got %d", oldExpiresAt, lock2.ExpiresAt)
+	}
+
+	// The old token is no longer valid
+	status, _, err = s.Renew(ctx, ownerID, lockID, oldToken, ttl)
+	if err == nil {
+		t.Fatal("Expected error renewing with the pre-bump token, got nil")
+	}
+	if status != clutcherrors.STATUS_LOCK_NOT_HELD {
+		t.Errorf("Expected status %d, got %d", clutcherrors.STATUS_LOCK_NOT_HELD, status)
+	}
+
+	// Bumping again keeps increasing the token
+	newToken := lock2.FencingToken
+	_, lock3, err := s.Bump(ctx, lockID, ownerID, newToken, ttl)
+	if err != nil {
+		t.Fatalf("Second Bump failed: %v", err)
+	}
+	if lock3.FencingToken <= newToken {
+		t.Errorf("Expected fencing token > %d, got %d", newToken, lock3.FencingToken)
+	}
+}
+
+func TestBumpOwnerMismatch(t *testing.T) {
+	s := NewServer()
+	ctx := context.Background()
+	lockID := "lock1"
+	ttl := 100 * time.Millisecond
+
+	_, lock1, err := s.Acquire(ctx, "owner1", lockID, ttl)
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	token := lock1.FencingToken
+
+	status, lock2, err := s.Bump(ctx, lockID, "owner2", token, ttl)
+	if err == nil {
+		t.Fatal("Expected error for bumping with wrong owner, got nil")
+	}
+	if status != clutcherrors.STATUS_LOCK_NOT_HELD {
+		t.Errorf("Expected status %d, got %d", clutcherrors.STATUS_LOCK_NOT_HELD, status)
+	}
+	if lock2 != nil {
+		t.Error("Expected nil lock for failed bump")
+	}
+	if lock1.FencingToken != token {
+		t.Errorf("Expected fencing token to stay %d, got %d", token, lock1.FencingToken)
+	}
+}
+
+func TestAcquireExpiryGrace(t *testing.T) {
+	s := NewServer(WithExpiryGrace(50 * time.Millisecond))
+	ctx := context.Background()
+	lockID := "lock1"
+	ttl := 10 * time.Millisecond
+
+	if _, _, err := s.Acquire(ctx, "owner1", lockID, ttl); err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+
+	// Expired, but still inside the grace window
+	time.Sleep(ttl + 10*time.Millisecond)
+	status, lock, err := s.Acquire(ctx, "owner2", lockID, ttl)
+	if err == nil {
+		t.Fatal("Expected error for acquire within grace period, got nil")
+	}
+	if status != clutcherrors.STATUS_LOCK_HELD {
+		t.Errorf("Expected status %d, got %d", clutcherrors.STATUS_LOCK_HELD, status)
+	}
+	if lock != nil {
+		t.Error("Expected nil lock for failed acquire")
+	}
+
+	// The old holder can't renew during the grace window either
+	status, _, err = s.Renew(ctx, "owner1", lockID, 1, ttl)
+	if err == nil || status != clutcherrors.STATUS_LOCK_NOT_HELD {
+		t.Errorf("Expected renew within grace to fail with status %d, got %d: %v", clutcherrors.STATUS_LOCK_NOT_HELD, status, err)
+	}
+
+	// Observing the expiry must not cut the grace window short
+	if status, _, _ := s.Acquire(ctx, "owner2", lockID, ttl); status != clutcherrors.STATUS_LOCK_HELD {
+		t.Errorf("Expected status %d after failed renew, got %d", clutcherrors.STATUS_LOCK_HELD, status)
+	}
+
+	// After the grace window the lock is free
+	time.Sleep(s.expiryGrace)
+	status, lock, err = s.Acquire(ctx, "owner2", lockID, ttl)
+	if err != nil {
+		t.Fatalf("Acquire after grace failed: %v", err)
+	}
+	if status != clutcherrors.STATUS_SUCCESS || lock.OwnerID != "owner2" {
+		t.Errorf("Expected owner2 to acquire, got status %d", status)
+	}
+}
+
+func TestAcquireAbove(t *testing.T) {
+	s := NewServer()
+	ctx := context.Background()
+	lockID := "lock1"
+
+	_, lock, err := s.Acquire(ctx, "owner1", lockID, time.Second)
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	token := lock.FencingToken
+	if _, err := s.Release(ctx, lockID, "owner1", token); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+
+	// A floor above the counter forces a larger grant
+	_, lock, err = s.AcquireAbove(ctx, "owner2", lockID, time.Second, 100)
+	if err != nil {
+		t.Fatalf("AcquireAbove failed: %v", err)
+	}
+	if lock.FencingToken != 101 {
+		t.Errorf("Expected fencing token 101, got %d", lock.FencingToken)
+	}
+	if _, err := s.Release(ctx, lockID, "owner2", lock.FencingToken); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+
+	// A floor below the counter changes nothing
+	_, lock, err = s.AcquireAbove(ctx, "owner3", lockID, time.Second, 5)
+	if err != nil {
+		t.Fatalf("AcquireAbove failed: %v", err)
+	}
+	if lock.FencingToken != 102 {
+		t.Errorf("Expected fencing token 102, got %d", lock.FencingToken)
+	}
+
+	status, _, err := s.AcquireAbove(ctx, "owner1", "lock2", time.Second, math.MaxUint64)
+	if err == nil || status != clutcherrors.STATUS_INVALID_REQUEST {
+		t.Errorf("Expected status %d for unreachable floor, got %d", clutcherrors.STATUS_INVALID_REQUEST, status)
+	}
+}
+
+func TestAcquireWithValue(t *testing.T) {
+	s := NewServer(WithIdempotentAcquire(), WithRenewHandoff())
+	ctx := context.Background()
+
+	_, lock, err := s.AcquireWithValue(ctx, "owner1", "leader", time.Minute, "10.0.0.7:7000")
+	if err != nil {
+		t.Fatalf("AcquireWithValue failed: %v", err)
+	}
+	if info, _ := s.LockInfo("leader"); info.Value != "10.0.0.7:7000" {
+		t.Errorf("Expected value %q, got %q", "10.0.0.7:7000", info.Value)
+	}
+
+	// The hold keeps its value through renewals, handoffs and a retried acquire
+	if _, _, err := s.Renew(ctx, "owner1", "leader", lock.FencingToken, time.Minute); err != nil {
+		t.Fatalf("Renew failed: %v", err)
+	}
+	if _, _, err := s.AcquireWithValue(ctx, "owner1", "leader", time.Minute, "10.0.0.8:7000"); err != nil {
+		t.Fatalf("AcquireWithValue failed: %v", err)
+	}
+	if _, _, err := s.RenewHandoff(ctx, "owner1", "leader", lock.FencingToken, time.Minute, "owner2"); err != nil {
+		t.Fatalf("RenewHandoff failed: %v", err)
+	}
+	if info, _ := s.LockInfo("leader"); info.Value != "10.0.0.7:7000" {
+		t.Errorf("Expected value %q to be kept, got %q", "10.0.0.7:7000", info.Value)
+	}
+
+	// The next hold starts without one
+	if _, err := s.Release(ctx, "leader", "owner2", lock.FencingToken); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+	if _, _, err := s.Acquire(ctx, "owner3", "leader", time.Minute); err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	if info, _ := s.LockInfo("leader"); info.Value != "" {
+		t.Errorf("Expected no value, got %q", info.Value)
+	}
+}
+
+func TestRenewHandoff(t *testing.T) {
+	ctx := context.Background()
+	lockID := "lock1"
+
+	// Disabled unless explicitly allowed
+	disabled := NewServer()
+	_, lock, err := disabled.Acquire(ctx, "main", lockID, time.Second)
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	status, _, err := disabled.RenewHandoff(ctx, "main", lockID, lock.FencingToken, time.Second, "sidecar")
+	if err == nil || status != clutcherrors.STATUS_INVALID_REQUEST {
+		t.Errorf("Expected status %d while disabled, got %d", clutcherrors.STATUS_INVALID_REQUEST, status)
+	}
+
+	s := NewServer(WithRenewHandoff())
+	_, lock, err = s.Acquire(ctx, "main", lockID, time.Second)
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	token := lock.FencingToken
+
+	// The old owner still has to present the right token
+	if _, _, err := s.RenewHandoff(ctx, "main", lockID, token+1, time.Second, "sidecar"); err == nil {
+		t.Error("Expected handoff with a wrong token to fail")
+	}
+
+	status, lock, err = s.RenewHandoff(ctx, "main", lockID, token, time.Second, "sidecar")
+	if err != nil {
+		t.Fatalf("RenewHandoff failed: %v", err)
+	}
+	if status != clutcherrors.STATUS_SUCCESS {
+		t.Errorf("Expected status %d, got %d", clutcherrors.STATUS_SUCCESS, status)
+	}
+	if lock.OwnerID != "sidecar" {
+		t.Errorf("Expected owner sidecar, got %s", lock.OwnerID)
+	}
+	if lock.FencingToken != token {
+		t.Errorf("Expected fencing token to stay %d, got %d", token, lock.FencingToken)
+	}
+
+	// The new owner can now renew and release with the same token; the old one can't
+	if _, _, err := s.Renew(ctx, "main", lockID, token, time.Second); err == nil {
+		t.Error("Expected old owner renew to fail after handoff")
+	}
+	if _, err := s.Release(ctx, lockID, "sidecar", token); err != nil {
+		t.Fatalf("Release by new owner failed: %v", err)
+	}
+	if n := ownerCount(s, "main") + ownerCount(s, "sidecar"); n != 0 {
+		t.Errorf("Expected owner quotas to be returned, %d still counted", n)
+	}
+}
+
+// ownerCount reports how many locks are counted against ownerID; owners with none have no entry
+func ownerCount(s *Server, ownerID string) int64 {
+	countIface, ok := s.ownerLockCounts.Load(ownerID)
+	if !ok {
+		return 0
+	}
+	return atomic.LoadInt64(countIface.(*int64))
+}
+
+func TestAcquireIdempotentForHolder(t *testing.T) {
+	clock := &manualClock{}
+	clock.now.Store(1_000_000)
+	s := NewServer(WithClock(clock), WithIdempotentAcquire())
+	ctx := context.Background()
+
+	_, lock, err := s.Acquire(ctx, "owner1", "lock1", time.Second)
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	token := lock.FencingToken
+
+	// A retry by the holder refreshes the lease and keeps the token
+	clock.advance(400 * time.Millisecond)
+	status, lock, err := s.Acquire(ctx, "owner1", "lock1", time.Second)
+	if err != nil {
+		t.Fatalf("Re-acquire failed with status %d: %v", status, err)
+	}
+	if lock.FencingToken != token {
+		t.Errorf("Expected token %d to be kept, got %d", token, lock.FencingToken)
+	}
+	if lock.ExpiresAt != 1_001_400 {
+		t.Errorf("Expected expiry 1001400, got %d", lock.ExpiresAt)
+	}
+	if counter := s.FencingCounter("lock1"); counter != token {
+		t.Errorf("Expected no new token to be issued, counter is %d", counter)
+	}
+
+	// Other owners are still refused, and one release frees the lock
+	if status, _, _ := s.Acquire(ctx, "owner2", "lock1", time.Second); status != clutcherrors.STATUS_LOCK_HELD {
+		t.Errorf("Expected status %d for another owner, got %d", clutcherrors.STATUS_LOCK_HELD, status)
+	}
+	if _, err := s.Release(ctx, "lock1", "owner1", token); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+	if _, _, err := s.Acquire(ctx, "owner2", "lock1", time.Second); err != nil {
+		t.Errorf("Expected lock to be free after one release, got %v", err)
+	}
+
+	// Without the option the holder is refused like anyone else
+	s = NewServer()
+	if _, _, err := s.Acquire(ctx, "owner1", "lock1", time.Second); err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	if status, _, _ := s.Acquire(ctx, "owner1", "lock1", time.Second); status != clutcherrors.STATUS_LOCK_HELD {
+		t.Errorf("Expected status %d by default, got %d", clutcherrors.STATUS_LOCK_HELD, status)
+	}
+}
+
+func TestCheckAcquire(t *testing.T) {
+	clock := &manualClock{}
+	clock.now.Store(1000)
+	s := NewServer(WithClock(clock), WithIdempotentAcquire())
+	ctx := context.Background()
+
+	status, lock, err := s.CheckAcquire(ctx, "owner1", "lock1", time.Second)
+	if err != nil || status != clutcherrors.STATUS_SUCCESS {
+		t.Fatalf("Expected a fresh grant, got status %d: %v", status, err)
+	}
+
+	// Held, even by the caller, is a conflict rather than a refresh
+	if status, _, _ := s.CheckAcquire(ctx, "owner1", "lock1", time.Second); status != clutcherrors.STATUS_LOCK_HELD {
+		t.Errorf("Expected status %d for a live hold, got %d", clutcherrors.STATUS_LOCK_HELD, status)
+	}
+
+	// A released lock had no holder left, so the next grant is fresh
+	if _, err := s.Release(ctx, "lock1", "owner1", lock.FencingToken); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+	status, lock, err = s.CheckAcquire(ctx, "owner2", "lock1", time.Second)
+	if err != nil || status != clutcherrors.STATUS_SUCCESS {
+		t.Fatalf("Expected a fresh grant after release, got status %d: %v", status, err)
+	}
+
+	// owner2 lets its lease lapse, so owner3 takes the lock from it
+	lapsedToken := lock.FencingToken
+	clock.advance(2 * time.Second)
+	status, reclaimed, err := s.CheckAcquire(ctx, "owner3", "lock1", time.Second)
+	if err != nil {
+		t.Fatalf("CheckAcquire failed: %v", err)
+	}
+	if status != clutcherrors.STATUS_ACQUIRED_RECLAIMED {
+		t.Errorf("Expected status %d, got %d", clutcherrors.STATUS_ACQUIRED_RECLAIMED, status)
+	}
+	if reclaimed == nil || reclaimed.OwnerID != "owner3" || reclaimed.FencingToken <= lapsedToken {
+		t.Errorf("Expected owner3 to hold a newer token than %d, got %+v", lapsedToken, reclaimed)
+	}
+
+	resp := s.Dispatch(ctx, newRequest(protocol.CHECK_ACQUIRE, "lock2", "owner1", 1000, 0))
+	if resp.Status != clutcherrors.STATUS_SUCCESS || resp.FencingToken == 0 {
+		t.Errorf("Expected a fresh grant over the wire, got %+v", resp)
+	}
+}
+
+func TestFencedOutStatus(t *testing.T) {
+	for _, fencedOut := range []bool{false, true} {
+		clock := &manualClock{}
+		clock.now.Store(1000)
+		opts := []Option{WithClock(clock)}
+		want := clutcherrors.STATUS_LOCK_NOT_HELD
+		if fencedOut {
+			opts = append(opts, WithFencedOutStatus())
+			want = clutcherrors.STATUS_FENCED_OUT
+		}
+		s := NewServer(opts...)
+		ctx := context.Background()
+
+		// A's lease lapses and B takes the lock
+		_, lockA, err := s.Acquire(ctx, "ownerA", "lock1", time.Second)
+		if err != nil {
+			t.Fatalf("Acquire failed: %v", err)
+		}
+		tokenA := lockA.FencingToken
+		clock.advance(2 * time.Second)
+		_, lockB, err := s.Acquire(ctx, "ownerB", "lock1", time.Second)
+		if err != nil {
+			t.Fatalf("Acquire failed: %v", err)
+		}
+		tokenB := lockB.FencingToken
+
+		if status, _, _ := s.Renew(ctx, "ownerA", "lock1", tokenA, time.Second); status != want {
+			t.Errorf("fencedOut=%v: expected renew status %d, got %d", fencedOut, want, status)
+		}
+		if status, _, _ := s.Bump(ctx, "lock1", "ownerA", tokenA, time.Second); status != want {
+			t.Errorf("fencedOut=%v: expected bump status %d, got %d", fencedOut, want, status)
+		}
+		resp := s.Dispatch(ctx, newRequest(protocol.RELEASE, "lock1", "ownerA", 0, tokenA))
+		if resp.Status != want {
+			t.Errorf("fencedOut=%v: expected release status %d, got %d", fencedOut, want, resp.Status)
+		}
+		if fencedOut && resp.FencingToken != tokenB {
+			t.Errorf("Expected the response to carry B's token %d, got %d", tokenB, resp.FencingToken)
+		}
+
+		// B's hold is untouched
+		if info, ok := s.LockInfo("lock1"); !ok || info.OwnerID != "ownerB" || info.FencingToken != tokenB {
+			t.Errorf("fencedOut=%v: expected ownerB to keep token %d, got %+v (ok=%v)", fencedOut, tokenB, info, ok)
+		}
+	}
+}
+
+func TestSelfHeldStatus(t *testing.T) {
+	for _, selfHeld := range []bool{false, true} {
+		opts := []Option{}
+		want := clutcherrors.STATUS_LOCK_HELD
+		if selfHeld {
+			opts = append(opts, WithSelfHeldStatus())
+			want = clutcherrors.STATUS_HELD_BY_CALLER
+		}
+		s := NewServer(opts...)
+		ctx := context.Background()
+
+		granted := s.Dispatch(ctx, newRequest(protocol.ACQUIRE, "lock1", "ownerA", 60_000, 0))
+		if granted.Status != clutcherrors.STATUS_SUCCESS {
+			t.Fatalf("Acquire failed: %+v", granted)
+		}
+
+		// The holder retrying its acquire is told it is blocked by itself
+		cmds := []uint8{protocol.ACQUIRE, protocol.CHECK_ACQUIRE}
+		if selfHeld {
+			// rather than queueing behind its own hold
+			cmds = append(cmds, protocol.ACQUIRE_WAIT)
+		}
+		for _, cmd := range cmds {
+			resp := s.Dispatch(ctx, newRequest(cmd, "lock1", "ownerA", 60_000, 0))
+			if resp.Status != want {
+				t.Errorf("selfHeld=%v: expected cmd %d status %d, got %d", selfHeld, cmd, want, resp.Status)
+			}
+			if selfHeld && (resp.FencingToken != granted.FencingToken || resp.ExpiresAt != granted.ExpiresAt) {
+				t.Errorf("Expected cmd %d to report the caller's hold %+v, got %+v", cmd, granted, resp)
+			}
+		}
+
+		// Anyone else is just told the lock is held
+		resp := s.Dispatch(ctx, newRequest(protocol.ACQUIRE, "lock1", "ownerB", 60_000, 0))
+		if resp.Status != clutcherrors.STATUS_LOCK_HELD {
+			t.Errorf("selfHeld=%v: expected status %d for another owner, got %d", selfHeld, clutcherrors.STATUS_LOCK_HELD, resp.Status)
+		}
+		if resp.FencingToken != 0 {
+			t.Errorf("Expected another owner not to see the hold's token, got %d", resp.FencingToken)
+		}
+
+		// and the hold is untouched, so the reported token renews it
+		resp = s.Dispatch(ctx, newRequest(protocol.RENEW, "lock1", "ownerA", 60_000, granted.FencingToken))
+		if resp.Status != clutcherrors.STATUS_SUCCESS {
+			t.Errorf("selfHeld=%v: expected renew with the reported token to succeed, got %d", selfHeld, resp.Status)
+		}
+	}
+}
+
+func TestSelfHeldStatusReportsHoldAtCheck(t *testing.T) {
+	clock := &manualClock{}
+	clock.now.Store(1_000_000)
+	s := NewServer(WithClock(clock), WithSelfHeldStatus())
+	ctx := context.Background()
+
+	_, granted, err := s.Acquire(ctx, "ownerA", "lock1", time.Second)
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	token := granted.FencingToken
+
+	status, hold, _ := s.Acquire(ctx, "ownerA", "lock1", time.Second)
+	if status != clutcherrors.STATUS_HELD_BY_CALLER || hold == nil {
+		t.Fatalf("Expected STATUS_HELD_BY_CALLER with the hold, got %d and %+v", status, hold)
+	}
+
+	// The hold lapses and passes to another owner before the caller reads what it was told
+	clock.advance(2 * time.Second)
+	if _, _, err := s.Acquire(ctx, "ownerB", "lock1", time.Second); err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	if hold.OwnerID != "ownerA" || hold.FencingToken != token {
+		t.Errorf("Expected the caller's own hold with token %d, got %+v", token, hold)
+	}
+}
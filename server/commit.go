@@ -0,0 +1,80 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/mrdhat/clutchdb/clutcherrors"
+	"github.com/mrdhat/clutchdb/command"
+	"github.com/mrdhat/clutchdb/wal"
+)
+
+// errNotLeader is returned for writes to a follower
+var errNotLeader = errors.New("not leader")
+
+// commit appends cmd to the commit log, if any, and syncs it. Must be called before the command's
+// effects are applied, with the lock's mutex held so commits for one lock stay in order.
+func (s *Server) commit(cmd command.Command) (clutcherrors.StatusCode, error) {
+	status, err := s.commitToLog(cmd)
+	if err != nil {
+		return status, err
+	}
+	s.counters.count(cmd.Type)
+	if s.events != nil {
+		s.events.Publish(cmd)
+	}
+	return status, nil
+}
+
+// commitToLog is the body of commit, before the event is published
+func (s *Server) commitToLog(cmd command.Command) (clutcherrors.StatusCode, error) {
+	if s.isFollower() {
+		return clutcherrors.STATUS_NOT_LEADER, errNotLeader
+	}
+	if s.commitLog == nil {
+		s.noteCommitted(cmd.LockID, false)
+		return clutcherrors.STATUS_SUCCESS, nil
+	}
+
+	n := atomic.AddInt64(&s.pendingCommits, 1)
+	defer atomic.AddInt64(&s.pendingCommits, -1)
+	if s.maxPendingCommits > 0 && n > int64(s.maxPendingCommits) {
+		return clutcherrors.STATUS_OVERLOADED, errors.New("too many pending commits")
+	}
+
+	if appender, ok := s.commitLog.(wal.SyncAppender); ok {
+		// One step, so a failed sync is known to have rolled back this record
+		if err := appender.AppendSync(cmd); err != nil {
+			return s.commitFailed(cmd, err)
+		}
+		s.noteCommitted(cmd.LockID, true)
+		return clutcherrors.STATUS_SUCCESS, nil
+	}
+
+	if err := s.commitLog.Append(cmd); err != nil {
+		return s.commitFailed(cmd, err)
+	}
+	if durable, ok := s.commitLog.(wal.DurableAppender); ok && durable.DurableAppends() {
+		// The append already waited for a group sync covering it
+		s.noteCommitted(cmd.LockID, true)
+		return clutcherrors.STATUS_SUCCESS, nil
+	}
+	if err := s.commitLog.Sync(); err != nil {
+		return s.commitFailed(cmd, fmt.Errorf("%w: %w", wal.ErrSyncFailed, err))
+	}
+	s.noteCommitted(cmd.LockID, true)
+	return clutcherrors.STATUS_SUCCESS, nil
+}
+
+// commitFailed reports a command that failed to commit with err. A record whose sync failed is
+// counted as logged only if it was left in the log, since recovery then replays it.
+func (s *Server) commitFailed(cmd command.Command, err error) (clutcherrors.StatusCode, error) {
+	if !errors.Is(err, wal.ErrSyncFailed) {
+		return clutcherrors.STATUS_OVERLOADED, fmt.Errorf("failed to append to wal: %w", err)
+	}
+	if errors.Is(err, wal.ErrNotRolledBack) {
+		s.noteCommitted(cmd.LockID, true)
+	}
+	return clutcherrors.STATUS_OVERLOADED, fmt.Errorf("failed to sync wal: %w", err)
+}
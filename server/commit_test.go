@@ -0,0 +1,185 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/mrdhat/clutchdb/clutcherrors"
+	"github.com/mrdhat/clutchdb/command"
+	"github.com/mrdhat/clutchdb/wal"
+	"github.com/mrdhat/clutchdb/wal/waltest"
+)
+
+// slowWAL blocks every Sync until release is closed
+type slowWAL struct {
+	wal.WAL
+	syncing chan struct{}
+	release chan struct{}
+}
+
+func (w *slowWAL) Sync() error {
+	w.syncing <- struct{}{}
+	<-w.release
+	return w.WAL.Sync()
+}
+
+// syncFailWAL fails AppendSync while fail is set, leaving the record in the log only if keep is
+type syncFailWAL struct {
+	wal.WAL
+	fail bool
+	keep bool
+}
+
+func (w *syncFailWAL) AppendSync(cmd command.Command) error {
+	if !w.fail {
+		if err := w.WAL.Append(cmd); err != nil {
+			return err
+		}
+		return w.WAL.Sync()
+	}
+	if !w.keep {
+		return fmt.Errorf("%w: sync failed", wal.ErrSyncFailed)
+	}
+	if err := w.WAL.Append(cmd); err != nil {
+		return err
+	}
+	return fmt.Errorf("%w: sync failed (%w)", wal.ErrSyncFailed, wal.ErrNotRolledBack)
+}
+
+func TestCommitLogRecordsCommands(t *testing.T) {
+	log := wal.NewWALWithStorage(wal.NewMemoryStorage())
+	s := NewServer(WithCommitLog(log))
+	ctx := context.Background()
+
+	_, lock, err := s.Acquire(ctx, "owner1", "lock1", time.Second)
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	if _, _, err := s.Renew(ctx, "owner1", "lock1", lock.FencingToken, time.Second); err != nil {
+		t.Fatalf("Renew failed: %v", err)
+	}
+	if _, err := s.Release(ctx, "lock1", "owner1", lock.FencingToken); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+
+	cmds, err := log.ReadAll()
+	if err != nil {
+		t.Fatalf("ReadAll failed: %v", err)
+	}
+	want := []command.CommandType{command.CmdAcquire, command.CmdRenew, command.CmdRelease}
+	if len(cmds) != len(want) {
+		t.Fatalf("Expected %d records, got %d", len(want), len(cmds))
+	}
+	for i, cmd := range cmds {
+		if cmd.Type != want[i] || cmd.LockID != "lock1" || cmd.FencingToken != lock.FencingToken {
+			t.Errorf("Unexpected record %d: %+v", i, cmd)
+		}
+	}
+}
+
+func TestCommitBackpressure(t *testing.T) {
+	slow := &slowWAL{
+		WAL:     wal.NewWALWithStorage(wal.NewMemoryStorage()),
+		syncing: make(chan struct{}, 1),
+		release: make(chan struct{}),
+	}
+	s := NewServer(WithCommitLog(slow), WithMaxPendingCommits(1))
+	ctx := context.Background()
+
+	done := make(chan clutcherrors.StatusCode)
+	go func() {
+		status, _, _ := s.Acquire(ctx, "owner1", "lock1", time.Second)
+		done <- status
+	}()
+	<-slow.syncing
+
+	// The only commit slot is stuck on disk, so a second command is turned away
+	status, _, err := s.Acquire(ctx, "owner2", "lock2", time.Second)
+	if err == nil {
+		t.Fatal("Expected acquire to be rejected while the WAL is stalled")
+	}
+	if status != clutcherrors.STATUS_OVERLOADED {
+		t.Errorf("Expected status %d, got %d", clutcherrors.STATUS_OVERLOADED, status)
+	}
+	if countIface, ok := s.ownerLockCounts.Load("owner2"); ok && *countIface.(*int64) != 0 {
+		t.Errorf("Expected rejected acquire to give back its quota, owner2 holds %d", *countIface.(*int64))
+	}
+
+	close(slow.release)
+	if status := <-done; status != clutcherrors.STATUS_SUCCESS {
+		t.Errorf("Expected stalled acquire to succeed once the WAL recovers, got %d", status)
+	}
+
+	// With the backlog drained, commands go through again
+	if _, _, err := s.Acquire(ctx, "owner2", "lock2", time.Second); err != nil {
+		t.Fatalf("Acquire after recovery failed: %v", err)
+	}
+}
+
+func TestCommitRejectedWhenAppendFails(t *testing.T) {
+	log := waltest.NewFaultyWAL()
+	log.FailAppend(1)
+	s := NewServer(WithCommitLog(log))
+	ctx := context.Background()
+
+	status, lock, err := s.Acquire(ctx, "owner1", "lock1", time.Second)
+	if !errors.Is(err, waltest.ErrInjected) {
+		t.Fatalf("Expected acquire to fail with the injected fault, got %v", err)
+	}
+	if status == clutcherrors.STATUS_SUCCESS || lock != nil {
+		t.Fatalf("Expected acquire to be rejected, got status %d", status)
+	}
+
+	// Nothing was applied, so the next attempt is granted the lock
+	_, lock, err = s.Acquire(ctx, "owner2", "lock1", time.Second)
+	if err != nil {
+		t.Fatalf("Acquire after failed commit failed: %v", err)
+	}
+	if lock.OwnerID != "owner2" {
+		t.Errorf("Expected owner2 to hold the lock, got %s", lock.OwnerID)
+	}
+}
+
+func TestCommitRejectedWhenSyncFails(t *testing.T) {
+	for _, keep := range []bool{false, true} {
+		log := &syncFailWAL{WAL: wal.NewWALWithStorage(wal.NewMemoryStorage()), fail: true, keep: keep}
+		s := NewServer(WithCommitLog(log))
+		ctx := context.Background()
+
+		status, _, err := s.Acquire(ctx, "owner1", "lock1", time.Second)
+		if !errors.Is(err, wal.ErrSyncFailed) || status != clutcherrors.STATUS_OVERLOADED {
+			t.Fatalf("Expected acquire to fail with a sync failure, got status %d: %v", status, err)
+		}
+
+		// The log offset only counts the record if it was left behind for recovery to replay
+		cmds, err := log.ReadAll()
+		if err != nil {
+			t.Fatalf("ReadAll failed: %v", err)
+		}
+		s.snapshotMu.Lock()
+		offset := s.logOffset
+		s.snapshotMu.Unlock()
+		if offset != uint64(len(cmds)) {
+			t.Errorf("Expected log offset %d with keep=%v, got %d", len(cmds), keep, offset)
+		}
+
+		// A rolled back acquire can't come back on replay
+		if !keep {
+			recovered := NewServer()
+			if err := recovered.Replay(cmds); err != nil {
+				t.Fatalf("Replay failed: %v", err)
+			}
+			if _, held := recovered.holdState("lock1", recovered.clock.NowMillis()); held {
+				t.Error("Expected a rolled back acquire not to be replayed")
+			}
+		}
+
+		log.fail = false
+		if _, _, err := s.Acquire(ctx, "owner2", "lock1", time.Second); err != nil {
+			t.Fatalf("Acquire after failed sync failed: %v", err)
+		}
+	}
+}
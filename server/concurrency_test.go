@@ -0,0 +1,206 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mrdhat/clutchdb/clutcherrors"
+)
+
+// grantedToken reads lock's fencing token under its mutex, since the Lock may be reused by a later hold
+func grantedToken(lock *Lock) uint64 {
+	lock.mu.Lock()
+	defer lock.mu.Unlock()
+	return lock.FencingToken
+}
+
+func TestConcurrentAcquireReleaseRounds(t *testing.T) {
+	s := NewServer()
+	ctx := context.Background()
+	const (
+		workers    = 8
+		iterations = 200
+	)
+
+	var (
+		holder    atomic.Value // owner that currently believes it holds the lock
+		lastToken uint64       // only touched by the current holder
+		grants    atomic.Int64
+		wg        sync.WaitGroup
+	)
+	holder.Store("")
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(ownerID string) {
+			defer wg.Done()
+			for i := 0; i < iterations; i++ {
+				status, lock, err := s.Acquire(ctx, ownerID, "lock1", time.Minute)
+				if status == clutcherrors.STATUS_LOCK_HELD {
+					continue
+				}
+				if err != nil {
+					t.Errorf("%s: unexpected acquire failure %d: %v", ownerID, status, err)
+					return
+				}
+
+				if !holder.CompareAndSwap("", ownerID) {
+					t.Errorf("%s was granted the lock while %s still holds it", ownerID, holder.Load())
+					return
+				}
+				token := grantedToken(lock)
+				if token <= lastToken {
+					t.Errorf("%s: token %d not greater than previous grant %d", ownerID, token, lastToken)
+				}
+				lastToken = token
+				grants.Add(1)
+				holder.Store("")
+
+				if _, err := s.Release(ctx, "lock1", ownerID, token); err != nil {
+					t.Errorf("%s: release of token %d failed: %v", ownerID, token, err)
+					return
+				}
+			}
+		}(fmt.Sprintf("owner%d", w))
+	}
+	wg.Wait()
+
+	if grants.Load() == 0 {
+		t.Fatal("Expected some acquires to succeed")
+	}
+	if n := atomic.LoadInt64(&s.liveLockCount); n != 0 {
+		t.Errorf("Expected no live locks after every grant was released, got %d", n)
+	}
+}
+
+func TestConcurrentAcquireAfterExpiry(t *testing.T) {
+	clock := &manualClock{}
+	clock.now.Store(1_000_000)
+	s := NewServer(WithClock(clock))
+	ctx := context.Background()
+	const (
+		workers = 8
+		rounds  = 100
+		ttl     = 10 * time.Millisecond
+	)
+
+	var lastToken uint64
+	for round := 0; round < rounds; round++ {
+		// Every round races for a lock whose previous hold has just lapsed
+		clock.advance(ttl + time.Millisecond)
+
+		var (
+			start   = make(chan struct{})
+			wg      sync.WaitGroup
+			mu      sync.Mutex
+			winners []uint64
+		)
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func(ownerID string) {
+				defer wg.Done()
+				<-start
+				status, lock, err := s.Acquire(ctx, ownerID, "lock1", ttl)
+				switch status {
+				case clutcherrors.STATUS_SUCCESS:
+					mu.Lock()
+					winners = append(winners, grantedToken(lock))
+					mu.Unlock()
+				case clutcherrors.STATUS_LOCK_HELD:
+				default:
+					t.Errorf("%s: unexpected acquire failure %d: %v", ownerID, status, err)
+				}
+			}(fmt.Sprintf("owner%d", w))
+		}
+		close(start)
+		wg.Wait()
+
+		if len(winners) != 1 {
+			t.Fatalf("Round %d: expected exactly 1 winner, got %d with tokens %v", round, len(winners), winners)
+		}
+		if winners[0] <= lastToken {
+			t.Fatalf("Round %d: token %d not greater than previous %d", round, winners[0], lastToken)
+		}
+		lastToken = winners[0]
+	}
+
+	// Expired holds handed over by acquire are accounted for exactly once
+	if n := atomic.LoadInt64(&s.liveLockCount); n != 1 {
+		t.Errorf("Expected 1 live lock, got %d", n)
+	}
+	var owned int64
+	s.ownerLockCounts.Range(func(_, value any) bool {
+		owned += atomic.LoadInt64(value.(*int64))
+		return true
+	})
+	if owned != 1 {
+		t.Errorf("Expected owner counts to total 1, got %d", owned)
+	}
+}
+
+// signalClock is a manualClock that reports each read on reads
+type signalClock struct {
+	manualClock
+	reads chan struct{}
+}
+
+func (c *signalClock) NowMillis() uint64 {
+	select {
+	case c.reads <- struct{}{}:
+	default:
+	}
+	return c.manualClock.NowMillis()
+}
+
+func TestAcquireRechecksExpiryUnderMutex(t *testing.T) {
+	clock := &signalClock{reads: make(chan struct{}, 1)}
+	clock.now.Store(1_000_000)
+	s := NewServer(WithClock(clock))
+	ctx := context.Background()
+	const ttl = 10 * time.Millisecond
+
+	if status, _, err := s.Acquire(ctx, "owner0", "lock1", ttl); err != nil {
+		t.Fatalf("Acquire failed: %d %v", status, err)
+	}
+	clock.advance(ttl + time.Millisecond)
+
+	lockIface, _ := s.activeLocks.Load("lock1")
+	lock := lockIface.(*Lock)
+
+	// Hold the expired lock's mutex so the next acquire has to wait on it
+	lock.mu.Lock()
+	for len(clock.reads) > 0 {
+		<-clock.reads
+	}
+	type result struct {
+		status clutcherrors.StatusCode
+		err    error
+	}
+	done := make(chan result, 1)
+	go func() {
+		status, _, err := s.Acquire(ctx, "owner1", "lock1", ttl)
+		done <- result{status, err}
+	}()
+	select {
+	case <-clock.reads:
+	case <-time.After(50 * time.Millisecond):
+	}
+	// Time passes while owner1 is blocked; a clock read from before the wait is now stale
+	clock.advance(ttl + time.Millisecond)
+	lock.mu.Unlock()
+
+	first := <-done
+	if first.err != nil {
+		t.Fatalf("Acquire by owner1 failed: %d %v", first.status, first.err)
+	}
+
+	// owner1's hold must run a full ttl from when it was granted, so owner2 is turned away
+	status, _, err := s.Acquire(ctx, "owner2", "lock1", ttl)
+	if status != clutcherrors.STATUS_LOCK_HELD {
+		t.Fatalf("Expected STATUS_LOCK_HELD for owner2, got %d (%v): lock granted twice", status, err)
+	}
+}
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"maps"
+	"time"
+
+	"github.com/mrdhat/clutchdb/protocol"
+)
+
+// WithDefaultTTL makes an ACQUIRE, ACQUIRE_WAIT or CHECK_ACQUIRE with a zero TTL hold the lock
+// for a server-chosen TTL, so clients needn't hard-code one: the lock's entry in perLock if it
+// has one, d otherwise. The default goes through the same TTL bounds as one the client sent, and
+// the response's expires_at tells the client what it got. A zero d leaves locks not in perLock
+// without a default.
+//
+// This replaces the rejection of zero-TTL acquires under WithStrictValidation for every lock
+// with a default: a server either fills the TTL in or refuses the request, never both.
+func WithDefaultTTL(d time.Duration, perLock map[string]time.Duration) Option {
+	return func(s *Server) {
+		s.defaultTTL = d
+		s.lockDefaultTTLs = maps.Clone(perLock)
+	}
+}
+
+// defaultTTLFor returns the TTL a zero-TTL acquire of lockID gets, 0 if none
+func (s *Server) defaultTTLFor(lockID string) time.Duration {
+	if d, ok := s.lockDefaultTTLs[lockID]; ok {
+		return d
+	}
+	return s.defaultTTL
+}
+
+// applyDefaultTTL returns req with its zero TTL replaced by the lock's default, if req is an
+// acquire that has one, leaving the caller's request untouched
+func (s *Server) applyDefaultTTL(req *protocol.Request) *protocol.Request {
+	if req.TTLMS != 0 || !acquires(req.Cmd) {
+		return req
+	}
+	d := s.defaultTTLFor(idString(req.LockID))
+	if d <= 0 {
+		return req
+	}
+	copied := *req
+	copied.TTLMS = protocol.DurationToMillis(d)
+	return &copied
+}
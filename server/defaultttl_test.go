@@ -0,0 +1,66 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mrdhat/clutchdb/clutcherrors"
+	"github.com/mrdhat/clutchdb/protocol"
+)
+
+func TestDefaultTTL(t *testing.T) {
+	clock := &manualClock{}
+	clock.now.Store(1000)
+	s := NewServer(WithClock(clock), WithStrictValidation(),
+		WithDefaultTTL(30*time.Second, map[string]time.Duration{"slow": 5 * time.Minute}))
+	ctx := context.Background()
+
+	resp := s.Dispatch(ctx, newRequest(protocol.ACQUIRE, "lock1", "owner1", 0, 0))
+	if resp.Status != clutcherrors.STATUS_SUCCESS {
+		t.Fatalf("Expected zero-TTL acquire to succeed, got %+v", resp)
+	}
+	if resp.ExpiresAt != 1000+30_000 {
+		t.Errorf("Expected the global default expiry %d, got %d", 1000+30_000, resp.ExpiresAt)
+	}
+
+	resp = s.Dispatch(ctx, newRequest(protocol.CHECK_ACQUIRE, "slow", "owner1", 0, 0))
+	if resp.Status != clutcherrors.STATUS_SUCCESS {
+		t.Fatalf("Expected zero-TTL check acquire to succeed, got %+v", resp)
+	}
+	if resp.ExpiresAt != 1000+300_000 {
+		t.Errorf("Expected the lock's own default expiry %d, got %d", 1000+300_000, resp.ExpiresAt)
+	}
+
+	// A TTL the client sends still wins
+	resp = s.Dispatch(ctx, newRequest(protocol.ACQUIRE, "lock2", "owner1", 500, 0))
+	if resp.ExpiresAt != 1000+500 {
+		t.Errorf("Expected the requested expiry %d, got %d", 1000+500, resp.ExpiresAt)
+	}
+
+	// Renew has no default and is still rejected in strict mode
+	resp = s.Dispatch(ctx, newRequest(protocol.RENEW, "lock2", "owner1", 0, resp.FencingToken))
+	if resp.Status != clutcherrors.STATUS_INVALID_REQUEST {
+		t.Errorf("Expected zero-TTL renew to be rejected, got %+v", resp)
+	}
+}
+
+func TestDefaultTTLPerLockOnly(t *testing.T) {
+	s := NewServer(WithStrictValidation(), WithDefaultTTL(0, map[string]time.Duration{"lock1": time.Minute}))
+	ctx := context.Background()
+
+	if resp := s.Dispatch(ctx, newRequest(protocol.ACQUIRE, "lock1", "owner1", 0, 0)); resp.Status != clutcherrors.STATUS_SUCCESS {
+		t.Errorf("Expected zero-TTL acquire of a lock with a default to succeed, got %+v", resp)
+	}
+	if resp := s.Dispatch(ctx, newRequest(protocol.ACQUIRE, "lock2", "owner1", 0, 0)); resp.Status != clutcherrors.STATUS_INVALID_REQUEST {
+		t.Errorf("Expected zero-TTL acquire of a lock without one to be rejected, got %+v", resp)
+	}
+}
+
+func TestDefaultTTLRespectsBounds(t *testing.T) {
+	s := NewServer(WithTTLBounds(time.Second, time.Minute), WithDefaultTTL(time.Hour, nil))
+	resp := s.Dispatch(context.Background(), newRequest(protocol.ACQUIRE, "lock1", "owner1", 0, 0))
+	if resp.Status != clutcherrors.STATUS_INVALID_REQUEST {
+		t.Errorf("Expected a default over the max TTL to be rejected, got %+v", resp)
+	}
+}
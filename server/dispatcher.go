@@ -0,0 +1,253 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/mrdhat/clutchdb/clutcherrors"
+	"github.com/mrdhat/clutchdb/protocol"
+)
+
+// Dispatch executes a decoded request and returns the response to send back
+func (s *Server) Dispatch(ctx context.Context, req *protocol.Request) *protocol.Response {
+	return s.handler(ctx, req)
+}
+
+// dispatch is the Handler at the core of the middleware chain
+func (s *Server) dispatch(ctx context.Context, req *protocol.Request) *protocol.Response {
+	if op, ok := frameOpFor(ctx, req); ok {
+		return s.executeFrame(ctx, req, op)
+	}
+
+	req, idErr := s.normalizeLockID(req)
+	if idErr != nil {
+		return s.failure(clutcherrors.STATUS_INVALID_REQUEST, idErr)
+	}
+	lockID := idString(req.LockID)
+	req = s.applyDefaultTTL(req)
+
+	if s.isFollower() && mutates(req.Cmd) {
+		return s.notLeader()
+	}
+
+	if req.TTLMS > protocol.MaxTTLMillis {
+		// Would wrap negative as a time.Duration and grant an already expired hold
+		return s.failure(clutcherrors.STATUS_INVALID_REQUEST, fmt.Errorf("ttl too long: %dms, max %dms", req.TTLMS, protocol.MaxTTLMillis))
+	}
+
+	if takesTTL(req.Cmd) && !s.ttlInBounds(req.TTLMS) {
+		limits := s.Limits()
+		return s.failure(clutcherrors.STATUS_INVALID_REQUEST, fmt.Errorf("ttl %dms outside server bounds %d-%dms", req.TTLMS, limits.MinTTLMS, limits.MaxTTLMS))
+	}
+
+	if s.strictValidation && !wellFormed(req) {
+		return s.failure(clutcherrors.STATUS_INVALID_REQUEST, errors.New("fields set that the command does not use"))
+	}
+
+	if resp := s.admit(req); resp != nil {
+		return resp
+	}
+
+	if isAdmin(req.Cmd) {
+		return s.dispatchAdmin(ctx, req)
+	}
+
+	var (
+		status clutcherrors.StatusCode
+		lock   *Lock
+		err    error
+	)
+	if s.commandTimeout > 0 {
+		status, lock, err = s.executeWithTimeout(ctx, req)
+	} else {
+		status, lock, err = s.execute(ctx, req)
+	}
+
+	resp := s.failure(status, err)
+	if lock != nil {
+		lock.mu.Lock()
+		resp.FencingToken = lock.FencingToken
+		resp.ExpiresAt = lock.ExpiresAt
+		lock.mu.Unlock()
+		if s.simpleMode {
+			resp.FencingToken = 0
+		}
+	}
+	if (acquires(req.Cmd) || req.Cmd == protocol.WATCH) && status == clutcherrors.STATUS_LOCK_HELD {
+		// Tell the loser when the incumbent's hold lapses so it can back off until then
+		resp.ExpiresAt = s.freesAt(lockID)
+	}
+	if status == clutcherrors.STATUS_FENCED_OUT {
+		// Show the fenced-out caller the hold that replaced theirs
+		if info, ok := s.LockInfo(lockID); ok {
+			resp.FencingToken = info.FencingToken
+			resp.ExpiresAt = info.ExpiresAt
+		}
+	}
+	return resp
+}
+
+// notLeader answers a request that would change state on a follower, which only changes state
+// through replication
+func (s *Server) notLeader() *protocol.Response {
+	resp := s.failure(clutcherrors.STATUS_NOT_LEADER, errNotLeader)
+	resp.LeaderHint = s.LeaderHint()
+	return resp
+}
+
+// admit checks req against the request ID requirement and the owner's rate limit, returning
+// the response refusing it or nil to let it run
+func (s *Server) admit(req *protocol.Request) *protocol.Response {
+	if s.requireRequestID && req.RequestID == ([16]byte{}) {
+		return s.failure(clutcherrors.STATUS_INVALID_REQUEST, errors.New("missing request id"))
+	}
+	if s.rateLimiter != nil && !s.rateLimiter.Allow(idString(req.OwnerID)) {
+		return s.failure(clutcherrors.STATUS_RATE_LIMITED, errors.New("rate limited"))
+	}
+	return nil
+}
+
+// failure builds a response with status, explaining err in its message when the server sends
+// messages. err is ignored when nil.
+func (s *Server) failure(status clutcherrors.StatusCode, err error) *protocol.Response {
+	resp := &protocol.Response{Status: status}
+	if s.errorMessages && err != nil {
+		msg := err.Error()
+		if len(msg) > protocol.MaxMessageLength {
+			msg = strings.ToValidUTF8(msg[:protocol.MaxMessageLength], "")
+		}
+		resp.Message = msg
+	}
+	return resp
+}
+
+// execute runs req against the lock table and returns its status, the reason it failed and, on
+// success, the lock it acted on
+func (s *Server) execute(ctx context.Context, req *protocol.Request) (status clutcherrors.StatusCode, lock *Lock, err error) {
+	lockID := idString(req.LockID)
+	ownerID := idString(req.OwnerID)
+	ttl := protocol.MillisToDuration(req.TTLMS)
+
+	switch req.Cmd {
+	case protocol.ACQUIRE:
+		status, lock, err = s.acquireAbove(ctx, ownerID, lockID, ttl, idString(req.Value), req.FencingToken)
+	case protocol.ACQUIRE_WAIT:
+		maxQueue := math.MaxInt
+		if req.FencingToken < math.MaxInt {
+			maxQueue = int(req.FencingToken)
+		}
+		status, lock, err = s.AcquireWaitPriority(ctx, ownerID, lockID, ttl, maxQueue, req.Priority)
+	case protocol.CHECK_ACQUIRE:
+		status, lock, err = s.CheckAcquire(ctx, ownerID, lockID, ttl)
+	case protocol.RENEW:
+		if newOwnerID := idString(req.NewOwnerID); newOwnerID != "" {
+			status, lock, err = s.RenewHandoff(ctx, ownerID, lockID, req.FencingToken, ttl, newOwnerID)
+		} else {
+			status, lock, err = s.Renew(ctx, ownerID, lockID, req.FencingToken, ttl)
+		}
+	case protocol.COMPARE_AND_RENEW:
+		status, lock, err = s.CompareAndRenew(ctx, ownerID, lockID, req.FencingToken, ttl, protocol.MillisToDuration(req.ThresholdMS))
+	case protocol.RELEASE:
+		status, err = s.Release(ctx, lockID, ownerID, req.FencingToken)
+	case protocol.BUMP:
+		status, lock, err = s.Bump(ctx, lockID, ownerID, req.FencingToken, ttl)
+	case protocol.WATCH:
+		status, err = s.watch(ctx, lockID, ttl)
+	default:
+		status, err = clutcherrors.STATUS_INVALID_REQUEST, fmt.Errorf("unknown command %d", req.Cmd)
+	}
+	return status, lock, err
+}
+
+// executeWithTimeout runs execute under the command timeout. A command that doesn't finish in time,
+// typically one stuck behind a slow WAL sync, is answered with STATUS_OVERLOADED and its eventual
+// result is discarded. It may still take effect, so the caller must treat the outcome as unknown.
+func (s *Server) executeWithTimeout(ctx context.Context, req *protocol.Request) (clutcherrors.StatusCode, *Lock, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.commandTimeout)
+	defer cancel()
+
+	type result struct {
+		status clutcherrors.StatusCode
+		lock   *Lock
+		err    error
+	}
+	done := make(chan result, 1)
+	go func() {
+		status, lock, err := s.execute(ctx, req)
+		done <- result{status, lock, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.status, r.lock, r.err
+	case <-ctx.Done():
+		s.logger.Warn("command timed out", "cmd", req.Cmd, "lock", idString(req.LockID), "timeout", s.commandTimeout)
+		return clutcherrors.STATUS_OVERLOADED, nil, fmt.Errorf("command timed out after %v", s.commandTimeout)
+	}
+}
+
+// mutates reports whether cmd changes the lock table, and so needs a leader
+func mutates(cmd uint8) bool {
+	switch cmd {
+	case protocol.ACQUIRE, protocol.ACQUIRE_WAIT, protocol.CHECK_ACQUIRE, protocol.RENEW, protocol.COMPARE_AND_RENEW, protocol.RELEASE, protocol.BUMP,
+		protocol.ADVANCE_FENCING_COUNTER, protocol.RENEW_ALL, protocol.RELEASE_BY_PREFIX:
+		return true
+	default:
+		return false
+	}
+}
+
+// takesTTL reports whether cmd grants or extends a hold for the request's TTL
+func takesTTL(cmd uint8) bool {
+	switch cmd {
+	case protocol.ACQUIRE, protocol.ACQUIRE_WAIT, protocol.CHECK_ACQUIRE, protocol.RENEW, protocol.COMPARE_AND_RENEW, protocol.BUMP:
+		return true
+	default:
+		return false
+	}
+}
+
+// acquires reports whether cmd takes a new hold on a lock
+func acquires(cmd uint8) bool {
+	return cmd == protocol.ACQUIRE || cmd == protocol.ACQUIRE_WAIT || cmd == protocol.CHECK_ACQUIRE
+}
+
+// wellFormed reports whether req only sets the fields its command uses: ACQUIRE, ACQUIRE_WAIT,
+// CHECK_ACQUIRE, RENEW, COMPARE_AND_RENEW and BUMP need a TTL, RELEASE must not carry one, only
+// RENEW may name a new owner, CHECK_ACQUIRE takes no token floor and WATCH no token
+func wellFormed(req *protocol.Request) bool {
+	handoff := req.NewOwnerID != [16]byte{}
+	switch req.Cmd {
+	case protocol.ACQUIRE, protocol.ACQUIRE_WAIT, protocol.COMPARE_AND_RENEW, protocol.BUMP:
+		return req.TTLMS != 0 && !handoff
+	case protocol.CHECK_ACQUIRE:
+		return req.TTLMS != 0 && !handoff && req.FencingToken == 0
+	case protocol.RENEW:
+		return req.TTLMS != 0
+	case protocol.RELEASE:
+		return req.TTLMS == 0 && !handoff
+	case protocol.WATCH:
+		return req.FencingToken == 0 && !handoff
+	default:
+		return true
+	}
+}
+
+// idString converts a fixed-size wire identifier to its string key, dropping zero padding
+func idString(id [16]byte) string {
+	return string(bytes.TrimRight(id[:], "\x00"))
+}
+
+// DispatchBatch executes each request independently and returns their responses in order.
+// A failing item does not stop the items after it.
+func (s *Server) DispatchBatch(ctx context.Context, reqs []*protocol.Request) []*protocol.Response {
+	resps := make([]*protocol.Response, len(reqs))
+	for i, req := range reqs {
+		resps[i] = s.Dispatch(ctx, req)
+	}
+	return resps
+}
@@ -0,0 +1,402 @@
+package server
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/mrdhat/clutchdb/clutcherrors"
+	"github.com/mrdhat/clutchdb/protocol"
+	"github.com/mrdhat/clutchdb/wal/waltest"
+)
+
+// newRequest builds a wire request for lockID/ownerID padded to 16 bytes
+func newRequest(cmd uint8, lockID, ownerID string, ttlMS uint64, fencingToken uint64) *protocol.Request {
+	req := &protocol.Request{Cmd: cmd, TTLMS: ttlMS, FencingToken: fencingToken}
+	copy(req.LockID[:], lockID)
+	copy(req.OwnerID[:], ownerID)
+	return req
+}
+
+func TestDispatch(t *testing.T) {
+	s := NewServer()
+	ctx := context.Background()
+
+	resp := s.Dispatch(ctx, newRequest(protocol.ACQUIRE, "lock1", "owner1", 100, 0))
+	if resp.Status != clutcherrors.STATUS_SUCCESS {
+		t.Fatalf("Expected status %d, got %d", clutcherrors.STATUS_SUCCESS, resp.Status)
+	}
+	if resp.FencingToken == 0 || resp.ExpiresAt == 0 {
+		t.Fatalf("Expected token and expiry to be set, got %+v", resp)
+	}
+	token := resp.FencingToken
+
+	// Zero padding is stripped, so the lock is addressable by its plain name
+	if _, ok := s.activeLocks.Load("lock1"); !ok {
+		t.Error("Expected lock1 to be registered without padding")
+	}
+
+	resp = s.Dispatch(ctx, newRequest(protocol.ACQUIRE, "lock1", "owner2", 100, 0))
+	if resp.Status != clutcherrors.STATUS_LOCK_HELD {
+		t.Errorf("Expected status %d, got %d", clutcherrors.STATUS_LOCK_HELD, resp.Status)
+	}
+
+	resp = s.Dispatch(ctx, newRequest(protocol.RENEW, "lock1", "owner1", 200, token))
+	if resp.Status != clutcherrors.STATUS_SUCCESS || resp.FencingToken != token {
+		t.Errorf("Expected renew to keep token %d, got %+v", token, resp)
+	}
+
+	resp = s.Dispatch(ctx, newRequest(protocol.BUMP, "lock1", "owner1", 200, token))
+	if resp.Status != clutcherrors.STATUS_SUCCESS || resp.FencingToken <= token {
+		t.Errorf("Expected bump to increase token past %d, got %+v", token, resp)
+	}
+	token = resp.FencingToken
+
+	resp = s.Dispatch(ctx, newRequest(protocol.RELEASE, "lock1", "owner1", 0, token))
+	if resp.Status != clutcherrors.STATUS_SUCCESS {
+		t.Errorf("Expected status %d, got %d", clutcherrors.STATUS_SUCCESS, resp.Status)
+	}
+
+	resp = s.Dispatch(ctx, newRequest(99, "lock1", "owner1", 0, 0))
+	if resp.Status != clutcherrors.STATUS_INVALID_REQUEST {
+		t.Errorf("Expected status %d, got %d", clutcherrors.STATUS_INVALID_REQUEST, resp.Status)
+	}
+}
+
+func TestDispatchAcquireTokenFloor(t *testing.T) {
+	s := NewServer()
+
+	resp := s.Dispatch(context.Background(), newRequest(protocol.ACQUIRE, "lock1", "owner1", 100, 41))
+	if resp.Status != clutcherrors.STATUS_SUCCESS {
+		t.Fatalf("Expected status %d, got %d", clutcherrors.STATUS_SUCCESS, resp.Status)
+	}
+	if resp.FencingToken != 42 {
+		t.Errorf("Expected fencing token 42, got %d", resp.FencingToken)
+	}
+}
+
+func TestDispatchContentionCarriesExpiry(t *testing.T) {
+	s := NewServer(WithExpiryGrace(20 * time.Millisecond))
+	ctx := context.Background()
+
+	held := s.Dispatch(ctx, newRequest(protocol.ACQUIRE, "lock1", "owner1", 1000, 0))
+	if held.Status != clutcherrors.STATUS_SUCCESS {
+		t.Fatalf("Expected status %d, got %d", clutcherrors.STATUS_SUCCESS, held.Status)
+	}
+
+	resp := s.Dispatch(ctx, newRequest(protocol.ACQUIRE, "lock1", "owner2", 1000, 0))
+	if resp.Status != clutcherrors.STATUS_LOCK_HELD {
+		t.Fatalf("Expected status %d, got %d", clutcherrors.STATUS_LOCK_HELD, resp.Status)
+	}
+	if want := held.ExpiresAt + 20; resp.ExpiresAt != want {
+		t.Errorf("Expected conflict to report the lock frees at %d, got %d", want, resp.ExpiresAt)
+	}
+	if resp.FencingToken != 0 {
+		t.Errorf("Expected no fencing token on conflict, got %d", resp.FencingToken)
+	}
+}
+
+func TestDispatchRateLimited(t *testing.T) {
+	s := NewServer(WithRateLimiter(NewRateLimiter(20, 2)))
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		resp := s.Dispatch(ctx, newRequest(protocol.ACQUIRE, "lock1", "owner1", 100, 0))
+		if resp.Status == clutcherrors.STATUS_RATE_LIMITED {
+			t.Fatalf("Expected request %d within burst not to be rate limited", i)
+		}
+	}
+
+	resp := s.Dispatch(ctx, newRequest(protocol.ACQUIRE, "lock1", "owner1", 100, 0))
+	if resp.Status != clutcherrors.STATUS_RATE_LIMITED {
+		t.Fatalf("Expected status %d, got %d", clutcherrors.STATUS_RATE_LIMITED, resp.Status)
+	}
+
+	// A different owner has its own budget
+	resp = s.Dispatch(ctx, newRequest(protocol.ACQUIRE, "lock2", "owner2", 100, 0))
+	if resp.Status != clutcherrors.STATUS_SUCCESS {
+		t.Errorf("Expected status %d, got %d", clutcherrors.STATUS_SUCCESS, resp.Status)
+	}
+
+	// 20/s refills a token every 50ms
+	time.Sleep(60 * time.Millisecond)
+	resp = s.Dispatch(ctx, newRequest(protocol.ACQUIRE, "lock3", "owner1", 100, 0))
+	if resp.Status != clutcherrors.STATUS_SUCCESS {
+		t.Errorf("Expected limiter to recover, got status %d", resp.Status)
+	}
+}
+
+func TestDispatchRejectsOverflowingTTL(t *testing.T) {
+	s := NewServer()
+	ctx := context.Background()
+
+	for _, cmd := range []uint8{protocol.ACQUIRE, protocol.RENEW, protocol.BUMP} {
+		resp := s.Dispatch(ctx, newRequest(cmd, "lock1", "owner1", math.MaxUint64, 1))
+		if resp.Status != clutcherrors.STATUS_INVALID_REQUEST {
+			t.Errorf("Command %d: expected status %d, got %d", cmd, clutcherrors.STATUS_INVALID_REQUEST, resp.Status)
+		}
+	}
+	if _, ok := s.activeLocks.Load("lock1"); ok {
+		t.Error("Expected no lock to be created for an overflowing TTL")
+	}
+
+	// The largest TTL that fits is still accepted
+	resp := s.Dispatch(ctx, newRequest(protocol.ACQUIRE, "lock1", "owner1", protocol.MaxTTLMillis, 0))
+	if resp.Status != clutcherrors.STATUS_SUCCESS {
+		t.Fatalf("Expected status %d, got %d", clutcherrors.STATUS_SUCCESS, resp.Status)
+	}
+	if resp.ExpiresAt <= s.clock.NowMillis() {
+		t.Errorf("Expected expiry in the future, got %d", resp.ExpiresAt)
+	}
+}
+
+func TestDispatchCommandTimeout(t *testing.T) {
+	log := waltest.NewFaultyWAL()
+	log.StallSync(200 * time.Millisecond)
+	s := NewServer(WithCommitLog(log), WithCommandTimeout(20*time.Millisecond))
+	ctx := context.Background()
+
+	start := time.Now()
+	resp := s.Dispatch(ctx, newRequest(protocol.ACQUIRE, "lock1", "owner1", 1000, 0))
+	if resp.Status != clutcherrors.STATUS_OVERLOADED {
+		t.Fatalf("Expected status %d, got %d", clutcherrors.STATUS_OVERLOADED, resp.Status)
+	}
+	if resp.FencingToken != 0 {
+		t.Errorf("Expected no fencing token from an abandoned command, got %d", resp.FencingToken)
+	}
+	if elapsed := time.Since(start); elapsed >= 200*time.Millisecond {
+		t.Errorf("Expected dispatch to give up before the sync finished, took %v", elapsed)
+	}
+
+	// Commands that finish in time are unaffected, once the stalled sync holding the log is done
+	log.StallSync(0)
+	if err := log.Sync(); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	resp = s.Dispatch(ctx, newRequest(protocol.ACQUIRE, "lock2", "owner1", 1000, 0))
+	if resp.Status != clutcherrors.STATUS_SUCCESS {
+		t.Errorf("Expected status %d, got %d", clutcherrors.STATUS_SUCCESS, resp.Status)
+	}
+}
+
+func TestDispatchStrictValidation(t *testing.T) {
+	withNewOwner := func(req *protocol.Request) *protocol.Request {
+		copy(req.NewOwnerID[:], "owner2")
+		return req
+	}
+	rejected := []struct {
+		name string
+		req  *protocol.Request
+	}{
+		{"release with ttl", newRequest(protocol.RELEASE, "lock1", "owner1", 100, 1)},
+		{"release with new owner", withNewOwner(newRequest(protocol.RELEASE, "lock1", "owner1", 0, 1))},
+		{"renew without ttl", newRequest(protocol.RENEW, "lock1", "owner1", 0, 1)},
+		{"acquire without ttl", newRequest(protocol.ACQUIRE, "lock1", "owner1", 0, 0)},
+		{"acquire with new owner", withNewOwner(newRequest(protocol.ACQUIRE, "lock1", "owner1", 100, 0))},
+		{"bump without ttl", newRequest(protocol.BUMP, "lock1", "owner1", 0, 1)},
+		{"watch with token", newRequest(protocol.WATCH, "lock1", "owner1", 10, 1)},
+	}
+
+	ctx := context.Background()
+	for _, tc := range rejected {
+		s := NewServer(WithStrictValidation())
+		_, lock, err := s.Acquire(ctx, "owner1", "lock1", time.Minute)
+		if err != nil {
+			t.Fatalf("%s: Acquire failed: %v", tc.name, err)
+		}
+		tc.req.FencingToken = max(tc.req.FencingToken, lock.FencingToken)
+
+		if resp := s.Dispatch(ctx, tc.req); resp.Status != clutcherrors.STATUS_INVALID_REQUEST {
+			t.Errorf("%s: expected status %d, got %d", tc.name, clutcherrors.STATUS_INVALID_REQUEST, resp.Status)
+		}
+
+		// Lenient by default: the same request is not rejected as malformed
+		lenient := NewServer()
+		if resp := lenient.Dispatch(ctx, tc.req); resp.Status == clutcherrors.STATUS_INVALID_REQUEST {
+			t.Errorf("%s: expected default server to accept the request", tc.name)
+		}
+	}
+
+	// Well-formed requests still go through in strict mode
+	s := NewServer(WithStrictValidation())
+	resp := s.Dispatch(ctx, newRequest(protocol.ACQUIRE, "lock1", "owner1", 100, 0))
+	if resp.Status != clutcherrors.STATUS_SUCCESS {
+		t.Fatalf("Expected acquire to succeed, got %d", resp.Status)
+	}
+	resp = s.Dispatch(ctx, newRequest(protocol.RELEASE, "lock1", "owner1", 0, resp.FencingToken))
+	if resp.Status != clutcherrors.STATUS_SUCCESS {
+		t.Errorf("Expected release to succeed, got %d", resp.Status)
+	}
+}
+
+func TestDispatchAcquireValue(t *testing.T) {
+	s := NewServer(WithStrictValidation())
+	ctx := context.Background()
+
+	req := newRequest(protocol.ACQUIRE, "leader", "owner1", 100, 0)
+	copy(req.Value[:], "10.0.0.7:7000")
+	if resp := s.Dispatch(ctx, req); resp.Status != clutcherrors.STATUS_SUCCESS {
+		t.Fatalf("Expected status %d, got %d", clutcherrors.STATUS_SUCCESS, resp.Status)
+	}
+	if info, _ := s.LockInfo("leader"); info.Value != "10.0.0.7:7000" {
+		t.Errorf("Expected value %q, got %q", "10.0.0.7:7000", info.Value)
+	}
+}
+
+func TestDispatchRequireRequestID(t *testing.T) {
+	s := NewServer(WithRequireRequestID())
+	ctx := context.Background()
+
+	// newRequest leaves the request ID zero
+	resp := s.Dispatch(ctx, newRequest(protocol.ACQUIRE, "lock1", "owner1", 100, 0))
+	if resp.Status != clutcherrors.STATUS_INVALID_REQUEST {
+		t.Errorf("Expected status %d for a zero request ID, got %d", clutcherrors.STATUS_INVALID_REQUEST, resp.Status)
+	}
+	if _, ok := s.LockInfo("lock1"); ok {
+		t.Error("Expected the rejected acquire not to take the lock")
+	}
+	status, _ := s.DispatchRenewAll(ctx, &protocol.RenewAllRequest{TTLMS: 100})
+	if status != clutcherrors.STATUS_INVALID_REQUEST {
+		t.Errorf("Expected status %d for a RENEW_ALL with a zero request ID, got %d", clutcherrors.STATUS_INVALID_REQUEST, status)
+	}
+
+	// Any nonzero byte will do
+	req := newRequest(protocol.ACQUIRE, "lock1", "owner1", 100, 0)
+	req.RequestID[15] = 1
+	if resp := s.Dispatch(ctx, req); resp.Status != clutcherrors.STATUS_SUCCESS {
+		t.Errorf("Expected acquire with a request ID to succeed, got %d", resp.Status)
+	}
+
+	// Off by default
+	if resp := NewServer().Dispatch(ctx, newRequest(protocol.ACQUIRE, "lock1", "owner1", 100, 0)); resp.Status != clutcherrors.STATUS_SUCCESS {
+		t.Errorf("Expected default server to accept a zero request ID, got %d", resp.Status)
+	}
+}
+
+func TestDispatchBatch(t *testing.T) {
+	s := NewServer()
+	ctx := context.Background()
+
+	// lock2 is already held by someone else
+	if _, _, err := s.Acquire(ctx, "other", "lock2", time.Second); err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+
+	reqs := []*protocol.Request{
+		newRequest(protocol.ACQUIRE, "lock1", "owner1", 100, 0),
+		newRequest(protocol.ACQUIRE, "lock2", "owner1", 100, 0),
+		newRequest(protocol.ACQUIRE, "lock3", "owner1", 100, 0),
+		newRequest(protocol.BATCH, "lock4", "owner1", 100, 0),
+	}
+
+	resps := s.DispatchBatch(ctx, reqs)
+	if len(resps) != len(reqs) {
+		t.Fatalf("Expected %d responses, got %d", len(reqs), len(resps))
+	}
+
+	want := []clutcherrors.StatusCode{
+		clutcherrors.STATUS_SUCCESS,
+		clutcherrors.STATUS_LOCK_HELD,
+		clutcherrors.STATUS_SUCCESS,
+		clutcherrors.STATUS_INVALID_REQUEST, // batches don't nest
+	}
+	for i, status := range want {
+		if resps[i].Status != status {
+			t.Errorf("Item %d: expected status %d, got %d", i, status, resps[i].Status)
+		}
+	}
+	if resps[0].FencingToken == 0 || resps[2].FencingToken == 0 {
+		t.Error("Expected successful items to carry fencing tokens")
+	}
+	if _, ok := s.activeLocks.Load("lock3"); !ok {
+		t.Error("Expected item after a failure to still execute")
+	}
+}
+
+func TestDispatchSimpleMode(t *testing.T) {
+	s := NewServer(WithSimpleMode())
+	ctx := context.Background()
+
+	resp := s.Dispatch(ctx, newRequest(protocol.ACQUIRE, "lock1", "owner1", 1000, 0))
+	if resp.Status != clutcherrors.STATUS_SUCCESS || resp.FencingToken != 0 {
+		t.Fatalf("Expected acquire to succeed with token 0, got %+v", resp)
+	}
+
+	// The owner alone authenticates renew and release, whatever token is sent
+	resp = s.Dispatch(ctx, newRequest(protocol.RENEW, "lock1", "owner1", 2000, 0))
+	if resp.Status != clutcherrors.STATUS_SUCCESS || resp.FencingToken != 0 {
+		t.Errorf("Expected owner-only renew to succeed, got %+v", resp)
+	}
+	resp = s.Dispatch(ctx, newRequest(protocol.RENEW, "lock1", "owner2", 2000, 0))
+	if resp.Status != clutcherrors.STATUS_LOCK_NOT_HELD {
+		t.Errorf("Expected renew by another owner to fail, got %+v", resp)
+	}
+	resp = s.Dispatch(ctx, newRequest(protocol.RELEASE, "lock1", "owner1", 0, 42))
+	if resp.Status != clutcherrors.STATUS_SUCCESS {
+		t.Errorf("Expected owner-only release to succeed, got %+v", resp)
+	}
+
+	// Tokens are still issued behind the scenes, so they keep increasing
+	resp = s.Dispatch(ctx, newRequest(protocol.ACQUIRE, "lock1", "owner2", 1000, 0))
+	if resp.Status != clutcherrors.STATUS_SUCCESS {
+		t.Fatalf("Expected re-acquire to succeed, got %+v", resp)
+	}
+	if counter := s.FencingCounter("lock1"); counter != 2 {
+		t.Errorf("Expected internal counter 2, got %d", counter)
+	}
+}
+
+func TestDispatchFencingModeEnforcesToken(t *testing.T) {
+	s := NewServer()
+	ctx := context.Background()
+
+	resp := s.Dispatch(ctx, newRequest(protocol.ACQUIRE, "lock1", "owner1", 1000, 0))
+	if resp.Status != clutcherrors.STATUS_SUCCESS {
+		t.Fatalf("Expected acquire to succeed, got %+v", resp)
+	}
+	token := resp.FencingToken
+
+	for _, cmd := range []uint8{protocol.RENEW, protocol.BUMP, protocol.RELEASE} {
+		ttl := uint64(1000)
+		if cmd == protocol.RELEASE {
+			ttl = 0
+		}
+		resp = s.Dispatch(ctx, newRequest(cmd, "lock1", "owner1", ttl, token+1))
+		if resp.Status != clutcherrors.STATUS_LOCK_NOT_HELD {
+			t.Errorf("Command %d: expected a wrong token to be rejected, got %+v", cmd, resp)
+		}
+	}
+}
+
+func TestDispatchErrorMessages(t *testing.T) {
+	clock := &manualClock{}
+	clock.now.Store(1_000_000)
+	s := NewServer(WithClock(clock), WithErrorMessages())
+	ctx := context.Background()
+
+	resp := s.Dispatch(ctx, newRequest(protocol.ACQUIRE, "lock1", "owner1", 1000, 0))
+	if resp.Status != clutcherrors.STATUS_SUCCESS || resp.Message != "" {
+		t.Fatalf("Expected acquire to succeed without a message, got %+v", resp)
+	}
+	token := resp.FencingToken
+
+	resp = s.Dispatch(ctx, newRequest(protocol.RENEW, "lock1", "owner2", 1000, token))
+	if resp.Status != clutcherrors.STATUS_LOCK_NOT_HELD || resp.Message != "owner mismatch" {
+		t.Errorf("Expected owner mismatch, got %+v", resp)
+	}
+
+	clock.advance(1500 * time.Millisecond)
+	resp = s.Dispatch(ctx, newRequest(protocol.RENEW, "lock1", "owner1", 1000, token))
+	if resp.Status != clutcherrors.STATUS_LOCK_NOT_HELD || resp.Message != "lock expired 500ms ago" {
+		t.Errorf("Expected expiry message, got %+v", resp)
+	}
+
+	// Without the option the status alone is sent
+	s = NewServer()
+	s.Dispatch(ctx, newRequest(protocol.ACQUIRE, "lock1", "owner1", 1000, 0))
+	resp = s.Dispatch(ctx, newRequest(protocol.RENEW, "lock1", "owner2", 1000, 1))
+	if resp.Status != clutcherrors.STATUS_LOCK_NOT_HELD || resp.Message != "" {
+		t.Errorf("Expected no message by default, got %+v", resp)
+	}
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"github.com/mrdhat/clutchdb/command"
+)
+
+// LockEvent is one committed change to a lock
+type LockEvent struct {
+	Type         command.CommandType // CmdAcquire, CmdRenew, CmdRelease, CmdBump or CmdTransfer
+	LockID       string
+	OwnerID      string
+	NewOwnerID   string // owner the lock was handed to (CmdTransfer only)
+	FencingToken uint64 // token of the hold, the new one for CmdBump
+	ExpiresAt    uint64 // when the hold lapses in Unix milliseconds, 0 for CmdRelease
+	Timestamp    uint64 // commit time in Unix milliseconds
+}
+
+// EventBus streams every committed lock change to its subscribers, for audit pipelines and
+// external indexes. Delivery is at most once: each subscriber has a bounded buffer and when it
+// falls behind, its oldest undelivered events are dropped and counted in Dropped. Expiry is
+// lazy, so a hold that lapses produces no event of its own.
+type EventBus struct {
+	mu      sync.Mutex
+	subs    map[chan LockEvent]struct{}
+	buffer  int
+	dropped atomic.Uint64
+}
+
+// NewEventBus returns an EventBus that buffers up to buffer events per subscriber
+func NewEventBus(buffer int) *EventBus {
+	if buffer < 1 {
+		buffer = 1
+	}
+	return &EventBus{subs: make(map[chan LockEvent]struct{}), buffer: buffer}
+}
+
+// WithEventBus publishes each command to bus once it is durable, or as it is applied when the
+// server has no commit log
+func WithEventBus(bus *EventBus) Option {
+	return func(s *Server) {
+		s.events = bus
+	}
+}
+
+// Subscribe returns a channel of every event published from now until ctx is done, when the
+// channel is closed
+func (b *EventBus) Subscribe(ctx context.Context) <-chan LockEvent {
+	ch := make(chan LockEvent, b.buffer)
+	b.mu.Lock()
+	b.subs[ch] = struct{}{}
+	b.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		b.mu.Lock()
+		delete(b.subs, ch)
+		close(ch)
+		b.mu.Unlock()
+	}()
+	return ch
+}
+
+// Publish sends the event for cmd to every subscriber without blocking. Its signature matches
+// wal.WithAppendObserver, so a bus can also follow a log directly. Commands that don't change a
+// hold, such as CmdAdvanceFencing, are ignored.
+func (b *EventBus) Publish(cmd command.Command) {
+	event := LockEvent{
+		Type:         cmd.Type,
+		LockID:       cmd.LockID,
+		OwnerID:      cmd.OwnerID,
+		NewOwnerID:   cmd.NewOwnerID,
+		FencingToken: cmd.FencingToken,
+		Timestamp:    cmd.CommitTimeMillis,
+	}
+	switch cmd.Type {
+	case command.CmdAcquire, command.CmdRenew, command.CmdBump, command.CmdTransfer:
+		event.ExpiresAt = cmd.CommitTimeMillis + cmd.TTLMillis
+	case command.CmdRelease:
+	default:
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs {
+		select {
+		case ch <- event:
+			continue
+		default:
+		}
+		// Full: drop the oldest event to make room. Only Publish sends, under b.mu, so once
+		// there is room the send below can't block.
+		select {
+		case <-ch:
+			b.dropped.Add(1)
+		default:
+			// The subscriber made room itself
+		}
+		ch <- event
+	}
+}
+
+// Dropped returns how many events have been dropped across all subscribers because they fell behind
+func (b *EventBus) Dropped() uint64 {
+	return b.dropped.Load()
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mrdhat/clutchdb/command"
+)
+
+func TestEventBus(t *testing.T) {
+	clock := &manualClock{}
+	clock.now.Store(1_000_000)
+	bus := NewEventBus(16)
+	s := NewServer(WithClock(clock), WithEventBus(bus))
+	ctx, cancel := context.WithCancel(context.Background())
+	events := bus.Subscribe(ctx)
+
+	_, lock, err := s.Acquire(ctx, "owner1", "lock1", time.Second)
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	clock.advance(100 * time.Millisecond)
+	if _, _, err := s.Renew(ctx, "owner1", "lock1", lock.FencingToken, time.Second); err != nil {
+		t.Fatalf("Renew failed: %v", err)
+	}
+	if _, _, err := s.Bump(ctx, "lock1", "owner1", lock.FencingToken, time.Second); err != nil {
+		t.Fatalf("Bump failed: %v", err)
+	}
+	// A refused acquire changes nothing and publishes nothing
+	s.Acquire(ctx, "owner2", "lock1", time.Second)
+	if _, err := s.Release(ctx, "lock1", "owner1", lock.FencingToken); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+
+	want := []LockEvent{
+		{Type: command.CmdAcquire, LockID: "lock1", OwnerID: "owner1", FencingToken: 1, ExpiresAt: 1_001_000, Timestamp: 1_000_000},
+		{Type: command.CmdRenew, LockID: "lock1", OwnerID: "owner1", FencingToken: 1, ExpiresAt: 1_001_100, Timestamp: 1_000_100},
+		{Type: command.CmdBump, LockID: "lock1", OwnerID: "owner1", FencingToken: 2, ExpiresAt: 1_001_100, Timestamp: 1_000_100},
+		{Type: command.CmdRelease, LockID: "lock1", OwnerID: "owner1", FencingToken: 2, Timestamp: 1_000_100},
+	}
+	for i, w := range want {
+		if got := <-events; got != w {
+			t.Errorf("Event %d: expected %+v, got %+v", i, w, got)
+		}
+	}
+
+	cancel()
+	for range events {
+		t.Error("Expected no further events")
+	}
+}
+
+func TestEventBusDropsOldest(t *testing.T) {
+	bus := NewEventBus(2)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := bus.Subscribe(ctx)
+
+	// Nobody reads, so only the newest two survive
+	for token := uint64(1); token <= 5; token++ {
+		bus.Publish(command.Command{Type: command.CmdAcquire, LockID: "lock1", OwnerID: "owner1", FencingToken: token})
+	}
+	if dropped := bus.Dropped(); dropped != 3 {
+		t.Errorf("Expected 3 dropped events, got %d", dropped)
+	}
+	for _, token := range []uint64{4, 5} {
+		if got := <-events; got.FencingToken != token {
+			t.Errorf("Expected event for token %d, got %+v", token, got)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"errors"
+)
+
+// holdKey identifies one hold of a lock. A lock's fencing token changes every time it
+// is granted, so callbacks keyed by it can't fire for a later holder.
+type holdKey struct {
+	lockID       string
+	fencingToken uint64
+}
+
+// OnExpire registers fn to run once if the hold of lockID identified by fencingToken
+// expires, whether that is noticed by the reaper or by a later command. It does not run
+// if the hold is released cleanly. fn runs on its own goroutine.
+func (s *Server) OnExpire(lockID string, fencingToken uint64, fn func()) error {
+	now := s.clock.NowMillis()
+
+	lockIface, ok := s.activeLocks.Load(lockID)
+	if !ok {
+		return errors.New("lock not held")
+	}
+	lock := lockIface.(*Lock)
+
+	// Holding lock.mu orders registration against expiry and release of the same hold
+	lock.mu.Lock()
+	defer lock.mu.Unlock()
+
+	if lock.removed || lock.OwnerID == "" || lapsed(lock.ExpiresAt, now) {
+		return errors.New("lock not held")
+	}
+	if lock.FencingToken != fencingToken {
+		return errors.New("fencing token mismatch")
+	}
+
+	s.expiryCallbacksMu.Lock()
+	defer s.expiryCallbacksMu.Unlock()
+	key := holdKey{lockID: lockID, fencingToken: fencingToken}
+	s.expiryCallbacks[key] = append(s.expiryCallbacks[key], fn)
+	return nil
+}
+
+// fireExpiryCallbacks runs and forgets the callbacks registered for a hold
+func (s *Server) fireExpiryCallbacks(lockID string, fencingToken uint64) {
+	s.expiryCallbacksMu.Lock()
+	key := holdKey{lockID: lockID, fencingToken: fencingToken}
+	fns := s.expiryCallbacks[key]
+	delete(s.expiryCallbacks, key)
+	s.expiryCallbacksMu.Unlock()
+
+	for _, fn := range fns {
+		go fn()
+	}
+}
+
+// dropExpiryCallbacks forgets the callbacks for a hold without running them
+func (s *Server) dropExpiryCallbacks(lockID string, fencingToken uint64) {
+	s.expiryCallbacksMu.Lock()
+	defer s.expiryCallbacksMu.Unlock()
+	delete(s.expiryCallbacks, holdKey{lockID: lockID, fencingToken: fencingToken})
+}
+
+// moveExpiryCallbacks re-keys callbacks when a hold's fencing token is rotated
+func (s *Server) moveExpiryCallbacks(lockID string, from uint64, to uint64) {
+	s.expiryCallbacksMu.Lock()
+	defer s.expiryCallbacksMu.Unlock()
+
+	fromKey := holdKey{lockID: lockID, fencingToken: from}
+	if fns, ok := s.expiryCallbacks[fromKey]; ok {
+		delete(s.expiryCallbacks, fromKey)
+		s.expiryCallbacks[holdKey{lockID: lockID, fencingToken: to}] = fns
+	}
+}
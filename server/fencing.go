@@ -0,0 +1,76 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/mrdhat/clutchdb/clutcherrors"
+	"github.com/mrdhat/clutchdb/command"
+	"github.com/mrdhat/clutchdb/protocol"
+)
+
+// WithAdminCommands lets Dispatch serve GET_FENCING_COUNTER and ADVANCE_FENCING_COUNTER, and
+// Serve answer TOMBSTONES. Off by default: anyone who can reach the server could otherwise burn
+// through token space.
+func WithAdminCommands() Option {
+	return func(s *Server) {
+		s.adminCommands = true
+	}
+}
+
+// FencingCounter returns the last fencing token issued for lockID, 0 if none ever was
+func (s *Server) FencingCounter(lockID string) uint64 {
+	tokenPtrIface, ok := s.fencingTokens.Load(lockID)
+	if !ok {
+		return 0
+	}
+	return atomic.LoadUint64(tokenPtrIface.(*uint64))
+}
+
+// AdvanceFencingCounter raises lockID's fencing counter to to, so the next grant gets a token
+// above it. It is meant for reseeding a node whose counters were lost. Counters never move
+// backwards: a target below the current counter is rejected, and one equal to it is a no-op.
+// The current hold, if any, keeps its token.
+func (s *Server) AdvanceFencingCounter(ctx context.Context, lockID string, to uint64) (clutcherrors.StatusCode, error) {
+	s.resetMu.RLock()
+	defer s.resetMu.RUnlock()
+
+	if current := s.FencingCounter(lockID); to < current {
+		return clutcherrors.STATUS_INVALID_REQUEST, fmt.Errorf("fencing counter is %d, can't lower it to %d", current, to)
+	}
+	if to == 0 {
+		return clutcherrors.STATUS_SUCCESS, nil
+	}
+
+	if status, err := s.commit(command.Command{
+		Type:             command.CmdAdvanceFencing,
+		LockID:           lockID,
+		FencingToken:     to,
+		CommitTimeMillis: s.clock.NowMillis(),
+	}); err != nil {
+		return status, err
+	}
+	s.raiseFencingToken(lockID, to)
+	return clutcherrors.STATUS_SUCCESS, nil
+}
+
+// dispatchAdmin answers the fencing counter admin commands. The response carries the counter
+// after the command.
+func (s *Server) dispatchAdmin(ctx context.Context, req *protocol.Request) *protocol.Response {
+	if !s.adminCommands {
+		return &protocol.Response{Status: clutcherrors.STATUS_INVALID_REQUEST}
+	}
+
+	lockID := idString(req.LockID)
+	status := clutcherrors.STATUS_SUCCESS
+	if req.Cmd == protocol.ADVANCE_FENCING_COUNTER {
+		status, _ = s.AdvanceFencingCounter(ctx, lockID, req.FencingToken)
+	}
+	return &protocol.Response{Status: status, FencingToken: s.FencingCounter(lockID)}
+}
+
+// isAdmin reports whether cmd is an admin command
+func isAdmin(cmd uint8) bool {
+	return cmd == protocol.GET_FENCING_COUNTER || cmd == protocol.ADVANCE_FENCING_COUNTER
+}
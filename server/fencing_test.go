@@ -0,0 +1,108 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mrdhat/clutchdb/clutcherrors"
+	"github.com/mrdhat/clutchdb/protocol"
+	"github.com/mrdhat/clutchdb/wal"
+)
+
+func TestAdvanceFencingCounterIncreaseOnly(t *testing.T) {
+	s := NewServer()
+	ctx := context.Background()
+
+	if counter := s.FencingCounter("lock1"); counter != 0 {
+		t.Fatalf("Expected counter 0 for an unused lock, got %d", counter)
+	}
+
+	_, lock, err := s.Acquire(ctx, "owner1", "lock1", time.Minute)
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	if counter := s.FencingCounter("lock1"); counter != lock.FencingToken {
+		t.Fatalf("Expected counter %d, got %d", lock.FencingToken, counter)
+	}
+	held := lock.FencingToken
+
+	if _, err := s.AdvanceFencingCounter(ctx, "lock1", 100); err != nil {
+		t.Fatalf("AdvanceFencingCounter failed: %v", err)
+	}
+	if status, err := s.AdvanceFencingCounter(ctx, "lock1", 50); status != clutcherrors.STATUS_INVALID_REQUEST || err == nil {
+		t.Errorf("Expected lowering the counter to be rejected, got %d (%v)", status, err)
+	}
+	if _, err := s.AdvanceFencingCounter(ctx, "lock1", 100); err != nil {
+		t.Errorf("Expected advancing to the current counter to succeed, got %v", err)
+	}
+	if counter := s.FencingCounter("lock1"); counter != 100 {
+		t.Fatalf("Expected counter 100, got %d", counter)
+	}
+
+	// The current hold keeps its token; the next grant lands above the new counter
+	if _, err := s.Release(ctx, "lock1", "owner1", held); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+	_, lock, err = s.Acquire(ctx, "owner2", "lock1", time.Minute)
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	if lock.FencingToken != 101 {
+		t.Errorf("Expected token 101 after advancing, got %d", lock.FencingToken)
+	}
+}
+
+func TestAdvanceFencingCounterSurvivesReplay(t *testing.T) {
+	log := wal.NewWALWithStorage(wal.NewMemoryStorage())
+	s := NewServer(WithCommitLog(log))
+	ctx := context.Background()
+
+	if _, err := s.AdvanceFencingCounter(ctx, "lock1", 500); err != nil {
+		t.Fatalf("AdvanceFencingCounter failed: %v", err)
+	}
+
+	cmds, err := log.ReadAll()
+	if err != nil {
+		t.Fatalf("ReadAll failed: %v", err)
+	}
+	recovered := NewServer()
+	if err := recovered.Replay(cmds); err != nil {
+		t.Fatalf("Replay failed: %v", err)
+	}
+	if counter := recovered.FencingCounter("lock1"); counter != 500 {
+		t.Errorf("Expected counter 500 after replay, got %d", counter)
+	}
+	if _, ok := recovered.activeLocks.Load("lock1"); ok {
+		t.Error("Expected advancing the counter not to create a hold")
+	}
+}
+
+func TestDispatchFencingCounterAdmin(t *testing.T) {
+	ctx := context.Background()
+
+	s := NewServer()
+	resp := s.Dispatch(ctx, newRequest(protocol.ADVANCE_FENCING_COUNTER, "lock1", "admin", 0, 10))
+	if resp.Status != clutcherrors.STATUS_INVALID_REQUEST {
+		t.Fatalf("Expected admin commands to be refused by default, got %d", resp.Status)
+	}
+	if counter := s.FencingCounter("lock1"); counter != 0 {
+		t.Fatalf("Expected counter untouched, got %d", counter)
+	}
+
+	s = NewServer(WithAdminCommands())
+	resp = s.Dispatch(ctx, newRequest(protocol.ADVANCE_FENCING_COUNTER, "lock1", "admin", 0, 10))
+	if resp.Status != clutcherrors.STATUS_SUCCESS || resp.FencingToken != 10 {
+		t.Fatalf("Expected counter advanced to 10, got %+v", resp)
+	}
+
+	resp = s.Dispatch(ctx, newRequest(protocol.ADVANCE_FENCING_COUNTER, "lock1", "admin", 0, 5))
+	if resp.Status != clutcherrors.STATUS_INVALID_REQUEST || resp.FencingToken != 10 {
+		t.Errorf("Expected lowering to be rejected with counter 10, got %+v", resp)
+	}
+
+	resp = s.Dispatch(ctx, newRequest(protocol.GET_FENCING_COUNTER, "lock1", "admin", 0, 0))
+	if resp.Status != clutcherrors.STATUS_SUCCESS || resp.FencingToken != 10 {
+		t.Errorf("Expected counter 10, got %+v", resp)
+	}
+}
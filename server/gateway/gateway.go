@@ -0,0 +1,145 @@
+// Package gateway serves the lock commands over HTTP with JSON bodies, for clients that
+// can't speak the binary protocol. Requests are translated into protocol requests and run
+// through the same dispatcher as TCP clients, so behavior is identical.
+package gateway
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/mrdhat/clutchdb/clutcherrors"
+	"github.com/mrdhat/clutchdb/protocol"
+	"github.com/mrdhat/clutchdb/server"
+)
+
+// Request is the JSON body accepted by every endpoint
+type Request struct {
+	LockID       string `json:"lock_id"`
+	OwnerID      string `json:"owner_id"`
+	TTLMS        uint64 `json:"ttl_ms,omitempty"`        // ACQUIRE and RENEW
+	FencingToken uint64 `json:"fencing_token,omitempty"` // RENEW and RELEASE, token floor for ACQUIRE
+}
+
+// Response is the JSON body returned by every endpoint
+type Response struct {
+	Status       clutcherrors.StatusCode `json:"status"`
+	FencingToken uint64                  `json:"fencing_token"`
+	ExpiresAt    uint64                  `json:"expires_at"`
+	Message      string                  `json:"message,omitempty"`     // Server's failure reason, under server.WithErrorMessages
+	LeaderHint   string                  `json:"leader_hint,omitempty"` // Leader address, with STATUS_NOT_LEADER
+	Error        string                  `json:"error,omitempty"`
+}
+
+// Option configures a gateway
+type Option func(*gateway)
+
+// gateway holds the settings shared by every endpoint
+type gateway struct {
+	secret []byte
+}
+
+// WithSecret signs every request with secret before dispatching it, for a server that
+// authenticates requests with server.HMACAuth under the same secret. The gateway does not
+// authenticate its own callers; put it behind something that does.
+func WithSecret(secret []byte) Option {
+	return func(g *gateway) {
+		g.secret = bytes.Clone(secret)
+	}
+}
+
+// New returns a handler serving POST /acquire, /renew and /release against s
+func New(s *server.Server, opts ...Option) http.Handler {
+	g := &gateway{}
+	for _, opt := range opts {
+		opt(g)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("POST /acquire", g.handler(s, protocol.ACQUIRE))
+	mux.Handle("POST /renew", g.handler(s, protocol.RENEW))
+	mux.Handle("POST /release", g.handler(s, protocol.RELEASE))
+	return mux
+}
+
+func (g *gateway) handler(s *server.Server, cmd uint8) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body Request
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeError(w, fmt.Errorf("invalid json: %w", err))
+			return
+		}
+		req, err := toProtocol(cmd, &body)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		if g.secret != nil {
+			protocol.SignRequest(req, g.secret)
+		}
+
+		resp := s.Dispatch(r.Context(), req)
+		writeJSON(w, httpStatus(resp.Status), &Response{
+			Status:       resp.Status,
+			FencingToken: resp.FencingToken,
+			ExpiresAt:    resp.ExpiresAt,
+			Message:      resp.Message,
+			LeaderHint:   resp.LeaderHint,
+		})
+	}
+}
+
+// toProtocol converts body to a wire request, enforcing the wire limits on IDs
+func toProtocol(cmd uint8, body *Request) (*protocol.Request, error) {
+	if body.LockID == "" || body.OwnerID == "" {
+		return nil, errors.New("lock_id and owner_id are required")
+	}
+	if len(body.LockID) > 16 || len(body.OwnerID) > 16 {
+		return nil, errors.New("lock_id and owner_id must be at most 16 bytes")
+	}
+
+	req := &protocol.Request{
+		Cmd:          cmd,
+		RequestID:    uuid.New(),
+		TTLMS:        body.TTLMS,
+		FencingToken: body.FencingToken,
+	}
+	copy(req.LockID[:], body.LockID)
+	copy(req.OwnerID[:], body.OwnerID)
+	return req, nil
+}
+
+// httpStatus maps a command status to the closest HTTP status code
+func httpStatus(status clutcherrors.StatusCode) int {
+	switch status {
+	case clutcherrors.STATUS_SUCCESS:
+		return http.StatusOK
+	case clutcherrors.STATUS_LOCK_HELD, clutcherrors.STATUS_HELD_BY_CALLER, clutcherrors.STATUS_LOCK_NOT_HELD, clutcherrors.STATUS_LOCK_EXPIRED, clutcherrors.STATUS_FENCED_OUT:
+		return http.StatusConflict
+	case clutcherrors.STATUS_QUOTA_EXCEEDED, clutcherrors.STATUS_RATE_LIMITED:
+		return http.StatusTooManyRequests
+	case clutcherrors.STATUS_OVERLOADED, clutcherrors.STATUS_NOT_LEADER:
+		return http.StatusServiceUnavailable
+	case clutcherrors.STATUS_FORBIDDEN:
+		return http.StatusForbidden
+	default:
+		return http.StatusBadRequest
+	}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	writeJSON(w, http.StatusBadRequest, &Response{
+		Status: clutcherrors.STATUS_INVALID_REQUEST,
+		Error:  err.Error(),
+	})
+}
+
+func writeJSON(w http.ResponseWriter, code int, resp *Response) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,164 @@
+package gateway
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mrdhat/clutchdb/clutcherrors"
+	"github.com/mrdhat/clutchdb/server"
+)
+
+// post sends body to path and decodes the JSON response
+func post(t *testing.T, ts *httptest.Server, path string, body any) (int, *Response) {
+	t.Helper()
+	data, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpResp, err := http.Post(ts.URL+path, "application/json", bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("POST %s failed: %v", path, err)
+	}
+	defer httpResp.Body.Close()
+
+	var resp Response
+	if err := json.NewDecoder(httpResp.Body).Decode(&resp); err != nil {
+		t.Fatalf("Decoding %s response failed: %v", path, err)
+	}
+	return httpResp.StatusCode, &resp
+}
+
+func TestGateway(t *testing.T) {
+	ts := httptest.NewServer(New(server.NewServer()))
+	defer ts.Close()
+
+	code, resp := post(t, ts, "/acquire", Request{LockID: "lock1", OwnerID: "owner1", TTLMS: 60000})
+	if code != http.StatusOK || resp.Status != clutcherrors.STATUS_SUCCESS || resp.FencingToken == 0 || resp.ExpiresAt == 0 {
+		t.Fatalf("Expected acquire to succeed, got %d %+v", code, resp)
+	}
+	token := resp.FencingToken
+
+	// Conflict: someone else holds it
+	code, resp = post(t, ts, "/acquire", Request{LockID: "lock1", OwnerID: "owner2", TTLMS: 60000})
+	if code != http.StatusConflict || resp.Status != clutcherrors.STATUS_LOCK_HELD {
+		t.Errorf("Expected conflicting acquire to be rejected, got %d %+v", code, resp)
+	}
+
+	code, resp = post(t, ts, "/renew", Request{LockID: "lock1", OwnerID: "owner1", TTLMS: 60000, FencingToken: token})
+	if code != http.StatusOK || resp.Status != clutcherrors.STATUS_SUCCESS || resp.FencingToken != token {
+		t.Errorf("Expected renew to succeed, got %d %+v", code, resp)
+	}
+
+	// Not held: the wrong owner can't renew or release
+	code, resp = post(t, ts, "/renew", Request{LockID: "lock1", OwnerID: "owner2", TTLMS: 60000, FencingToken: token})
+	if code != http.StatusConflict || resp.Status != clutcherrors.STATUS_LOCK_NOT_HELD {
+		t.Errorf("Expected renew by another owner to be rejected, got %d %+v", code, resp)
+	}
+	code, resp = post(t, ts, "/release", Request{LockID: "lock1", OwnerID: "owner2", FencingToken: token})
+	if code != http.StatusConflict || resp.Status != clutcherrors.STATUS_LOCK_NOT_HELD {
+		t.Errorf("Expected release by another owner to be rejected, got %d %+v", code, resp)
+	}
+
+	code, resp = post(t, ts, "/release", Request{LockID: "lock1", OwnerID: "owner1", FencingToken: token})
+	if code != http.StatusOK || resp.Status != clutcherrors.STATUS_SUCCESS {
+		t.Errorf("Expected release to succeed, got %d %+v", code, resp)
+	}
+	code, resp = post(t, ts, "/release", Request{LockID: "lock1", OwnerID: "owner1", FencingToken: token})
+	if code != http.StatusConflict || resp.Status != clutcherrors.STATUS_LOCK_NOT_HELD {
+		t.Errorf("Expected second release to be rejected, got %d %+v", code, resp)
+	}
+}
+
+func TestGatewayInvalidRequest(t *testing.T) {
+	ts := httptest.NewServer(New(server.NewServer()))
+	defer ts.Close()
+
+	code, resp := post(t, ts, "/acquire", Request{LockID: "a-lock-id-longer-than-16-bytes", OwnerID: "owner1", TTLMS: 1000})
+	if code != http.StatusBadRequest || resp.Status != clutcherrors.STATUS_INVALID_REQUEST || resp.Error == "" {
+		t.Errorf("Expected oversized lock id to be rejected, got %d %+v", code, resp)
+	}
+	code, resp = post(t, ts, "/acquire", "not an object")
+	if code != http.StatusBadRequest || resp.Status != clutcherrors.STATUS_INVALID_REQUEST {
+		t.Errorf("Expected malformed body to be rejected, got %d %+v", code, resp)
+	}
+
+	httpResp, err := http.Get(ts.URL + "/acquire")
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	httpResp.Body.Close()
+	if httpResp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("Expected GET to be rejected with %d, got %d", http.StatusMethodNotAllowed, httpResp.StatusCode)
+	}
+}
+
+func TestGatewayAssignsRequestID(t *testing.T) {
+	ts := httptest.NewServer(New(server.NewServer(server.WithRequireRequestID())))
+	defer ts.Close()
+
+	code, resp := post(t, ts, "/acquire", Request{LockID: "lock1", OwnerID: "owner1", TTLMS: 60000})
+	if code != http.StatusOK || resp.Status != clutcherrors.STATUS_SUCCESS {
+		t.Errorf("Expected acquire through the gateway to carry a request ID, got %d %+v", code, resp)
+	}
+}
+
+func TestGatewaySecret(t *testing.T) {
+	secret := []byte("shared secret")
+	s := server.NewServer(server.WithMiddleware(server.HMACAuth(secret)))
+
+	unsigned := httptest.NewServer(New(s))
+	defer unsigned.Close()
+	code, resp := post(t, unsigned, "/acquire", Request{LockID: "lock1", OwnerID: "owner1", TTLMS: 60000})
+	if code != http.StatusForbidden || resp.Status != clutcherrors.STATUS_FORBIDDEN {
+		t.Errorf("Expected acquire without the secret to be forbidden, got %d %+v", code, resp)
+	}
+
+	signed := httptest.NewServer(New(s, WithSecret(secret)))
+	defer signed.Close()
+	code, resp = post(t, signed, "/acquire", Request{LockID: "lock1", OwnerID: "owner1", TTLMS: 60000})
+	if code != http.StatusOK || resp.Status != clutcherrors.STATUS_SUCCESS {
+		t.Errorf("Expected acquire with the secret to succeed, got %d %+v", code, resp)
+	}
+}
+
+func TestGatewayHeldByCaller(t *testing.T) {
+	ts := httptest.NewServer(New(server.NewServer(server.WithSelfHeldStatus(), server.WithErrorMessages())))
+	defer ts.Close()
+
+	code, resp := post(t, ts, "/acquire", Request{LockID: "lock1", OwnerID: "owner1", TTLMS: 60000})
+	if code != http.StatusOK || resp.Status != clutcherrors.STATUS_SUCCESS {
+		t.Fatalf("Expected acquire to succeed, got %d %+v", code, resp)
+	}
+	token := resp.FencingToken
+
+	// A retried acquire is a conflict with the caller's own hold, not a malformed request
+	code, resp = post(t, ts, "/acquire", Request{LockID: "lock1", OwnerID: "owner1", TTLMS: 60000})
+	if code != http.StatusConflict || resp.Status != clutcherrors.STATUS_HELD_BY_CALLER {
+		t.Errorf("Expected retried acquire to conflict, got %d %+v", code, resp)
+	}
+	if resp.FencingToken != token {
+		t.Errorf("Expected the caller's hold with token %d, got %d", token, resp.FencingToken)
+	}
+	if resp.Message == "" {
+		t.Error("Expected the server's failure reason in the response")
+	}
+}
+
+func TestGatewayLeaderHint(t *testing.T) {
+	s := server.NewServer()
+	s.StepDown()
+	s.SetLeaderHint("10.0.0.7:7000")
+	ts := httptest.NewServer(New(s))
+	defer ts.Close()
+
+	code, resp := post(t, ts, "/acquire", Request{LockID: "lock1", OwnerID: "owner1", TTLMS: 60000})
+	if code != http.StatusServiceUnavailable || resp.Status != clutcherrors.STATUS_NOT_LEADER {
+		t.Fatalf("Expected acquire on a follower to be redirected, got %d %+v", code, resp)
+	}
+	if resp.LeaderHint != "10.0.0.7:7000" {
+		t.Errorf("Expected leader hint 10.0.0.7:7000, got %q", resp.LeaderHint)
+	}
+}
@@ -0,0 +1,50 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+)
+
+// WithInvariantChecks makes Acquire, Renew, Bump and Release check the lock they act on and panic
+// if it is inconsistent. It is meant for tests and debugging; leave it off in production.
+func WithInvariantChecks() Option {
+	return func(s *Server) {
+		s.invariantChecks = true
+	}
+}
+
+// validate checks the invariants of a granted hold. Must be called with l.mu held.
+//   - ID and OwnerID are set: a hold always names its lock and owner
+//   - FencingToken is non-zero: tokens start at 1
+//   - ExpiresAt is not before AcquiredAt: a hold can't lapse before it began
+//   - removed is false: a hold is still in the lock table
+func (l *Lock) validate() error {
+	switch {
+	case l.ID == "":
+		return errors.New("lock has no id")
+	case l.OwnerID == "":
+		return fmt.Errorf("lock %s has no owner", l.ID)
+	case l.FencingToken == 0:
+		return fmt.Errorf("lock %s has fencing token 0", l.ID)
+	case l.ExpiresAt < l.AcquiredAt:
+		return fmt.Errorf("lock %s expires at %d, before it was acquired at %d", l.ID, l.ExpiresAt, l.AcquiredAt)
+	case l.removed:
+		return fmt.Errorf("lock %s is held but not in the lock table", l.ID)
+	}
+	return nil
+}
+
+// checkInvariants panics if invariant checks are on and lock, which op just acted on, fails
+// validate or holds a token above its lock's fencing counter. Must be called with lock.mu held.
+func (s *Server) checkInvariants(op string, lock *Lock) {
+	if !s.invariantChecks {
+		return
+	}
+	err := lock.validate()
+	if counter := s.FencingCounter(lock.ID); err == nil && lock.FencingToken > counter {
+		err = fmt.Errorf("lock %s has fencing token %d above its counter %d", lock.ID, lock.FencingToken, counter)
+	}
+	if err != nil {
+		panic(fmt.Sprintf("%s broke a lock invariant: %v", op, err))
+	}
+}
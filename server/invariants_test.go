@@ -0,0 +1,69 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLockValidate(t *testing.T) {
+	valid := Lock{ID: "lock1", OwnerID: "owner1", FencingToken: 1, AcquiredAt: 100, ExpiresAt: 200}
+	if err := valid.validate(); err != nil {
+		t.Fatalf("Expected valid lock to pass, got %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		breaks func(l *Lock)
+		want   string
+	}{
+		{"no id", func(l *Lock) { l.ID = "" }, "no id"},
+		{"no owner", func(l *Lock) { l.OwnerID = "" }, "no owner"},
+		{"zero token", func(l *Lock) { l.FencingToken = 0 }, "fencing token 0"},
+		{"expires before acquired", func(l *Lock) { l.ExpiresAt = 50 }, "before it was acquired"},
+		{"removed", func(l *Lock) { l.removed = true }, "not in the lock table"},
+	}
+	for _, tt := range tests {
+		lock := Lock{ID: valid.ID, OwnerID: valid.OwnerID, FencingToken: valid.FencingToken, AcquiredAt: valid.AcquiredAt, ExpiresAt: valid.ExpiresAt}
+		tt.breaks(&lock)
+		err := lock.validate()
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: expected error containing %q, got %v", tt.name, tt.want, err)
+		}
+	}
+}
+
+func TestInvariantChecks(t *testing.T) {
+	s := NewServer(WithInvariantChecks())
+	ctx := context.Background()
+
+	// A normal lifecycle passes every check
+	_, lock, err := s.Acquire(ctx, "owner1", "lock1", time.Second)
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	if _, _, err := s.Renew(ctx, "owner1", "lock1", lock.FencingToken, time.Second); err != nil {
+		t.Fatalf("Renew failed: %v", err)
+	}
+	if _, err := s.Release(ctx, "lock1", "owner1", lock.FencingToken); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+
+	// A token the counter never issued is corruption
+	_, lock, err = s.Acquire(ctx, "owner1", "lock2", time.Second)
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	lock.mu.Lock()
+	lock.FencingToken = 99
+	lock.mu.Unlock()
+
+	defer func() {
+		r := recover()
+		if r == nil || !strings.Contains(r.(string), "above its counter") {
+			t.Errorf("Expected release to panic on the bad token, got %v", r)
+		}
+	}()
+	s.Release(ctx, "lock2", "owner1", 99)
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/mrdhat/clutchdb/protocol"
+)
+
+// LockIDPolicy decides which lock IDs the dispatcher accepts and how they are normalized
+// before they reach the lock table, so that, say, "Lock" and "lock" can't name different locks
+type LockIDPolicy struct {
+	MaxLength int             // in bytes, after normalization; 0 means no limit beyond the wire's
+	Allowed   func(rune) bool // reports whether a character may appear in an ID; nil allows any
+	Lowercase bool            // fold IDs to lower case
+}
+
+// WithLockIDPolicy makes the dispatcher normalize every lock ID with policy and reject IDs it
+// doesn't allow with STATUS_INVALID_REQUEST. Without it any ID is accepted as sent.
+func WithLockIDPolicy(policy LockIDPolicy) Option {
+	return func(s *Server) {
+		s.lockIDPolicy = &policy
+	}
+}
+
+// Normalize returns lockID as the policy stores it, or an error saying why it is rejected.
+// It is deterministic: the same ID always normalizes the same way.
+func (p *LockIDPolicy) Normalize(lockID string) (string, error) {
+	if lockID == "" {
+		return "", errors.New("empty lock id")
+	}
+	if !utf8.ValidString(lockID) {
+		return "", errors.New("lock id is not valid utf-8")
+	}
+	if p.Lowercase {
+		lockID = strings.ToLower(lockID)
+	}
+	if p.MaxLength > 0 && len(lockID) > p.MaxLength {
+		return "", fmt.Errorf("lock id too long: %d bytes, max %d", len(lockID), p.MaxLength)
+	}
+	if p.Allowed != nil {
+		for _, r := range lockID {
+			if !p.Allowed(r) {
+				return "", fmt.Errorf("lock id contains disallowed character %q", r)
+			}
+		}
+	}
+	return lockID, nil
+}
+
+// normalizeLockID applies the server's lock ID policy, if it has one, to a wire request. It
+// returns req itself when nothing changes, or a copy carrying the normalized ID.
+func (s *Server) normalizeLockID(req *protocol.Request) (*protocol.Request, error) {
+	if s.lockIDPolicy == nil {
+		return req, nil
+	}
+	lockID := idString(req.LockID)
+	normalized, err := s.lockIDPolicy.Normalize(lockID)
+	if err != nil {
+		return nil, err
+	}
+	if normalized == lockID {
+		return req, nil
+	}
+	if len(normalized) > len(req.LockID) {
+		return nil, fmt.Errorf("normalized lock id too long: %d bytes, max %d", len(normalized), len(req.LockID))
+	}
+	copied := *req
+	copied.LockID = [16]byte{}
+	copy(copied.LockID[:], normalized)
+	return &copied, nil
+}
+
+// normalizeLockIDs applies the server's lock ID policy, if it has one, to each of lockIDs
+func (s *Server) normalizeLockIDs(lockIDs []string) ([]string, error) {
+	if s.lockIDPolicy == nil {
+		return lockIDs, nil
+	}
+	normalized := make([]string, len(lockIDs))
+	for i, lockID := range lockIDs {
+		var err error
+		if normalized[i], err = s.lockIDPolicy.Normalize(lockID); err != nil {
+			return nil, err
+		}
+	}
+	return normalized, nil
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mrdhat/clutchdb/clutcherrors"
+	"github.com/mrdhat/clutchdb/protocol"
+)
+
+// idChar allows lower case letters, digits and a few separators
+func idChar(r rune) bool {
+	return (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '-' || r == '/' || r == '_'
+}
+
+func TestLockIDPolicyNormalize(t *testing.T) {
+	policy := &LockIDPolicy{MaxLength: 8, Allowed: idChar, Lowercase: true}
+	tests := []struct {
+		lockID string
+		want   string
+		ok     bool
+	}{
+		{"lock1", "lock1", true},
+		{"Lock1", "lock1", true},
+		{"LOCK-A/1", "lock-a/1", true},
+		{"", "", false},
+		{"lock 1", "", false},
+		{"lock\x01", "", false},
+		{"\xff", "", false},
+		{"lock12345", "", false},
+	}
+	for _, tt := range tests {
+		got, err := policy.Normalize(tt.lockID)
+		if tt.ok && (err != nil || got != tt.want) {
+			t.Errorf("Normalize(%q): expected %q, got %q (%v)", tt.lockID, tt.want, got, err)
+		}
+		if !tt.ok && err == nil {
+			t.Errorf("Normalize(%q): expected rejection, got %q", tt.lockID, got)
+		}
+	}
+}
+
+func TestDispatchLockIDPolicy(t *testing.T) {
+	ctx := context.Background()
+
+	// Permissive by default: IDs differing in case are different locks
+	s := NewServer()
+	for _, lockID := range []string{"Lock", "lock"} {
+		if resp := s.Dispatch(ctx, newRequest(protocol.ACQUIRE, lockID, "owner1", 1000, 0)); resp.Status != clutcherrors.STATUS_SUCCESS {
+			t.Errorf("Expected %s to be acquired without a policy, got status %d", lockID, resp.Status)
+		}
+	}
+
+	s = NewServer(WithLockIDPolicy(LockIDPolicy{Allowed: idChar, Lowercase: true}))
+	resp := s.Dispatch(ctx, newRequest(protocol.ACQUIRE, "Lock", "owner1", 1000, 0))
+	if resp.Status != clutcherrors.STATUS_SUCCESS {
+		t.Fatalf("Expected status %d, got %d", clutcherrors.STATUS_SUCCESS, resp.Status)
+	}
+	if _, ok := s.LockInfo("lock"); !ok {
+		t.Error("Expected the lock to be stored under its normalized ID")
+	}
+	if resp := s.Dispatch(ctx, newRequest(protocol.ACQUIRE, "LOCK", "owner2", 1000, 0)); resp.Status != clutcherrors.STATUS_LOCK_HELD {
+		t.Errorf("Expected LOCK to name the held lock, got status %d", resp.Status)
+	}
+	if resp := s.Dispatch(ctx, newRequest(protocol.RELEASE, "lOcK", "owner1", 0, resp.FencingToken)); resp.Status != clutcherrors.STATUS_SUCCESS {
+		t.Errorf("Expected release under another casing to succeed, got status %d", resp.Status)
+	}
+
+	if resp := s.Dispatch(ctx, newRequest(protocol.ACQUIRE, "lock 1", "owner1", 1000, 0)); resp.Status != clutcherrors.STATUS_INVALID_REQUEST {
+		t.Errorf("Expected status %d for a disallowed character, got %d", clutcherrors.STATUS_INVALID_REQUEST, resp.Status)
+	}
+	if status, _ := s.DispatchLockInfoMulti(context.Background(), &protocol.LockInfoMultiRequest{LockIDs: []string{"lock", "bad\tid"}}); status != clutcherrors.STATUS_INVALID_REQUEST {
+		t.Errorf("Expected status %d for a lookup naming a disallowed ID, got %d", clutcherrors.STATUS_INVALID_REQUEST, status)
+	}
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"context"
+
+	"github.com/mrdhat/clutchdb/clutcherrors"
+	"github.com/mrdhat/clutchdb/protocol"
+)
+
+// LockInfo returns the current hold on lockID. ok is false if the lock is free or its hold has
+// expired, whether or not the reaper has removed it yet.
+func (s *Server) LockInfo(lockID string) (info protocol.LockInfo, ok bool) {
+	state, ok := s.holdState(lockID, s.clock.NowMillis())
+	if !ok {
+		return info, false
+	}
+	return protocol.LockInfo{
+		LockID:       state.LockID,
+		OwnerID:      state.OwnerID,
+		FencingToken: state.FencingToken,
+		ExpiresAt:    state.ExpiresAt,
+		AcquiredAt:   state.AcquiredAt,
+		Value:        state.Value,
+	}, true
+}
+
+// LockInfos returns the current hold on each of lockIDs, in order. A lock that is free or whose
+// hold has expired gets an entry with only its LockID set.
+func (s *Server) LockInfos(lockIDs []string) []protocol.LockInfo {
+	infos := make([]protocol.LockInfo, len(lockIDs))
+	for i, lockID := range lockIDs {
+		info, ok := s.LockInfo(lockID)
+		if !ok {
+			info = protocol.LockInfo{LockID: lockID}
+		}
+		infos[i] = info
+	}
+	return infos
+}
+
+// DispatchLockInfoMulti answers a decoded GET_LOCK_INFO_MULTI request, passing its envelope
+// through the middleware chain. Under a lock ID policy the results carry the normalized IDs.
+func (s *Server) DispatchLockInfoMulti(ctx context.Context, req *protocol.LockInfoMultiRequest) (clutcherrors.StatusCode, []protocol.LockInfo) {
+	var infos []protocol.LockInfo
+	status := s.dispatchFrame(ctx, req.Envelope(), func(ctx context.Context) (clutcherrors.StatusCode, func()) {
+		status, found := s.lockInfoMulti(req)
+		return status, func() { infos = found }
+	})
+	return status, infos
+}
+
+// lockInfoMulti answers a GET_LOCK_INFO_MULTI at the core of the middleware chain
+func (s *Server) lockInfoMulti(req *protocol.LockInfoMultiRequest) (clutcherrors.StatusCode, []protocol.LockInfo) {
+	lockIDs, err := s.normalizeLockIDs(req.LockIDs)
+	if err != nil {
+		return clutcherrors.STATUS_INVALID_REQUEST, nil
+	}
+	return clutcherrors.STATUS_SUCCESS, s.LockInfos(lockIDs)
+}
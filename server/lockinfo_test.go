@@ -0,0 +1,194 @@
+package server
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mrdhat/clutchdb/clutcherrors"
+	"github.com/mrdhat/clutchdb/protocol"
+	"github.com/mrdhat/clutchdb/wal"
+)
+
+func TestLockInfoAcquiredAt(t *testing.T) {
+	clock := &manualClock{}
+	clock.now.Store(1_000_000)
+	log := wal.NewWALWithStorage(wal.NewMemoryStorage())
+	s := NewServer(WithClock(clock), WithCommitLog(log))
+	ctx := context.Background()
+
+	_, lock, err := s.Acquire(ctx, "owner1", "lock1", 100*time.Millisecond)
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	info, ok := s.LockInfo("lock1")
+	if !ok || info.OwnerID != "owner1" || info.AcquiredAt != 1_000_000 {
+		t.Fatalf("Expected lock1 acquired by owner1 at 1000000, got %+v (ok=%v)", info, ok)
+	}
+
+	// Renewing keeps the original acquire time
+	clock.advance(50 * time.Millisecond)
+	if _, _, err := s.Renew(ctx, "owner1", "lock1", lock.FencingToken, 100*time.Millisecond); err != nil {
+		t.Fatalf("Renew failed: %v", err)
+	}
+	if info, _ := s.LockInfo("lock1"); info.AcquiredAt != 1_000_000 {
+		t.Errorf("Expected renew to keep AcquiredAt 1000000, got %d", info.AcquiredAt)
+	}
+
+	// It survives a replay of the commit log
+	cmds, err := log.ReadAll()
+	if err != nil {
+		t.Fatalf("ReadAll failed: %v", err)
+	}
+	recovered := NewServer(WithClock(clock))
+	if err := recovered.Replay(cmds); err != nil {
+		t.Fatalf("Replay failed: %v", err)
+	}
+	if info, _ := recovered.LockInfo("lock1"); info.AcquiredAt != 1_000_000 {
+		t.Errorf("Expected replay to keep AcquiredAt 1000000, got %d", info.AcquiredAt)
+	}
+
+	// A new owner taking over after expiry starts a new hold
+	clock.advance(time.Second)
+	if _, ok := s.LockInfo("lock1"); ok {
+		t.Error("Expected no info for an expired hold")
+	}
+	if _, _, err := s.Acquire(ctx, "owner2", "lock1", 100*time.Millisecond); err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	if info, _ := s.LockInfo("lock1"); info.OwnerID != "owner2" || info.AcquiredAt != 1_001_050 {
+		t.Errorf("Expected owner2 acquired at 1001050, got %+v", info)
+	}
+}
+
+func TestReadsTreatUnreapedExpiryAsFree(t *testing.T) {
+	clock := &manualClock{}
+	clock.now.Store(1_000_000)
+	s := NewServer(WithClock(clock))
+	ctx := context.Background()
+
+	if _, _, err := s.Acquire(ctx, "owner1", "lock1", 100*time.Millisecond); err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	if _, _, err := s.Acquire(ctx, "owner2", "lock2", time.Minute); err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+
+	// lock1 lapses at its ExpiresAt, with no reaper or command to remove it from the table
+	clock.advance(100 * time.Millisecond)
+	if _, ok := s.activeLocks.Load("lock1"); !ok {
+		t.Fatal("Expected lock1 to still be in the lock table")
+	}
+
+	if info, ok := s.LockInfo("lock1"); ok {
+		t.Errorf("Expected LockInfo to report lock1 free, got %+v", info)
+	}
+	infos := s.LockInfos([]string{"lock1", "lock2"})
+	if infos[0].OwnerID != "" || infos[1].OwnerID != "owner2" {
+		t.Errorf("Expected only lock2 held in LockInfos, got %+v", infos)
+	}
+	if stats := s.Stats(); stats.ActiveLocks != 1 {
+		t.Errorf("Expected 1 active lock in Stats, got %d", stats.ActiveLocks)
+	}
+	data, err := s.DumpState()
+	if err != nil {
+		t.Fatalf("DumpState failed: %v", err)
+	}
+	if strings.Contains(string(data), "lock1") {
+		t.Errorf("Expected DumpState to skip lock1, got %s", data)
+	}
+	for _, state := range s.Snapshot().Locks {
+		if state.LockID == "lock1" {
+			t.Errorf("Expected Snapshot to skip lock1, got %+v", state)
+		}
+	}
+}
+
+func TestCommandsAgreeWithReadsAtExpiry(t *testing.T) {
+	clock := &manualClock{}
+	clock.now.Store(1_000_000)
+	s := NewServer(WithClock(clock))
+	ctx := context.Background()
+
+	_, lock, err := s.Acquire(ctx, "owner1", "lock1", 100*time.Millisecond)
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	token := lock.FencingToken
+
+	// At exactly ExpiresAt the hold reads as free, so the holder can't renew or release it either
+	clock.advance(100 * time.Millisecond)
+	if info, ok := s.LockInfo("lock1"); ok {
+		t.Fatalf("Expected LockInfo to report lock1 free, got %+v", info)
+	}
+	if status, _, _ := s.Renew(ctx, "owner1", "lock1", token, time.Second); status != clutcherrors.STATUS_LOCK_NOT_HELD {
+		t.Errorf("Expected renew at expiry to fail with STATUS_LOCK_NOT_HELD, got %d", status)
+	}
+	if status, _ := s.Release(ctx, "lock1", "owner1", token); status != clutcherrors.STATUS_LOCK_NOT_HELD {
+		t.Errorf("Expected release at expiry to fail with STATUS_LOCK_NOT_HELD, got %d", status)
+	}
+	if status, _, err := s.Acquire(ctx, "owner2", "lock1", time.Second); status != clutcherrors.STATUS_SUCCESS {
+		t.Errorf("Expected acquire at expiry to succeed, got %d: %v", status, err)
+	}
+}
+
+func TestServeConnLockInfoMulti(t *testing.T) {
+	clock := &manualClock{}
+	clock.now.Store(1_000_000)
+	s := NewServer(WithClock(clock))
+	ctx := context.Background()
+
+	_, held, err := s.Acquire(ctx, "owner1", "held", time.Second)
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	if _, _, err := s.Acquire(ctx, "owner2", "expired", 10*time.Millisecond); err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	_, released, err := s.Acquire(ctx, "owner3", "released", time.Second)
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	if _, err := s.Release(ctx, "released", "owner3", released.FencingToken); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+	clock.advance(100 * time.Millisecond)
+
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	go s.ServeConn(ctx, serverConn)
+
+	lockIDs := []string{"expired", "held", "never", "released"}
+	if err := protocol.WriteLockInfoMultiRequest(clientConn, &protocol.LockInfoMultiRequest{LockIDs: lockIDs}); err != nil {
+		t.Fatalf("WriteLockInfoMultiRequest failed: %v", err)
+	}
+	status, infos, err := protocol.ReadLockInfoMultiResponse(clientConn)
+	if err != nil {
+		t.Fatalf("ReadLockInfoMultiResponse failed: %v", err)
+	}
+	if status != clutcherrors.STATUS_SUCCESS || len(infos) != len(lockIDs) {
+		t.Fatalf("Expected %d results with status %d, got status %d and %+v", len(lockIDs), clutcherrors.STATUS_SUCCESS, status, infos)
+	}
+	for i, info := range infos {
+		if info.LockID != lockIDs[i] {
+			t.Errorf("Result %d: expected lock %s, got %s", i, lockIDs[i], info.LockID)
+		}
+		if lockIDs[i] == "held" {
+			if info.OwnerID != "owner1" || info.FencingToken != held.FencingToken {
+				t.Errorf("Expected held lock owned by owner1 with token %d, got %+v", held.FencingToken, info)
+			}
+		} else if info.FencingToken != 0 || info.OwnerID != "" {
+			t.Errorf("Expected %s to be reported free, got %+v", lockIDs[i], info)
+		}
+	}
+
+	// The connection keeps serving ordinary requests afterwards
+	if err := protocol.WriteRequest(clientConn, newRequest(protocol.ACQUIRE, "never", "owner1", 1000, 0)); err != nil {
+		t.Fatalf("WriteRequest failed: %v", err)
+	}
+	if resp, err := protocol.ReadResponse(clientConn); err != nil || resp.Status != clutcherrors.STATUS_SUCCESS {
+		t.Errorf("Expected acquire to succeed, got %+v (%v)", resp, err)
+	}
+}
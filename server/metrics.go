@@ -0,0 +1,25 @@
+package server
+
+import "time"
+
+// Metrics receives measurements from the server. Methods are called with a lock's mutex held,
+// so implementations must be safe for concurrent use and must not block.
+type Metrics interface {
+	// ObserveHeldDuration records how long a hold lasted, from acquire until release or expiry
+	ObserveHeldDuration(d time.Duration)
+}
+
+// WithMetrics reports server measurements to m
+func WithMetrics(m Metrics) Option {
+	return func(s *Server) {
+		s.metrics = m
+	}
+}
+
+// observeHeld reports the hold on lock as having ended at end. Must be called with lock.mu held.
+func (s *Server) observeHeld(lock *Lock, end uint64) {
+	if s.metrics == nil || end < lock.AcquiredAt {
+		return
+	}
+	s.metrics.ObserveHeldDuration(time.Duration(end-lock.AcquiredAt) * time.Millisecond)
+}
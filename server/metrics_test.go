@@ -0,0 +1,82 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// manualClock only moves when advanced
+type manualClock struct {
+	now atomic.Uint64
+}
+
+func (c *manualClock) NowMillis() uint64 {
+	return c.now.Load()
+}
+
+func (c *manualClock) advance(d time.Duration) {
+	c.now.Add(uint64(d.Milliseconds()))
+}
+
+// recordingMetrics keeps every observation
+type recordingMetrics struct {
+	mu   sync.Mutex
+	held []time.Duration
+}
+
+func (m *recordingMetrics) ObserveHeldDuration(d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.held = append(m.held, d)
+}
+
+func (m *recordingMetrics) observed() []time.Duration {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]time.Duration(nil), m.held...)
+}
+
+func TestHeldDurationMetrics(t *testing.T) {
+	clock := &manualClock{}
+	clock.now.Store(1_000_000)
+	metrics := &recordingMetrics{}
+	s := NewServer(WithClock(clock), WithMetrics(metrics))
+	ctx := context.Background()
+
+	_, lock, err := s.Acquire(ctx, "owner1", "lock1", time.Minute)
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	clock.advance(20 * time.Millisecond)
+	// Renewing does not restart the hold
+	if _, _, err := s.Renew(ctx, "owner1", "lock1", lock.FencingToken, time.Minute); err != nil {
+		t.Fatalf("Renew failed: %v", err)
+	}
+	clock.advance(30 * time.Millisecond)
+	if _, err := s.Release(ctx, "lock1", "owner1", lock.FencingToken); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+
+	// An expired hold counts up to its expiry, however late it is reaped
+	if _, _, err := s.Acquire(ctx, "owner1", "lock2", 10*time.Millisecond); err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	clock.advance(time.Second)
+	if n := s.ReapExpired(); n != 1 {
+		t.Fatalf("Expected 1 lock reaped, got %d", n)
+	}
+
+	held := metrics.observed()
+	want := []time.Duration{50 * time.Millisecond, 10 * time.Millisecond}
+	if len(held) != len(want) {
+		t.Fatalf("Expected %d observations, got %v", len(want), held)
+	}
+	for i := range want {
+		if held[i] != want[i] {
+			t.Errorf("Observation %d: expected %v, got %v", i, want[i], held[i])
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/mrdhat/clutchdb/clutcherrors"
+	"github.com/mrdhat/clutchdb/protocol"
+)
+
+// Handler answers one decoded request
+type Handler func(ctx context.Context, req *protocol.Request) *protocol.Response
+
+// Middleware wraps a Handler to add behavior around every request, such as auth, logging or
+// metrics. It may answer a request itself without calling next.
+type Middleware func(next Handler) Handler
+
+// WithMiddleware wraps request handling in mw. The first middleware is outermost: it sees each
+// request first and its response last. Batched requests pass through the chain one by one.
+//
+// Requests in their own frame layout, such as RENEW_ALL, GET_LOCK_INFO_MULTI and the server
+// reports, pass through as their protocol.Request envelope: the command, request ID and any
+// owner and TTL, with the encoded frame in Frame. Only the status of the response a middleware
+// returns for one reaches the client; per-lock results come from the core.
+func WithMiddleware(mw ...Middleware) Option {
+	return func(s *Server) {
+		s.middleware = append(s.middleware, mw...)
+	}
+}
+
+// buildHandler wraps the core dispatcher in the configured middleware
+func (s *Server) buildHandler() Handler {
+	h := Handler(s.dispatch)
+	for i := len(s.middleware) - 1; i >= 0; i-- {
+		h = s.middleware[i](h)
+	}
+	return h
+}
+
+// frameOp executes a request in its own frame layout. It returns the request's status and apply,
+// which hands its results to the caller. apply is only called if op finishes within the command
+// timeout, so results that come too late are dropped rather than raced over.
+type frameOp func(ctx context.Context) (status clutcherrors.StatusCode, apply func())
+
+// frameOpKey is the context key under which dispatchFrame passes the frameOp for an envelope
+// down the middleware chain
+type frameOpKey struct{}
+
+// dispatchFrame runs op through the middleware chain, with env, the envelope of the request op
+// executes, standing in for it. It returns the status of the chain's response.
+func (s *Server) dispatchFrame(ctx context.Context, env *protocol.Request, op frameOp) clutcherrors.StatusCode {
+	return s.handler(context.WithValue(ctx, frameOpKey{}, op), env).Status
+}
+
+// dispatchReport runs report, which gathers the answer to a SERVER_INFO, SERVER_STATS or
+// LIST_OWNERS and returns a function handing it to the caller, through the middleware chain
+func (s *Server) dispatchReport(ctx context.Context, req *protocol.ReportRequest, report func(ctx context.Context) func()) clutcherrors.StatusCode {
+	return s.dispatchFrame(ctx, req.Envelope(), func(ctx context.Context) (clutcherrors.StatusCode, func()) {
+		return clutcherrors.STATUS_SUCCESS, report(ctx)
+	})
+}
+
+// frameOpFor returns the frameOp that req is the envelope of, if any
+func frameOpFor(ctx context.Context, req *protocol.Request) (frameOp, bool) {
+	if req.Frame == "" {
+		return nil, false
+	}
+	op, ok := ctx.Value(frameOpKey{}).(frameOp)
+	return op, ok
+}
+
+// executeFrame is the core of the middleware chain for an envelope: it runs op, under the command
+// timeout if one is set. A frame that changes state is checked as dispatch checks a standard
+// request first.
+func (s *Server) executeFrame(ctx context.Context, req *protocol.Request, op frameOp) *protocol.Response {
+	if mutates(req.Cmd) {
+		if s.isFollower() {
+			return s.notLeader()
+		}
+		if resp := s.admit(req); resp != nil {
+			return resp
+		}
+	}
+
+	if s.commandTimeout <= 0 {
+		status, apply := op(ctx)
+		apply()
+		return &protocol.Response{Status: status}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.commandTimeout)
+	defer cancel()
+
+	type result struct {
+		status clutcherrors.StatusCode
+		apply  func()
+	}
+	done := make(chan result, 1)
+	go func() {
+		status, apply := op(ctx)
+		done <- result{status, apply}
+	}()
+
+	select {
+	case r := <-done:
+		r.apply()
+		return &protocol.Response{Status: r.status}
+	case <-ctx.Done():
+		s.logger.Warn("command timed out", "cmd", req.Cmd, "timeout", s.commandTimeout)
+		return s.failure(clutcherrors.STATUS_OVERLOADED, fmt.Errorf("command timed out after %v", s.commandTimeout))
+	}
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mrdhat/clutchdb/clutcherrors"
+	"github.com/mrdhat/clutchdb/protocol"
+)
+
+func TestMiddlewareChain(t *testing.T) {
+	var order []string
+	counted := 0
+	counter := func(next Handler) Handler {
+		return func(ctx context.Context, req *protocol.Request) *protocol.Response {
+			order = append(order, "counter")
+			counted++
+			return next(ctx, req)
+		}
+	}
+	rejecter := func(next Handler) Handler {
+		return func(ctx context.Context, req *protocol.Request) *protocol.Response {
+			order = append(order, "rejecter")
+			if req.Cmd == protocol.RELEASE {
+				return &protocol.Response{Status: clutcherrors.STATUS_INVALID_REQUEST}
+			}
+			return next(ctx, req)
+		}
+	}
+	s := NewServer(WithMiddleware(counter, rejecter))
+	ctx := context.Background()
+
+	resp := s.Dispatch(ctx, newRequest(protocol.ACQUIRE, "lock1", "owner1", 1000, 0))
+	if resp.Status != clutcherrors.STATUS_SUCCESS {
+		t.Fatalf("Expected acquire to pass through, got %+v", resp)
+	}
+	if len(order) != 2 || order[0] != "counter" || order[1] != "rejecter" {
+		t.Errorf("Expected counter then rejecter, got %v", order)
+	}
+
+	// The rejecter answers without reaching the lock table
+	resp = s.Dispatch(ctx, newRequest(protocol.RELEASE, "lock1", "owner1", 0, resp.FencingToken))
+	if resp.Status != clutcherrors.STATUS_INVALID_REQUEST {
+		t.Errorf("Expected release to be rejected, got %+v", resp)
+	}
+	if _, ok := s.activeLocks.Load("lock1"); !ok {
+		t.Error("Expected rejected release to leave the lock held")
+	}
+	if counted != 2 {
+		t.Errorf("Expected counter to see 2 requests, got %d", counted)
+	}
+}
+
+func TestMiddlewareSeesFrames(t *testing.T) {
+	var seen []uint8
+	rejectPrefix := func(next Handler) Handler {
+		return func(ctx context.Context, req *protocol.Request) *protocol.Response {
+			seen = append(seen, req.Cmd)
+			if req.Cmd == protocol.RELEASE_BY_PREFIX {
+				return &protocol.Response{Status: clutcherrors.STATUS_FORBIDDEN}
+			}
+			return next(ctx, req)
+		}
+	}
+	s := NewServer(WithMiddleware(rejectPrefix))
+	ctx := context.Background()
+
+	req := newRequest(protocol.ACQUIRE, "lock1", "owner1", 1000, 0)
+	if resp := s.Dispatch(ctx, req); resp.Status != clutcherrors.STATUS_SUCCESS {
+		t.Fatalf("Acquire failed: %+v", resp)
+	}
+
+	owner := req.OwnerID
+	status, results := s.DispatchRenewAll(ctx, &protocol.RenewAllRequest{OwnerID: owner, TTLMS: 1000})
+	if status != clutcherrors.STATUS_SUCCESS || len(results) != 1 || results[0].Status != clutcherrors.STATUS_SUCCESS {
+		t.Fatalf("Expected renew all to pass through, got %v %+v", status, results)
+	}
+
+	// The middleware answers without reaching the lock table
+	status, count := s.DispatchPrefix(ctx, &protocol.PrefixRequest{Cmd: protocol.RELEASE_BY_PREFIX, OwnerID: owner, Prefix: "lock"})
+	if status != clutcherrors.STATUS_FORBIDDEN || count != 0 {
+		t.Errorf("Expected release by prefix to be rejected, got %v with count %d", status, count)
+	}
+	if _, ok := s.LockInfo("lock1"); !ok {
+		t.Error("Expected rejected release by prefix to leave the lock held")
+	}
+
+	if len(seen) != 3 || seen[1] != protocol.RENEW_ALL || seen[2] != protocol.RELEASE_BY_PREFIX {
+		t.Errorf("Expected middleware to see every request, got %v", seen)
+	}
+}
@@ -0,0 +1,44 @@
+package server
+
+import (
+	"context"
+	"sort"
+
+	"github.com/mrdhat/clutchdb/protocol"
+)
+
+// ListOwners tallies the live locks each owner holds, as reported by LIST_OWNERS, for spotting
+// owners hoarding locks before they hit WithMaxLocksPerOwner. Owners holding the most come first,
+// ties in owner ID order. Expired holds aren't counted, whether or not they have been reaped.
+// It returns nil if ctx is done before the walk of the lock table finishes.
+func (s *Server) ListOwners(ctx context.Context) []protocol.OwnerSummary {
+	now := s.clock.NowMillis()
+	counts := make(map[string]uint32)
+	s.activeLocks.Range(func(key, value any) bool {
+		if ctx.Err() != nil {
+			return false
+		}
+		lock := value.(*Lock)
+		lock.mu.Lock()
+		if state, ok := liveHold(lock, now); ok {
+			counts[state.OwnerID]++
+		}
+		lock.mu.Unlock()
+		return true
+	})
+	if ctx.Err() != nil {
+		return nil
+	}
+
+	owners := make([]protocol.OwnerSummary, 0, len(counts))
+	for ownerID, n := range counts {
+		owners = append(owners, protocol.OwnerSummary{OwnerID: ownerID, Locks: n})
+	}
+	sort.Slice(owners, func(i, j int) bool {
+		if owners[i].Locks != owners[j].Locks {
+			return owners[i].Locks > owners[j].Locks
+		}
+		return owners[i].OwnerID < owners[j].OwnerID
+	})
+	return owners
+}
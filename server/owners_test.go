@@ -0,0 +1,65 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/mrdhat/clutchdb/clutcherrors"
+	"github.com/mrdhat/clutchdb/protocol"
+)
+
+func TestListOwners(t *testing.T) {
+	clock := &manualClock{}
+	clock.now.Store(1_000_000)
+	s := NewServer(WithClock(clock))
+	ctx := context.Background()
+
+	held := map[string]int{"owner1": 1, "owner2": 3, "owner3": 1}
+	for ownerID, n := range held {
+		for i := 0; i < n; i++ {
+			if _, _, err := s.Acquire(ctx, ownerID, fmt.Sprintf("%s-lock%d", ownerID, i), time.Minute); err != nil {
+				t.Fatalf("Acquire failed: %v", err)
+			}
+		}
+	}
+	// An expired hold, not yet reaped, doesn't count
+	if _, _, err := s.Acquire(ctx, "owner4", "short", time.Millisecond); err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	clock.advance(time.Second)
+
+	want := []protocol.OwnerSummary{
+		{OwnerID: "owner2", Locks: 3},
+		{OwnerID: "owner1", Locks: 1},
+		{OwnerID: "owner3", Locks: 1},
+	}
+	if owners := s.ListOwners(ctx); !reflect.DeepEqual(owners, want) {
+		t.Errorf("Expected %+v, got %+v", want, owners)
+	}
+
+	// The same over the wire
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	go s.ServeConn(ctx, serverConn)
+
+	if err := protocol.WriteListOwnersRequest(clientConn, [16]byte{1}); err != nil {
+		t.Fatalf("WriteListOwnersRequest failed: %v", err)
+	}
+	status, owners, total, err := protocol.ReadListOwnersResponse(clientConn)
+	if err != nil {
+		t.Fatalf("ReadListOwnersResponse failed: %v", err)
+	}
+	if status != clutcherrors.STATUS_SUCCESS || total != 3 || !reflect.DeepEqual(owners, want) {
+		t.Errorf("Expected %+v, got status %d and %+v of %d", want, status, owners, total)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if owners := s.ListOwners(cancelled); owners != nil {
+		t.Errorf("Expected no result once ctx is done, got %+v", owners)
+	}
+}
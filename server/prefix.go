@@ -0,0 +1,107 @@
+package server
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/mrdhat/clutchdb/clutcherrors"
+	"github.com/mrdhat/clutchdb/protocol"
+)
+
+// CountByPrefix returns how many live holds there are on locks whose ID starts with prefix, as
+// reported by COUNT_BY_PREFIX. Prefixes match bytes, not path segments: "tenant/42" also covers
+// "tenant/420/x", so end a prefix with its separator to scope it to one subtree. An empty prefix
+// matches every lock. Expired holds aren't counted, whether or not they have been reaped.
+func (s *Server) CountByPrefix(prefix string) int {
+	now := s.clock.NowMillis()
+	count := 0
+	s.activeLocks.Range(func(key, value any) bool {
+		if !strings.HasPrefix(key.(string), prefix) {
+			return true
+		}
+		lock := value.(*Lock)
+		lock.mu.Lock()
+		if _, ok := liveHold(lock, now); ok {
+			count++
+		}
+		lock.mu.Unlock()
+		return true
+	})
+	return count
+}
+
+// ReleaseByPrefix releases every live hold ownerID has on locks whose ID starts with prefix,
+// matched as CountByPrefix does, and returns the released lock IDs in order. It is meant for
+// offboarding, such as dropping every lock under "tenant/42/" at once; other owners' holds under
+// the prefix are left alone.
+//
+// The holds to release are those in the lock table when it is called. Each is then released as
+// if by Release with the token it had, so a hold that lapsed, was released or was taken again
+// in between, even by ownerID, is skipped rather than released. It stops at the first release
+// that fails to commit, or when ctx is done, returning that failure and what it released so far.
+func (s *Server) ReleaseByPrefix(ctx context.Context, prefix string, ownerID string) (clutcherrors.StatusCode, []string, error) {
+	now := s.clock.NowMillis()
+	var holds []LockState
+	s.activeLocks.Range(func(key, value any) bool {
+		if !strings.HasPrefix(key.(string), prefix) {
+			return true
+		}
+		lock := value.(*Lock)
+		lock.mu.Lock()
+		if state, ok := liveHold(lock, now); ok && state.OwnerID == ownerID {
+			holds = append(holds, state)
+		}
+		lock.mu.Unlock()
+		return true
+	})
+	sort.Slice(holds, func(i, j int) bool { return holds[i].LockID < holds[j].LockID })
+
+	var released []string
+	for _, hold := range holds {
+		if err := ctx.Err(); err != nil {
+			return clutcherrors.STATUS_OVERLOADED, released, err
+		}
+		status, err := s.Release(ctx, hold.LockID, ownerID, hold.FencingToken)
+		switch status {
+		case clutcherrors.STATUS_SUCCESS:
+			released = append(released, hold.LockID)
+		case clutcherrors.STATUS_LOCK_NOT_HELD, clutcherrors.STATUS_LOCK_EXPIRED, clutcherrors.STATUS_FENCED_OUT:
+			// Changed hands or lapsed since the scan
+		default:
+			return status, released, err
+		}
+	}
+	return clutcherrors.STATUS_SUCCESS, released, nil
+}
+
+// DispatchPrefix executes a decoded RELEASE_BY_PREFIX or COUNT_BY_PREFIX request, passing its
+// envelope through the middleware chain, and returns its status and count: of the locks
+// released, or of the live locks under the prefix
+func (s *Server) DispatchPrefix(ctx context.Context, req *protocol.PrefixRequest) (clutcherrors.StatusCode, uint32) {
+	var count uint32
+	status := s.dispatchFrame(ctx, req.Envelope(), func(ctx context.Context) (clutcherrors.StatusCode, func()) {
+		status, n := s.prefixOp(ctx, req)
+		return status, func() { count = n }
+	})
+	return status, count
+}
+
+// prefixOp executes a RELEASE_BY_PREFIX or COUNT_BY_PREFIX at the core of the middleware chain
+func (s *Server) prefixOp(ctx context.Context, req *protocol.PrefixRequest) (clutcherrors.StatusCode, uint32) {
+	prefix := req.Prefix
+	if s.lockIDPolicy != nil && prefix != "" {
+		// Normalizing a prefix gives the prefix of the normalized IDs, since it only folds case
+		var err error
+		if prefix, err = s.lockIDPolicy.Normalize(prefix); err != nil {
+			return clutcherrors.STATUS_INVALID_REQUEST, 0
+		}
+	}
+
+	if req.Cmd == protocol.COUNT_BY_PREFIX {
+		return clutcherrors.STATUS_SUCCESS, clampUint32(s.CountByPrefix(prefix))
+	}
+
+	status, released, _ := s.ReleaseByPrefix(ctx, prefix, idString(req.OwnerID))
+	return status, clampUint32(len(released))
+}
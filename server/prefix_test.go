@@ -0,0 +1,194 @@
+package server
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/mrdhat/clutchdb/clutcherrors"
+	"github.com/mrdhat/clutchdb/protocol"
+)
+
+func TestCountByPrefix(t *testing.T) {
+	clock := &manualClock{}
+	clock.now.Store(1_000_000)
+	s := NewServer(WithClock(clock))
+	ctx := context.Background()
+
+	for _, h := range []struct{ owner, lock string }{
+		{"owner1", "t/42/a"},
+		{"owner2", "t/42/b"},
+		{"owner1", "t/420/a"},
+		{"owner1", "t/4"},
+	} {
+		if _, _, err := s.Acquire(ctx, h.owner, h.lock, time.Minute); err != nil {
+			t.Fatalf("Acquire failed: %v", err)
+		}
+	}
+	// An expired hold, not yet reaped, doesn't count
+	if _, _, err := s.Acquire(ctx, "owner1", "t/42/c", time.Millisecond); err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	clock.advance(time.Second)
+
+	for prefix, want := range map[string]int{"t/42/": 2, "t/420/": 1, "t/42": 3, "t/": 4, "": 4, "u/": 0} {
+		if got := s.CountByPrefix(prefix); got != want {
+			t.Errorf("Expected %d locks under %q, got %d", want, prefix, got)
+		}
+	}
+}
+
+func TestReleaseByPrefix(t *testing.T) {
+	clock := &manualClock{}
+	clock.now.Store(1_000_000)
+	s := NewServer(WithClock(clock))
+	ctx := context.Background()
+
+	for _, h := range []struct{ owner, lock string }{
+		{"owner1", "t/42/b"},
+		{"owner1", "t/42/a"},
+		{"owner2", "t/42/c"},
+		{"owner1", "t/420/a"},
+	} {
+		if _, _, err := s.Acquire(ctx, h.owner, h.lock, time.Minute); err != nil {
+			t.Fatalf("Acquire failed: %v", err)
+		}
+	}
+
+	status, released, err := s.ReleaseByPrefix(ctx, "t/42/", "owner1")
+	if err != nil || status != clutcherrors.STATUS_SUCCESS {
+		t.Fatalf("ReleaseByPrefix failed: %d %v", status, err)
+	}
+	if want := []string{"t/42/a", "t/42/b"}; !reflect.DeepEqual(released, want) {
+		t.Errorf("Expected %v released, got %v", want, released)
+	}
+	// Neither another owner's hold under the prefix nor a lock just outside it is touched
+	for _, lockID := range []string{"t/42/c", "t/420/a"} {
+		if _, ok := s.LockInfo(lockID); !ok {
+			t.Errorf("Expected %s still held", lockID)
+		}
+	}
+	if got := s.CountByPrefix("t/42/"); got != 1 {
+		t.Errorf("Expected 1 lock left under t/42/, got %d", got)
+	}
+}
+
+func TestReleaseByPrefixSkipsExpiredHolds(t *testing.T) {
+	clock := &manualClock{}
+	clock.now.Store(1_000_000)
+	s := NewServer(WithClock(clock))
+	ctx := context.Background()
+
+	if _, _, err := s.Acquire(ctx, "owner1", "t/1/short", time.Millisecond); err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	if _, _, err := s.Acquire(ctx, "owner1", "t/1/long", time.Minute); err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	clock.advance(time.Second)
+
+	status, released, err := s.ReleaseByPrefix(ctx, "t/1/", "owner1")
+	if err != nil || status != clutcherrors.STATUS_SUCCESS {
+		t.Fatalf("ReleaseByPrefix failed: %d %v", status, err)
+	}
+	if want := []string{"t/1/long"}; !reflect.DeepEqual(released, want) {
+		t.Errorf("Expected %v released, got %v", want, released)
+	}
+}
+
+func TestPrefixOverTheWire(t *testing.T) {
+	s := NewServer()
+	ctx := context.Background()
+	for _, lockID := range []string{"t/7/a", "t/7/b", "t/70/a"} {
+		if _, _, err := s.Acquire(ctx, "owner1", lockID, time.Minute); err != nil {
+			t.Fatalf("Acquire failed: %v", err)
+		}
+	}
+
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	go s.ServeConn(ctx, serverConn)
+
+	var owner [16]byte
+	copy(owner[:], "owner1")
+	send := func(cmd uint8) (clutcherrors.StatusCode, uint32) {
+		t.Helper()
+		if err := protocol.WritePrefixRequest(clientConn, &protocol.PrefixRequest{Cmd: cmd, RequestID: [16]byte{1}, OwnerID: owner, Prefix: "t/7/"}); err != nil {
+			t.Fatalf("WritePrefixRequest failed: %v", err)
+		}
+		status, count, err := protocol.ReadPrefixResponse(clientConn)
+		if err != nil {
+			t.Fatalf("ReadPrefixResponse failed: %v", err)
+		}
+		return status, count
+	}
+
+	if status, count := send(protocol.COUNT_BY_PREFIX); status != clutcherrors.STATUS_SUCCESS || count != 2 {
+		t.Errorf("Expected 2 locks, got status %d and %d", status, count)
+	}
+	if status, count := send(protocol.RELEASE_BY_PREFIX); status != clutcherrors.STATUS_SUCCESS || count != 2 {
+		t.Errorf("Expected 2 locks released, got status %d and %d", status, count)
+	}
+	if status, count := send(protocol.COUNT_BY_PREFIX); status != clutcherrors.STATUS_SUCCESS || count != 0 {
+		t.Errorf("Expected no locks left, got status %d and %d", status, count)
+	}
+	if got := s.CountByPrefix("t/70/"); got != 1 {
+		t.Errorf("Expected t/70/a still held, got %d", got)
+	}
+}
+
+func TestReleaseByPrefixAuthenticated(t *testing.T) {
+	secret := []byte("shared secret")
+	s := NewServer(WithMiddleware(HMACAuth(secret)), WithRateLimiter(NewRateLimiter(0.001, 1)))
+	ctx := context.Background()
+	if _, _, err := s.Acquire(ctx, "owner1", "t/7/a", time.Minute); err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	go s.ServeConn(ctx, serverConn)
+
+	var owner [16]byte
+	copy(owner[:], "owner1")
+	send := func(req *protocol.PrefixRequest) clutcherrors.StatusCode {
+		t.Helper()
+		if err := protocol.WritePrefixRequest(clientConn, req); err != nil {
+			t.Fatalf("WritePrefixRequest failed: %v", err)
+		}
+		status, _, err := protocol.ReadPrefixResponse(clientConn)
+		if err != nil {
+			t.Fatalf("ReadPrefixResponse failed: %v", err)
+		}
+		return status
+	}
+
+	// Anyone who can name an owner could otherwise drop all of its locks
+	unsigned := &protocol.PrefixRequest{Cmd: protocol.RELEASE_BY_PREFIX, RequestID: [16]byte{1}, OwnerID: owner}
+	if status := send(unsigned); status != clutcherrors.STATUS_FORBIDDEN {
+		t.Fatalf("Expected unsigned release by prefix to be forbidden, got %d", status)
+	}
+	if got := s.CountByPrefix(""); got != 1 {
+		t.Fatalf("Expected the lock to stay held, got %d held", got)
+	}
+
+	sign := func(req *protocol.PrefixRequest) *protocol.PrefixRequest {
+		env := req.Envelope()
+		protocol.SignRequest(env, secret)
+		req.MAC = env.MAC
+		return req
+	}
+	if status := send(sign(&protocol.PrefixRequest{Cmd: protocol.RELEASE_BY_PREFIX, RequestID: [16]byte{2}, OwnerID: owner})); status != clutcherrors.STATUS_SUCCESS {
+		t.Fatalf("Expected signed release by prefix to succeed, got %d", status)
+	}
+	if got := s.CountByPrefix(""); got != 0 {
+		t.Errorf("Expected the lock released, got %d held", got)
+	}
+
+	// The owner's rate limit applies as it does to a RELEASE
+	if status := send(sign(&protocol.PrefixRequest{Cmd: protocol.RELEASE_BY_PREFIX, RequestID: [16]byte{3}, OwnerID: owner})); status != clutcherrors.STATUS_RATE_LIMITED {
+		t.Errorf("Expected a second release by prefix to be rate limited, got %d", status)
+	}
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/mrdhat/clutchdb/shard"
+)
+
+// rateLimiterShards spreads buckets over independently locked shards so
+// unrelated owners don't contend on a single mutex
+const rateLimiterShards = 32
+
+// RateLimiter is a token-bucket limiter keyed by an arbitrary string (e.g. owner ID)
+type RateLimiter struct {
+	rate   float64 // tokens added per second
+	burst  float64 // bucket capacity
+	shards [rateLimiterShards]rateLimiterShard
+}
+
+type rateLimiterShard struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	nextSweep time.Time // when to next drop idle buckets
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter returns a limiter allowing rate requests per second per key, with bursts up to burst
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	l := &RateLimiter{rate: rate, burst: float64(burst)}
+	for i := range l.shards {
+		l.shards[i].buckets = make(map[string]*bucket)
+	}
+	return l
+}
+
+// Allow reports whether key may issue a request now, consuming a token if so
+func (l *RateLimiter) Allow(key string) bool {
+	return l.allowAt(key, time.Now())
+}
+
+func (l *RateLimiter) allowAt(key string, now time.Time) bool {
+	s := &l.shards[shard.ShardFor(key, rateLimiterShards)]
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !now.Before(s.nextSweep) {
+		l.sweep(s, now)
+	}
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		s.buckets[key] = b
+	}
+
+	// Refill for the time elapsed since the last request
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens += elapsed * l.rate
+		if b.tokens > l.burst {
+			b.tokens = l.burst
+		}
+		b.last = now
+	}
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// sweep drops the buckets in s that have refilled since their last request. A full bucket is
+// what a new key gets anyway, so forgetting it loses nothing, and keys that stop sending don't
+// pile up. Sweeps run once per refill window, so each costs a pass over buckets that have had that
+// long to go idle. Must be called with s.mu held.
+func (l *RateLimiter) sweep(s *rateLimiterShard, now time.Time) {
+	if l.rate <= 0 {
+		// Buckets never refill, so they must never be forgotten either
+		s.nextSweep = now.Add(math.MaxInt64)
+		return
+	}
+	for key, b := range s.buckets {
+		refill := time.Duration((l.burst - b.tokens) / l.rate * float64(time.Second))
+		if !now.Before(b.last.Add(refill)) {
+			delete(s.buckets, key)
+		}
+	}
+	s.nextSweep = now.Add(time.Duration(l.burst / l.rate * float64(time.Second)))
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/mrdhat/clutchdb/shard"
+)
+
+func TestRateLimiterBurst(t *testing.T) {
+	l := NewRateLimiter(10, 3)
+	now := time.Now()
+
+	for i := 0; i < 3; i++ {
+		if !l.allowAt("owner1", now) {
+			t.Fatalf("Expected request %d within burst to be allowed", i)
+		}
+	}
+	if l.allowAt("owner1", now) {
+		t.Fatal("Expected request beyond burst to be rejected")
+	}
+
+	// Other keys have their own bucket
+	if !l.allowAt("owner2", now) {
+		t.Error("Expected a different owner to be allowed")
+	}
+}
+
+func TestRateLimiterRecovers(t *testing.T) {
+	l := NewRateLimiter(10, 1)
+	now := time.Now()
+
+	if !l.allowAt("owner1", now) {
+		t.Fatal("Expected first request to be allowed")
+	}
+	if l.allowAt("owner1", now.Add(50*time.Millisecond)) {
+		t.Fatal("Expected request before refill to be rejected")
+	}
+
+	// 10/s refills one token every 100ms
+	if !l.allowAt("owner1", now.Add(150*time.Millisecond)) {
+		t.Fatal("Expected request after refill to be allowed")
+	}
+
+	// Tokens never accumulate past the burst
+	later := now.Add(10 * time.Second)
+	if !l.allowAt("owner1", later) {
+		t.Fatal("Expected request after long idle to be allowed")
+	}
+	if l.allowAt("owner1", later) {
+		t.Fatal("Expected burst to be capped at 1")
+	}
+}
+
+func TestRateLimiterForgetsIdleKeys(t *testing.T) {
+	l := NewRateLimiter(10, 2)
+	now := time.Now()
+
+	for i := 0; i < 1000; i++ {
+		l.allowAt(fmt.Sprintf("owner%d", i), now)
+	}
+
+	// Once a refill window has passed, a sweep drops every bucket that is full again
+	later := now.Add(time.Second)
+	for i := range l.shards {
+		l.shards[i].mu.Lock()
+		l.sweep(&l.shards[i], later)
+		l.shards[i].mu.Unlock()
+	}
+	remaining := 0
+	for i := range l.shards {
+		remaining += len(l.shards[i].buckets)
+	}
+	if remaining != 0 {
+		t.Errorf("Expected every idle bucket dropped, %d left", remaining)
+	}
+
+	// A bucket still refilling is kept, or the key would get a fresh burst
+	l.allowAt("owner1", later)
+	l.allowAt("owner1", later)
+	half := later.Add(100 * time.Millisecond)
+	s := &l.shards[shard.ShardFor("owner1", rateLimiterShards)]
+	s.mu.Lock()
+	l.sweep(s, half)
+	s.mu.Unlock()
+	if !l.allowAt("owner1", half) || l.allowAt("owner1", half) {
+		t.Error("Expected owner1 to have refilled only one token after a sweep")
+	}
+}
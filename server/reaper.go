@@ -0,0 +1,135 @@
+package server
+
+import (
+	"context"
+	"time"
+)
+
+// ReapExpired examines up to the reaper batch size of locks and removes those whose hold (plus any
+// expiry grace) has lapsed, firing their expiry callbacks. It returns how many were removed.
+// Reaping only reclaims memory and notifies observers early: commands already
+// treat an expired lock as free whether or not it has been reaped.
+func (s *Server) ReapExpired() int {
+	reaped, _ := s.reapPass()
+	return reaped
+}
+
+// reapPass runs one reaper pass and reports how many locks it removed and examined. Passes work
+// through rounds: a round lists the table once and each pass takes the next batch from the list,
+// so a round costs one walk of the table however small the batches. Locks added during a round are
+// examined in the next.
+func (s *Server) reapPass() (int, int) {
+	s.reaperMu.Lock()
+	defer s.reaperMu.Unlock()
+
+	if len(s.reaperPending) == 0 {
+		s.reaperPending = s.lockIDs()
+	}
+	n := len(s.reaperPending)
+	if s.reaperBatchSize > 0 {
+		n = min(n, s.reaperBatchSize)
+	}
+	lockIDs := s.reaperPending[:n]
+	s.reaperPending = s.reaperPending[n:]
+	if len(s.reaperPending) == 0 {
+		// Round over, let the list go
+		s.reaperPending = nil
+	}
+
+	return s.reapLocks(lockIDs), len(lockIDs)
+}
+
+// RunReaperOnce removes every lock in the table whose hold (plus any expiry grace) has lapsed, in
+// one pass regardless of the reaper batch size, and returns how many it removed. It runs even
+// while the reaper is paused, for tests and maintenance that want eviction at a known point.
+func (s *Server) RunReaperOnce() int {
+	s.reaperMu.Lock()
+	defer s.reaperMu.Unlock()
+	return s.reapLocks(s.lockIDs())
+}
+
+// PauseReaper stops the background reaper from removing expired locks until ResumeReaper. The
+// reaper goroutine keeps running, and commands still treat expired locks as free.
+func (s *Server) PauseReaper() {
+	s.reaperPaused.Store(true)
+}
+
+// ResumeReaper lets the background reaper remove expired locks again after PauseReaper
+func (s *Server) ResumeReaper() {
+	s.reaperPaused.Store(false)
+}
+
+// reapLocks removes those of lockIDs whose hold has lapsed and returns how many it removed.
+// Must be called with reaperMu held.
+func (s *Server) reapLocks(lockIDs []string) int {
+	now := s.clock.NowMillis()
+	grace := uint64(s.expiryGrace.Milliseconds())
+	reaped := 0
+
+	for _, lockID := range lockIDs {
+		lockIface, ok := s.activeLocks.Load(lockID)
+		if !ok {
+			continue
+		}
+		lock := lockIface.(*Lock)
+
+		lock.mu.Lock()
+		if !lock.removed && lock.ExpiresAt+grace <= now {
+			s.expireHold(lock)
+			s.removeLock(lockID, lock)
+			reaped++
+		}
+		lock.mu.Unlock()
+	}
+	return reaped
+}
+
+// lockIDs lists the IDs in the lock table without taking any lock mutexes
+func (s *Server) lockIDs() []string {
+	var lockIDs []string
+	s.activeLocks.Range(func(key, _ any) bool {
+		lockIDs = append(lockIDs, key.(string))
+		return true
+	})
+	return lockIDs
+}
+
+// nextReapInterval picks the sleep before the next pass: straight back to the minimum when a
+// pass was all expirations, halving while it finds some, and doubling while it finds none
+func (s *Server) nextReapInterval(current time.Duration, reaped int, examined int) time.Duration {
+	var next time.Duration
+	switch {
+	case reaped > 0 && reaped == examined:
+		next = s.reaperMinInterval
+	case reaped > 0:
+		next = current / 2
+	default:
+		next = current * 2
+	}
+	return min(max(next, s.reaperMinInterval), s.reaperMaxInterval)
+}
+
+// StartReaper runs reaper passes until ctx is done, adapting the interval between them. Passes
+// are skipped while the reaper is paused.
+func (s *Server) StartReaper(ctx context.Context) {
+	go func() {
+		interval := s.reaperMinInterval
+		timer := time.NewTimer(interval)
+		defer timer.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-timer.C:
+				if s.reaperPaused.Load() {
+					timer.Reset(interval)
+					continue
+				}
+				reaped, examined := s.reapPass()
+				interval = s.nextReapInterval(interval, reaped, examined)
+				timer.Reset(interval)
+			}
+		}
+	}()
+}
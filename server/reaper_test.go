@@ -0,0 +1,226 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestExpiryCallbackFiresOnce(t *testing.T) {
+	s := NewServer()
+	ctx := context.Background()
+
+	_, lock, err := s.Acquire(ctx, "owner1", "lock1", 20*time.Millisecond)
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+
+	var fired int32
+	done := make(chan struct{}, 2)
+	if err := s.OnExpire("lock1", lock.FencingToken, func() {
+		atomic.AddInt32(&fired, 1)
+		done <- struct{}{}
+	}); err != nil {
+		t.Fatalf("OnExpire failed: %v", err)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if n := s.ReapExpired(); n != 1 {
+		t.Fatalf("Expected 1 lock reaped, got %d", n)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected expiry callback to fire")
+	}
+
+	// A later holder of the same lock must not trigger the first holder's callback
+	if _, _, err := s.Acquire(ctx, "owner2", "lock1", 10*time.Millisecond); err != nil {
+		t.Fatalf("Re-acquire failed: %v", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	s.ReapExpired()
+	time.Sleep(20 * time.Millisecond)
+
+	if n := atomic.LoadInt32(&fired); n != 1 {
+		t.Errorf("Expected callback to fire once, fired %d times", n)
+	}
+	if _, ok := s.activeLocks.Load("lock1"); ok {
+		t.Error("Expected reaped lock to be removed")
+	}
+}
+
+func TestExpiryCallbackNotFiredOnRelease(t *testing.T) {
+	s := NewServer()
+	ctx := context.Background()
+
+	_, lock, err := s.Acquire(ctx, "owner1", "lock1", 20*time.Millisecond)
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	token := lock.FencingToken
+
+	var fired int32
+	if err := s.OnExpire("lock1", token, func() { atomic.AddInt32(&fired, 1) }); err != nil {
+		t.Fatalf("OnExpire failed: %v", err)
+	}
+
+	if _, err := s.Release(ctx, "lock1", "owner1", token); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	s.ReapExpired()
+	time.Sleep(20 * time.Millisecond)
+
+	if n := atomic.LoadInt32(&fired); n != 0 {
+		t.Errorf("Expected no callback after clean release, fired %d times", n)
+	}
+}
+
+func TestOnExpireRejectsStaleToken(t *testing.T) {
+	s := NewServer()
+
+	_, lock, err := s.Acquire(context.Background(), "owner1", "lock1", time.Second)
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+
+	if err := s.OnExpire("lock1", lock.FencingToken+1, func() {}); err == nil {
+		t.Error("Expected OnExpire to reject a token that isn't the current hold")
+	}
+	if err := s.OnExpire("missing", 1, func() {}); err == nil {
+		t.Error("Expected OnExpire to reject an unknown lock")
+	}
+}
+
+func TestReapExpiredBatchSize(t *testing.T) {
+	s := NewServer(WithReaperBatchSize(3))
+	ctx := context.Background()
+
+	for i := 0; i < 7; i++ {
+		if _, _, err := s.Acquire(ctx, "owner1", fmt.Sprintf("lock%d", i), time.Millisecond); err != nil {
+			t.Fatalf("Acquire failed: %v", err)
+		}
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	// Passes resume where the last one stopped until the whole table is covered
+	for i, want := range []int{3, 3, 1, 0} {
+		if n := s.ReapExpired(); n != want {
+			t.Errorf("Pass %d: expected %d locks reaped, got %d", i, want, n)
+		}
+	}
+
+	remaining := 0
+	s.activeLocks.Range(func(_, _ any) bool {
+		remaining++
+		return true
+	})
+	if remaining != 0 {
+		t.Errorf("Expected every expired lock to be reaped, %d remain", remaining)
+	}
+}
+
+func TestReapRoundPicksUpNewLocks(t *testing.T) {
+	clock := &manualClock{}
+	clock.now.Store(1_000_000)
+	s := NewServer(WithClock(clock), WithReaperBatchSize(2))
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		if _, _, err := s.Acquire(ctx, "owner1", fmt.Sprintf("lock%d", i), time.Millisecond); err != nil {
+			t.Fatalf("Acquire failed: %v", err)
+		}
+	}
+	clock.advance(time.Second)
+	if n := s.ReapExpired(); n != 2 {
+		t.Fatalf("Expected the first batch to reap 2 locks, got %d", n)
+	}
+
+	// Added mid-round, so it waits for the round listing the table after it
+	if _, _, err := s.Acquire(ctx, "owner1", "late", time.Millisecond); err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	clock.advance(time.Second)
+	for i, want := range []int{1, 1} {
+		if n := s.ReapExpired(); n != want {
+			t.Errorf("Pass %d: expected %d locks reaped, got %d", i, want, n)
+		}
+	}
+}
+
+func TestNextReapInterval(t *testing.T) {
+	s := NewServer()
+
+	tests := []struct {
+		current  time.Duration
+		reaped   int
+		examined int
+		want     time.Duration
+	}{
+		{100 * time.Millisecond, 0, 10, 200 * time.Millisecond},
+		{800 * time.Millisecond, 0, 10, s.reaperMaxInterval},
+		{100 * time.Millisecond, 5, 10, 50 * time.Millisecond},
+		{800 * time.Millisecond, 10, 10, s.reaperMinInterval},
+		{s.reaperMinInterval, 1, 10, s.reaperMinInterval},
+	}
+
+	for _, tt := range tests {
+		if got := s.nextReapInterval(tt.current, tt.reaped, tt.examined); got != tt.want {
+			t.Errorf("s.nextReapInterval(%v, %d, %d) = %v, want %v", tt.current, tt.reaped, tt.examined, got, tt.want)
+		}
+	}
+}
+
+func TestPauseReaper(t *testing.T) {
+	clock := &manualClock{}
+	clock.now.Store(1_000_000)
+	s := NewServer(WithClock(clock), WithReaperBatchSize(1), WithReaperInterval(time.Millisecond, time.Millisecond))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for _, lockID := range []string{"lock1", "lock2"} {
+		if _, _, err := s.Acquire(ctx, "owner1", lockID, time.Second); err != nil {
+			t.Fatalf("Acquire failed: %v", err)
+		}
+	}
+	s.PauseReaper()
+	s.StartReaper(ctx)
+	clock.advance(2 * time.Second)
+
+	// Paused passes leave expired locks in the table
+	time.Sleep(20 * time.Millisecond)
+	for _, lockID := range []string{"lock1", "lock2"} {
+		if _, ok := s.activeLocks.Load(lockID); !ok {
+			t.Errorf("Expected %s to stay in the table while the reaper is paused", lockID)
+		}
+	}
+
+	// A manual pass covers the whole table despite the batch size, even while paused
+	if n := s.RunReaperOnce(); n != 2 {
+		t.Errorf("Expected 2 locks reaped, got %d", n)
+	}
+	if _, ok := s.activeLocks.Load("lock1"); ok {
+		t.Error("Expected lock1 to be reaped by the manual pass")
+	}
+
+	if _, _, err := s.Acquire(ctx, "owner1", "lock3", time.Second); err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	clock.advance(2 * time.Second)
+	s.ResumeReaper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, ok := s.activeLocks.Load("lock3"); !ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the resumed reaper to remove lock3")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/mrdhat/clutchdb/wal"
+)
+
+// RecoveryStats describes a startup recovery, for alarming on recoveries slow enough to hurt failover
+type RecoveryStats struct {
+	RecordsRead   int   // commit log records read, including those already covered by snapshots
+	BytesRead     int64 // size of the commit log read, framing and encryption included; 0 unless it is a wal.Sizer
+	LocksRestored int   // live holds in the lock table afterwards
+
+	// Quarantine describes the damaged commit log that was moved aside when it was opened, which
+	// lost every record from the damage on; nil if there was none. See wal.WithQuarantine.
+	Quarantine *wal.QuarantineReport
+
+	SnapshotLoad time.Duration // merging the snapshots, 0 without any
+	WALRead      time.Duration // reading and decoding the commit log
+	Replay       time.Duration // applying the records after the snapshots
+}
+
+// RecoveryMetrics is implemented by Metrics that also want to observe recovery
+type RecoveryMetrics interface {
+	// ObserveRecovery records the outcome of a successful Recover
+	ObserveRecovery(stats RecoveryStats)
+}
+
+// Recover rebuilds the lock table at startup from snaps, as RestoreSnapshots takes them, and
+// the records in log after the last one's WALOffset. With no snaps the whole log is replayed.
+// Each phase is timed, and the stats are also reported to Metrics implementing RecoveryMetrics.
+// A log that was salvaged when opened is logged as an error and reported in the stats.
+func (s *Server) Recover(log wal.WAL, snaps []*Snapshot) (RecoveryStats, error) {
+	var stats RecoveryStats
+
+	locks := make(map[string]*LockState)
+	var offset uint64
+	if len(snaps) > 0 {
+		start := time.Now()
+		var err error
+		if locks, err = s.loadSnapshots(snaps); err != nil {
+			return stats, err
+		}
+		offset = snaps[len(snaps)-1].WALOffset
+		stats.SnapshotLoad = time.Since(start)
+	}
+
+	if q, ok := log.(wal.Quarantiner); ok {
+		if report, ok := q.Quarantine(); ok {
+			s.logger.Error("commit log was damaged, recovering from the records before the damage and losing the rest",
+				"log", report.Path, "quarantined", report.CorruptPath, "bad_offset", report.BadOffset,
+				"salvaged", report.Salvaged, "err", report.Err)
+			stats.Quarantine = &report
+		}
+	}
+
+	start := time.Now()
+	cmds, err := log.ReadAll()
+	if err != nil {
+		return stats, fmt.Errorf("failed to read commit log: %w", err)
+	}
+	stats.WALRead = time.Since(start)
+	stats.RecordsRead = len(cmds)
+	if sizer, ok := log.(wal.Sizer); ok {
+		// ReadAll reads the log to its end, so that is its size
+		if size, err := sizer.Size(); err == nil {
+			stats.BytesRead = size
+		}
+	}
+	if offset > uint64(len(cmds)) {
+		return stats, fmt.Errorf("snapshot covers %d records, but the commit log only has %d", offset, len(cmds))
+	}
+
+	start = time.Now()
+	if err := s.replayOnto(locks, cmds[offset:]); err != nil {
+		return stats, err
+	}
+	stats.Replay = time.Since(start)
+	stats.LocksRestored = int(atomic.LoadInt64(&s.liveLockCount))
+
+	if m, ok := s.metrics.(RecoveryMetrics); ok {
+		m.ObserveRecovery(stats)
+	}
+	return stats, nil
+}
@@ -0,0 +1,178 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mrdhat/clutchdb/wal"
+)
+
+// recoveryMetrics records held durations and the last recovery
+type recoveryMetrics struct {
+	recordingMetrics
+	recovery *RecoveryStats
+}
+
+func (m *recoveryMetrics) ObserveRecovery(stats RecoveryStats) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.recovery = &stats
+}
+
+func TestRecoverStats(t *testing.T) {
+	clock := &manualClock{}
+	clock.now.Store(1_000_000)
+	path := filepath.Join(t.TempDir(), "wal")
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatalf("OpenFile failed: %v", err)
+	}
+	log := wal.NewWAL(file)
+	s := NewServer(WithClock(clock), WithCommitLog(log))
+	ctx := context.Background()
+
+	var tokens []uint64
+	for _, lockID := range []string{"lock1", "lock2", "lock3"} {
+		_, lock, err := s.Acquire(ctx, "owner1", lockID, time.Minute)
+		if err != nil {
+			t.Fatalf("Acquire failed: %v", err)
+		}
+		tokens = append(tokens, lock.FencingToken)
+	}
+	snap := roundTrip(t, s.Snapshot())
+	if _, err := s.Release(ctx, "lock1", "owner1", tokens[0]); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+	if err := log.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	file, err = os.OpenFile(path, os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatalf("OpenFile failed: %v", err)
+	}
+	reopened := wal.NewWAL(file)
+	defer reopened.Close()
+
+	metrics := &recoveryMetrics{}
+	recovered := NewServer(WithClock(clock), WithMetrics(metrics))
+	stats, err := recovered.Recover(reopened, []*Snapshot{snap})
+	if err != nil {
+		t.Fatalf("Recover failed: %v", err)
+	}
+	if stats.RecordsRead != 4 || stats.LocksRestored != 2 {
+		t.Errorf("Expected 4 records read and 2 locks restored, got %+v", stats)
+	}
+	if info, err := os.Stat(path); err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	} else if stats.BytesRead != info.Size() {
+		t.Errorf("Expected the %d bytes of the log to be counted, got %d", info.Size(), stats.BytesRead)
+	}
+	if stats.SnapshotLoad <= 0 || stats.WALRead <= 0 || stats.Replay <= 0 {
+		t.Errorf("Expected every phase to be timed, got %+v", stats)
+	}
+	if metrics.recovery == nil || *metrics.recovery != stats {
+		t.Errorf("Expected the stats to be reported to metrics, got %+v", metrics.recovery)
+	}
+	if _, ok := recovered.LockInfo("lock1"); ok {
+		t.Error("Expected lock1, released after the snapshot, to stay released")
+	}
+	if info, ok := recovered.LockInfo("lock3"); !ok || info.FencingToken != tokens[2] {
+		t.Errorf("Expected lock3 held with token %d, got %+v (ok=%v)", tokens[2], info, ok)
+	}
+}
+
+func TestRecoverStatsCountEncryptedBytes(t *testing.T) {
+	block, err := aes.NewCipher(bytes.Repeat([]byte{0x42}, 32))
+	if err != nil {
+		t.Fatalf("NewCipher failed: %v", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatalf("NewGCM failed: %v", err)
+	}
+	log := wal.NewWALWithStorage(wal.NewMemoryStorage(), wal.WithCipher(aead))
+	defer log.Close()
+	s := NewServer(WithCommitLog(log))
+	if _, _, err := s.Acquire(context.Background(), "owner1", "lock1", time.Minute); err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+
+	stats, err := NewServer().Recover(log, nil)
+	if err != nil {
+		t.Fatalf("Recover failed: %v", err)
+	}
+	cmds, err := log.ReadAll()
+	if err != nil {
+		t.Fatalf("ReadAll failed: %v", err)
+	}
+	plain := int64(len(wal.EncodeRecord(cmds[0])))
+	size, err := log.(wal.Sizer).Size()
+	if err != nil {
+		t.Fatalf("Size failed: %v", err)
+	}
+	// The nonce, sequence number and tag are read too
+	if stats.BytesRead != size || size <= plain {
+		t.Errorf("Expected the %d bytes of the log to be counted, above the %d of the plaintext record, got %d", size, plain, stats.BytesRead)
+	}
+}
+
+func TestRecoverFromQuarantinedLog(t *testing.T) {
+	clock := &manualClock{}
+	clock.now.Store(1_000_000)
+	path := filepath.Join(t.TempDir(), "000001.wal")
+	log, err := wal.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	s := NewServer(WithClock(clock), WithCommitLog(log))
+	ctx := context.Background()
+	for _, lockID := range []string{"lock1", "lock2", "lock3"} {
+		if _, _, err := s.Acquire(ctx, "owner1", lockID, time.Minute); err != nil {
+			t.Fatalf("Acquire failed: %v", err)
+		}
+	}
+	log.Close()
+
+	// Damage the last record
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+	data[len(data)-1] ^= 0xff
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	salvaged, err := wal.Open(path, wal.WithQuarantine())
+	if err != nil {
+		t.Fatalf("Open with quarantine failed: %v", err)
+	}
+	defer salvaged.Close()
+	var logged bytes.Buffer
+	recovered := NewServer(WithClock(clock), WithLogger(slog.New(slog.NewTextHandler(&logged, nil))))
+	stats, err := recovered.Recover(salvaged, nil)
+	if err != nil {
+		t.Fatalf("Recover failed: %v", err)
+	}
+	if stats.Quarantine == nil || stats.Quarantine.Salvaged != 2 {
+		t.Errorf("Expected the quarantine to be reported with 2 records salvaged, got %+v", stats.Quarantine)
+	}
+	if !strings.Contains(logged.String(), "level=ERROR") {
+		t.Errorf("Expected the quarantine to be logged as an error, got %q", logged.String())
+	}
+	if _, ok := recovered.LockInfo("lock2"); !ok {
+		t.Error("Expected lock2, before the damage, to be restored")
+	}
+	if _, ok := recovered.LockInfo("lock3"); ok {
+		t.Error("Expected lock3, in the damaged record, to be lost")
+	}
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/mrdhat/clutchdb/clutcherrors"
+	"github.com/mrdhat/clutchdb/protocol"
+)
+
+// RenewAllByOwner extends every lock ownerID holds by ttl and returns one result per lock,
+// sorted by lock ID. A hold that has lapsed is reported as STATUS_LOCK_EXPIRED rather than
+// renewed. With tokens set, only the locks named there are renewed, and each must still carry
+// the fencing token the caller expects; the rest get STATUS_LOCK_NOT_HELD. Without tokens,
+// whatever hold ownerID has on each lock is extended.
+func (s *Server) RenewAllByOwner(ctx context.Context, ownerID string, ttl time.Duration, tokens map[string]uint64) []protocol.RenewResult {
+	if tokens == nil {
+		tokens = s.ownerHolds(ownerID)
+	}
+
+	results := make([]protocol.RenewResult, 0, len(tokens))
+	for lockID, token := range tokens {
+		results = append(results, s.renewOwned(ctx, ownerID, lockID, token, ttl))
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].LockID < results[j].LockID })
+	return results
+}
+
+// ownerHolds returns the token of every hold ownerID has in the lock table, lapsed or not
+func (s *Server) ownerHolds(ownerID string) map[string]uint64 {
+	tokens := make(map[string]uint64)
+	s.activeLocks.Range(func(key, value any) bool {
+		lock := value.(*Lock)
+		lock.mu.Lock()
+		if !lock.removed && lock.OwnerID == ownerID {
+			tokens[lock.ID] = lock.FencingToken
+		}
+		lock.mu.Unlock()
+		return true
+	})
+	return tokens
+}
+
+// renewOwned renews one lock for RenewAllByOwner
+func (s *Server) renewOwned(ctx context.Context, ownerID string, lockID string, token uint64, ttl time.Duration) protocol.RenewResult {
+	result := protocol.RenewResult{LockID: lockID}
+
+	// Renew reports a lapsed hold as not held; tell the owner its hold expired instead
+	if lockIface, ok := s.activeLocks.Load(lockID); ok {
+		lock := lockIface.(*Lock)
+		lock.mu.Lock()
+		expired := !lock.removed && lock.OwnerID == ownerID && s.tokenMatches(lock, token) && lapsed(lock.ExpiresAt, s.clock.NowMillis())
+		lock.mu.Unlock()
+		if expired {
+			result.Status = clutcherrors.STATUS_LOCK_EXPIRED
+			return result
+		}
+	}
+
+	status, lock, _ := s.Renew(ctx, ownerID, lockID, token, ttl)
+	result.Status = status
+	if lock != nil {
+		lock.mu.Lock()
+		result.FencingToken = lock.FencingToken
+		result.ExpiresAt = lock.ExpiresAt
+		lock.mu.Unlock()
+		if s.simpleMode {
+			result.FencingToken = 0
+		}
+	}
+	return result
+}
+
+// DispatchRenewAll executes a decoded RENEW_ALL request, passing its envelope through the
+// middleware chain. The status applies to the request as a whole; per-lock outcomes are in the
+// results.
+func (s *Server) DispatchRenewAll(ctx context.Context, req *protocol.RenewAllRequest) (clutcherrors.StatusCode, []protocol.RenewResult) {
+	var results []protocol.RenewResult
+	status := s.dispatchFrame(ctx, req.Envelope(), func(ctx context.Context) (clutcherrors.StatusCode, func()) {
+		status, renewed := s.renewAll(ctx, req)
+		return status, func() { results = renewed }
+	})
+	return status, results
+}
+
+// renewAll executes a RENEW_ALL at the core of the middleware chain
+func (s *Server) renewAll(ctx context.Context, req *protocol.RenewAllRequest) (clutcherrors.StatusCode, []protocol.RenewResult) {
+	if req.TTLMS == 0 || req.TTLMS > protocol.MaxTTLMillis || !s.ttlInBounds(req.TTLMS) {
+		return clutcherrors.STATUS_INVALID_REQUEST, nil
+	}
+
+	var tokens map[string]uint64
+	if len(req.Tokens) > 0 {
+		tokens = make(map[string]uint64, len(req.Tokens))
+		for _, token := range req.Tokens {
+			lockID := token.LockID
+			if s.lockIDPolicy != nil {
+				var err error
+				if lockID, err = s.lockIDPolicy.Normalize(lockID); err != nil {
+					return clutcherrors.STATUS_INVALID_REQUEST, nil
+				}
+			}
+			tokens[lockID] = token.FencingToken
+		}
+	}
+	return clutcherrors.STATUS_SUCCESS, s.RenewAllByOwner(ctx, idString(req.OwnerID), protocol.MillisToDuration(req.TTLMS), tokens)
+}
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/mrdhat/clutchdb/clutcherrors"
+	"github.com/mrdhat/clutchdb/protocol"
+)
+
+func TestRenewAllByOwner(t *testing.T) {
+	clock := &manualClock{}
+	clock.now.Store(1_000_000)
+	s := NewServer(WithClock(clock))
+	ctx := context.Background()
+
+	tokens := make(map[string]uint64)
+	for lockID, ttl := range map[string]time.Duration{"lock1": time.Minute, "lock2": time.Second, "lock3": time.Minute} {
+		_, lock, err := s.Acquire(ctx, "owner1", lockID, ttl)
+		if err != nil {
+			t.Fatalf("Acquire %s failed: %v", lockID, err)
+		}
+		tokens[lockID] = lock.FencingToken
+	}
+	if _, _, err := s.Acquire(ctx, "owner2", "other", time.Minute); err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+
+	// lock2 lapses; the others are still held
+	clock.advance(2 * time.Second)
+	results := s.RenewAllByOwner(ctx, "owner1", time.Minute, nil)
+
+	want := []struct {
+		lockID string
+		status clutcherrors.StatusCode
+	}{
+		{"lock1", clutcherrors.STATUS_SUCCESS},
+		{"lock2", clutcherrors.STATUS_LOCK_EXPIRED},
+		{"lock3", clutcherrors.STATUS_SUCCESS},
+	}
+	if len(results) != len(want) {
+		t.Fatalf("Expected %d results, got %+v", len(want), results)
+	}
+	for i, w := range want {
+		if results[i].LockID != w.lockID || results[i].Status != w.status {
+			t.Errorf("Result %d: expected %s with status %d, got %+v", i, w.lockID, w.status, results[i])
+		}
+	}
+	if results[0].ExpiresAt != 1_062_000 || results[0].FencingToken != tokens["lock1"] {
+		t.Errorf("Expected lock1 extended to 1062000 with its token, got %+v", results[0])
+	}
+	if info, ok := s.LockInfo("other"); !ok || info.ExpiresAt != 1_060_000 {
+		t.Errorf("Expected another owner's lock to be left alone, got %+v", info)
+	}
+}
+
+func TestRenewAllByOwnerStrictTokens(t *testing.T) {
+	s := NewServer()
+	ctx := context.Background()
+
+	_, lock1, err := s.Acquire(ctx, "owner1", "lock1", time.Minute)
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	_, lock2, err := s.Acquire(ctx, "owner1", "lock2", time.Minute)
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	if _, _, err := s.Acquire(ctx, "owner1", "lock3", time.Minute); err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+
+	// lock2 carries a stale token and lock3 isn't asked for
+	results := s.RenewAllByOwner(ctx, "owner1", time.Minute, map[string]uint64{
+		"lock1": lock1.FencingToken,
+		"lock2": lock2.FencingToken + 1,
+		"lock4": 1,
+	})
+	want := map[string]clutcherrors.StatusCode{
+		"lock1": clutcherrors.STATUS_SUCCESS,
+		"lock2": clutcherrors.STATUS_LOCK_NOT_HELD,
+		"lock4": clutcherrors.STATUS_LOCK_NOT_HELD,
+	}
+	if len(results) != len(want) {
+		t.Fatalf("Expected %d results, got %+v", len(want), results)
+	}
+	for _, result := range results {
+		if status, ok := want[result.LockID]; !ok || result.Status != status {
+			t.Errorf("Unexpected result %+v", result)
+		}
+	}
+}
+
+func TestServeConnRenewAll(t *testing.T) {
+	s := NewServer()
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	go s.ServeConn(context.Background(), serverConn)
+
+	req := newRequest(protocol.ACQUIRE, "lock1", "owner1", 1000, 0)
+	if err := protocol.WriteRequest(clientConn, req); err != nil {
+		t.Fatalf("WriteRequest failed: %v", err)
+	}
+	acquired, err := protocol.ReadResponse(clientConn)
+	if err != nil {
+		t.Fatalf("ReadResponse failed: %v", err)
+	}
+
+	renewAll := &protocol.RenewAllRequest{OwnerID: req.OwnerID, TTLMS: 5000}
+	if err := protocol.WriteRenewAllRequest(clientConn, renewAll); err != nil {
+		t.Fatalf("WriteRenewAllRequest failed: %v", err)
+	}
+	status, results, err := protocol.ReadRenewAllResponse(clientConn)
+	if err != nil {
+		t.Fatalf("ReadRenewAllResponse failed: %v", err)
+	}
+	if status != clutcherrors.STATUS_SUCCESS || len(results) != 1 {
+		t.Fatalf("Expected one result, got status %d %+v", status, results)
+	}
+	if results[0].Status != clutcherrors.STATUS_SUCCESS || results[0].ExpiresAt <= acquired.ExpiresAt {
+		t.Errorf("Expected lock1 to be extended past %d, got %+v", acquired.ExpiresAt, results[0])
+	}
+
+	// The connection carries on with ordinary requests
+	if err := protocol.WriteRequest(clientConn, newRequest(protocol.RELEASE, "lock1", "owner1", 0, acquired.FencingToken)); err != nil {
+		t.Fatalf("WriteRequest failed: %v", err)
+	}
+	if resp, err := protocol.ReadResponse(clientConn); err != nil || resp.Status != clutcherrors.STATUS_SUCCESS {
+		t.Errorf("Expected release to succeed, got %+v, %v", resp, err)
+	}
+}
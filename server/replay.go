@@ -0,0 +1,208 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+
+	"github.com/mrdhat/clutchdb/command"
+	"github.com/mrdhat/clutchdb/wal"
+)
+
+// Replay rebuilds the lock table from commands read back from a commit log, in log order.
+// It is meant for a fresh server at startup: commands are applied directly, not re-logged.
+// Holds that have lapsed by now are dropped, but their fencing tokens are still honored
+// so tokens never go backwards across a restart.
+//
+// The log is the source of truth for order: commands apply in the order given, which must be
+// the order they were appended. CommitTimeMillis only feeds expiry math and is never used to
+// order commands, since commands committed in the same millisecond share it.
+func (s *Server) Replay(cmds []command.Command) error {
+	return s.replayOnto(make(map[string]*LockState), cmds)
+}
+
+// replayOnto applies cmds to the holds in locks, then installs the holds still live. Each record
+// describes the whole hold after it, so replaying one the holds already reflect is harmless.
+func (s *Server) replayOnto(locks map[string]*LockState, cmds []command.Command) error {
+	for i, cmd := range cmds {
+		if cmd.Type == command.CmdReset {
+			clear(locks)
+			s.fencingTokens.Clear()
+			continue
+		}
+		s.raiseFencingToken(cmd.LockID, cmd.FencingToken)
+		next, err := replayCommand(locks[cmd.LockID], cmd)
+		if err != nil {
+			return fmt.Errorf("replay record %d: %w", i, err)
+		}
+		if next == nil {
+			delete(locks, cmd.LockID)
+		} else {
+			locks[cmd.LockID] = next
+		}
+	}
+
+	now := s.clock.NowMillis()
+	for _, lock := range locks {
+		if !lapsed(lock.ExpiresAt, now) {
+			s.installLock(*lock)
+		}
+	}
+
+	s.snapshotMu.Lock()
+	s.logOffset += uint64(len(cmds))
+	for _, cmd := range cmds {
+		if cmd.LockID != "" {
+			s.markDirtyLocked(cmd.LockID)
+		}
+	}
+	s.snapshotMu.Unlock()
+	return nil
+}
+
+// ApplyStream makes the server a hot standby: it reads records framed by wal.EncodeRecord from
+// r, such as a leader's wal.Replicator stream, and applies each to the lock table. The server
+// steps down to follower and answers writes with STATUS_NOT_LEADER until PromoteToLeader.
+// It returns nil when r ends cleanly; ctx is checked between records, so close r to stop a
+// blocked read. AppliedOffset tells where to resume after a disconnect.
+func (s *Server) ApplyStream(ctx context.Context, r io.Reader) error {
+	s.StepDown()
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		cmd, err := wal.ReadRecord(r)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read record %d: %w", s.AppliedOffset(), err)
+		}
+		if err := s.applyCommand(cmd); err != nil {
+			return fmt.Errorf("failed to apply record %d: %w", s.AppliedOffset(), err)
+		}
+		atomic.AddUint64(&s.appliedOffset, 1)
+	}
+}
+
+// AppliedOffset returns how many streamed records have been applied, which is the offset to
+// resume a leader's stream from
+func (s *Server) AppliedOffset() uint64 {
+	return atomic.LoadUint64(&s.appliedOffset)
+}
+
+// applyCommand applies one replicated command to the live lock table
+func (s *Server) applyCommand(cmd command.Command) error {
+	s.noteCommitted(cmd.LockID, false)
+	if cmd.Type == command.CmdReset {
+		s.clearState()
+		return nil
+	}
+	s.raiseFencingToken(cmd.LockID, cmd.FencingToken)
+	if cmd.Type == command.CmdAdvanceFencing {
+		return nil
+	}
+
+	for {
+		lockIface, _ := s.activeLocks.LoadOrStore(cmd.LockID, &Lock{ID: cmd.LockID})
+		lock := lockIface.(*Lock)
+
+		lock.mu.Lock()
+		if !lock.removed {
+			err := s.applyLocked(lock, cmd)
+			lock.mu.Unlock()
+			return err
+		}
+		// Removed while we waited on its mutex; retry with the current entry
+		lock.mu.Unlock()
+	}
+}
+
+// applyLocked is the body of applyCommand. Must be called with lock.mu held.
+func (s *Server) applyLocked(lock *Lock, cmd command.Command) error {
+	var prev *LockState
+	if lock.OwnerID != "" {
+		prev = &LockState{OwnerID: lock.OwnerID, AcquiredAt: lock.AcquiredAt, Value: lock.Value}
+	}
+
+	next, err := replayCommand(prev, cmd)
+	if err != nil {
+		if prev == nil {
+			s.removeLock(lock.ID, lock)
+		}
+		return err
+	}
+
+	if next == nil {
+		s.observeHeld(lock, cmd.CommitTimeMillis)
+		s.recordRelease(lock.ID, cmd.OwnerID, cmd.FencingToken, cmd.CommitTimeMillis)
+		s.removeLock(lock.ID, lock)
+		s.releaseOwnership(lock)
+		s.dropExpiryCallbacks(lock.ID, cmd.FencingToken)
+		s.notifyWaiters(lock.ID)
+		return nil
+	}
+
+	switch {
+	case prev != nil && (cmd.Type == command.CmdRenew || cmd.Type == command.CmdBump):
+		// The same hold carries on
+		s.moveExpiryCallbacks(lock.ID, lock.FencingToken, next.FencingToken)
+	case prev != nil && cmd.Type == command.CmdTransfer:
+		s.observeHeld(lock, cmd.CommitTimeMillis)
+		s.releaseOwnerCount(prev.OwnerID)
+		s.countOwner(next.OwnerID)
+	default:
+		// A new hold replaces whatever expired hold was there
+		s.expireHold(lock)
+		s.countOwner(next.OwnerID)
+		atomic.AddInt64(&s.liveLockCount, 1)
+	}
+
+	lock.OwnerID = next.OwnerID
+	lock.FencingToken = next.FencingToken
+	lock.ExpiresAt = next.ExpiresAt
+	lock.AcquiredAt = next.AcquiredAt
+	lock.Value = next.Value
+	return nil
+}
+
+// replayCommand returns the hold on cmd's lock after cmd, given the hold before it (nil if
+// none). A nil result means the lock is free.
+func replayCommand(prev *LockState, cmd command.Command) (*LockState, error) {
+	switch cmd.Type {
+	case command.CmdAcquire, command.CmdRenew, command.CmdBump, command.CmdTransfer:
+		// A renew, bump or transfer whose acquire was truncated away still describes the hold
+		ownerID := cmd.OwnerID
+		if cmd.Type == command.CmdTransfer {
+			ownerID = cmd.NewOwnerID
+		}
+		// The hold started at its acquire's commit time and survives renewals and bumps
+		acquiredAt := cmd.CommitTimeMillis
+		if prev != nil && cmd.Type != command.CmdAcquire && cmd.Type != command.CmdTransfer {
+			acquiredAt = prev.AcquiredAt
+		}
+		// As does its value, handoffs included
+		value := cmd.Value
+		if prev != nil && cmd.Type != command.CmdAcquire {
+			value = prev.Value
+		}
+		return &LockState{
+			LockID:       cmd.LockID,
+			OwnerID:      ownerID,
+			FencingToken: cmd.FencingToken,
+			ExpiresAt:    cmd.CommitTimeMillis + cmd.TTLMillis,
+			AcquiredAt:   acquiredAt,
+			Value:        value,
+		}, nil
+	case command.CmdRelease:
+		return nil, nil
+	case command.CmdAdvanceFencing:
+		// Only moves the counter, which the caller raises for every command
+		return prev, nil
+	default:
+		return nil, fmt.Errorf("unknown command type %d", cmd.Type)
+	}
+}
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mrdhat/clutchdb/command"
+	"github.com/mrdhat/clutchdb/wal"
+)
+
+func TestReplay(t *testing.T) {
+	log := wal.NewWALWithStorage(wal.NewMemoryStorage())
+	s := NewServer(WithCommitLog(log), WithRenewHandoff())
+	ctx := context.Background()
+
+	_, lock, err := s.Acquire(ctx, "owner1", "lock1", time.Minute)
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	if _, _, err := s.Renew(ctx, "owner1", "lock1", lock.FencingToken, time.Minute); err != nil {
+		t.Fatalf("Renew failed: %v", err)
+	}
+	if _, _, err := s.Bump(ctx, "lock1", "owner1", lock.FencingToken, time.Minute); err != nil {
+		t.Fatalf("Bump failed: %v", err)
+	}
+	if _, _, err := s.RenewHandoff(ctx, "owner1", "lock1", lock.FencingToken, time.Minute, "owner2"); err != nil {
+		t.Fatalf("RenewHandoff failed: %v", err)
+	}
+
+	_, released, err := s.Acquire(ctx, "owner3", "lock2", time.Minute)
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	if _, err := s.Release(ctx, "lock2", "owner3", released.FencingToken); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+
+	cmds, err := log.ReadAll()
+	if err != nil {
+		t.Fatalf("ReadAll failed: %v", err)
+	}
+	recovered := NewServer()
+	if err := recovered.Replay(cmds); err != nil {
+		t.Fatalf("Replay failed: %v", err)
+	}
+
+	want, _ := s.DumpState()
+	got, _ := recovered.DumpState()
+	if string(got) != string(want) {
+		t.Errorf("Expected replayed state:\n%s\ngot:\n%s", want, got)
+	}
+
+	// The released lock's token survives the restart
+	_, lock2, err := recovered.Acquire(ctx, "owner4", "lock2", time.Minute)
+	if err != nil {
+		t.Fatalf("Acquire after replay failed: %v", err)
+	}
+	if lock2.FencingToken <= released.FencingToken {
+		t.Errorf("Expected fencing token > %d after replay, got %d", released.FencingToken, lock2.FencingToken)
+	}
+}
+
+func TestReplayKeepsValue(t *testing.T) {
+	log := wal.NewWALWithStorage(wal.NewMemoryStorage())
+	s := NewServer(WithCommitLog(log), WithRenewHandoff())
+	ctx := context.Background()
+
+	_, lock, err := s.AcquireWithValue(ctx, "owner1", "leader", time.Minute, "10.0.0.7:7000")
+	if err != nil {
+		t.Fatalf("AcquireWithValue failed: %v", err)
+	}
+	if _, _, err := s.Bump(ctx, "leader", "owner1", lock.FencingToken, time.Minute); err != nil {
+		t.Fatalf("Bump failed: %v", err)
+	}
+	if _, _, err := s.RenewHandoff(ctx, "owner1", "leader", lock.FencingToken, time.Minute, "owner2"); err != nil {
+		t.Fatalf("RenewHandoff failed: %v", err)
+	}
+
+	cmds, err := log.ReadAll()
+	if err != nil {
+		t.Fatalf("ReadAll failed: %v", err)
+	}
+	recovered := NewServer()
+	if err := recovered.Replay(cmds); err != nil {
+		t.Fatalf("Replay failed: %v", err)
+	}
+	if info, ok := recovered.LockInfo("leader"); !ok || info.Value != "10.0.0.7:7000" || info.OwnerID != "owner2" {
+		t.Errorf("Expected owner2's hold with value %q, got %+v (ok=%v)", "10.0.0.7:7000", info, ok)
+	}
+}
+
+func TestReplayUnknownCommand(t *testing.T) {
+	s := NewServer()
+	if err := s.Replay([]command.Command{{Type: 99, LockID: "lock1"}}); err == nil {
+		t.Error("Expected replay to reject an unknown command type")
+	}
+}
+
+func TestReplaySameTimestampKeepsLogOrder(t *testing.T) {
+	clock := &manualClock{}
+	clock.now.Store(1_000_000)
+	ts := clock.NowMillis()
+
+	// Everything committed in the same millisecond; only the log order tells them apart
+	log := wal.NewWALWithStorage(wal.NewMemoryStorage())
+	for _, cmd := range []command.Command{
+		{Type: command.CmdAcquire, LockID: "lock1", OwnerID: "owner1", FencingToken: 1, CommitTimeMillis: ts, TTLMillis: 1000},
+		{Type: command.CmdRelease, LockID: "lock1", OwnerID: "owner1", FencingToken: 1, CommitTimeMillis: ts},
+		{Type: command.CmdAcquire, LockID: "lock2", OwnerID: "owner1", FencingToken: 1, CommitTimeMillis: ts, TTLMillis: 1000},
+		{Type: command.CmdRelease, LockID: "lock2", OwnerID: "owner1", FencingToken: 1, CommitTimeMillis: ts},
+		{Type: command.CmdAcquire, LockID: "lock2", OwnerID: "owner2", FencingToken: 2, CommitTimeMillis: ts, TTLMillis: 1000},
+	} {
+		if err := log.Append(cmd); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+	cmds, err := log.ReadAll()
+	if err != nil {
+		t.Fatalf("ReadAll failed: %v", err)
+	}
+
+	s := NewServer(WithClock(clock))
+	if err := s.Replay(cmds); err != nil {
+		t.Fatalf("Replay failed: %v", err)
+	}
+	if info, ok := s.LockInfo("lock1"); ok {
+		t.Errorf("Expected lock1 to end released, got %+v", info)
+	}
+	if info, ok := s.LockInfo("lock2"); !ok || info.OwnerID != "owner2" || info.FencingToken != 2 {
+		t.Errorf("Expected lock2 held by owner2 with token 2, got %+v (ok=%v)", info, ok)
+	}
+}
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/mrdhat/clutchdb/clutcherrors"
+	"github.com/mrdhat/clutchdb/command"
+	"github.com/mrdhat/clutchdb/protocol"
+	"github.com/mrdhat/clutchdb/wal"
+)
+
+func TestReplicationReconstructsState(t *testing.T) {
+	replicator, err := wal.NewReplicator(wal.NewWALWithStorage(wal.NewMemoryStorage()), 64)
+	if err != nil {
+		t.Fatalf("NewReplicator failed: %v", err)
+	}
+	leader := NewServer(WithCommitLog(replicator))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Some history exists before the follower connects
+	_, lock1, err := leader.Acquire(ctx, "owner1", "lock1", time.Minute)
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+
+	pr, pw := io.Pipe()
+	defer pr.Close()
+	go replicator.Stream(ctx, pw, 0)
+
+	if _, _, err := leader.Acquire(ctx, "owner2", "lock2", time.Minute); err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	if _, _, err := leader.Bump(ctx, "lock1", "owner1", lock1.FencingToken, time.Minute); err != nil {
+		t.Fatalf("Bump failed: %v", err)
+	}
+	_, lock3, err := leader.Acquire(ctx, "owner3", "lock3", time.Minute)
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	if _, err := leader.Release(ctx, "lock3", "owner3", lock3.FencingToken); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+
+	var cmds []command.Command
+	for len(cmds) < 5 {
+		cmd, err := wal.ReadRecord(pr)
+		if err != nil {
+			t.Fatalf("ReadRecord failed: %v", err)
+		}
+		cmds = append(cmds, cmd)
+	}
+
+	follower := NewServer()
+	if err := follower.Replay(cmds); err != nil {
+		t.Fatalf("Replay failed: %v", err)
+	}
+	want, _ := leader.DumpState()
+	got, _ := follower.DumpState()
+	if string(got) != string(want) {
+		t.Errorf("Expected follower state:\n%s\ngot:\n%s", want, got)
+	}
+}
+
+func TestApplyStream(t *testing.T) {
+	log := wal.NewWALWithStorage(wal.NewMemoryStorage())
+	leader := NewServer(WithCommitLog(log), WithRenewHandoff())
+	ctx := context.Background()
+
+	_, lock1, err := leader.Acquire(ctx, "owner1", "lock1", time.Minute)
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	if _, _, err := leader.Renew(ctx, "owner1", "lock1", lock1.FencingToken, time.Minute); err != nil {
+		t.Fatalf("Renew failed: %v", err)
+	}
+	_, lock2, err := leader.Acquire(ctx, "owner2", "lock2", time.Minute)
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	if _, _, err := leader.RenewHandoff(ctx, "owner2", "lock2", lock2.FencingToken, time.Minute, "owner3"); err != nil {
+		t.Fatalf("RenewHandoff failed: %v", err)
+	}
+	_, lock3, err := leader.Acquire(ctx, "owner1", "lock3", time.Minute)
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	if _, err := leader.Release(ctx, "lock3", "owner1", lock3.FencingToken); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+
+	// Record the stream a replicator would send
+	cmds, err := log.ReadAll()
+	if err != nil {
+		t.Fatalf("ReadAll failed: %v", err)
+	}
+	var stream bytes.Buffer
+	for _, cmd := range cmds {
+		stream.Write(wal.EncodeRecord(cmd))
+	}
+
+	follower := NewServer()
+	if err := follower.ApplyStream(ctx, &stream); err != nil {
+		t.Fatalf("ApplyStream failed: %v", err)
+	}
+	if follower.AppliedOffset() != uint64(len(cmds)) {
+		t.Errorf("Expected applied offset %d, got %d", len(cmds), follower.AppliedOffset())
+	}
+
+	want, _ := leader.DumpState()
+	got, _ := follower.DumpState()
+	if string(got) != string(want) {
+		t.Errorf("Expected follower state:\n%s\ngot:\n%s", want, got)
+	}
+	for _, owner := range []string{"owner1", "owner2", "owner3"} {
+		if got, want := ownerCount(follower, owner), ownerCount(leader, owner); got != want {
+			t.Errorf("Expected %s to hold %d locks on the follower, got %d", owner, want, got)
+		}
+	}
+
+	// A follower does not take writes
+	resp := follower.Dispatch(ctx, newRequest(protocol.ACQUIRE, "lock4", "owner1", 1000, 0))
+	if resp.Status != clutcherrors.STATUS_NOT_LEADER {
+		t.Errorf("Expected status %d, got %d", clutcherrors.STATUS_NOT_LEADER, resp.Status)
+	}
+	if status, _, _ := follower.Acquire(ctx, "owner1", "lock4", time.Second); status != clutcherrors.STATUS_NOT_LEADER {
+		t.Errorf("Expected in-process acquire to return status %d, got %d", clutcherrors.STATUS_NOT_LEADER, status)
+	}
+}
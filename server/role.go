@@ -0,0 +1,68 @@
+package server
+
+// Role is whether a server accepts writes
+type Role int32
+
+const (
+	RoleLeader   Role = iota // Accepts writes
+	RoleFollower             // Rejects writes with STATUS_NOT_LEADER; state only changes by replication
+)
+
+func (r Role) String() string {
+	switch r {
+	case RoleLeader:
+		return "leader"
+	case RoleFollower:
+		return "follower"
+	default:
+		return "unknown"
+	}
+}
+
+// WithRole sets the role the server starts in. Servers start as leader by default.
+func WithRole(role Role) Option {
+	return func(s *Server) {
+		s.role.Store(int32(role))
+	}
+}
+
+// Role returns the server's current role
+func (s *Server) Role() Role {
+	return Role(s.role.Load())
+}
+
+// PromoteToLeader makes the server accept writes. Fencing tokens carry on from the highest
+// one it has seen, so a follower that applied the old leader's stream never reissues a token.
+func (s *Server) PromoteToLeader() {
+	s.setRole(RoleLeader)
+}
+
+// StepDown makes the server reject writes with STATUS_NOT_LEADER. Reads still work.
+func (s *Server) StepDown() {
+	s.setRole(RoleFollower)
+}
+
+func (s *Server) setRole(role Role) {
+	if prev := Role(s.role.Swap(int32(role))); prev != role {
+		s.logger.Info("role changed", "from", prev, "to", role)
+	}
+}
+
+// SetLeaderHint records the address of the current leader. Followers return it with
+// STATUS_NOT_LEADER so clients can redirect; an empty addr means the leader is unknown.
+func (s *Server) SetLeaderHint(addr string) {
+	s.leaderHint.Store(&addr)
+}
+
+// LeaderHint returns the address set by SetLeaderHint, or "" if none
+func (s *Server) LeaderHint() string {
+	if addr := s.leaderHint.Load(); addr != nil {
+		return *addr
+	}
+	return ""
+}
+
+// isFollower reports whether writes must be refused
+func (s *Server) isFollower() bool {
+	return s.Role() == RoleFollower
+}
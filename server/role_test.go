@@ -0,0 +1,80 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mrdhat/clutchdb/clutcherrors"
+	"github.com/mrdhat/clutchdb/protocol"
+)
+
+func TestFollowerRejectsWrites(t *testing.T) {
+	s := NewServer()
+	ctx := context.Background()
+
+	_, lock, err := s.Acquire(ctx, "owner1", "lock1", time.Minute)
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+
+	s.StepDown()
+	if s.Role() != RoleFollower {
+		t.Fatalf("Expected role %v, got %v", RoleFollower, s.Role())
+	}
+
+	if status, _, _ := s.Acquire(ctx, "owner2", "lock2", time.Minute); status != clutcherrors.STATUS_NOT_LEADER {
+		t.Errorf("Expected acquire status %d, got %d", clutcherrors.STATUS_NOT_LEADER, status)
+	}
+	if status, _, _ := s.Renew(ctx, "owner1", "lock1", lock.FencingToken, time.Minute); status != clutcherrors.STATUS_NOT_LEADER {
+		t.Errorf("Expected renew status %d, got %d", clutcherrors.STATUS_NOT_LEADER, status)
+	}
+	if status, _ := s.Release(ctx, "lock1", "owner1", lock.FencingToken); status != clutcherrors.STATUS_NOT_LEADER {
+		t.Errorf("Expected release status %d, got %d", clutcherrors.STATUS_NOT_LEADER, status)
+	}
+	resp := s.Dispatch(ctx, newRequest(protocol.BUMP, "lock1", "owner1", 1000, lock.FencingToken))
+	if resp.Status != clutcherrors.STATUS_NOT_LEADER {
+		t.Errorf("Expected bump status %d, got %d", clutcherrors.STATUS_NOT_LEADER, resp.Status)
+	}
+
+	// Reads still work, and the rejected writes changed nothing
+	info, ok := s.LockInfo("lock1")
+	if !ok || info.OwnerID != "owner1" || info.FencingToken != lock.FencingToken {
+		t.Errorf("Expected lock1 still held by owner1, got %+v (ok=%v)", info, ok)
+	}
+	if _, ok := s.LockInfo("lock2"); ok {
+		t.Error("Expected rejected acquire not to take lock2")
+	}
+
+	s.PromoteToLeader()
+	_, lock2, err := s.Acquire(ctx, "owner2", "lock2", time.Minute)
+	if err != nil {
+		t.Fatalf("Acquire after promotion failed: %v", err)
+	}
+	if lock2.OwnerID != "owner2" {
+		t.Errorf("Expected owner2 to hold lock2, got %s", lock2.OwnerID)
+	}
+}
+
+func TestWithRole(t *testing.T) {
+	s := NewServer(WithRole(RoleFollower))
+	if status, _, _ := s.Acquire(context.Background(), "owner1", "lock1", time.Minute); status != clutcherrors.STATUS_NOT_LEADER {
+		t.Errorf("Expected status %d, got %d", clutcherrors.STATUS_NOT_LEADER, status)
+	}
+}
+
+func TestNotLeaderCarriesLeaderHint(t *testing.T) {
+	s := NewServer(WithRole(RoleFollower))
+	ctx := context.Background()
+
+	resp := s.Dispatch(ctx, newRequest(protocol.ACQUIRE, "lock1", "owner1", 1000, 0))
+	if resp.Status != clutcherrors.STATUS_NOT_LEADER || resp.LeaderHint != "" {
+		t.Errorf("Expected NOT_LEADER without a hint, got %+v", resp)
+	}
+
+	s.SetLeaderHint("10.0.0.7:7000")
+	resp = s.Dispatch(ctx, newRequest(protocol.ACQUIRE, "lock1", "owner1", 1000, 0))
+	if resp.Status != clutcherrors.STATUS_NOT_LEADER || resp.LeaderHint != "10.0.0.7:7000" {
+		t.Errorf("Expected NOT_LEADER pointing at 10.0.0.7:7000, got %+v", resp)
+	}
+}
@@ -0,0 +1,343 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/mrdhat/clutchdb/clutcherrors"
+	"github.com/mrdhat/clutchdb/protocol"
+)
+
+// refuseTimeout bounds how long Serve spends telling a connection over the limit it is refused
+const refuseTimeout = 100 * time.Millisecond
+
+// Serve accepts connections on ln and serves each on its own goroutine until ctx is done
+// or ln fails. Closing ln makes Serve return. See WithMaxConnections for limiting how many
+// connections are open at once.
+func (s *Server) Serve(ctx context.Context, ln net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+
+	for {
+		reserved := false
+		if s.connSlots != nil && s.waitForConnSlot {
+			select {
+			case s.connSlots <- struct{}{}:
+				reserved = true
+			case <-ctx.Done():
+				return nil
+			}
+		}
+
+		conn, err := ln.Accept()
+		if err != nil {
+			if reserved {
+				<-s.connSlots
+			}
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		if s.connSlots != nil && !reserved {
+			select {
+			case s.connSlots <- struct{}{}:
+			default:
+				s.refuseConn(conn)
+				continue
+			}
+		}
+		go func() {
+			if s.connSlots != nil {
+				defer func() { <-s.connSlots }()
+			}
+			s.ServeConn(ctx, conn)
+		}()
+	}
+}
+
+// refuseConn answers a connection over WithMaxConnections with STATUS_OVERLOADED and closes it.
+// The write is bounded so a client that doesn't read can't stall the accept loop.
+func (s *Server) refuseConn(conn net.Conn) {
+	defer conn.Close()
+	s.logger.Warn("refusing connection, too many open", "remote", conn.RemoteAddr(), "max", s.maxConnections)
+	conn.SetWriteDeadline(time.Now().Add(refuseTimeout))
+	protocol.WriteResponse(conn, s.failure(clutcherrors.STATUS_OVERLOADED, errors.New("too many connections")))
+}
+
+// ServeConn answers requests on conn until the client disconnects or sends a frame that can't
+// be decoded, then closes conn. Requests are answered in order unless the client negotiated
+// protocol version 2 and the server allows pipelining; see WithPipelineDepth.
+func (s *Server) ServeConn(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	var (
+		req protocol.Request
+		buf [protocol.RequestFrameSize]byte
+	)
+
+	version, ok := s.handshake(conn, r, w)
+	if !ok {
+		return
+	}
+	echo := version >= 2
+	pipelined := echo && s.pipelineDepth > 1
+
+	var (
+		writeMu  sync.Mutex // serializes frames on w while pipelined requests run
+		inFlight sync.WaitGroup
+		slots    = make(chan struct{}, max(s.pipelineDepth, 1))
+	)
+	defer inFlight.Wait()
+
+	respond := func(req *protocol.Request) error {
+		resp := s.Dispatch(ctx, req)
+		if echo {
+			resp.RequestID = req.RequestID
+		}
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		if err := protocol.WriteResponse(w, resp); err != nil {
+			return err
+		}
+		return w.Flush()
+	}
+
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+
+		// The command byte follows the length prefix and decides the frame layout
+		header, err := r.Peek(5)
+		if err != nil {
+			return
+		}
+
+		if handled, ok := s.serveOwnFrame(ctx, conn, r, w, &inFlight, header[4], echo); handled {
+			if !ok {
+				return
+			}
+			continue
+		}
+
+		if err := protocol.ReadRequestFrom(r, &req, &buf); err != nil {
+			inFlight.Wait()
+			s.rejectFrame(conn, w, err)
+			return
+		}
+		if !pipelined {
+			if err := respond(&req); err != nil {
+				return
+			}
+			continue
+		}
+
+		slots <- struct{}{}
+		inFlight.Add(1)
+		go func(req protocol.Request) {
+			defer inFlight.Done()
+			defer func() { <-slots }()
+			if err := respond(&req); err != nil {
+				// Unblocks the reader so the connection winds down
+				conn.Close()
+			}
+		}(req)
+	}
+}
+
+// serveOwnFrame answers a request whose command has a frame layout of its own, such as BATCH or
+// RENEW_ALL. handled is false, with nothing read, for a request in the standard frame; ok is
+// false if the connection can't carry on.
+func (s *Server) serveOwnFrame(ctx context.Context, conn net.Conn, r *bufio.Reader, w *bufio.Writer, inFlight *sync.WaitGroup, cmd uint8, echo bool) (handled bool, ok bool) {
+	switch cmd {
+	case protocol.BATCH:
+		var (
+			reqs  []*protocol.Request
+			resps []*protocol.Response
+		)
+		return true, s.serveFrame(conn, w, inFlight,
+			func() (err error) { reqs, err = protocol.ReadBatchRequest(r); return err },
+			func() {
+				resps = s.DispatchBatch(ctx, reqs)
+				if echo {
+					for i := range resps {
+						resps[i].RequestID = reqs[i].RequestID
+					}
+				}
+			},
+			func() error { return protocol.WriteResponseList(w, resps) })
+
+	case protocol.RENEW_ALL:
+		var (
+			req     *protocol.RenewAllRequest
+			status  clutcherrors.StatusCode
+			results []protocol.RenewResult
+		)
+		return true, s.serveFrame(conn, w, inFlight,
+			func() (err error) { req, err = protocol.ReadRenewAllRequest(r); return err },
+			func() { status, results = s.DispatchRenewAll(ctx, req) },
+			func() error { return protocol.WriteRenewAllResponse(w, status, results) })
+
+	case protocol.GET_LOCK_INFO_MULTI:
+		var (
+			req    *protocol.LockInfoMultiRequest
+			status clutcherrors.StatusCode
+			infos  []protocol.LockInfo
+		)
+		return true, s.serveFrame(conn, w, inFlight,
+			func() (err error) { req, err = protocol.ReadLockInfoMultiRequest(r); return err },
+			func() { status, infos = s.DispatchLockInfoMulti(ctx, req) },
+			func() error { return protocol.WriteLockInfoMultiResponse(w, status, infos) })
+
+	case protocol.SERVER_INFO:
+		var (
+			req    *protocol.ReportRequest
+			status clutcherrors.StatusCode
+			limits = &protocol.ServerLimits{} // sent zeroed if middleware refuses the request
+		)
+		return true, s.serveFrame(conn, w, inFlight,
+			func() (err error) { req, err = protocol.ReadReportRequest(r, cmd); return err },
+			func() {
+				status = s.dispatchReport(ctx, req, func(context.Context) func() {
+					found := s.Limits()
+					return func() { limits = found }
+				})
+			},
+			func() error { return protocol.WriteServerInfoResponse(w, status, limits) })
+
+	case protocol.LIST_OWNERS:
+		var (
+			req    *protocol.ReportRequest
+			status clutcherrors.StatusCode
+			owners []protocol.OwnerSummary
+		)
+		return true, s.serveFrame(conn, w, inFlight,
+			func() (err error) { req, err = protocol.ReadReportRequest(r, cmd); return err },
+			func() {
+				status = s.dispatchReport(ctx, req, func(ctx context.Context) func() {
+					found := s.ListOwners(ctx)
+					return func() { owners = found }
+				})
+			},
+			func() error { return protocol.WriteListOwnersResponse(w, status, owners) })
+
+	case protocol.TOMBSTONES:
+		var (
+			req        *protocol.ReportRequest
+			status     clutcherrors.StatusCode
+			tombstones []protocol.Tombstone
+		)
+		return true, s.serveFrame(conn, w, inFlight,
+			func() (err error) { req, err = protocol.ReadReportRequest(r, cmd); return err },
+			func() { status, tombstones = s.dispatchTombstones(ctx, req) },
+			func() error { return protocol.WriteTombstonesResponse(w, status, tombstones) })
+
+	case protocol.SERVER_STATS:
+		var (
+			req    *protocol.ReportRequest
+			status clutcherrors.StatusCode
+			stats  = &protocol.ServerStats{} // sent zeroed if middleware refuses the request
+		)
+		return true, s.serveFrame(conn, w, inFlight,
+			func() (err error) { req, err = protocol.ReadReportRequest(r, cmd); return err },
+			func() {
+				status = s.dispatchReport(ctx, req, func(context.Context) func() {
+					found := s.Stats()
+					return func() { stats = found }
+				})
+			},
+			func() error { return protocol.WriteServerStatsResponse(w, status, stats) })
+
+	case protocol.RELEASE_BY_PREFIX, protocol.COUNT_BY_PREFIX:
+		var (
+			req    *protocol.PrefixRequest
+			status clutcherrors.StatusCode
+			count  uint32
+		)
+		return true, s.serveFrame(conn, w, inFlight,
+			func() (err error) { req, err = protocol.ReadPrefixRequest(r); return err },
+			func() { status, count = s.DispatchPrefix(ctx, req) },
+			func() error { return protocol.WritePrefixResponse(w, status, count) })
+
+	default:
+		return false, true
+	}
+}
+
+// serveFrame answers one request in its own frame layout: read decodes it, handle executes it
+// and write encodes the response. The response can't be told apart from single responses around
+// it, so it waits for every pipelined request to be answered first. It reports whether the
+// connection can carry on.
+func (s *Server) serveFrame(conn net.Conn, w *bufio.Writer, inFlight *sync.WaitGroup, read func() error, handle func(), write func() error) bool {
+	if err := read(); err != nil {
+		inFlight.Wait()
+		s.rejectFrame(conn, w, err)
+		return false
+	}
+	inFlight.Wait()
+	handle()
+	if err := write(); err != nil {
+		return false
+	}
+	return w.Flush() == nil
+}
+
+// handshake answers a HELLO if it is the connection's first frame. Clients that skip it speak
+// version 1. It returns the connection's protocol version and reports whether the connection
+// should carry on serving requests.
+func (s *Server) handshake(conn net.Conn, r *bufio.Reader, w *bufio.Writer) (uint16, bool) {
+	header, err := r.Peek(5)
+	if err != nil {
+		return 0, false
+	}
+	if header[4] != protocol.HELLO {
+		if s.minVersion > 1 {
+			s.logger.Warn("rejecting client without version negotiation", "remote", conn.RemoteAddr())
+			protocol.WriteResponse(w, &protocol.Response{Status: clutcherrors.STATUS_UNSUPPORTED_VERSION})
+			w.Flush()
+			return 0, false
+		}
+		return 1, true
+	}
+
+	offered, err := protocol.ReadHello(r)
+	if err != nil {
+		s.rejectFrame(conn, w, err)
+		return 0, false
+	}
+	version, ok := protocol.NegotiateVersion(offered, s.minVersion, s.maxVersion)
+	if !ok {
+		s.logger.Warn("no common protocol version", "remote", conn.RemoteAddr(), "offered", offered)
+		protocol.WriteHelloResponse(w, clutcherrors.STATUS_UNSUPPORTED_VERSION, 0)
+		w.Flush()
+		return 0, false
+	}
+	s.logger.Debug("negotiated protocol version", "remote", conn.RemoteAddr(), "version", version)
+	if err := protocol.WriteHelloResponse(w, clutcherrors.STATUS_SUCCESS, version); err != nil {
+		return 0, false
+	}
+	return version, w.Flush() == nil
+}
+
+// rejectFrame answers an undecodable frame with STATUS_INVALID_REQUEST. Framing can't be
+// trusted after that, so the caller closes the connection.
+func (s *Server) rejectFrame(conn net.Conn, w *bufio.Writer, err error) {
+	if errors.Is(err, protocol.ErrClosed) || errors.Is(err, protocol.ErrShortFrame) {
+		// The client went away, there is no one to answer
+		s.logger.Debug("connection closed mid-frame", "remote", conn.RemoteAddr(), "err", err)
+		return
+	}
+	s.logger.Warn("rejecting undecodable frame", "remote", conn.RemoteAddr(), "err", err)
+	protocol.WriteResponse(w, &protocol.Response{Status: clutcherrors.STATUS_INVALID_REQUEST})
+	w.Flush()
+}
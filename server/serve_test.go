@@ -0,0 +1,261 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/mrdhat/clutchdb/clutcherrors"
+	"github.com/mrdhat/clutchdb/protocol"
+)
+
+func TestServeConn(t *testing.T) {
+	s := NewServer()
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	go s.ServeConn(context.Background(), serverConn)
+
+	if err := protocol.WriteRequest(clientConn, newRequest(protocol.ACQUIRE, "lock1", "owner1", 1000, 0)); err != nil {
+		t.Fatalf("WriteRequest failed: %v", err)
+	}
+	resp, err := protocol.ReadResponse(clientConn)
+	if err != nil {
+		t.Fatalf("ReadResponse failed: %v", err)
+	}
+	if resp.Status != clutcherrors.STATUS_SUCCESS {
+		t.Fatalf("Expected status %d, got %d", clutcherrors.STATUS_SUCCESS, resp.Status)
+	}
+
+	batch := []*protocol.Request{
+		newRequest(protocol.RENEW, "lock1", "owner1", 1000, resp.FencingToken),
+		newRequest(protocol.ACQUIRE, "lock1", "owner2", 1000, 0),
+	}
+	if err := protocol.WriteBatchRequest(clientConn, batch); err != nil {
+		t.Fatalf("WriteBatchRequest failed: %v", err)
+	}
+	resps, err := protocol.ReadResponseList(clientConn)
+	if err != nil {
+		t.Fatalf("ReadResponseList failed: %v", err)
+	}
+	if len(resps) != 2 || resps[0].Status != clutcherrors.STATUS_SUCCESS || resps[1].Status != clutcherrors.STATUS_LOCK_HELD {
+		t.Errorf("Unexpected batch responses: %+v %+v", resps[0], resps[1])
+	}
+
+	// A frame with a bad length gets an error response, then the connection is closed
+	if _, err := clientConn.Write([]byte{0, 0, 0, 1, protocol.ACQUIRE}); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	resp, err = protocol.ReadResponse(clientConn)
+	if err != nil {
+		t.Fatalf("ReadResponse failed: %v", err)
+	}
+	if resp.Status != clutcherrors.STATUS_INVALID_REQUEST {
+		t.Errorf("Expected status %d, got %d", clutcherrors.STATUS_INVALID_REQUEST, resp.Status)
+	}
+	if _, err := protocol.ReadResponse(clientConn); err == nil {
+		t.Error("Expected connection to be closed after a bad frame")
+	}
+}
+
+func TestServeConnHandshake(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     []Option
+		offered  uint16
+		status   clutcherrors.StatusCode
+		version  uint16
+		serveReq bool
+	}{
+		{"matched", nil, protocol.MaxVersion, clutcherrors.STATUS_SUCCESS, protocol.MaxVersion, true},
+		{"downgraded", []Option{WithProtocolVersions(1, 2)}, 5, clutcherrors.STATUS_SUCCESS, 2, true},
+		{"unsupported", []Option{WithProtocolVersions(2, 3)}, 1, clutcherrors.STATUS_UNSUPPORTED_VERSION, 0, false},
+	}
+	for _, tt := range tests {
+		s := NewServer(tt.opts...)
+		clientConn, serverConn := net.Pipe()
+		go s.ServeConn(context.Background(), serverConn)
+
+		if err := protocol.WriteHello(clientConn, tt.offered); err != nil {
+			t.Fatalf("%s: WriteHello failed: %v", tt.name, err)
+		}
+		status, version, err := protocol.ReadHelloResponse(clientConn)
+		if err != nil {
+			t.Fatalf("%s: ReadHelloResponse failed: %v", tt.name, err)
+		}
+		if status != tt.status || version != tt.version {
+			t.Errorf("%s: expected status %d version %d, got status %d version %d", tt.name, tt.status, tt.version, status, version)
+		}
+
+		// Lock traffic follows a successful handshake; a failed one closes the connection
+		protocol.WriteRequest(clientConn, newRequest(protocol.ACQUIRE, "lock1", "owner1", 1000, 0))
+		resp, err := protocol.ReadResponse(clientConn)
+		if tt.serveReq && (err != nil || resp.Status != clutcherrors.STATUS_SUCCESS) {
+			t.Errorf("%s: expected acquire after handshake to succeed, got %+v, %v", tt.name, resp, err)
+		}
+		if !tt.serveReq && err == nil {
+			t.Errorf("%s: expected connection to be closed after a failed handshake", tt.name)
+		}
+		clientConn.Close()
+	}
+}
+
+func TestServeConnPipelined(t *testing.T) {
+	s := NewServer(WithPipelineDepth(4))
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	go s.ServeConn(context.Background(), serverConn)
+
+	if err := protocol.WriteHello(clientConn, 2); err != nil {
+		t.Fatalf("WriteHello failed: %v", err)
+	}
+	if _, version, err := protocol.ReadHelloResponse(clientConn); err != nil || version != 2 {
+		t.Fatalf("Expected version 2, got %d (%v)", version, err)
+	}
+
+	// Each request asks for a different token floor, so its grant identifies it
+	const n = 16
+	go func() {
+		for i := 0; i < n; i++ {
+			req := newRequest(protocol.ACQUIRE, fmt.Sprintf("lock%d", i), "owner1", 1000, uint64(i*10))
+			req.RequestID = [16]byte{byte(i + 1)}
+			if err := protocol.WriteRequest(clientConn, req); err != nil {
+				return
+			}
+		}
+	}()
+
+	seen := make(map[[16]byte]bool)
+	for i := 0; i < n; i++ {
+		resp, err := protocol.ReadResponse(clientConn)
+		if err != nil {
+			t.Fatalf("ReadResponse %d failed: %v", i, err)
+		}
+		if resp.Status != clutcherrors.STATUS_SUCCESS {
+			t.Fatalf("Expected status %d, got %d", clutcherrors.STATUS_SUCCESS, resp.Status)
+		}
+		if seen[resp.RequestID] {
+			t.Fatalf("Request %v answered twice", resp.RequestID)
+		}
+		seen[resp.RequestID] = true
+
+		req := int(resp.RequestID[0]) - 1
+		if want := uint64(req*10 + 1); resp.FencingToken != want {
+			t.Errorf("Response for request %d carries token %d, expected %d", req, resp.FencingToken, want)
+		}
+	}
+}
+
+func TestServeMaxConnections(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := NewServer(WithMaxConnections(2))
+	go s.Serve(ctx, ln)
+
+	acquire := func(conn net.Conn, lockID string) (*protocol.Response, error) {
+		if err := protocol.WriteRequest(conn, newRequest(protocol.ACQUIRE, lockID, "owner1", 1000, 0)); err != nil {
+			return nil, err
+		}
+		return protocol.ReadResponse(conn)
+	}
+
+	// A served request proves each connection holds a slot
+	var open []net.Conn
+	for i := 0; i < 2; i++ {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatalf("Dial failed: %v", err)
+		}
+		defer conn.Close()
+		if resp, err := acquire(conn, fmt.Sprintf("lock%d", i)); err != nil || resp.Status != clutcherrors.STATUS_SUCCESS {
+			t.Fatalf("Expected connection %d to be served, got %+v, %v", i, resp, err)
+		}
+		open = append(open, conn)
+	}
+
+	excess, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer excess.Close()
+	resp, err := protocol.ReadResponse(excess)
+	if err != nil {
+		t.Fatalf("ReadResponse failed: %v", err)
+	}
+	if resp.Status != clutcherrors.STATUS_OVERLOADED {
+		t.Errorf("Expected status %d for a connection over the limit, got %d", clutcherrors.STATUS_OVERLOADED, resp.Status)
+	}
+	if _, err := protocol.ReadResponse(excess); err == nil {
+		t.Error("Expected the refused connection to be closed")
+	}
+
+	// Closing a connection frees its slot
+	open[0].Close()
+	deadline := time.Now().Add(time.Second)
+	for {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatalf("Dial failed: %v", err)
+		}
+		resp, err := acquire(conn, "lock2")
+		conn.Close()
+		if err == nil && resp.Status == clutcherrors.STATUS_SUCCESS {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected a connection to be served after one closed, got %+v, %v", resp, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestServeWaitForConnections(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := NewServer(WithMaxConnections(1), WithWaitForConnections())
+	go s.Serve(ctx, ln)
+
+	first, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	if err := protocol.WriteRequest(first, newRequest(protocol.ACQUIRE, "lock1", "owner1", 1000, 0)); err != nil {
+		t.Fatalf("WriteRequest failed: %v", err)
+	}
+	if _, err := protocol.ReadResponse(first); err != nil {
+		t.Fatalf("ReadResponse failed: %v", err)
+	}
+
+	// The second connection sits in the backlog, its request unanswered, until the first closes
+	second, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer second.Close()
+	if err := protocol.WriteRequest(second, newRequest(protocol.ACQUIRE, "lock2", "owner1", 1000, 0)); err != nil {
+		t.Fatalf("WriteRequest failed: %v", err)
+	}
+	second.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if resp, err := protocol.ReadResponse(second); err == nil {
+		t.Fatalf("Expected the second connection to wait, got %+v", resp)
+	}
+
+	first.Close()
+	second.SetReadDeadline(time.Now().Add(time.Second))
+	resp, err := protocol.ReadResponse(second)
+	if err != nil {
+		t.Fatalf("ReadResponse failed: %v", err)
+	}
+	if resp.Status != clutcherrors.STATUS_SUCCESS {
+		t.Errorf("Expected status %d, got %d", clutcherrors.STATUS_SUCCESS, resp.Status)
+	}
+}
@@ -0,0 +1,328 @@
+package server
+
+import (
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mrdhat/clutchdb/protocol"
+	"github.com/mrdhat/clutchdb/wal"
+)
+
+// Server holds a lock table and everything needed to serve it. Servers share no state,
+// so several can run independently in one process.
+type Server struct {
+	activeLocks     sync.Map     // lockID -> *Lock
+	fencingTokens   sync.Map     // lockID -> *uint64, last token granted
+	ownerLockCounts sync.Map     // ownerID -> *int64
+	liveLockCount   int64        // number of granted, not yet released or observed-expired locks
+	waitQueues      sync.Map     // lockID -> *waitQueue
+	pendingCommits  int64        // commands currently appending or syncing
+	appliedOffset   uint64       // replicated records applied by ApplyStream
+	role            atomic.Int32 // Role
+	leaderHint      atomic.Pointer[string]
+	startedAt       uint64 // when NewServer ran, in Unix milliseconds
+	counters        commandCounters
+
+	tombstones *tombstoneLog // nil unless WithTombstones
+	events     *EventBus     // nil unless WithEventBus
+
+	handler    Handler // dispatch wrapped in middleware
+	middleware []Middleware
+
+	expiryCallbacksMu sync.Mutex
+	expiryCallbacks   map[holdKey][]func()
+
+	snapshotMu     sync.Mutex          // guards the fields below, which track what snapshots cover
+	logOffset      uint64              // records in the commit log, counting those replayed at startup
+	dirtyLocks     map[string]struct{} // locks changed since the last snapshot
+	lastSnapshotID uint64              // 0 until a snapshot is taken or restored
+
+	connSlots chan struct{} // one token per open connection accepted by Serve, nil if unlimited
+
+	reaperMu      sync.Mutex // serializes reaper passes and guards reaperPending
+	reaperPending []string   // lock IDs left to examine in the current round of passes
+	reaperPaused  atomic.Bool
+
+	resetMu sync.RWMutex // held for read by commands that change locks or counters, and for write by Reset
+
+	sweepMu      sync.Mutex // guards sweepPending
+	sweepPending []string   // lock IDs left to examine in the current round of quota sweeps
+
+	clock             Clock
+	commitLog         wal.WAL
+	logger            *slog.Logger
+	metrics           Metrics
+	rateLimiter       *RateLimiter
+	lockIDPolicy      *LockIDPolicy
+	maxLocksPerOwner  int
+	maxTotalLocks     int
+	expiryGrace       time.Duration
+	minTTL            time.Duration
+	maxTTL            time.Duration
+	allowRenewHandoff bool
+	strictValidation  bool
+	requireRequestID  bool
+	adminCommands     bool
+	simpleMode        bool
+	errorMessages     bool
+	fencedOutStatus   bool
+	selfHeldStatus    bool
+	invariantChecks   bool
+	idempotentAcquire bool
+	maxPendingCommits int
+	maxConnections    int
+	waitForConnSlot   bool
+	commandTimeout    time.Duration
+	pipelineDepth     int
+	reaperBatchSize   int
+	reaperMinInterval time.Duration
+	reaperMaxInterval time.Duration
+	waitAging         time.Duration
+	defaultTTL        time.Duration
+	lockDefaultTTLs   map[string]time.Duration
+	minVersion        uint16
+	maxVersion        uint16
+}
+
+// Option configures a Server
+type Option func(*Server)
+
+// NewServer returns a Server with an empty lock table
+func NewServer(opts ...Option) *Server {
+	s := &Server{
+		expiryCallbacks:   make(map[holdKey][]func()),
+		clock:             NewMonotonicClock(),
+		logger:            slog.New(slog.DiscardHandler),
+		reaperBatchSize:   1024,
+		reaperMinInterval: 10 * time.Millisecond,
+		reaperMaxInterval: time.Second,
+		waitAging:         time.Second,
+		minVersion:        protocol.MinVersion,
+		maxVersion:        protocol.MaxVersion,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.maxConnections > 0 {
+		s.connSlots = make(chan struct{}, s.maxConnections)
+	}
+	s.startedAt = s.clock.NowMillis()
+	s.handler = s.buildHandler()
+	return s
+}
+
+// WithClock sets the clock used to stamp and check lock expiry
+func WithClock(clock Clock) Option {
+	return func(s *Server) {
+		s.clock = clock
+	}
+}
+
+// WithCommitLog durably records every command to log before it is applied
+func WithCommitLog(log wal.WAL) Option {
+	return func(s *Server) {
+		s.commitLog = log
+	}
+}
+
+// WithLogger sets where the server reports connection and background errors
+func WithLogger(logger *slog.Logger) Option {
+	return func(s *Server) {
+		s.logger = logger
+	}
+}
+
+// WithRateLimiter limits how often each owner may issue commands
+func WithRateLimiter(limiter *RateLimiter) Option {
+	return func(s *Server) {
+		s.rateLimiter = limiter
+	}
+}
+
+// WithMaxLocksPerOwner caps how many locks a single owner may hold at once. 0 means unlimited.
+func WithMaxLocksPerOwner(n int) Option {
+	return func(s *Server) {
+		s.maxLocksPerOwner = n
+	}
+}
+
+// WithMaxTotalLocks caps how many live locks the server holds at once. 0 means unlimited.
+func WithMaxTotalLocks(n int) Option {
+	return func(s *Server) {
+		s.maxTotalLocks = n
+	}
+}
+
+// WithExpiryGrace keeps an expired lock from being re-acquired for d after it lapses,
+// giving a holder with a skewed clock time to notice it lost the lock
+func WithExpiryGrace(d time.Duration) Option {
+	return func(s *Server) {
+		s.expiryGrace = d
+	}
+}
+
+// WithTTLBounds makes the server reject acquires, renewals and bumps asking for a TTL shorter
+// than min or longer than max with STATUS_INVALID_REQUEST. 0 leaves that side unbounded, up to
+// what the protocol can carry. Clients can learn the bounds with SERVER_INFO.
+func WithTTLBounds(min time.Duration, max time.Duration) Option {
+	return func(s *Server) {
+		s.minTTL = min
+		s.maxTTL = max
+	}
+}
+
+// WithRenewHandoff lets RenewHandoff rebind a lock to a new owner. Off by default because
+// anyone holding the token can then move the lock.
+func WithRenewHandoff() Option {
+	return func(s *Server) {
+		s.allowRenewHandoff = true
+	}
+}
+
+// WithMaxPendingCommits caps how many commands may be waiting on the commit log at once.
+// Commands beyond it fail fast with STATUS_OVERLOADED instead of piling up behind a
+// stalled disk. 0 means unlimited.
+func WithMaxPendingCommits(n int) Option {
+	return func(s *Server) {
+		s.maxPendingCommits = n
+	}
+}
+
+// WithMaxConnections caps how many connections Serve has open at once, so clients can't exhaust
+// the server's file descriptors. Connections beyond it are answered with STATUS_OVERLOADED and
+// closed, unless WithWaitForConnections is set. 0 means unlimited.
+func WithMaxConnections(n int) Option {
+	return func(s *Server) {
+		s.maxConnections = n
+	}
+}
+
+// WithWaitForConnections makes Serve stop accepting while WithMaxConnections is reached, leaving
+// new connections in the listen backlog until an open one closes, instead of refusing them
+func WithWaitForConnections() Option {
+	return func(s *Server) {
+		s.waitForConnSlot = true
+	}
+}
+
+// WithCommandTimeout bounds how long Dispatch waits for a command, including its WAL append and
+// sync. A command that overruns is answered with STATUS_OVERLOADED rather than acknowledged late.
+// 0 means no timeout.
+func WithCommandTimeout(d time.Duration) Option {
+	return func(s *Server) {
+		s.commandTimeout = d
+	}
+}
+
+// WithPipelineDepth lets a connection that negotiated protocol version 2 have up to n requests
+// executing at once. Responses are written as requests finish and carry the request's ID, so
+// they may arrive out of order. 0 or 1 answers every connection in order.
+func WithPipelineDepth(n int) Option {
+	return func(s *Server) {
+		s.pipelineDepth = n
+	}
+}
+
+// WithReaperBatchSize caps how many locks one reaper pass examines. Passes resume after the
+// last lock ID examined, so the whole table is covered over successive passes. 0 means unlimited.
+func WithReaperBatchSize(n int) Option {
+	return func(s *Server) {
+		s.reaperBatchSize = n
+	}
+}
+
+// WithReaperInterval bounds the reaper's sleep between passes. It sleeps less while passes
+// find expired locks and backs off towards max while they don't.
+func WithReaperInterval(min time.Duration, max time.Duration) Option {
+	return func(s *Server) {
+		s.reaperMinInterval = min
+		s.reaperMaxInterval = max
+	}
+}
+
+// WithWaitAging sets how long a queued AcquireWait caller waits for each step its priority rises,
+// which bounds how long higher-priority arrivals can keep it waiting. The default is one second;
+// 0 turns aging off, leaving low-priority waiters to starve while higher ones keep arriving.
+func WithWaitAging(d time.Duration) Option {
+	return func(s *Server) {
+		s.waitAging = d
+	}
+}
+
+// WithStrictValidation makes Dispatch reject requests that set fields their command doesn't
+// use, such as a RELEASE with a TTL or a RENEW without one, instead of ignoring them. Acquires
+// without a TTL get the default instead on a lock with one; see WithDefaultTTL.
+func WithStrictValidation() Option {
+	return func(s *Server) {
+		s.strictValidation = true
+	}
+}
+
+// WithRequireRequestID makes Dispatch and RENEW_ALL reject requests whose RequestID is all zero
+// with STATUS_INVALID_REQUEST, so every operation in the log and the event stream can be told
+// apart. The bundled client always sends a random ID; this guards against clients that don't.
+func WithRequireRequestID() Option {
+	return func(s *Server) {
+		s.requireRequestID = true
+	}
+}
+
+// WithIdempotentAcquire makes an acquire by the owner already holding a live lock succeed, as a
+// renew: the hold's expiry is reset to the new ttl and its fencing token is kept. This lets a
+// client safely retry an acquire whose response it lost. It is not reentrancy: a single release
+// still frees the lock, however many times it was acquired.
+func WithIdempotentAcquire() Option {
+	return func(s *Server) {
+		s.idempotentAcquire = true
+	}
+}
+
+// WithSimpleMode turns off fencing for clients that only want mutual exclusion. Responses carry
+// a fencing token of 0 and renew, bump and release authenticate by owner alone, ignoring the
+// token they are given. This is unsafe whenever a holder can pause past its TTL, for example in
+// a GC pause: nothing stops the stale holder's writes once another owner has the lock. Tokens
+// are still issued internally, so switching back to fencing mode later is safe.
+func WithSimpleMode() Option {
+	return func(s *Server) {
+		s.simpleMode = true
+	}
+}
+
+// WithErrorMessages makes failed responses carry a short human-readable reason, such as
+// "owner mismatch", for debugging from packet captures. The status code stays authoritative.
+func WithErrorMessages() Option {
+	return func(s *Server) {
+		s.errorMessages = true
+	}
+}
+
+// WithFencedOutStatus makes renew, release and bump answer STATUS_FENCED_OUT instead of
+// STATUS_LOCK_NOT_HELD when the lock is live under a newer fencing token than the caller's, so a
+// holder whose lease lapsed can tell it was replaced from the lock merely being gone. The
+// response carries the newer hold's token and expiry. It has no effect in simple mode.
+func WithFencedOutStatus() Option {
+	return func(s *Server) {
+		s.fencedOutStatus = true
+	}
+}
+
+// WithSelfHeldStatus makes an acquire of a lock the requesting owner already holds answer
+// STATUS_HELD_BY_CALLER instead of STATUS_LOCK_HELD, with the hold's token and expiry, so a client
+// retrying an acquire whose response it lost can renew instead of waiting on itself. The Lock
+// returned with it is a copy of the hold as it was then, not the entry in the lock table. It has
+// no effect under WithIdempotentAcquire, which grants such acquires as renewals.
+func WithSelfHeldStatus() Option {
+	return func(s *Server) {
+		s.selfHeldStatus = true
+	}
+}
+
+// WithProtocolVersions sets the range of protocol versions the server agrees to in HELLO
+func WithProtocolVersions(min uint16, max uint16) Option {
+	return func(s *Server) {
+		s.minVersion = min
+		s.maxVersion = max
+	}
+}
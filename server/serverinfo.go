@@ -0,0 +1,42 @@
+package server
+
+import (
+	"math"
+
+	"github.com/mrdhat/clutchdb/protocol"
+)
+
+// Limits returns the limits the server enforces, as advertised by SERVER_INFO
+func (s *Server) Limits() *protocol.ServerLimits {
+	limits := &protocol.ServerLimits{
+		MinTTLMS:         protocol.DurationToMillis(s.minTTL),
+		MaxTTLMS:         protocol.MaxTTLMillis,
+		MaxLocksPerOwner: clampUint32(s.maxLocksPerOwner),
+		MaxTotalLocks:    clampUint32(s.maxTotalLocks),
+		MaxConnections:   clampUint32(s.maxConnections),
+		PipelineDepth:    clampUint32(max(s.pipelineDepth, 1)),
+	}
+	if s.maxTTL > 0 {
+		limits.MaxTTLMS = protocol.DurationToMillis(s.maxTTL)
+	}
+	return limits
+}
+
+// ttlInBounds reports whether a request may ask for a TTL of ttlMS under WithTTLBounds
+func (s *Server) ttlInBounds(ttlMS uint64) bool {
+	if s.minTTL > 0 && ttlMS < protocol.DurationToMillis(s.minTTL) {
+		return false
+	}
+	return s.maxTTL <= 0 || ttlMS <= protocol.DurationToMillis(s.maxTTL)
+}
+
+// clampUint32 converts a non-negative limit to its wire size, saturating rather than wrapping
+func clampUint32(n int) uint32 {
+	if n <= 0 {
+		return 0
+	}
+	if uint64(n) > math.MaxUint32 {
+		return math.MaxUint32
+	}
+	return uint32(n)
+}
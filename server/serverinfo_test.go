@@ -0,0 +1,57 @@
+package server
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/mrdhat/clutchdb/clutcherrors"
+	"github.com/mrdhat/clutchdb/protocol"
+)
+
+func TestServeConnServerInfo(t *testing.T) {
+	s := NewServer(WithTTLBounds(100*time.Millisecond, time.Minute), WithMaxLocksPerOwner(5), WithMaxConnections(8))
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	go s.ServeConn(context.Background(), serverConn)
+
+	if err := protocol.WriteServerInfoRequest(clientConn, [16]byte{1}); err != nil {
+		t.Fatalf("WriteServerInfoRequest failed: %v", err)
+	}
+	status, limits, err := protocol.ReadServerInfoResponse(clientConn)
+	if err != nil {
+		t.Fatalf("ReadServerInfoResponse failed: %v", err)
+	}
+	want := protocol.ServerLimits{MinTTLMS: 100, MaxTTLMS: 60_000, MaxLocksPerOwner: 5, MaxConnections: 8, PipelineDepth: 1}
+	if status != clutcherrors.STATUS_SUCCESS || *limits != want {
+		t.Errorf("Expected %+v, got status %d and %+v", want, status, limits)
+	}
+
+	// The advertised bounds are the ones enforced
+	for _, ttlMS := range []uint64{99, 60_001} {
+		if err := protocol.WriteRequest(clientConn, newRequest(protocol.ACQUIRE, "lock1", "owner1", ttlMS, 0)); err != nil {
+			t.Fatalf("WriteRequest failed: %v", err)
+		}
+		resp, err := protocol.ReadResponse(clientConn)
+		if err != nil {
+			t.Fatalf("ReadResponse failed: %v", err)
+		}
+		if resp.Status != clutcherrors.STATUS_INVALID_REQUEST {
+			t.Errorf("Expected status %d for a %dms TTL, got %d", clutcherrors.STATUS_INVALID_REQUEST, ttlMS, resp.Status)
+		}
+	}
+	if err := protocol.WriteRequest(clientConn, newRequest(protocol.ACQUIRE, "lock1", "owner1", 100, 0)); err != nil {
+		t.Fatalf("WriteRequest failed: %v", err)
+	}
+	if resp, err := protocol.ReadResponse(clientConn); err != nil || resp.Status != clutcherrors.STATUS_SUCCESS {
+		t.Errorf("Expected a TTL at the minimum to be granted, got %+v, %v", resp, err)
+	}
+}
+
+func TestLimitsUnbounded(t *testing.T) {
+	limits := NewServer().Limits()
+	if limits.MinTTLMS != 0 || limits.MaxTTLMS != protocol.MaxTTLMillis {
+		t.Errorf("Expected TTLs bounded only by the protocol, got %+v", limits)
+	}
+}
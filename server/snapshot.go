@@ -0,0 +1,386 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"sort"
+	"sync/atomic"
+
+	"github.com/mrdhat/clutchdb/command"
+)
+
+// Snapshot is a point-in-time copy of the lock table for bounding recovery time. A full snapshot
+// holds every live lock; a delta holds only the locks changed since the snapshot before it, so
+// it is cheap to take often. Recovery restores a full snapshot, the deltas taken after it in
+// order, then replays the commit log records after the last one's WALOffset.
+type Snapshot struct {
+	ID        uint64            // unique per server, increasing
+	BaseID    uint64            // snapshot this is a delta against, 0 for a full snapshot
+	WALOffset uint64            // commit log records reflected; recovery replays the ones after
+	Locks     []LockState       // live holds: all of them, or for a delta the changed ones
+	Freed     []string          // delta only: locks changed since BaseID that are now free
+	Counters  map[string]uint64 // fencing counters: all of them, or for a delta the changed ones
+}
+
+// ErrSnapshotCorrupt is returned when decoding a snapshot that fails its checksum or is malformed
+var ErrSnapshotCorrupt = errors.New("snapshot corrupt")
+
+// snapshotMagic opens every encoded snapshot
+const snapshotMagic = 0x434c534e // "CLSN"
+
+// snapshotVersion is the encoding version written by MarshalBinary. Version 1 snapshots, from
+// before holds had values, are still read.
+const snapshotVersion = 2
+
+// Snapshot takes a full snapshot of the lock table. Snapshots must be taken one at a time;
+// commands may run meanwhile, and any they commit after WALOffset is read are replayed from the
+// log on recovery.
+func (s *Server) Snapshot() *Snapshot {
+	s.snapshotMu.Lock()
+	s.lastSnapshotID++
+	snap := &Snapshot{ID: s.lastSnapshotID, WALOffset: s.logOffset, Counters: make(map[string]uint64)}
+	s.dirtyLocks = nil
+	s.snapshotMu.Unlock()
+
+	now := s.clock.NowMillis()
+	s.activeLocks.Range(func(key, value any) bool {
+		lock := value.(*Lock)
+		lock.mu.Lock()
+		if state, ok := liveHold(lock, now); ok {
+			snap.Locks = append(snap.Locks, state)
+		}
+		lock.mu.Unlock()
+		return true
+	})
+	s.fencingTokens.Range(func(key, value any) bool {
+		if counter := atomic.LoadUint64(value.(*uint64)); counter > 0 {
+			snap.Counters[key.(string)] = counter
+		}
+		return true
+	})
+
+	sort.Slice(snap.Locks, func(i, j int) bool { return snap.Locks[i].LockID < snap.Locks[j].LockID })
+	return snap
+}
+
+// DeltaSnapshot takes a snapshot of only the locks changed since the last snapshot, which it
+// names as its base. It fails if no snapshot has been taken or restored yet.
+func (s *Server) DeltaSnapshot() (*Snapshot, error) {
+	s.snapshotMu.Lock()
+	if s.lastSnapshotID == 0 {
+		s.snapshotMu.Unlock()
+		return nil, errors.New("no snapshot to take a delta against")
+	}
+	snap := &Snapshot{ID: s.lastSnapshotID + 1, BaseID: s.lastSnapshotID, WALOffset: s.logOffset, Counters: make(map[string]uint64)}
+	dirty := s.dirtyLocks
+	s.dirtyLocks = nil
+	s.lastSnapshotID = snap.ID
+	s.snapshotMu.Unlock()
+
+	now := s.clock.NowMillis()
+	for lockID := range dirty {
+		state, ok := s.holdState(lockID, now)
+		if ok {
+			snap.Locks = append(snap.Locks, state)
+		} else {
+			snap.Freed = append(snap.Freed, lockID)
+		}
+		if counter := s.FencingCounter(lockID); counter > 0 {
+			snap.Counters[lockID] = counter
+		}
+	}
+
+	sort.Slice(snap.Locks, func(i, j int) bool { return snap.Locks[i].LockID < snap.Locks[j].LockID })
+	sort.Strings(snap.Freed)
+	return snap, nil
+}
+
+// RestoreSnapshots rebuilds the lock table from a full snapshot, the deltas taken after it in
+// order, and tail: the commit log records after the last snapshot's WALOffset. Like Replay it is
+// meant for a fresh server at startup, and holds that have lapsed by now are dropped.
+func (s *Server) RestoreSnapshots(snaps []*Snapshot, tail []command.Command) error {
+	locks, err := s.loadSnapshots(snaps)
+	if err != nil {
+		return err
+	}
+	return s.replayOnto(locks, tail)
+}
+
+// loadSnapshots merges snaps into the holds they describe, restores their fencing counters and
+// positions the server at the last one's WALOffset
+func (s *Server) loadSnapshots(snaps []*Snapshot) (map[string]*LockState, error) {
+	if len(snaps) == 0 {
+		return nil, errors.New("no snapshots to restore")
+	}
+	if snaps[0].BaseID != 0 {
+		return nil, fmt.Errorf("snapshot %d is a delta, restore must start from a full snapshot", snaps[0].ID)
+	}
+
+	locks := make(map[string]*LockState)
+	for i, snap := range snaps {
+		if i > 0 && snap.BaseID != snaps[i-1].ID {
+			return nil, fmt.Errorf("snapshot %d is based on %d, not on the snapshot before it, %d", snap.ID, snap.BaseID, snaps[i-1].ID)
+		}
+		for _, state := range snap.Locks {
+			locks[state.LockID] = &state
+		}
+		for _, lockID := range snap.Freed {
+			delete(locks, lockID)
+		}
+		for lockID, counter := range snap.Counters {
+			s.raiseFencingToken(lockID, counter)
+		}
+	}
+
+	last := snaps[len(snaps)-1]
+	s.snapshotMu.Lock()
+	s.logOffset = last.WALOffset
+	s.lastSnapshotID = last.ID
+	s.dirtyLocks = nil
+	s.snapshotMu.Unlock()
+	return locks, nil
+}
+
+// noteCommitted records that a command changed lockID, for the next delta snapshot, and counts
+// its record when it went to the commit log
+func (s *Server) noteCommitted(lockID string, logged bool) {
+	s.snapshotMu.Lock()
+	defer s.snapshotMu.Unlock()
+	if logged {
+		s.logOffset++
+	}
+	if lockID != "" {
+		s.markDirtyLocked(lockID)
+	}
+}
+
+// markDirtyLocked adds lockID to the locks changed since the last snapshot. Must be called with
+// s.snapshotMu held.
+func (s *Server) markDirtyLocked(lockID string) {
+	if s.dirtyLocks == nil {
+		s.dirtyLocks = make(map[string]struct{})
+	}
+	s.dirtyLocks[lockID] = struct{}{}
+}
+
+// holdState returns the live hold on lockID, if any
+func (s *Server) holdState(lockID string, now uint64) (LockState, bool) {
+	lockIface, ok := s.activeLocks.Load(lockID)
+	if !ok {
+		return LockState{}, false
+	}
+	lock := lockIface.(*Lock)
+	lock.mu.Lock()
+	defer lock.mu.Unlock()
+	return liveHold(lock, now)
+}
+
+// lapsed reports whether a hold expiring at expiresAt has run out at now. A hold lasts up to but
+// not including its expiry, and every command and read decides expiry here so none of them can
+// disagree at that instant.
+func lapsed(expiresAt uint64, now uint64) bool {
+	return expiresAt <= now
+}
+
+// liveHold describes lock's hold if it is still live at now. Must be called with lock.mu held.
+// Every read path decides liveness here, so a hold that has lapsed reads as free even while its
+// entry waits for the reaper or the next command on the lock to clean it up.
+func liveHold(lock *Lock, now uint64) (LockState, bool) {
+	if lock.removed || lock.OwnerID == "" || lapsed(lock.ExpiresAt, now) {
+		return LockState{}, false
+	}
+	return LockState{
+		LockID:       lock.ID,
+		OwnerID:      lock.OwnerID,
+		FencingToken: lock.FencingToken,
+		ExpiresAt:    lock.ExpiresAt,
+		AcquiredAt:   lock.AcquiredAt,
+		Value:        lock.Value,
+	}, true
+}
+
+// MarshalBinary encodes snap behind a CRC32 of its contents.
+//
+//	| u32 magic | u8 version | u64 id | u64 base_id | u64 wal_offset |
+//	| u32 count | count × ( string lock_id | string owner_id | u64 fencing_token | u64 expires_at | u64 acquired_at | string value ) |
+//	| u32 count | count × string lock_id |
+//	| u32 count | count × ( string lock_id | u64 counter ) |
+//	| u32 crc32 |
+//
+// where each string is a u16 length followed by its bytes.
+func (snap *Snapshot) MarshalBinary() ([]byte, error) {
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.BigEndian, uint32(snapshotMagic))
+	buf.WriteByte(snapshotVersion)
+	binary.Write(buf, binary.BigEndian, snap.ID)
+	binary.Write(buf, binary.BigEndian, snap.BaseID)
+	binary.Write(buf, binary.BigEndian, snap.WALOffset)
+
+	binary.Write(buf, binary.BigEndian, uint32(len(snap.Locks)))
+	for _, state := range snap.Locks {
+		if err := writeSnapshotString(buf, state.LockID); err != nil {
+			return nil, err
+		}
+		if err := writeSnapshotString(buf, state.OwnerID); err != nil {
+			return nil, err
+		}
+		binary.Write(buf, binary.BigEndian, state.FencingToken)
+		binary.Write(buf, binary.BigEndian, state.ExpiresAt)
+		binary.Write(buf, binary.BigEndian, state.AcquiredAt)
+		if err := writeSnapshotString(buf, state.Value); err != nil {
+			return nil, err
+		}
+	}
+
+	binary.Write(buf, binary.BigEndian, uint32(len(snap.Freed)))
+	for _, lockID := range snap.Freed {
+		if err := writeSnapshotString(buf, lockID); err != nil {
+			return nil, err
+		}
+	}
+
+	lockIDs := make([]string, 0, len(snap.Counters))
+	for lockID := range snap.Counters {
+		lockIDs = append(lockIDs, lockID)
+	}
+	sort.Strings(lockIDs)
+	binary.Write(buf, binary.BigEndian, uint32(len(lockIDs)))
+	for _, lockID := range lockIDs {
+		if err := writeSnapshotString(buf, lockID); err != nil {
+			return nil, err
+		}
+		binary.Write(buf, binary.BigEndian, snap.Counters[lockID])
+	}
+
+	binary.Write(buf, binary.BigEndian, crc32.ChecksumIEEE(buf.Bytes()))
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary decodes a snapshot written by MarshalBinary, failing with ErrSnapshotCorrupt
+// if its checksum doesn't match
+func (snap *Snapshot) UnmarshalBinary(data []byte) error {
+	if len(data) < 4 {
+		return fmt.Errorf("%w: %d bytes", ErrSnapshotCorrupt, len(data))
+	}
+	body, sum := data[:len(data)-4], binary.BigEndian.Uint32(data[len(data)-4:])
+	if crc32.ChecksumIEEE(body) != sum {
+		return fmt.Errorf("%w: checksum mismatch", ErrSnapshotCorrupt)
+	}
+
+	r := bytes.NewReader(body)
+	var header struct {
+		Magic     uint32
+		Version   uint8
+		ID        uint64
+		BaseID    uint64
+		WALOffset uint64
+	}
+	if err := binary.Read(r, binary.BigEndian, &header); err != nil {
+		return fmt.Errorf("%w: header: %v", ErrSnapshotCorrupt, err)
+	}
+	if header.Magic != snapshotMagic {
+		return fmt.Errorf("%w: not a snapshot", ErrSnapshotCorrupt)
+	}
+	if header.Version < 1 || header.Version > snapshotVersion {
+		return fmt.Errorf("unsupported snapshot version %d", header.Version)
+	}
+	decoded := Snapshot{ID: header.ID, BaseID: header.BaseID, WALOffset: header.WALOffset, Counters: make(map[string]uint64)}
+
+	count, err := readSnapshotCount(r)
+	if err != nil {
+		return err
+	}
+	for i := uint32(0); i < count; i++ {
+		var state LockState
+		if state.LockID, err = readSnapshotString(r); err != nil {
+			return err
+		}
+		if state.OwnerID, err = readSnapshotString(r); err != nil {
+			return err
+		}
+		var fixed [3]uint64
+		if err := binary.Read(r, binary.BigEndian, &fixed); err != nil {
+			return fmt.Errorf("%w: lock %d: %v", ErrSnapshotCorrupt, i, err)
+		}
+		state.FencingToken, state.ExpiresAt, state.AcquiredAt = fixed[0], fixed[1], fixed[2]
+		if header.Version >= 2 {
+			if state.Value, err = readSnapshotString(r); err != nil {
+				return err
+			}
+		}
+		decoded.Locks = append(decoded.Locks, state)
+	}
+
+	if count, err = readSnapshotCount(r); err != nil {
+		return err
+	}
+	for i := uint32(0); i < count; i++ {
+		lockID, err := readSnapshotString(r)
+		if err != nil {
+			return err
+		}
+		decoded.Freed = append(decoded.Freed, lockID)
+	}
+
+	if count, err = readSnapshotCount(r); err != nil {
+		return err
+	}
+	for i := uint32(0); i < count; i++ {
+		lockID, err := readSnapshotString(r)
+		if err != nil {
+			return err
+		}
+		var counter uint64
+		if err := binary.Read(r, binary.BigEndian, &counter); err != nil {
+			return fmt.Errorf("%w: counter %d: %v", ErrSnapshotCorrupt, i, err)
+		}
+		decoded.Counters[lockID] = counter
+	}
+
+	if r.Len() != 0 {
+		return fmt.Errorf("%w: %d trailing bytes", ErrSnapshotCorrupt, r.Len())
+	}
+	*snap = decoded
+	return nil
+}
+
+// writeSnapshotString writes s as a u16 length followed by its bytes
+func writeSnapshotString(buf *bytes.Buffer, s string) error {
+	if len(s) > math.MaxUint16 {
+		return fmt.Errorf("string too long for snapshot: %d bytes", len(s))
+	}
+	binary.Write(buf, binary.BigEndian, uint16(len(s)))
+	buf.WriteString(s)
+	return nil
+}
+
+// readSnapshotString reads a string written by writeSnapshotString
+func readSnapshotString(r *bytes.Reader) (string, error) {
+	var length uint16
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return "", fmt.Errorf("%w: %v", ErrSnapshotCorrupt, err)
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return "", fmt.Errorf("%w: %v", ErrSnapshotCorrupt, err)
+	}
+	return string(data), nil
+}
+
+// readSnapshotCount reads a u32 list length, rejecting one the remaining bytes can't hold
+func readSnapshotCount(r *bytes.Reader) (uint32, error) {
+	var count uint32
+	if err := binary.Read(r, binary.BigEndian, &count); err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrSnapshotCorrupt, err)
+	}
+	// Every entry takes at least 2 bytes, so count can't be trusted beyond that
+	if int64(count)*2 > int64(r.Len()) {
+		return 0, fmt.Errorf("%w: %d entries in %d bytes", ErrSnapshotCorrupt, count, r.Len())
+	}
+	return count, nil
+}
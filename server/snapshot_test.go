@@ -0,0 +1,217 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/mrdhat/clutchdb/wal"
+)
+
+// roundTrip encodes and decodes snap, as if it went to disk and back
+func roundTrip(t *testing.T, snap *Snapshot) *Snapshot {
+	t.Helper()
+	data, err := snap.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary failed: %v", err)
+	}
+	decoded := &Snapshot{}
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatalf("UnmarshalBinary failed: %v", err)
+	}
+	return decoded
+}
+
+func TestDeltaSnapshotRestoreMatchesFull(t *testing.T) {
+	clock := &manualClock{}
+	clock.now.Store(1_000_000)
+	s := NewServer(WithClock(clock))
+	ctx := context.Background()
+
+	tokens := make(map[string]uint64)
+	for _, lockID := range []string{"lock1", "lock2", "lock3", "lock4"} {
+		_, lock, err := s.Acquire(ctx, "owner1", lockID, time.Second)
+		if err != nil {
+			t.Fatalf("Acquire failed: %v", err)
+		}
+		tokens[lockID] = lock.FencingToken
+	}
+	base := roundTrip(t, s.Snapshot())
+
+	// Change some locks and leave others alone
+	clock.advance(100 * time.Millisecond)
+	if _, err := s.Release(ctx, "lock1", "owner1", tokens["lock1"]); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+	if _, _, err := s.Renew(ctx, "owner1", "lock2", tokens["lock2"], 5*time.Second); err != nil {
+		t.Fatalf("Renew failed: %v", err)
+	}
+	if _, _, err := s.Bump(ctx, "lock3", "owner1", tokens["lock3"], time.Second); err != nil {
+		t.Fatalf("Bump failed: %v", err)
+	}
+	if _, _, err := s.Acquire(ctx, "owner2", "lock5", time.Second); err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	delta, err := s.DeltaSnapshot()
+	if err != nil {
+		t.Fatalf("DeltaSnapshot failed: %v", err)
+	}
+	delta = roundTrip(t, delta)
+	if delta.BaseID != base.ID {
+		t.Errorf("Expected delta based on %d, got %d", base.ID, delta.BaseID)
+	}
+	if len(delta.Locks) != 3 || !reflect.DeepEqual(delta.Freed, []string{"lock1"}) {
+		t.Errorf("Expected only the changed locks in the delta, got %+v freed %v", delta.Locks, delta.Freed)
+	}
+	full := roundTrip(t, s.Snapshot())
+
+	fromDelta := NewServer(WithClock(clock))
+	if err := fromDelta.RestoreSnapshots([]*Snapshot{base, delta}, nil); err != nil {
+		t.Fatalf("RestoreSnapshots failed: %v", err)
+	}
+	fromFull := NewServer(WithClock(clock))
+	if err := fromFull.RestoreSnapshots([]*Snapshot{full}, nil); err != nil {
+		t.Fatalf("RestoreSnapshots failed: %v", err)
+	}
+
+	want, _ := s.DumpState()
+	for name, restored := range map[string]*Server{"base+delta": fromDelta, "full": fromFull} {
+		got, _ := restored.DumpState()
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s: expected table\n%s\ngot\n%s", name, want, got)
+		}
+		for _, lockID := range []string{"lock1", "lock2", "lock3", "lock4", "lock5"} {
+			if restored.FencingCounter(lockID) != s.FencingCounter(lockID) {
+				t.Errorf("%s: expected %s counter %d, got %d", name, lockID, s.FencingCounter(lockID), restored.FencingCounter(lockID))
+			}
+		}
+	}
+}
+
+func TestRestoreSnapshotsReplaysTail(t *testing.T) {
+	clock := &manualClock{}
+	clock.now.Store(1_000_000)
+	log := wal.NewWALWithStorage(wal.NewMemoryStorage())
+	s := NewServer(WithClock(clock), WithCommitLog(log))
+	ctx := context.Background()
+
+	_, lock, err := s.Acquire(ctx, "owner1", "lock1", time.Second)
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	snap := s.Snapshot()
+	if snap.WALOffset != 1 {
+		t.Fatalf("Expected snapshot to cover 1 record, got %d", snap.WALOffset)
+	}
+
+	// After the snapshot: lock1 is released and lock2 acquired, only in the log
+	if _, err := s.Release(ctx, "lock1", "owner1", lock.FencingToken); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+	if _, _, err := s.Acquire(ctx, "owner2", "lock2", time.Second); err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	cmds, err := log.ReadAll()
+	if err != nil {
+		t.Fatalf("ReadAll failed: %v", err)
+	}
+
+	restored := NewServer(WithClock(clock))
+	if err := restored.RestoreSnapshots([]*Snapshot{snap}, cmds[snap.WALOffset:]); err != nil {
+		t.Fatalf("RestoreSnapshots failed: %v", err)
+	}
+	if _, ok := restored.LockInfo("lock1"); ok {
+		t.Error("Expected lock1 released by the log tail")
+	}
+	if info, ok := restored.LockInfo("lock2"); !ok || info.OwnerID != "owner2" {
+		t.Errorf("Expected lock2 held by owner2, got %+v (ok=%v)", info, ok)
+	}
+
+	// The chain carries on from the restored snapshot
+	delta, err := restored.DeltaSnapshot()
+	if err != nil {
+		t.Fatalf("DeltaSnapshot failed: %v", err)
+	}
+	if delta.BaseID != snap.ID || delta.WALOffset != 3 {
+		t.Errorf("Expected delta on %d at offset 3, got base %d offset %d", snap.ID, delta.BaseID, delta.WALOffset)
+	}
+}
+
+func TestSnapshotChecksum(t *testing.T) {
+	s := NewServer()
+	if _, _, err := s.Acquire(context.Background(), "owner1", "lock1", time.Second); err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	data, err := s.Snapshot().MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary failed: %v", err)
+	}
+
+	data[len(data)/2] ^= 0xff
+	if err := (&Snapshot{}).UnmarshalBinary(data); !errors.Is(err, ErrSnapshotCorrupt) {
+		t.Errorf("Expected ErrSnapshotCorrupt, got %v", err)
+	}
+}
+
+func TestSnapshotKeepsValue(t *testing.T) {
+	s := NewServer()
+	if _, _, err := s.AcquireWithValue(context.Background(), "owner1", "leader", time.Minute, "10.0.0.7:7000"); err != nil {
+		t.Fatalf("AcquireWithValue failed: %v", err)
+	}
+
+	restored := NewServer()
+	if err := restored.RestoreSnapshots([]*Snapshot{roundTrip(t, s.Snapshot())}, nil); err != nil {
+		t.Fatalf("RestoreSnapshots failed: %v", err)
+	}
+	if info, _ := restored.LockInfo("leader"); info.Value != "10.0.0.7:7000" {
+		t.Errorf("Expected value %q, got %q", "10.0.0.7:7000", info.Value)
+	}
+}
+
+func TestSnapshotReadsVersion1(t *testing.T) {
+	// A snapshot from before holds had values, with one lock, no freed locks and no counters
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, uint32(snapshotMagic))
+	buf.WriteByte(1)
+	binary.Write(&buf, binary.BigEndian, [3]uint64{7, 0, 1})
+	binary.Write(&buf, binary.BigEndian, uint32(1))
+	writeSnapshotString(&buf, "lock1")
+	writeSnapshotString(&buf, "owner1")
+	binary.Write(&buf, binary.BigEndian, [3]uint64{3, 2000, 1000})
+	binary.Write(&buf, binary.BigEndian, [2]uint32{0, 0})
+	binary.Write(&buf, binary.BigEndian, crc32.ChecksumIEEE(buf.Bytes()))
+
+	var snap Snapshot
+	if err := snap.UnmarshalBinary(buf.Bytes()); err != nil {
+		t.Fatalf("UnmarshalBinary failed: %v", err)
+	}
+	want := LockState{LockID: "lock1", OwnerID: "owner1", FencingToken: 3, ExpiresAt: 2000, AcquiredAt: 1000}
+	if snap.ID != 7 || len(snap.Locks) != 1 || snap.Locks[0] != want {
+		t.Errorf("Expected snapshot 7 holding %+v, got %+v", want, snap)
+	}
+}
+
+func TestRestoreSnapshotsRejectsBrokenChain(t *testing.T) {
+	s := NewServer()
+	if _, err := s.DeltaSnapshot(); err == nil {
+		t.Error("Expected a delta without a base to fail")
+	}
+
+	base := s.Snapshot()
+	first, _ := s.DeltaSnapshot()
+	second, _ := s.DeltaSnapshot()
+
+	for name, snaps := range map[string][]*Snapshot{
+		"delta first":   {first, second},
+		"missing delta": {base, second},
+	} {
+		if err := NewServer().RestoreSnapshots(snaps, nil); err == nil {
+			t.Errorf("%s: expected restore to fail", name)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestDumpLoadStateRoundTrip(t *testing.T) {
+	s := NewServer()
+	ctx := context.Background()
+
+	for _, lockID := range []string{"lock1", "lock2", "lock3"} {
+		if _, _, err := s.Acquire(ctx, "owner-"+lockID, lockID, time.Minute); err != nil {
+			t.Fatalf("Acquire failed: %v", err)
+		}
+	}
+	// Expired locks are not part of the dump
+	if _, _, err := s.Acquire(ctx, "owner1", "stale", time.Millisecond); err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	data, err := s.DumpState()
+	if err != nil {
+		t.Fatalf("DumpState failed: %v", err)
+	}
+
+	s = NewServer()
+	if err := s.LoadState(data); err != nil {
+		t.Fatalf("LoadState failed: %v", err)
+	}
+
+	again, err := s.DumpState()
+	if err != nil {
+		t.Fatalf("DumpState failed: %v", err)
+	}
+	if string(again) != string(data) {
+		t.Errorf("Expected round trip to preserve state:\n%s\ngot:\n%s", data, again)
+	}
+
+	if _, ok := s.activeLocks.Load("stale"); ok {
+		t.Error("Expected expired lock to be skipped")
+	}
+
+	// Loaded locks behave like granted ones
+	lockIface, _ := s.activeLocks.Load("lock2")
+	token := lockIface.(*Lock).FencingToken
+	if _, _, err := s.Acquire(ctx, "other", "lock2", time.Minute); err == nil {
+		t.Error("Expected loaded lock to be held")
+	}
+	if _, err := s.Release(ctx, "lock2", "owner-lock2", token); err != nil {
+		t.Fatalf("Release of loaded lock failed: %v", err)
+	}
+	_, lock, err := s.Acquire(ctx, "other", "lock2", time.Minute)
+	if err != nil {
+		t.Fatalf("Acquire after release failed: %v", err)
+	}
+	if lock.FencingToken <= token {
+		t.Errorf("Expected fencing token > %d, got %d", token, lock.FencingToken)
+	}
+}
+
+func TestLoadStateRejectsHeldLock(t *testing.T) {
+	s := NewServer()
+
+	if _, _, err := s.Acquire(context.Background(), "owner1", "lock1", time.Minute); err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	data, err := s.DumpState()
+	if err != nil {
+		t.Fatalf("DumpState failed: %v", err)
+	}
+
+	if err := s.LoadState(data); err == nil {
+		t.Error("Expected LoadState to reject a lock that is already held")
+	}
+	if err := s.LoadState([]byte("not json")); err == nil {
+		t.Error("Expected LoadState to reject malformed input")
+	}
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"sync/atomic"
+
+	"github.com/mrdhat/clutchdb/command"
+	"github.com/mrdhat/clutchdb/protocol"
+	"github.com/mrdhat/clutchdb/wal"
+)
+
+// commandCounters counts commands committed since the server started. Replayed and replicated
+// commands aren't counted.
+type commandCounters struct {
+	acquires atomic.Uint64
+	renews   atomic.Uint64
+	releases atomic.Uint64
+}
+
+// count records one committed command of type cmdType
+func (c *commandCounters) count(cmdType command.CommandType) {
+	switch cmdType {
+	case command.CmdAcquire:
+		c.acquires.Add(1)
+	case command.CmdRenew:
+		c.renews.Add(1)
+	case command.CmdRelease:
+		c.releases.Add(1)
+	}
+}
+
+// Stats returns the server's live counters, as reported by SERVER_STATS. ActiveLocks walks the
+// lock table so that holds which lapsed but haven't been reaped yet aren't counted. WALBytes is 0
+// unless the commit log is a wal.Sizer.
+func (s *Server) Stats() *protocol.ServerStats {
+	stats := &protocol.ServerStats{
+		ActiveLocks: s.liveLocks(),
+		Acquires:    s.counters.acquires.Load(),
+		Renews:      s.counters.renews.Load(),
+		Releases:    s.counters.releases.Load(),
+	}
+	if sizer, ok := s.commitLog.(wal.Sizer); ok {
+		if size, err := sizer.Size(); err == nil {
+			stats.WALBytes = uint64(size)
+		}
+	}
+	if now := s.clock.NowMillis(); now > s.startedAt {
+		stats.UptimeMS = now - s.startedAt
+	}
+	return stats
+}
+
+// liveLocks counts the holds live now
+func (s *Server) liveLocks() uint64 {
+	now := s.clock.NowMillis()
+	var n uint64
+	s.activeLocks.Range(func(key, value any) bool {
+		lock := value.(*Lock)
+		lock.mu.Lock()
+		if _, ok := liveHold(lock, now); ok {
+			n++
+		}
+		lock.mu.Unlock()
+		return true
+	})
+	return n
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/mrdhat/clutchdb/clutcherrors"
+	"github.com/mrdhat/clutchdb/protocol"
+)
+
+// Tombstone records a clean release, kept for auditing lock churn after the lock is gone
+type Tombstone struct {
+	LockID       string
+	OwnerID      string // owner that released the lock
+	FencingToken uint64 // final token of the released hold
+	ReleasedAt   uint64 // release time in milliseconds
+}
+
+// tombstoneLog keeps the most recent releases, oldest first
+type tombstoneLog struct {
+	mu       sync.Mutex
+	entries  []Tombstone
+	capacity int
+	ttl      uint64 // milliseconds
+}
+
+// WithTombstones keeps a record of up to capacity recent releases for ttl each, queryable through
+// Tombstones, or over the wire with TOMBSTONES if admin commands are enabled. The oldest records
+// are dropped first once capacity is reached. Off by default.
+func WithTombstones(capacity int, ttl time.Duration) Option {
+	return func(s *Server) {
+		if capacity <= 0 {
+			s.tombstones = nil
+			return
+		}
+		s.tombstones = &tombstoneLog{capacity: capacity, ttl: uint64(ttl.Milliseconds())}
+	}
+}
+
+// Tombstones returns the releases recorded within the tombstone TTL, oldest first. It returns
+// nil unless the server was built WithTombstones.
+func (s *Server) Tombstones() []Tombstone {
+	t := s.tombstones
+	if t == nil {
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.pruneLocked(s.clock.NowMillis())
+	return append([]Tombstone(nil), t.entries...)
+}
+
+// dispatchTombstones answers a TOMBSTONES through the middleware chain. Like the fencing counter
+// admin commands, it is refused unless the server was built WithAdminCommands.
+func (s *Server) dispatchTombstones(ctx context.Context, req *protocol.ReportRequest) (clutcherrors.StatusCode, []protocol.Tombstone) {
+	var tombstones []protocol.Tombstone
+	status := s.dispatchFrame(ctx, req.Envelope(), func(context.Context) (clutcherrors.StatusCode, func()) {
+		if !s.adminCommands {
+			return clutcherrors.STATUS_INVALID_REQUEST, func() {}
+		}
+		found := s.Tombstones()
+		wire := make([]protocol.Tombstone, len(found))
+		for i, t := range found {
+			wire[i] = protocol.Tombstone(t)
+		}
+		return clutcherrors.STATUS_SUCCESS, func() { tombstones = wire }
+	})
+	return status, tombstones
+}
+
+// recordRelease adds a tombstone for a lock released by ownerID, if tombstones are enabled
+func (s *Server) recordRelease(lockID string, ownerID string, fencingToken uint64, now uint64) {
+	t := s.tombstones
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.pruneLocked(now)
+	if len(t.entries) == t.capacity {
+		// Shift rather than reslice so the backing array doesn't grow without bound
+		n := copy(t.entries, t.entries[1:])
+		t.entries = t.entries[:n]
+	}
+	t.entries = append(t.entries, Tombstone{
+		LockID:       lockID,
+		OwnerID:      ownerID,
+		FencingToken: fencingToken,
+		ReleasedAt:   now,
+	})
+}
+
+// pruneLocked drops tombstones older than the TTL. Must be called with t.mu held.
+func (t *tombstoneLog) pruneLocked(now uint64) {
+	i := 0
+	for i < len(t.entries) && t.entries[i].ReleasedAt+t.ttl <= now {
+		i++
+	}
+	if i > 0 {
+		n := copy(t.entries, t.entries[i:])
+		t.entries = t.entries[:n]
+	}
+}
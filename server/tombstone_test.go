@@ -0,0 +1,134 @@
+package server
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/mrdhat/clutchdb/clutcherrors"
+	"github.com/mrdhat/clutchdb/protocol"
+)
+
+func TestTombstoneRecordedThenExpires(t *testing.T) {
+	clock := &manualClock{}
+	clock.now.Store(1_000_000)
+	s := NewServer(WithClock(clock), WithTombstones(10, time.Minute))
+	ctx := context.Background()
+
+	_, lock, err := s.Acquire(ctx, "owner1", "lock1", time.Minute)
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	clock.advance(time.Second)
+	if _, err := s.Release(ctx, "lock1", "owner1", lock.FencingToken); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+
+	tombstones := s.Tombstones()
+	want := Tombstone{LockID: "lock1", OwnerID: "owner1", FencingToken: lock.FencingToken, ReleasedAt: 1_001_000}
+	if len(tombstones) != 1 || tombstones[0] != want {
+		t.Fatalf("Expected tombstone %+v, got %+v", want, tombstones)
+	}
+
+	clock.advance(time.Minute)
+	if tombstones := s.Tombstones(); len(tombstones) != 0 {
+		t.Fatalf("Expected tombstone to expire, got %+v", tombstones)
+	}
+}
+
+func TestTombstonesBounded(t *testing.T) {
+	s := NewServer(WithTombstones(2, time.Minute))
+	ctx := context.Background()
+
+	for _, lockID := range []string{"lock1", "lock2", "lock3"} {
+		_, lock, err := s.Acquire(ctx, "owner1", lockID, time.Minute)
+		if err != nil {
+			t.Fatalf("Acquire failed: %v", err)
+		}
+		if _, err := s.Release(ctx, lockID, "owner1", lock.FencingToken); err != nil {
+			t.Fatalf("Release failed: %v", err)
+		}
+	}
+
+	tombstones := s.Tombstones()
+	if len(tombstones) != 2 || tombstones[0].LockID != "lock2" || tombstones[1].LockID != "lock3" {
+		t.Fatalf("Expected the 2 newest tombstones, got %+v", tombstones)
+	}
+}
+
+func TestTombstonesDisabledByDefault(t *testing.T) {
+	s := NewServer()
+	ctx := context.Background()
+
+	_, lock, err := s.Acquire(ctx, "owner1", "lock1", time.Minute)
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	if _, err := s.Release(ctx, "lock1", "owner1", lock.FencingToken); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+	if tombstones := s.Tombstones(); tombstones != nil {
+		t.Fatalf("Expected no tombstones, got %+v", tombstones)
+	}
+}
+
+// readTombstones asks s for its tombstones over the wire
+func readTombstones(t *testing.T, s *Server) (clutcherrors.StatusCode, []protocol.Tombstone) {
+	t.Helper()
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	go s.ServeConn(context.Background(), serverConn)
+
+	if err := protocol.WriteReportRequest(clientConn, &protocol.ReportRequest{Cmd: protocol.TOMBSTONES, RequestID: [16]byte{1}}); err != nil {
+		t.Fatalf("WriteReportRequest failed: %v", err)
+	}
+	status, tombstones, _, err := protocol.ReadTombstonesResponse(clientConn)
+	if err != nil {
+		t.Fatalf("ReadTombstonesResponse failed: %v", err)
+	}
+	return status, tombstones
+}
+
+func TestServeTombstones(t *testing.T) {
+	clock := &manualClock{}
+	clock.now.Store(1_000_000)
+	s := NewServer(WithClock(clock), WithTombstones(10, time.Minute), WithAdminCommands())
+	ctx := context.Background()
+
+	_, lock, err := s.Acquire(ctx, "owner1", "lock1", time.Minute)
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	if _, err := s.Release(ctx, "lock1", "owner1", lock.FencingToken); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+
+	want := []protocol.Tombstone{{LockID: "lock1", OwnerID: "owner1", FencingToken: lock.FencingToken, ReleasedAt: 1_000_000}}
+	if status, tombstones := readTombstones(t, s); status != clutcherrors.STATUS_SUCCESS || !reflect.DeepEqual(tombstones, want) {
+		t.Fatalf("Expected %+v, got status %d and %+v", want, status, tombstones)
+	}
+
+	clock.advance(time.Minute)
+	if status, tombstones := readTombstones(t, s); status != clutcherrors.STATUS_SUCCESS || len(tombstones) != 0 {
+		t.Fatalf("Expected tombstone to expire, got status %d and %+v", status, tombstones)
+	}
+}
+
+func TestServeTombstonesNeedsAdminCommands(t *testing.T) {
+	s := NewServer(WithTombstones(10, time.Minute))
+	ctx := context.Background()
+
+	_, lock, err := s.Acquire(ctx, "owner1", "lock1", time.Minute)
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	if _, err := s.Release(ctx, "lock1", "owner1", lock.FencingToken); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+
+	if status, tombstones := readTombstones(t, s); status != clutcherrors.STATUS_INVALID_REQUEST || len(tombstones) != 0 {
+		t.Fatalf("Expected status %d and no tombstones, got status %d and %+v", clutcherrors.STATUS_INVALID_REQUEST, status, tombstones)
+	}
+}
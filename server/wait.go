@@ -0,0 +1,249 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mrdhat/clutchdb/clutcherrors"
+)
+
+// waiter is a blocked AcquireWait call
+type waiter struct {
+	ready    chan struct{} // signalled when the waiter is at the head and the lock may be free
+	priority uint32
+	queuedAt uint64 // when the waiter joined the queue, in Unix milliseconds
+}
+
+// waitQueue orders AcquireWait callers for one lock. Only the head waiter attempts acquisition.
+// The head is the waiter with the highest priority after aging, the earliest arrival among
+// equals, so waiters of one priority are served in arrival order.
+type waitQueue struct {
+	mu       sync.Mutex
+	waiters  []*waiter
+	watchers []*watcher // WaitFree callers, woken whenever the lock frees; they never take a turn
+	dead     bool       // removed from Server.waitQueues; enqueuers must load a fresh queue
+}
+
+// AcquireWait acquires lockID like Acquire, but if the lock is held it queues
+// behind earlier waiters and blocks until it is this caller's turn and the lock
+// frees (by release or expiry), or ctx is done.
+func (s *Server) AcquireWait(ctx context.Context, ownerID string, lockID string, ttl time.Duration) (clutcherrors.StatusCode, *Lock, error) {
+	return s.AcquireWaitMaxQueue(ctx, ownerID, lockID, ttl, -1)
+}
+
+// AcquireWaitMaxQueue is AcquireWait for callers that only want to wait behind a short queue. If
+// more than maxQueue callers are already waiting for lockID it fails at once with
+// STATUS_LOCK_HELD instead of joining them, so hot locks shed load rather than build up queues.
+// A negative maxQueue means no limit.
+func (s *Server) AcquireWaitMaxQueue(ctx context.Context, ownerID string, lockID string, ttl time.Duration, maxQueue int) (clutcherrors.StatusCode, *Lock, error) {
+	return s.AcquireWaitPriority(ctx, ownerID, lockID, ttl, maxQueue, 0)
+}
+
+// AcquireWaitPriority is AcquireWaitMaxQueue for callers of mixed importance: when the lock frees
+// it goes to the waiter with the highest priority, whenever it arrived. Waiting raises a caller's
+// priority by one for every WithWaitAging interval it spends queued, so a steady stream of
+// higher-priority callers only delays lower-priority ones rather than starving them.
+func (s *Server) AcquireWaitPriority(ctx context.Context, ownerID string, lockID string, ttl time.Duration, maxQueue int, priority uint32) (clutcherrors.StatusCode, *Lock, error) {
+	q, w, waiting := s.enqueueWaiter(lockID, maxQueue, priority)
+	if w == nil {
+		return clutcherrors.STATUS_LOCK_HELD, nil, fmt.Errorf("wait queue full: %d already waiting", waiting)
+	}
+	defer s.removeWaiter(q, lockID, w)
+
+	woken := false
+	for {
+		if s.isHead(q, w) {
+			status, lock, err := s.acquire(ctx, ownerID, lockID, ttl, "", 0, true, false)
+			if status != clutcherrors.STATUS_LOCK_HELD {
+				return status, lock, err
+			}
+		} else if woken {
+			// Waiters may have overtaken us since the wake-up was sent; it is theirs now
+			s.signalHead(q)
+		}
+
+		// Sleep until signalled, or until the current hold lapses without a release
+		var timer *time.Timer
+		var expired <-chan time.Time
+		if s.isHead(q, w) {
+			timer = time.NewTimer(s.untilExpiry(lockID))
+			expired = timer.C
+		}
+
+		var err error
+		select {
+		case <-w.ready:
+		case <-expired:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if err != nil {
+			return clutcherrors.STATUS_LOCK_HELD, nil, err
+		}
+		woken = true
+	}
+}
+
+// enqueueWaiter appends a new waiter to the tail of lockID's queue. If more than maxQueue callers
+// are already waiting it returns a nil waiter and how many are waiting; a negative maxQueue
+// means no limit.
+func (s *Server) enqueueWaiter(lockID string, maxQueue int, priority uint32) (*waitQueue, *waiter, int) {
+	for {
+		qIface, _ := s.waitQueues.LoadOrStore(lockID, &waitQueue{})
+		q := qIface.(*waitQueue)
+
+		q.mu.Lock()
+		if q.dead {
+			q.mu.Unlock()
+			continue
+		}
+		if waiting := len(q.waiters); maxQueue >= 0 && waiting > maxQueue {
+			q.mu.Unlock()
+			return q, nil, waiting
+		}
+		w := &waiter{ready: make(chan struct{}, 1), priority: priority, queuedAt: s.clock.NowMillis()}
+		q.waiters = append(q.waiters, w)
+		q.mu.Unlock()
+		return q, w, 0
+	}
+}
+
+func (s *Server) isHead(q *waitQueue, w *waiter) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return s.headLocked(q) == w
+}
+
+// headLocked returns the waiter whose turn it is, nil if q is empty. Must be called with q.mu held.
+func (s *Server) headLocked(q *waitQueue) *waiter {
+	var head *waiter
+	var headPriority uint64
+	now := s.clock.NowMillis()
+	for _, w := range q.waiters {
+		// Strictly greater, so the earliest arrival wins a tie
+		if priority := s.agedPriority(w, now); head == nil || priority > headPriority {
+			head, headPriority = w, priority
+		}
+	}
+	return head
+}
+
+// agedPriority returns w's priority plus one for every aging interval it has waited
+func (s *Server) agedPriority(w *waiter, now uint64) uint64 {
+	priority := uint64(w.priority)
+	aging := uint64(s.waitAging.Milliseconds())
+	if aging > 0 && now > w.queuedAt {
+		priority += (now - w.queuedAt) / aging
+	}
+	return priority
+}
+
+// removeWaiter takes w out of q, handing the turn to the next waiter if w was at the head
+func (s *Server) removeWaiter(q *waitQueue, lockID string, w *waiter) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	wasHead := s.headLocked(q) == w
+	for i, other := range q.waiters {
+		if other != w {
+			continue
+		}
+		q.waiters = append(q.waiters[:i], q.waiters[i+1:]...)
+		if s.dropIfIdleLocked(q, lockID) {
+			return
+		}
+		if wasHead {
+			s.signalHeadLocked(q)
+		}
+		return
+	}
+}
+
+// dropIfIdleLocked removes q from the server once nobody is waiting or watching on it, and
+// reports whether it did. Must be called with q.mu held.
+func (s *Server) dropIfIdleLocked(q *waitQueue, lockID string) bool {
+	if len(q.waiters) > 0 || len(q.watchers) > 0 {
+		return false
+	}
+	q.dead = true
+	s.waitQueues.CompareAndDelete(lockID, q)
+	return true
+}
+
+// signalHeadLocked wakes the head waiter. Must be called with q.mu held.
+func (s *Server) signalHeadLocked(q *waitQueue) {
+	head := s.headLocked(q)
+	if head == nil {
+		return
+	}
+	select {
+	case head.ready <- struct{}{}:
+	default:
+		// Already signalled
+	}
+}
+
+func (s *Server) signalHead(q *waitQueue) {
+	q.mu.Lock()
+	s.signalHeadLocked(q)
+	q.mu.Unlock()
+}
+
+// notifyWaiters wakes the head waiter for lockID, if any, and every WaitFree caller after the
+// lock frees
+func (s *Server) notifyWaiters(lockID string) {
+	qIface, ok := s.waitQueues.Load(lockID)
+	if !ok {
+		return
+	}
+	q := qIface.(*waitQueue)
+	q.mu.Lock()
+	s.signalHeadLocked(q)
+	signalWatchersLocked(q)
+	q.mu.Unlock()
+}
+
+// hasWaiters reports whether any AcquireWait calls are queued for lockID
+func (s *Server) hasWaiters(lockID string) bool {
+	qIface, ok := s.waitQueues.Load(lockID)
+	if !ok {
+		return false
+	}
+	q := qIface.(*waitQueue)
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.waiters) > 0
+}
+
+// untilExpiry returns how long until the current hold on lockID lapses, including any expiry grace
+func (s *Server) untilExpiry(lockID string) time.Duration {
+	freesAt := s.freesAt(lockID)
+	now := s.clock.NowMillis()
+	if freesAt <= now {
+		return 0
+	}
+	// Acquire treats ExpiresAt itself as still held, so wake just after it
+	return time.Duration(freesAt-now+1) * time.Millisecond
+}
+
+// freesAt returns when the current hold on lockID, plus any expiry grace, lapses in Unix
+// milliseconds. It is 0 if the lock isn't in the table.
+func (s *Server) freesAt(lockID string) uint64 {
+	lockIface, ok := s.activeLocks.Load(lockID)
+	if !ok {
+		return 0
+	}
+	lock := lockIface.(*Lock)
+
+	lock.mu.Lock()
+	defer lock.mu.Unlock()
+	if lock.removed {
+		return 0
+	}
+	return lock.ExpiresAt + uint64(s.expiryGrace.Milliseconds())
+}
@@ -0,0 +1,312 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mrdhat/clutchdb/clutcherrors"
+	"github.com/mrdhat/clutchdb/protocol"
+)
+
+// queueLen returns the number of waiters queued for lockID
+func queueLen(s *Server, lockID string) int {
+	qIface, ok := s.waitQueues.Load(lockID)
+	if !ok {
+		return 0
+	}
+	q := qIface.(*waitQueue)
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.waiters)
+}
+
+// waitForQueueLen polls until lockID has n waiters queued
+func waitForQueueLen(t *testing.T, s *Server, lockID string, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for queueLen(s, lockID) != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %d waiters, have %d", n, queueLen(s, lockID))
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestAcquireWaitFIFO(t *testing.T) {
+	s := NewServer()
+	ctx := context.Background()
+	lockID := "lock1"
+	ttl := time.Second
+	numWaiters := 5
+
+	_, holder, err := s.Acquire(ctx, "holder", lockID, ttl)
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var order []int
+
+	for i := 0; i < numWaiters; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			ownerID := fmt.Sprintf("owner%d", id)
+
+			status, lock, err := s.AcquireWait(ctx, ownerID, lockID, ttl)
+			if err != nil || status != clutcherrors.STATUS_SUCCESS {
+				t.Errorf("AcquireWait %d failed with status %d: %v", id, status, err)
+				return
+			}
+
+			mu.Lock()
+			order = append(order, id)
+			mu.Unlock()
+
+			if _, err := s.Release(ctx, lockID, ownerID, lock.FencingToken); err != nil {
+				t.Errorf("Release %d failed: %v", id, err)
+			}
+		}(i)
+
+		// Make sure waiters arrive in a known order
+		waitForQueueLen(t, s, lockID, i+1)
+	}
+
+	if _, err := s.Release(ctx, lockID, "holder", holder.FencingToken); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+	wg.Wait()
+
+	if len(order) != numWaiters {
+		t.Fatalf("Expected %d acquisitions, got %d", numWaiters, len(order))
+	}
+	for i, id := range order {
+		if id != i {
+			t.Fatalf("Expected waiters served in arrival order, got %v", order)
+		}
+	}
+}
+
+// servedOrder queues one AcquireWait per priority, in order, behind a held lock, advancing clock by
+// gap after each arrival, then frees the lock and returns the indexes of the waiters in the order
+// they were granted it
+func servedOrder(t *testing.T, s *Server, clock *manualClock, priorities []uint32, gap time.Duration) []int {
+	t.Helper()
+	ctx := context.Background()
+	lockID := "lock1"
+	ttl := time.Minute
+
+	_, holder, err := s.Acquire(ctx, "holder", lockID, ttl)
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var order []int
+	for i, priority := range priorities {
+		wg.Add(1)
+		go func(id int, priority uint32) {
+			defer wg.Done()
+			ownerID := fmt.Sprintf("owner%d", id)
+
+			status, lock, err := s.AcquireWaitPriority(ctx, ownerID, lockID, ttl, -1, priority)
+			if err != nil || status != clutcherrors.STATUS_SUCCESS {
+				t.Errorf("AcquireWaitPriority %d failed with status %d: %v", id, status, err)
+				return
+			}
+
+			mu.Lock()
+			order = append(order, id)
+			mu.Unlock()
+
+			if _, err := s.Release(ctx, lockID, ownerID, lock.FencingToken); err != nil {
+				t.Errorf("Release %d failed: %v", id, err)
+			}
+		}(i, priority)
+
+		waitForQueueLen(t, s, lockID, i+1)
+		clock.advance(gap)
+	}
+
+	if _, err := s.Release(ctx, lockID, "holder", holder.FencingToken); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+	wg.Wait()
+	return order
+}
+
+func TestAcquireWaitPriority(t *testing.T) {
+	clock := &manualClock{}
+	clock.now.Store(1_000_000)
+	s := NewServer(WithClock(clock))
+
+	// A late high-priority waiter is served before earlier low-priority ones, which keep
+	// their arrival order
+	order := servedOrder(t, s, clock, []uint32{0, 0, 0, 5, 1}, time.Millisecond)
+	if fmt.Sprint(order) != "[3 4 0 1 2]" {
+		t.Errorf("Expected waiters served in order [3 4 0 1 2], got %v", order)
+	}
+}
+
+func TestAcquireWaitAging(t *testing.T) {
+	clock := &manualClock{}
+	clock.now.Store(1_000_000)
+	s := NewServer(WithClock(clock), WithWaitAging(time.Second))
+
+	// Waiter 0 has waited three seconds longer, which outweighs waiter 1's priority of 2
+	order := servedOrder(t, s, clock, []uint32{0, 2}, 3*time.Second)
+	if fmt.Sprint(order) != "[0 1]" {
+		t.Errorf("Expected aged waiter served first, got %v", order)
+	}
+
+	// Without aging priority alone decides
+	clock = &manualClock{}
+	clock.now.Store(1_000_000)
+	s = NewServer(WithClock(clock), WithWaitAging(0))
+	order = servedOrder(t, s, clock, []uint32{0, 2}, 3*time.Second)
+	if fmt.Sprint(order) != "[1 0]" {
+		t.Errorf("Expected higher priority served first without aging, got %v", order)
+	}
+}
+
+func TestAcquireNoBargingPastWaiters(t *testing.T) {
+	s := NewServer()
+	ctx := context.Background()
+	lockID := "lock1"
+	ttl := time.Second
+
+	_, holder, err := s.Acquire(ctx, "holder", lockID, ttl)
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+
+	done := make(chan clutcherrors.StatusCode, 1)
+	go func() {
+		status, _, _ := s.AcquireWait(ctx, "waiter", lockID, ttl)
+		done <- status
+	}()
+	waitForQueueLen(t, s, lockID, 1)
+
+	if _, err := s.Release(ctx, lockID, "holder", holder.FencingToken); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+
+	// Either the waiter already holds the lock or it is still queued; a newcomer loses both ways
+	status, _, err := s.Acquire(ctx, "barger", lockID, ttl)
+	if err == nil {
+		t.Fatal("Expected barging acquire to fail, got nil")
+	}
+	if status != clutcherrors.STATUS_LOCK_HELD {
+		t.Errorf("Expected status %d, got %d", clutcherrors.STATUS_LOCK_HELD, status)
+	}
+
+	if status := <-done; status != clutcherrors.STATUS_SUCCESS {
+		t.Errorf("Expected waiter to acquire, got status %d", status)
+	}
+}
+
+func TestAcquireWaitExpiry(t *testing.T) {
+	s := NewServer()
+	ctx := context.Background()
+	lockID := "lock1"
+
+	if _, _, err := s.Acquire(ctx, "holder", lockID, 30*time.Millisecond); err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+
+	// No release: the waiter must wake when the hold lapses
+	start := time.Now()
+	status, lock, err := s.AcquireWait(ctx, "waiter", lockID, time.Second)
+	if err != nil || status != clutcherrors.STATUS_SUCCESS {
+		t.Fatalf("AcquireWait failed with status %d: %v", status, err)
+	}
+	if lock.OwnerID != "waiter" {
+		t.Errorf("Expected owner waiter, got %s", lock.OwnerID)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Expected waiter to acquire shortly after expiry, took %v", elapsed)
+	}
+}
+
+func TestAcquireWaitCancel(t *testing.T) {
+	s := NewServer()
+	lockID := "lock1"
+
+	if _, _, err := s.Acquire(context.Background(), "holder", lockID, time.Second); err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	status, lock, err := s.AcquireWait(ctx, "waiter", lockID, time.Second)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected deadline exceeded, got %v", err)
+	}
+	if status != clutcherrors.STATUS_LOCK_HELD {
+		t.Errorf("Expected status %d, got %d", clutcherrors.STATUS_LOCK_HELD, status)
+	}
+	if lock != nil {
+		t.Error("Expected nil lock for cancelled wait")
+	}
+	if s.hasWaiters(lockID) {
+		t.Error("Expected cancelled waiter to leave the queue")
+	}
+}
+
+func TestAcquireWaitMaxQueue(t *testing.T) {
+	s := NewServer()
+	ctx := context.Background()
+	lockID := "lock1"
+
+	_, holder, err := s.Acquire(ctx, "holder", lockID, time.Second)
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+
+	// Nobody is waiting yet, so a caller that accepts no queue ahead of it still waits
+	first := make(chan *protocol.Response, 1)
+	go func() {
+		first <- s.Dispatch(ctx, newRequest(protocol.ACQUIRE_WAIT, lockID, "waiter1", 1000, 0))
+	}()
+	waitForQueueLen(t, s, lockID, 1)
+
+	// Over the threshold: fails at once without joining the queue
+	resp := s.Dispatch(ctx, newRequest(protocol.ACQUIRE_WAIT, lockID, "waiter2", 1000, 0))
+	if resp.Status != clutcherrors.STATUS_LOCK_HELD {
+		t.Fatalf("Expected status %d, got %+v", clutcherrors.STATUS_LOCK_HELD, resp)
+	}
+	if resp.ExpiresAt != holder.ExpiresAt {
+		t.Errorf("Expected rejection to carry the holder's expiry %d, got %d", holder.ExpiresAt, resp.ExpiresAt)
+	}
+	if n := queueLen(s, lockID); n != 1 {
+		t.Errorf("Expected rejected caller to stay out of the queue, have %d waiters", n)
+	}
+
+	// At the threshold: waits its turn
+	second := make(chan *protocol.Response, 1)
+	go func() {
+		second <- s.Dispatch(ctx, newRequest(protocol.ACQUIRE_WAIT, lockID, "waiter3", 1000, 1))
+	}()
+	waitForQueueLen(t, s, lockID, 2)
+
+	if _, err := s.Release(ctx, lockID, "holder", holder.FencingToken); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+	resp = <-first
+	if resp.Status != clutcherrors.STATUS_SUCCESS {
+		t.Fatalf("Expected first waiter to acquire, got %+v", resp)
+	}
+	if _, err := s.Release(ctx, lockID, "waiter1", resp.FencingToken); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+	if resp := <-second; resp.Status != clutcherrors.STATUS_SUCCESS {
+		t.Errorf("Expected second waiter to acquire, got %+v", resp)
+	}
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/mrdhat/clutchdb/clutcherrors"
+)
+
+// watcher is a blocked WaitFree call, woken alongside a lock's head waiter
+type watcher struct {
+	ready chan struct{}
+}
+
+// WaitFree blocks until nobody holds lockID, by release or by expiry including any expiry
+// grace, or until ctx is done. It returns at once if the lock is free already. It only waits:
+// the lock goes to whoever acquires it first, so a caller that wants it acquires next and
+// waits again if it loses the race.
+func (s *Server) WaitFree(ctx context.Context, lockID string) error {
+	q, w := s.enqueueWatcher(lockID)
+	defer s.removeWatcher(q, lockID, w)
+
+	for {
+		// Registered before checking, so a release in between still wakes us
+		wait := s.untilExpiry(lockID)
+		if wait == 0 {
+			return nil
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-w.ready:
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+		timer.Stop()
+	}
+}
+
+// watch executes a WATCH: WaitFree for at most maxWait, or for as long as ctx allows if it is 0
+func (s *Server) watch(ctx context.Context, lockID string, maxWait time.Duration) (clutcherrors.StatusCode, error) {
+	if maxWait > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, maxWait)
+		defer cancel()
+	}
+	if err := s.WaitFree(ctx, lockID); err != nil {
+		return clutcherrors.STATUS_LOCK_HELD, errors.New("lock still held")
+	}
+	return clutcherrors.STATUS_SUCCESS, nil
+}
+
+// enqueueWatcher adds a new watcher to lockID's queue
+func (s *Server) enqueueWatcher(lockID string) (*waitQueue, *watcher) {
+	for {
+		qIface, _ := s.waitQueues.LoadOrStore(lockID, &waitQueue{})
+		q := qIface.(*waitQueue)
+
+		q.mu.Lock()
+		if q.dead {
+			q.mu.Unlock()
+			continue
+		}
+		w := &watcher{ready: make(chan struct{}, 1)}
+		q.watchers = append(q.watchers, w)
+		q.mu.Unlock()
+		return q, w
+	}
+}
+
+// removeWatcher takes w out of q
+func (s *Server) removeWatcher(q *waitQueue, lockID string, w *watcher) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for i, other := range q.watchers {
+		if other == w {
+			q.watchers = append(q.watchers[:i], q.watchers[i+1:]...)
+			break
+		}
+	}
+	s.dropIfIdleLocked(q, lockID)
+}
+
+// signalWatchersLocked wakes every watcher. Must be called with q.mu held.
+func signalWatchersLocked(q *waitQueue) {
+	for _, w := range q.watchers {
+		select {
+		case w.ready <- struct{}{}:
+		default:
+			// Already signalled
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mrdhat/clutchdb/clutcherrors"
+	"github.com/mrdhat/clutchdb/command"
+	"github.com/mrdhat/clutchdb/protocol"
+)
+
+// watcherCount returns the number of WaitFree calls blocked on lockID
+func watcherCount(s *Server, lockID string) int {
+	qIface, ok := s.waitQueues.Load(lockID)
+	if !ok {
+		return 0
+	}
+	q := qIface.(*waitQueue)
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.watchers)
+}
+
+// waitForWatchers polls until lockID has n watchers
+func waitForWatchers(t *testing.T, s *Server, lockID string, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for watcherCount(s, lockID) != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %d watchers, have %d", n, watcherCount(s, lockID))
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestWaitFreeRelease(t *testing.T) {
+	s := NewServer()
+	ctx := context.Background()
+
+	// A free lock doesn't wait at all
+	if err := s.WaitFree(ctx, "lock1"); err != nil {
+		t.Fatalf("WaitFree failed: %v", err)
+	}
+
+	_, lock, err := s.Acquire(ctx, "owner1", "lock1", time.Minute)
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	done := make(chan error, 2)
+	for range 2 {
+		go func() { done <- s.WaitFree(ctx, "lock1") }()
+	}
+	waitForWatchers(t, s, "lock1", 2)
+
+	select {
+	case err := <-done:
+		t.Fatalf("Expected WaitFree to block while the lock is held, got %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	// Every watcher wakes on the release, and none of them takes the lock
+	if _, err := s.Release(ctx, "lock1", "owner1", lock.FencingToken); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+	for range 2 {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("WaitFree failed: %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Expected WaitFree to return after the release")
+		}
+	}
+	if _, ok := s.LockInfo("lock1"); ok {
+		t.Error("Expected the lock to stay free")
+	}
+	if _, ok := s.waitQueues.Load("lock1"); ok {
+		t.Error("Expected the queue to be dropped once the watchers left")
+	}
+}
+
+func TestWaitFreeExpiry(t *testing.T) {
+	s := NewServer()
+	ctx := context.Background()
+
+	if _, _, err := s.Acquire(ctx, "owner1", "lock1", 30*time.Millisecond); err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	start := time.Now()
+	if err := s.WaitFree(ctx, "lock1"); err != nil {
+		t.Fatalf("WaitFree failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+		t.Errorf("Expected WaitFree to last until the hold lapsed, returned after %v", elapsed)
+	}
+	if _, _, err := s.Acquire(ctx, "owner2", "lock1", time.Second); err != nil {
+		t.Errorf("Expected the lapsed lock to be free, Acquire failed: %v", err)
+	}
+}
+
+func TestWaitFreeCanceled(t *testing.T) {
+	s := NewServer()
+	if _, _, err := s.Acquire(context.Background(), "owner1", "lock1", time.Minute); err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := s.WaitFree(ctx, "lock1"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+	}
+	if _, ok := s.waitQueues.Load("lock1"); ok {
+		t.Error("Expected the queue to be dropped once the watcher left")
+	}
+}
+
+func TestWaitFreeLeavesAcquireWaitOrder(t *testing.T) {
+	s := NewServer()
+	ctx := context.Background()
+
+	_, lock, err := s.Acquire(ctx, "owner1", "lock1", time.Minute)
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	watched := make(chan error, 1)
+	go func() { watched <- s.WaitFree(ctx, "lock1") }()
+	waitForWatchers(t, s, "lock1", 1)
+	type result struct {
+		status clutcherrors.StatusCode
+		lock   *Lock
+	}
+	acquired := make(chan result, 1)
+	go func() {
+		status, lock, _ := s.AcquireWait(ctx, "owner2", "lock1", time.Minute)
+		acquired <- result{status, lock}
+	}()
+	waitForQueueLen(t, s, "lock1", 1)
+
+	// A watcher isn't a waiter, so the queued acquire still gets the lock
+	if _, err := s.Release(ctx, "lock1", "owner1", lock.FencingToken); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+	r := <-acquired
+	if r.status != clutcherrors.STATUS_SUCCESS {
+		t.Fatalf("Expected AcquireWait to succeed, got %v", r.status)
+	}
+
+	// The watcher returns once it sees the lock free, whether between the holds or after both
+	if _, err := s.Release(ctx, "lock1", "owner2", r.lock.FencingToken); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+	select {
+	case err := <-watched:
+		if err != nil {
+			t.Fatalf("WaitFree failed: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected WaitFree to return after the releases")
+	}
+}
+
+func TestDispatchWatch(t *testing.T) {
+	s := NewServer()
+	ctx := context.Background()
+
+	_, lock, err := s.Acquire(ctx, "owner1", "lock1", time.Minute)
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+
+	// Still held when the wait runs out: the caller learns when the hold lapses
+	resp := s.Dispatch(ctx, newRequest(protocol.WATCH, "lock1", "owner2", 20, 0))
+	if resp.Status != clutcherrors.STATUS_LOCK_HELD {
+		t.Fatalf("Expected status %d, got %d", clutcherrors.STATUS_LOCK_HELD, resp.Status)
+	}
+	if resp.ExpiresAt != lock.ExpiresAt {
+		t.Errorf("Expected expires_at %d, got %d", lock.ExpiresAt, resp.ExpiresAt)
+	}
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		s.Release(ctx, "lock1", "owner1", lock.FencingToken)
+	}()
+	resp = s.Dispatch(ctx, newRequest(protocol.WATCH, "lock1", "owner2", 0, 0))
+	if resp.Status != clutcherrors.STATUS_SUCCESS {
+		t.Fatalf("Expected status %d, got %d", clutcherrors.STATUS_SUCCESS, resp.Status)
+	}
+}
+
+func TestWaitFreeFollowerReplicatedRelease(t *testing.T) {
+	follower := NewServer(WithRole(RoleFollower))
+	ctx := context.Background()
+
+	now := follower.clock.NowMillis()
+	acquire := command.Command{Type: command.CmdAcquire, LockID: "lock1", OwnerID: "owner1", FencingToken: 1, CommitTimeMillis: now, TTLMillis: 60000}
+	if err := follower.applyCommand(acquire); err != nil {
+		t.Fatalf("applyCommand failed: %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- follower.WaitFree(ctx, "lock1") }()
+	waitForWatchers(t, follower, "lock1", 1)
+	release := command.Command{Type: command.CmdRelease, LockID: "lock1", OwnerID: "owner1", FencingToken: 1, CommitTimeMillis: now}
+	if err := follower.applyCommand(release); err != nil {
+		t.Fatalf("applyCommand failed: %v", err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("WaitFree failed: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected WaitFree to return after the replicated release")
+	}
+}
@@ -0,0 +1,15 @@
+package shard
+
+import "hash/fnv"
+
+// ShardFor maps lockID to a shard in [0, shards) using 32-bit FNV-1a.
+// The mapping is stable across processes and architectures, so clients and
+// servers can agree on lock placement. shards must be positive.
+func ShardFor(lockID string, shards int) int {
+	if shards <= 0 {
+		panic("shard: shards must be positive")
+	}
+	h := fnv.New32a()
+	h.Write([]byte(lockID))
+	return int(h.Sum32() % uint32(shards))
+}
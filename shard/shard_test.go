@@ -0,0 +1,44 @@
+package shard
+
+import "testing"
+
+func TestShardForPinned(t *testing.T) {
+	// These values pin the FNV-1a mapping; changing them reroutes existing locks
+	testCases := []struct {
+		lockID string
+		shards int
+		want   int
+	}{
+		{"", 8, 5},
+		{"", 1024, 453},
+		{"lock1", 8, 1},
+		{"lock1", 16, 9},
+		{"lock1", 1024, 265},
+		{"lock2", 8, 0},
+		{"lock2", 1024, 80},
+		{"mylock", 16, 12},
+		{"tenant/42/resource/7", 8, 7},
+		{"tenant/42/resource/7", 1024, 151},
+	}
+
+	for _, tc := range testCases {
+		if got := ShardFor(tc.lockID, tc.shards); got != tc.want {
+			t.Errorf("ShardFor(%q, %d) = %d, want %d", tc.lockID, tc.shards, got, tc.want)
+		}
+	}
+}
+
+func TestShardForRange(t *testing.T) {
+	for i := 0; i < 1000; i++ {
+		lockID := "lock" + string(rune('A'+i%26)) + string(rune('a'+i/26))
+		if got := ShardFor(lockID, 7); got < 0 || got >= 7 {
+			t.Fatalf("ShardFor(%q, 7) = %d, out of range", lockID, got)
+		}
+	}
+}
+
+func TestShardForSingleShard(t *testing.T) {
+	if got := ShardFor("anything", 1); got != 0 {
+		t.Errorf("Expected shard 0 with a single shard, got %d", got)
+	}
+}
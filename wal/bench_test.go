@@ -0,0 +1,38 @@
+package wal
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/mrdhat/clutchdb/command"
+)
+
+func BenchmarkReadAll(b *testing.B) {
+	const records = 100_000
+	w := NewWALWithStorage(NewMemoryStorage())
+	for i := range records {
+		cmd := command.Command{
+			Type:             command.CmdAcquire,
+			LockID:           fmt.Sprintf("lock%d", i%1000),
+			OwnerID:          fmt.Sprintf("owner%d", i%100),
+			TTLMillis:        30_000,
+			CommitTimeMillis: 1_700_000_000_000 + uint64(i),
+			FencingToken:     uint64(i + 1),
+		}
+		if err := w.Append(cmd); err != nil {
+			b.Fatalf("failed to append: %v", err)
+		}
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cmds, err := w.ReadAll()
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(cmds) != records {
+			b.Fatalf("read %d records, want %d", len(cmds), records)
+		}
+	}
+}
@@ -0,0 +1,23 @@
+package wal
+
+import "fmt"
+
+// Copy appends every record in src to dst and syncs dst, returning how many records were
+// copied. Records are checksummed as they are read from src and re-framed by dst, so Copy can
+// move a log between storage backends or change its encryption. On error dst may hold some of
+// the records, and the count says how many.
+func Copy(dst WAL, src WAL) (int, error) {
+	cmds, err := src.ReadAll()
+	if err != nil {
+		return 0, fmt.Errorf("failed to read source: %w", err)
+	}
+	for i, cmd := range cmds {
+		if err := dst.Append(cmd); err != nil {
+			return i, fmt.Errorf("failed to append record %d: %w", i, err)
+		}
+	}
+	if err := dst.Sync(); err != nil {
+		return len(cmds), fmt.Errorf("failed to sync destination: %w", err)
+	}
+	return len(cmds), nil
+}
@@ -0,0 +1,58 @@
+package wal
+
+import (
+	"os"
+	"testing"
+
+	"github.com/mrdhat/clutchdb/command"
+)
+
+func TestCopyFileToMemory(t *testing.T) {
+	tmpFile, err := os.CreateTemp("", "wal_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	src := NewWAL(tmpFile)
+	defer src.Close()
+	cmds := []command.Command{
+		{Type: command.CmdAcquire, RequestID: [16]byte{1}, LockID: "lock1", OwnerID: "owner1", TTLMillis: 1000, FencingToken: 1, CommitTimeMillis: 100},
+		{Type: command.CmdBump, LockID: "lock1", OwnerID: "owner1", TTLMillis: 1000, FencingToken: 2, CommitTimeMillis: 200},
+		{Type: command.CmdRelease, LockID: "lock1", OwnerID: "owner1", FencingToken: 2, CommitTimeMillis: 300},
+	}
+	for _, cmd := range cmds {
+		if err := src.Append(cmd); err != nil {
+			t.Fatalf("failed to append: %v", err)
+		}
+	}
+	if err := src.Sync(); err != nil {
+		t.Fatalf("failed to sync: %v", err)
+	}
+
+	dst := NewWALWithStorage(NewMemoryStorage())
+	n, err := Copy(dst, src)
+	if err != nil {
+		t.Fatalf("Copy failed: %v", err)
+	}
+	if n != len(cmds) {
+		t.Errorf("expected %d records copied, got %d", len(cmds), n)
+	}
+
+	want, err := src.ReadAll()
+	if err != nil {
+		t.Fatalf("failed to read source: %v", err)
+	}
+	got, err := dst.ReadAll()
+	if err != nil {
+		t.Fatalf("failed to read destination: %v", err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expected %d records, got %d", len(want), len(got))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("record %d: expected %+v, got %+v", i, want[i], got[i])
+		}
+	}
+}
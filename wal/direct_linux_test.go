@@ -0,0 +1,103 @@
+//go:build linux
+
+package wal
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/mrdhat/clutchdb/command"
+)
+
+func TestOpenWithDirectIO(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "000001.wal")
+
+	// Start from a log written the regular way, whose end isn't block-aligned
+	plain, err := Open(path)
+	if err != nil {
+		t.Fatalf("failed to open: %v", err)
+	}
+	if err := plain.Append(command.Command{Type: command.CmdAcquire, LockID: "lock0", OwnerID: "owner", TTLMillis: 1000}); err != nil {
+		t.Fatalf("failed to append: %v", err)
+	}
+	plain.Close()
+
+	w, err := Open(path, WithDirectIO())
+	if errors.Is(err, syscall.EINVAL) {
+		t.Skipf("filesystem does not support O_DIRECT: %v", err)
+	}
+	if err != nil {
+		t.Fatalf("failed to open with direct i/o: %v", err)
+	}
+	for _, lockID := range []string{"lock1", "lock2", "lock3"} {
+		if err := w.Append(command.Command{Type: command.CmdAcquire, LockID: lockID, OwnerID: "owner", TTLMillis: 1000}); err != nil {
+			t.Fatalf("failed to append: %v", err)
+		}
+	}
+	if err := w.Sync(); err != nil {
+		t.Fatalf("failed to sync: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("failed to close: %v", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("failed to stat: %v", err)
+	}
+	if info.Size()%directIOAlignment != 0 {
+		t.Errorf("log size %d is not a multiple of %d", info.Size(), directIOAlignment)
+	}
+
+	// A reader not expecting padding takes it for damage
+	plain, err = Open(path)
+	if err != nil {
+		t.Fatalf("failed to reopen: %v", err)
+	}
+	if _, err := plain.ReadAll(); err == nil {
+		t.Error("expected padding to fail a read without WithPadding")
+	}
+	plain.Close()
+
+	// and is invisible to one that is
+	reopened, err := Open(path, WithPadding())
+	if err != nil {
+		t.Fatalf("failed to reopen: %v", err)
+	}
+	defer reopened.Close()
+	cmds, err := reopened.ReadAll()
+	if err != nil {
+		t.Fatalf("failed to read: %v", err)
+	}
+	if len(cmds) != 4 {
+		t.Fatalf("read %d records, want 4", len(cmds))
+	}
+	for i, cmd := range cmds {
+		if want := "lock" + string(rune('0'+i)); cmd.LockID != want {
+			t.Errorf("record %d has lock %q, want %q", i, cmd.LockID, want)
+		}
+	}
+
+	// and appends after it land past the padding
+	if err := reopened.Append(command.Command{Type: command.CmdRelease, LockID: "lock1", OwnerID: "owner"}); err != nil {
+		t.Fatalf("failed to append: %v", err)
+	}
+	if cmds, err = reopened.ReadAll(); err != nil || len(cmds) != 5 {
+		t.Fatalf("read %d records (%v), want 5", len(cmds), err)
+	}
+}
+
+func TestPaddingFrame(t *testing.T) {
+	for _, size := range []int64{0, 1, 100, directIOAlignment - 4, directIOAlignment - 3, directIOAlignment - 1, directIOAlignment} {
+		pad := paddingFrame(size)
+		if (size+int64(len(pad)))%directIOAlignment != 0 {
+			t.Errorf("padding %d bytes leaves %d unaligned", size, size+int64(len(pad)))
+		}
+		if len(pad) != 0 && len(pad) < 4 {
+			t.Errorf("padding %d bytes is %d bytes, too short for its length prefix", size, len(pad))
+		}
+	}
+}
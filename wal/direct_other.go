@@ -0,0 +1,13 @@
+//go:build !linux
+
+package wal
+
+import (
+	"errors"
+	"os"
+)
+
+// openDirect fails: direct I/O is only supported on Linux
+func openDirect(path string) (*os.File, error) {
+	return nil, errors.New("direct i/o is only supported on linux")
+}
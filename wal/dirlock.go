@@ -0,0 +1,91 @@
+package wal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// lockFileName is the file in a log's directory that Open holds an exclusive lock on
+const lockFileName = "LOCK"
+
+// ErrLocked is returned by Open when another WAL, usually in another process, holds the log's
+// directory
+var ErrLocked = errors.New("wal directory locked by another process")
+
+// WithoutDirLock makes Open skip locking the log's directory, for filesystems where advisory
+// locks are unsupported or unreliable, such as some network mounts. Nothing then stops two
+// processes appending to the same log and interleaving their records.
+func WithoutDirLock() Option {
+	return func(w *wal) {
+		w.noDirLock = true
+	}
+}
+
+// Open opens the log at path for appending, creating it if needed. It first takes an exclusive
+// lock on a LOCK file next to it, so a directory holds at most one open log: if another WAL,
+// in this process or another, holds it Open fails at once with ErrLocked. The lock is released
+// by Close, or by the OS if the process dies. Locking is only enforced where the OS
+// has flock: Linux, macOS and the BSDs.
+func Open(path string, opts ...Option) (WAL, error) {
+	w := &wal{}
+	for _, opt := range opts {
+		opt(w)
+	}
+
+	if !w.noDirLock {
+		lock, err := lockDir(filepath.Dir(path))
+		if err != nil {
+			return nil, err
+		}
+		w.dirLock = lock
+	}
+
+	if w.quarantine {
+		if err := w.salvage(path); err != nil {
+			w.unlockDir()
+			return nil, err
+		}
+	}
+
+	if w.directIO {
+		storage, err := openDirectStorage(path)
+		if err != nil {
+			w.unlockDir()
+			return nil, err
+		}
+		w.storage = storage
+		return w, nil
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		w.unlockDir()
+		return nil, fmt.Errorf("failed to open wal: %w", err)
+	}
+	w.storage = NewFileStorage(file)
+	return w, nil
+}
+
+// lockDir takes an exclusive lock on dir's LOCK file and returns the open file holding it
+func lockDir(dir string) (*os.File, error) {
+	file, err := os.OpenFile(filepath.Join(dir, lockFileName), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %w", err)
+	}
+	if err := lockFile(file); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%s: %w", dir, err)
+	}
+	return file, nil
+}
+
+// unlockDir releases the directory lock taken by Open, if any
+func (w *wal) unlockDir() {
+	if w.dirLock != nil {
+		// Closing the descriptor drops the lock
+		w.dirLock.Close()
+		w.dirLock = nil
+	}
+}
@@ -0,0 +1,53 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+
+package wal
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/mrdhat/clutchdb/command"
+)
+
+func TestOpenLocksDirectory(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "000001.wal")
+
+	first, err := Open(path)
+	if err != nil {
+		t.Fatalf("failed to open: %v", err)
+	}
+	if err := first.Append(command.Command{Type: command.CmdAcquire, LockID: "lock1", OwnerID: "owner1", TTLMillis: 1000}); err != nil {
+		t.Fatalf("failed to append: %v", err)
+	}
+
+	// Any log in the same directory is refused while the first is open
+	for _, other := range []string{path, filepath.Join(dir, "000002.wal")} {
+		if _, err := Open(other); !errors.Is(err, ErrLocked) {
+			t.Errorf("second open of %s returned %v, want ErrLocked", other, err)
+		}
+	}
+	// unless locking is turned off
+	unlocked, err := Open(path, WithoutDirLock())
+	if err != nil {
+		t.Fatalf("failed to open without lock: %v", err)
+	}
+	unlocked.Close()
+
+	if err := first.Close(); err != nil {
+		t.Fatalf("failed to close: %v", err)
+	}
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatalf("failed to reopen after close: %v", err)
+	}
+	defer reopened.Close()
+	cmds, err := reopened.ReadAll()
+	if err != nil {
+		t.Fatalf("failed to read: %v", err)
+	}
+	if len(cmds) != 1 || cmds[0].LockID != "lock1" {
+		t.Errorf("reopened log has %+v, want the one acquire", cmds)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package wal
+
+import "os"
+
+// lockFile is a no-op where flock isn't available, including Windows, Solaris and AIX
+func lockFile(file *os.File) error {
+	return nil
+}
@@ -0,0 +1,82 @@
+package wal
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrSyncFailed is returned by Append under WithGroupSync, and by AppendSync, when the record was
+// written but the sync covering it failed. The record is discarded from the log unless the error
+// also wraps ErrNotRolledBack, in which case recovery may still replay it.
+var ErrSyncFailed = errors.New("group sync failed")
+
+// WithGroupSync makes Append return only once its record is durable, syncing for many appends at
+// once: a sync runs maxDelay after the first unsynced append, or as soon as maxPendingBytes have
+// been appended since the last sync, whichever comes first. Quiet periods then sync promptly
+// while bursts share one fsync. Sync still syncs at once, covering any appends waiting.
+// maxPendingBytes of 0 leaves only the delay.
+func WithGroupSync(maxDelay time.Duration, maxPendingBytes int) Option {
+	return func(w *wal) {
+		w.groupDelay = maxDelay
+		w.groupBytes = maxPendingBytes
+	}
+}
+
+// DurableAppender is implemented by WALs whose Append can wait for the record to be synced.
+// Callers that sync after every append can skip the Sync when DurableAppends reports true.
+type DurableAppender interface {
+	DurableAppends() bool
+}
+
+// DurableAppends reports whether Append waits for its record to be synced, under WithGroupSync
+func (w *wal) DurableAppends() bool {
+	return w.groupDelay > 0
+}
+
+// syncBatch is the set of appends waiting on one group sync
+type syncBatch struct {
+	done  chan struct{} // closed once err is set
+	err   error
+	bytes int
+	timer *time.Timer
+}
+
+// joinBatchLocked adds an append of n bytes to the pending group sync, starting one if needed,
+// and returns it for the caller to wait on. It returns nil without WithGroupSync. Must be called
+// with w.mu held.
+func (w *wal) joinBatchLocked(n int) *syncBatch {
+	if w.groupDelay <= 0 {
+		return nil
+	}
+	batch := w.batch
+	if batch == nil {
+		batch = &syncBatch{done: make(chan struct{})}
+		batch.timer = time.AfterFunc(w.groupDelay, func() {
+			w.mu.Lock()
+			defer w.mu.Unlock()
+			// A size-triggered or explicit sync may already have covered it
+			if w.batch == batch && !w.closed {
+				w.syncLocked()
+			}
+		})
+		w.batch = batch
+	}
+	batch.bytes += n
+	if w.groupBytes > 0 && batch.bytes >= w.groupBytes {
+		w.syncLocked()
+	}
+	return batch
+}
+
+// completeBatchLocked releases the appends waiting on the pending group sync with the result of
+// a sync that covers them. Must be called with w.mu held.
+func (w *wal) completeBatchLocked(err error) {
+	batch := w.batch
+	if batch == nil {
+		return
+	}
+	w.batch = nil
+	batch.timer.Stop()
+	batch.err = err
+	close(batch.done)
+}
@@ -0,0 +1,89 @@
+package wal
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mrdhat/clutchdb/command"
+)
+
+// countingSyncStorage counts syncs
+type countingSyncStorage struct {
+	WALStorage
+	syncs atomic.Int32
+}
+
+func (s *countingSyncStorage) Sync() error {
+	s.syncs.Add(1)
+	return s.WALStorage.Sync()
+}
+
+func TestGroupSyncMaxDelay(t *testing.T) {
+	storage := &countingSyncStorage{WALStorage: NewMemoryStorage()}
+	const maxDelay = 20 * time.Millisecond
+	w := NewWALWithStorage(storage, WithGroupSync(maxDelay, 1<<20))
+	defer w.Close()
+
+	// A lone append is far below the size threshold, so the delay alone syncs it
+	start := time.Now()
+	if err := w.Append(command.Command{Type: command.CmdAcquire, LockID: "lock1", OwnerID: "owner1", FencingToken: 1}); err != nil {
+		t.Fatalf("failed to append: %v", err)
+	}
+	elapsed := time.Since(start)
+	if elapsed < maxDelay || elapsed > maxDelay+time.Second {
+		t.Errorf("expected the append to return after about %v, took %v", maxDelay, elapsed)
+	}
+	if n := storage.syncs.Load(); n != 1 {
+		t.Errorf("expected 1 sync, got %d", n)
+	}
+}
+
+func TestGroupSyncMaxPendingBytes(t *testing.T) {
+	storage := &countingSyncStorage{WALStorage: NewMemoryStorage()}
+	cmd := command.Command{Type: command.CmdAcquire, LockID: "lock1", OwnerID: "owner1", FencingToken: 1}
+	const n = 4
+	w := NewWALWithStorage(storage, WithGroupSync(time.Hour, n*len(EncodeRecord(cmd))))
+	defer w.Close()
+
+	// None of the appends can return before the last one fills the window, then all share a sync
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- w.Append(cmd)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("failed to append: %v", err)
+		}
+	}
+	if got := storage.syncs.Load(); got != 1 {
+		t.Errorf("expected %d appends to share 1 sync, got %d", n, got)
+	}
+
+	cmds, err := w.ReadAll()
+	if err != nil {
+		t.Fatalf("failed to read all: %v", err)
+	}
+	if len(cmds) != n {
+		t.Errorf("expected %d records, got %d", n, len(cmds))
+	}
+}
+
+func TestGroupSyncFailure(t *testing.T) {
+	storage := &failingSyncStorage{WALStorage: NewMemoryStorage(), fail: true}
+	w := NewWALWithStorage(storage, WithGroupSync(time.Millisecond, 0))
+
+	err := w.Append(command.Command{Type: command.CmdAcquire, LockID: "lock1", OwnerID: "owner1", FencingToken: 1})
+	if !errors.Is(err, ErrSyncFailed) {
+		t.Errorf("expected ErrSyncFailed, got %v", err)
+	}
+}
@@ -0,0 +1,275 @@
+package wal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/mrdhat/clutchdb/command"
+)
+
+// corruptExt is appended to the name of a log moved aside by WithQuarantine
+const corruptExt = ".corrupt"
+
+// reportExt is appended to a quarantined log's name for the report describing it
+const reportExt = ".report"
+
+// QuarantineReport describes a damaged log that Open moved aside under WithQuarantine
+type QuarantineReport struct {
+	Path        string // the log, now holding only the records before the damage
+	CorruptPath string // the damaged log as it was found, Path plus ".corrupt"
+	ReportPath  string // a text copy of this report, CorruptPath plus ".report"
+	BadOffset   int64  // byte offset in CorruptPath of the first record that couldn't be read
+	Salvaged    int    // records before BadOffset, kept in Path
+	Err         error  // why the record at BadOffset couldn't be read
+}
+
+// Quarantiner is implemented by WALs that may have moved a damaged log aside when opened
+type Quarantiner interface {
+	// Quarantine returns the report of the damaged log moved aside, false if there was none
+	Quarantine() (QuarantineReport, bool)
+}
+
+// WithQuarantine makes Open salvage a log with a damaged record rather than leave it for ReadAll
+// to fail on: the records before the damage are rewritten to a fresh log at the same path, the
+// damaged log is kept beside it with a ".corrupt" suffix and a report of where the damage starts
+// is written next to that. Every record from the damaged one on is lost, so this trades losing
+// holds, and the fencing tokens they carried, for being able to start at all. Callers should
+// alert on it; Quarantiner reports what happened.
+//
+// A record cut short at the end of the log, as a crash mid-append leaves, is not damage: Open
+// cuts it off, as it was never durable. A record is only taken for torn if no complete record
+// follows it, so a damaged length that runs past the records after it is quarantined. Nor are a
+// failing disk, records from a newer writer or a log whose first record doesn't decrypt, which
+// more likely means the wrong key, damage: Open fails on those instead. It also fails if
+// an earlier quarantined log is still in the way, rather than overwrite it; one left by a
+// quarantine of this same log that crashed part way is picked up where it stopped.
+func WithQuarantine() Option {
+	return func(w *wal) {
+		w.quarantine = true
+	}
+}
+
+func (w *wal) Quarantine() (QuarantineReport, bool) {
+	if w.quarantined == nil {
+		return QuarantineReport{}, false
+	}
+	return *w.quarantined, true
+}
+
+// salvage reads the log at path and quarantines it if it finds a damaged record
+func (w *wal) salvage(path string) error {
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open wal: %w", err)
+	}
+	src := &readErrReader{r: file}
+	cmds, badOffset, readErr := w.readPrefix(src, &logPosition{})
+	file.Close()
+
+	switch {
+	case readErr == nil:
+		return nil
+	case src.err != nil:
+		return fmt.Errorf("failed to read wal: %w", readErr)
+	case readErr == io.ErrUnexpectedEOF:
+		torn, err := w.tornTail(path, badOffset)
+		if err != nil {
+			return err
+		}
+		if torn {
+			return cutTornTail(path, badOffset)
+		}
+	case errors.Is(readErr, ErrUnsupportedVersion),
+		errors.Is(readErr, ErrRecordAuthentication) && len(cmds) == 0:
+		return fmt.Errorf("failed to read wal: %w", readErr)
+	}
+
+	report := QuarantineReport{
+		Path:        path,
+		CorruptPath: path + corruptExt,
+		ReportPath:  path + corruptExt + reportExt,
+		BadOffset:   badOffset,
+		Salvaged:    len(cmds),
+		Err:         readErr,
+	}
+	if readErr == io.ErrUnexpectedEOF {
+		readErr = fmt.Errorf("record length runs past the records after it: %w", readErr)
+		report.Err = readErr
+	}
+	if err := w.quarantineLog(report, cmds); err != nil {
+		return fmt.Errorf("failed to quarantine damaged wal (%v): %w", readErr, err)
+	}
+	w.quarantined = &report
+	return nil
+}
+
+// tornTail reports whether the record at offset in the log at path, which runs past the end of
+// the log, was cut short by a crash mid-append rather than damaged. A torn record is the last
+// thing in the log, so it can't be longer than a record can be, and no complete record can start
+// within it; a damaged length prefix is told apart by the records still following it.
+func (w *wal) tornTail(path string, offset int64) (bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, fmt.Errorf("failed to open wal: %w", err)
+	}
+	defer file.Close()
+
+	limit := w.maxFrameSize()
+	rest, err := io.ReadAll(io.NewSectionReader(file, offset, limit+1))
+	if err != nil {
+		return false, fmt.Errorf("failed to read wal: %w", err)
+	}
+	if int64(len(rest)) > limit {
+		return false, nil
+	}
+
+	var scratch []byte
+	for p := 1; p+8 <= len(rest); p++ {
+		// Only try lengths that fit, so a stray huge one isn't allocated
+		length := binary.BigEndian.Uint32(rest[p:])
+		if length&padFlag != 0 || length < 4 || int(length) > len(rest)-p-4 {
+			continue
+		}
+		if _, err := w.readRecord(bytes.NewReader(rest[p:p+4+int(length)]), &logPosition{offset: offset + int64(p)}, &scratch); err == nil {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// maxFrameSize is the most bytes one framed record can take up, with every ID at its longest
+func (w *wal) maxFrameSize() int64 {
+	payload := 1 + 1 + 16 + 3*(2+math.MaxUint16) + 3*binary.MaxVarintLen64
+	if w.aead != nil {
+		payload += 8 + w.aead.NonceSize() + w.aead.Overhead()
+	}
+	return int64(4 + 4 + payload)
+}
+
+// cutTornTail truncates the log at path to the offset where its torn final record starts
+func cutTornTail(path string, offset int64) error {
+	file, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open wal: %w", err)
+	}
+	err = file.Truncate(offset)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to cut torn record: %w", err)
+	}
+	return nil
+}
+
+// quarantineLog keeps the log at report.Path as report.CorruptPath, writes the report beside it
+// and replaces the log with one holding only cmds. The log is replaced by a rename, so a crash
+// part way leaves the damaged log in place to be salvaged again; each step before it can be
+// redone over what an interrupted run left.
+func (w *wal) quarantineLog(report QuarantineReport, cmds []command.Command) error {
+	tmp, err := os.CreateTemp(filepath.Dir(report.Path), filepath.Base(report.Path)+".salvage-*")
+	if err != nil {
+		return fmt.Errorf("failed to create salvaged log: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+
+	salvaged := &wal{storage: NewFileStorage(tmp), aead: w.aead, varints: w.varints}
+	for i, cmd := range cmds {
+		if err := salvaged.Append(cmd); err != nil {
+			salvaged.Close()
+			return fmt.Errorf("failed to write salvaged record %d: %w", i, err)
+		}
+	}
+	if err := salvaged.Close(); err != nil {
+		return fmt.Errorf("failed to write salvaged log: %w", err)
+	}
+
+	// A second link keeps the damaged log under its new name once the salvaged one replaces it
+	if err := keepDamaged(report.Path, report.CorruptPath); err != nil {
+		return fmt.Errorf("failed to keep damaged log: %w", err)
+	}
+	if err := writeReport(report); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), report.Path); err != nil {
+		return fmt.Errorf("failed to replace log: %w", err)
+	}
+	return syncDir(filepath.Dir(report.Path))
+}
+
+// keepDamaged links path as corruptPath. A corruptPath that is already a link to path, as a
+// crash after linking leaves, is kept; any other file there is an earlier quarantine in the way.
+func keepDamaged(path string, corruptPath string) error {
+	err := os.Link(path, corruptPath)
+	if !errors.Is(err, fs.ErrExist) {
+		return err
+	}
+	kept, statErr := os.Stat(corruptPath)
+	if statErr != nil {
+		return statErr
+	}
+	current, statErr := os.Stat(path)
+	if statErr != nil {
+		return statErr
+	}
+	if !os.SameFile(kept, current) {
+		return fmt.Errorf("earlier quarantined log in the way: %w", err)
+	}
+	return nil
+}
+
+// writeReport writes report to report.ReportPath for whoever looks at the quarantined log. It is
+// written under a temporary name and renamed into place, replacing any left by an interrupted run.
+func writeReport(report QuarantineReport) error {
+	text := fmt.Sprintf("quarantined %s at %s\nfirst bad record at byte offset %d: %v\n%d records before it salvaged to %s\n",
+		report.CorruptPath, time.Now().UTC().Format(time.RFC3339), report.BadOffset, report.Err, report.Salvaged, report.Path)
+	file, err := os.CreateTemp(filepath.Dir(report.ReportPath), filepath.Base(report.ReportPath)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create quarantine report: %w", err)
+	}
+	defer os.Remove(file.Name()) // no-op once renamed
+
+	_, err = file.WriteString(text)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), report.ReportPath)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write quarantine report: %w", err)
+	}
+	return nil
+}
+
+// readErrReader remembers the first error its reader returns other than io.EOF, to tell a log
+// that couldn't be read apart from one that was read and found damaged
+type readErrReader struct {
+	r   io.Reader
+	err error
+}
+
+func (r *readErrReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if err != nil && err != io.EOF && r.err == nil {
+		r.err = err
+	}
+	return n, err
+}
@@ -0,0 +1,280 @@
+package wal
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mrdhat/clutchdb/command"
+)
+
+// writeDamagedLog writes records to path and flips a byte in the one at index bad, returning the
+// records and the offset where the damaged one starts
+func writeDamagedLog(t *testing.T, path string, records int, bad int) ([]command.Command, int64) {
+	t.Helper()
+	w, err := Open(path)
+	if err != nil {
+		t.Fatalf("failed to open: %v", err)
+	}
+	var cmds []command.Command
+	var badOffset int64
+	for i := range records {
+		cmd := command.Command{Type: command.CmdAcquire, LockID: fmt.Sprintf("lock%d", i), OwnerID: "owner1", FencingToken: uint64(i + 1), TTLMillis: 1000}
+		if i < bad {
+			badOffset += int64(len(EncodeRecord(cmd)))
+		}
+		if err := w.Append(cmd); err != nil {
+			t.Fatalf("failed to append: %v", err)
+		}
+		cmds = append(cmds, cmd)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("failed to close: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read log: %v", err)
+	}
+	data[badOffset+10] ^= 0xff // inside the payload, past the length and checksum
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatalf("failed to damage log: %v", err)
+	}
+	return cmds, badOffset
+}
+
+func TestOpenWithQuarantine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "000001.wal")
+	cmds, badOffset := writeDamagedLog(t, path, 5, 2)
+	damaged, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read log: %v", err)
+	}
+
+	// Without quarantine the damage fails the read
+	plain, err := Open(path)
+	if err != nil {
+		t.Fatalf("failed to open: %v", err)
+	}
+	if _, err := plain.ReadAll(); err == nil {
+		t.Fatal("expected reading a damaged log to fail")
+	}
+	plain.Close()
+
+	w, err := Open(path, WithQuarantine())
+	if err != nil {
+		t.Fatalf("failed to open with quarantine: %v", err)
+	}
+	defer w.Close()
+
+	report, ok := w.(Quarantiner).Quarantine()
+	if !ok {
+		t.Fatal("expected the log to be quarantined")
+	}
+	if report.BadOffset != badOffset || report.Salvaged != 2 || report.CorruptPath != path+".corrupt" {
+		t.Errorf("unexpected report: %+v", report)
+	}
+
+	// The good prefix is salvaged and the log is usable
+	read, err := w.ReadAll()
+	if err != nil {
+		t.Fatalf("failed to read salvaged log: %v", err)
+	}
+	if !reflect.DeepEqual(read, cmds[:2]) {
+		t.Errorf("expected %+v, got %+v", cmds[:2], read)
+	}
+	if err := w.Append(cmds[4]); err != nil {
+		t.Fatalf("failed to append to salvaged log: %v", err)
+	}
+
+	// and the damaged one is kept as it was, with a report beside it
+	kept, err := os.ReadFile(report.CorruptPath)
+	if err != nil {
+		t.Fatalf("failed to read quarantined log: %v", err)
+	}
+	if !bytes.Equal(kept, damaged) {
+		t.Error("expected the quarantined log to be the damaged one, unchanged")
+	}
+	text, err := os.ReadFile(report.ReportPath)
+	if err != nil {
+		t.Fatalf("failed to read report: %v", err)
+	}
+	if !strings.Contains(string(text), fmt.Sprintf("offset %d", badOffset)) {
+		t.Errorf("expected the report to name offset %d, got %q", badOffset, text)
+	}
+}
+
+func TestOpenWithQuarantineDamagedLength(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "000001.wal")
+	cmds, badOffset := writeDamagedLog(t, path, 5, 2)
+
+	// Restore the payload and damage the length instead, so it runs past the end of the log
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read log: %v", err)
+	}
+	data[badOffset+10] ^= 0xff
+	data[badOffset+2] ^= 0x10
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatalf("failed to damage log: %v", err)
+	}
+
+	w, err := Open(path, WithQuarantine())
+	if err != nil {
+		t.Fatalf("failed to open with quarantine: %v", err)
+	}
+	defer w.Close()
+	report, ok := w.(Quarantiner).Quarantine()
+	if !ok {
+		t.Fatal("expected the log to be quarantined, not taken for a torn tail")
+	}
+	if report.BadOffset != badOffset || report.Salvaged != 2 {
+		t.Errorf("unexpected report: %+v", report)
+	}
+	read, err := w.ReadAll()
+	if err != nil {
+		t.Fatalf("failed to read salvaged log: %v", err)
+	}
+	if !reflect.DeepEqual(read, cmds[:2]) {
+		t.Errorf("expected %+v, got %+v", cmds[:2], read)
+	}
+}
+
+func TestOpenWithQuarantineResumesInterrupted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "000001.wal")
+	cmds, _ := writeDamagedLog(t, path, 5, 2)
+
+	// A crash after keeping the damaged log, with a partial report, but before replacing it
+	if err := os.Link(path, path+".corrupt"); err != nil {
+		t.Fatalf("failed to link: %v", err)
+	}
+	if err := os.WriteFile(path+".corrupt.report", []byte("quarantined"), 0o644); err != nil {
+		t.Fatalf("failed to write report: %v", err)
+	}
+
+	w, err := Open(path, WithQuarantine())
+	if err != nil {
+		t.Fatalf("failed to open with quarantine: %v", err)
+	}
+	defer w.Close()
+	report, ok := w.(Quarantiner).Quarantine()
+	if !ok {
+		t.Fatal("expected the log to be quarantined")
+	}
+	read, err := w.ReadAll()
+	if err != nil {
+		t.Fatalf("failed to read salvaged log: %v", err)
+	}
+	if !reflect.DeepEqual(read, cmds[:2]) {
+		t.Errorf("expected %+v, got %+v", cmds[:2], read)
+	}
+	text, err := os.ReadFile(report.ReportPath)
+	if err != nil {
+		t.Fatalf("failed to read report: %v", err)
+	}
+	if !strings.Contains(string(text), "first bad record") {
+		t.Errorf("expected the report to be rewritten, got %q", text)
+	}
+}
+
+func TestOpenWithQuarantineRefusesEarlierQuarantine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "000001.wal")
+	writeDamagedLog(t, path, 5, 2)
+	if err := os.WriteFile(path+".corrupt", []byte("an older damaged log"), 0o644); err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+
+	if _, err := Open(path, WithQuarantine()); err == nil {
+		t.Fatal("expected an earlier quarantined log to fail the open")
+	}
+	kept, err := os.ReadFile(path + ".corrupt")
+	if err != nil {
+		t.Fatalf("failed to read quarantined log: %v", err)
+	}
+	if string(kept) != "an older damaged log" {
+		t.Error("expected the earlier quarantined log to be left alone")
+	}
+}
+
+func TestOpenWithQuarantineCutsTornTail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "000001.wal")
+	cmds, lastOffset := writeDamagedLog(t, path, 3, 2)
+
+	// A crash part way through appending the last record
+	if err := os.Truncate(path, lastOffset+12); err != nil {
+		t.Fatalf("failed to tear log: %v", err)
+	}
+
+	w, err := Open(path, WithQuarantine())
+	if err != nil {
+		t.Fatalf("failed to open with quarantine: %v", err)
+	}
+	defer w.Close()
+	if _, ok := w.(Quarantiner).Quarantine(); ok {
+		t.Error("expected a torn tail not to be quarantined")
+	}
+	read, err := w.ReadAll()
+	if err != nil {
+		t.Fatalf("failed to read log: %v", err)
+	}
+	if !reflect.DeepEqual(read, cmds[:2]) {
+		t.Errorf("expected %+v, got %+v", cmds[:2], read)
+	}
+	if err := w.Append(cmds[2]); err != nil {
+		t.Fatalf("failed to append: %v", err)
+	}
+	if read, err := w.ReadAll(); err != nil || !reflect.DeepEqual(read, cmds) {
+		t.Errorf("expected %+v after appending, got %+v (%v)", cmds, read, err)
+	}
+}
+
+func TestOpenWithQuarantineLeavesIntactLogs(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "000001.wal")
+	w, err := Open(path, WithQuarantine())
+	if err != nil {
+		t.Fatalf("failed to open new log: %v", err)
+	}
+	if err := w.Append(command.Command{Type: command.CmdAcquire, LockID: "lock1", OwnerID: "owner1", FencingToken: 1}); err != nil {
+		t.Fatalf("failed to append: %v", err)
+	}
+	w.Close()
+
+	w, err = Open(path, WithQuarantine())
+	if err != nil {
+		t.Fatalf("failed to reopen: %v", err)
+	}
+	defer w.Close()
+	if _, ok := w.(Quarantiner).Quarantine(); ok {
+		t.Error("expected an intact log not to be quarantined")
+	}
+	if _, err := os.Stat(path + ".corrupt"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected no quarantined log, got %v", err)
+	}
+}
+
+func TestOpenWithQuarantineRefusesWrongKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "000001.wal")
+	w, err := Open(path, WithCipher(newTestAEAD(t, bytes.Repeat([]byte{0x42}, 32))))
+	if err != nil {
+		t.Fatalf("failed to open: %v", err)
+	}
+	if err := w.Append(command.Command{Type: command.CmdAcquire, LockID: "lock1", OwnerID: "owner1", FencingToken: 1}); err != nil {
+		t.Fatalf("failed to append: %v", err)
+	}
+	w.Close()
+
+	// Nothing decrypts, which looks like the wrong key rather than damage
+	wrongKey := WithCipher(newTestAEAD(t, bytes.Repeat([]byte{0x43}, 32)))
+	if _, err := Open(path, wrongKey, WithQuarantine()); !errors.Is(err, ErrRecordAuthentication) {
+		t.Errorf("expected ErrRecordAuthentication, got %v", err)
+	}
+	if _, err := os.Stat(path + ".corrupt"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected the log not to be quarantined, got %v", err)
+	}
+}
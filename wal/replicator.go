@@ -0,0 +1,241 @@
+package wal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/mrdhat/clutchdb/command"
+)
+
+// ErrFollowerLagging is returned by Stream when a follower can't keep up with new records.
+// The follower should reconnect from the last offset it applied.
+var ErrFollowerLagging = errors.New("follower fell behind")
+
+// Replicator is a WAL that also ships its records to followers. Offsets count records from
+// the start of the log. Records are shipped once they are durable, in log order, using the
+// unencrypted framing of EncodeRecord so followers can decode them with ReadRecord. A record
+// whose sync failed is never shipped: the log discards it, unless the sync error wraps
+// ErrNotRolledBack, in which case it goes out with the next successful sync.
+//
+// A log from this package is watched through an append observer, so appends and group syncs run
+// as concurrently through the Replicator as without it. Any other WAL is appended to one record
+// at a time, holding the Replicator's lock until the record is written, or durable if Append
+// waits for that.
+type Replicator struct {
+	WAL
+
+	mu        sync.Mutex // guards followers and synced; serializes appends unless observed
+	observed  bool       // records are shipped by an observer on the log rather than by Sync
+	pending   [][]byte   // records appended since the last successful Sync, unless observed
+	synced    uint64     // number of records shipped so far
+	followers map[*follower]struct{}
+	buffer    int
+}
+
+// follower is a Stream call waiting for new records
+type follower struct {
+	records chan []byte
+	lagging chan struct{} // closed when the follower is dropped for falling behind
+}
+
+// NewReplicator wraps log so that its records can be streamed to followers. Each follower
+// may have up to buffer records queued; one that falls further behind is dropped rather than
+// slowing down appends.
+func NewReplicator(log WAL, buffer int) (*Replicator, error) {
+	r := &Replicator{
+		WAL:       log,
+		followers: make(map[*follower]struct{}),
+		buffer:    buffer,
+	}
+	if w, ok := log.(*wal); ok {
+		synced, err := w.observeFrom(r.ship)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read log: %w", err)
+		}
+		r.observed = true
+		r.synced = synced
+		return r, nil
+	}
+
+	cmds, err := log.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read log: %w", err)
+	}
+	r.synced = uint64(len(cmds))
+	return r, nil
+}
+
+func (r *Replicator) Append(cmd command.Command) error {
+	if r.observed {
+		return r.WAL.Append(cmd)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.appendLocked(cmd)
+}
+
+// Sync syncs the log and ships every record appended since the last successful Sync
+func (r *Replicator) Sync() error {
+	if r.observed {
+		return r.WAL.Sync()
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.syncLocked()
+}
+
+// AppendSync appends cmd and returns once it is durable and shipped, as the log's AppendSync
+func (r *Replicator) AppendSync(cmd command.Command) error {
+	if r.observed {
+		return r.WAL.(SyncAppender).AppendSync(cmd)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.appendLocked(cmd); err != nil || r.DurableAppends() {
+		return err
+	}
+	if err := r.syncLocked(); err != nil {
+		return fmt.Errorf("%w: %w", ErrSyncFailed, err)
+	}
+	return nil
+}
+
+// DurableAppends reports whether the log's Append waits for its record to be synced
+func (r *Replicator) DurableAppends() bool {
+	durable, ok := r.WAL.(DurableAppender)
+	return ok && durable.DurableAppends()
+}
+
+// appendLocked appends cmd to a log that isn't observed, shipping it at once if the append
+// waited for it to be durable. Must be called with r.mu held.
+func (r *Replicator) appendLocked(cmd command.Command) error {
+	if err := r.WAL.Append(cmd); err != nil {
+		if errors.Is(err, ErrNotRolledBack) {
+			// Still in the log, so followers must get it to stay in step
+			r.pending = append(r.pending, EncodeRecord(cmd))
+		}
+		return err
+	}
+	r.pending = append(r.pending, EncodeRecord(cmd))
+	if r.DurableAppends() {
+		r.shipPendingLocked()
+	}
+	return nil
+}
+
+// syncLocked syncs a log that isn't observed and ships what it made durable. Must be called
+// with r.mu held.
+func (r *Replicator) syncLocked() error {
+	if err := r.WAL.Sync(); err != nil {
+		if !errors.Is(err, ErrNotRolledBack) {
+			// The log discarded them
+			r.pending = nil
+		}
+		return err
+	}
+	r.shipPendingLocked()
+	return nil
+}
+
+// ship sends a durable record to the followers. It is the append observer on an observed log,
+// called with the log locked, which orders it before r.mu.
+func (r *Replicator) ship(cmd command.Command) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sendLocked(EncodeRecord(cmd))
+}
+
+// shipPendingLocked sends the pending records to the followers. Must be called with r.mu held.
+func (r *Replicator) shipPendingLocked() {
+	for _, record := range r.pending {
+		r.sendLocked(record)
+	}
+	r.pending = nil
+}
+
+// sendLocked sends one record to every follower, dropping any that can't take it. Must be
+// called with r.mu held.
+func (r *Replicator) sendLocked(record []byte) {
+	for f := range r.followers {
+		select {
+		case f.records <- record:
+		default:
+			// Never block the commit path on a slow follower
+			delete(r.followers, f)
+			close(f.lagging)
+		}
+	}
+	r.synced++
+}
+
+// Stream writes every durable record from offset onwards to w, then each new one as it is
+// synced, until ctx is done, a write fails or the follower falls behind.
+func (r *Replicator) Stream(ctx context.Context, w io.Writer, offset uint64) error {
+	backlog, f, err := r.subscribe(offset)
+	if err != nil {
+		return err
+	}
+	defer r.unsubscribe(f)
+
+	for _, cmd := range backlog {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if _, err := w.Write(EncodeRecord(cmd)); err != nil {
+			return fmt.Errorf("failed to write record: %w", err)
+		}
+	}
+
+	for {
+		select {
+		case record := <-f.records:
+			if _, err := w.Write(record); err != nil {
+				return fmt.Errorf("failed to write record: %w", err)
+			}
+		case <-f.lagging:
+			return ErrFollowerLagging
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// subscribe registers a follower and returns the durable records from offset up to the point
+// it starts receiving new ones. The log is read after registering, without holding r.mu, so a
+// new follower doesn't stall appends while it catches up.
+func (r *Replicator) subscribe(offset uint64) ([]command.Command, *follower, error) {
+	r.mu.Lock()
+	if offset > r.synced {
+		synced := r.synced
+		r.mu.Unlock()
+		return nil, nil, fmt.Errorf("offset %d beyond end of log at %d", offset, synced)
+	}
+	f := &follower{
+		records: make(chan []byte, r.buffer),
+		lagging: make(chan struct{}),
+	}
+	r.followers[f] = struct{}{}
+	// Records synced from here on are sent to f, so the backlog stops here
+	end := r.synced
+	r.mu.Unlock()
+
+	cmds, err := r.WAL.ReadAll()
+	if err != nil {
+		r.unsubscribe(f)
+		return nil, nil, fmt.Errorf("failed to read log: %w", err)
+	}
+	if uint64(len(cmds)) < end {
+		r.unsubscribe(f)
+		return nil, nil, fmt.Errorf("log has %d records, expected at least %d", len(cmds), end)
+	}
+	return cmds[offset:end], f, nil
+}
+
+func (r *Replicator) unsubscribe(f *follower) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.followers, f)
+}
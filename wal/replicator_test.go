@@ -0,0 +1,263 @@
+package wal
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mrdhat/clutchdb/command"
+)
+
+func TestReplicatorStreamsFromOffset(t *testing.T) {
+	r, err := NewReplicator(NewWALWithStorage(NewMemoryStorage()), 16)
+	if err != nil {
+		t.Fatalf("NewReplicator failed: %v", err)
+	}
+	appendSynced := func(lockID string) {
+		t.Helper()
+		if err := r.Append(command.Command{Type: command.CmdAcquire, LockID: lockID, FencingToken: 1}); err != nil {
+			t.Fatalf("failed to append: %v", err)
+		}
+		if err := r.Sync(); err != nil {
+			t.Fatalf("failed to sync: %v", err)
+		}
+	}
+	appendSynced("lock1")
+	appendSynced("lock2")
+
+	pr, pw := io.Pipe()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- r.Stream(ctx, pw, 1) }()
+
+	// Skips the record before the offset, then follows new ones
+	appendSynced("lock3")
+	for _, want := range []string{"lock2", "lock3"} {
+		cmd, err := ReadRecord(pr)
+		if err != nil {
+			t.Fatalf("ReadRecord failed: %v", err)
+		}
+		if cmd.LockID != want {
+			t.Errorf("expected %s, got %s", want, cmd.LockID)
+		}
+	}
+
+	cancel()
+	pr.Close()
+	if err := <-done; !errors.Is(err, context.Canceled) && !errors.Is(err, io.ErrClosedPipe) {
+		t.Errorf("expected Stream to stop on cancel, got %v", err)
+	}
+}
+
+func TestReplicatorDropsLaggingFollower(t *testing.T) {
+	r, err := NewReplicator(NewWALWithStorage(NewMemoryStorage()), 1)
+	if err != nil {
+		t.Fatalf("NewReplicator failed: %v", err)
+	}
+
+	// The follower never reads, so its first write blocks forever
+	pr, pw := io.Pipe()
+	defer pr.Close()
+	done := make(chan error, 1)
+	go func() { done <- r.Stream(context.Background(), pw, 0) }()
+
+	waitForFollower(t, r)
+	for i := 0; i < 3; i++ {
+		if err := r.Append(command.Command{Type: command.CmdAcquire, LockID: "lock1", FencingToken: uint64(i + 1)}); err != nil {
+			t.Fatalf("failed to append: %v", err)
+		}
+		if err := r.Sync(); err != nil {
+			t.Fatalf("failed to sync: %v", err)
+		}
+	}
+
+	// The leader kept committing and dropped the follower instead of waiting on it
+	r.mu.Lock()
+	n := len(r.followers)
+	r.mu.Unlock()
+	if n != 0 {
+		t.Errorf("expected lagging follower to be dropped, %d still registered", n)
+	}
+
+	pr.Close()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("expected Stream to fail for a stuck follower")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected Stream to return after the follower was dropped")
+	}
+}
+
+// waitForFollower waits until a Stream call has registered with r
+func waitForFollower(t *testing.T, r *Replicator) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		r.mu.Lock()
+		n := len(r.followers)
+		r.mu.Unlock()
+		if n > 0 {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("timed out waiting for follower")
+}
+
+// blockingReadWAL blocks ReadAll until release is closed, once block is set
+type blockingReadWAL struct {
+	WAL
+	block   bool
+	reading chan struct{}
+	release chan struct{}
+}
+
+func (w *blockingReadWAL) ReadAll() ([]command.Command, error) {
+	if w.block {
+		close(w.reading)
+		<-w.release
+	}
+	return w.WAL.ReadAll()
+}
+
+func TestReplicatorAppendsWhileFollowerCatchesUp(t *testing.T) {
+	log := &blockingReadWAL{
+		WAL:     NewWALWithStorage(NewMemoryStorage()),
+		reading: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	r, err := NewReplicator(log, 16)
+	if err != nil {
+		t.Fatalf("NewReplicator failed: %v", err)
+	}
+	appendSynced := func(lockID string) {
+		t.Helper()
+		if err := r.Append(command.Command{Type: command.CmdAcquire, LockID: lockID, FencingToken: 1}); err != nil {
+			t.Fatalf("failed to append: %v", err)
+		}
+		if err := r.Sync(); err != nil {
+			t.Fatalf("failed to sync: %v", err)
+		}
+	}
+	appendSynced("lock1")
+
+	log.block = true
+	pr, pw := io.Pipe()
+	defer pr.Close()
+	go r.Stream(context.Background(), pw, 0)
+	<-log.reading
+
+	// The leader keeps committing while the new follower reads the log
+	appended := make(chan error, 1)
+	go func() {
+		if err := r.Append(command.Command{Type: command.CmdAcquire, LockID: "lock2", FencingToken: 1}); err != nil {
+			appended <- err
+			return
+		}
+		appended <- r.Sync()
+	}()
+	select {
+	case err := <-appended:
+		if err != nil {
+			t.Fatalf("failed to append: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected append to proceed while a follower reads the log")
+	}
+	close(log.release)
+
+	// The follower gets the backlog, then the record synced while it was read, once each
+	for _, want := range []string{"lock1", "lock2"} {
+		cmd, err := ReadRecord(pr)
+		if err != nil {
+			t.Fatalf("ReadRecord failed: %v", err)
+		}
+		if cmd.LockID != want {
+			t.Errorf("expected %s, got %s", want, cmd.LockID)
+		}
+	}
+}
+
+func TestReplicatorKeepsGroupSync(t *testing.T) {
+	storage := &countingSyncStorage{WALStorage: NewMemoryStorage()}
+	cmd := command.Command{Type: command.CmdAcquire, LockID: "lock1", OwnerID: "owner1", FencingToken: 1}
+	const n = 4
+	r, err := NewReplicator(NewWALWithStorage(storage, WithGroupSync(time.Hour, n*len(EncodeRecord(cmd)))), n)
+	if err != nil {
+		t.Fatalf("NewReplicator failed: %v", err)
+	}
+	defer r.Close()
+	if !r.DurableAppends() {
+		t.Fatal("expected the replicator to report durable appends")
+	}
+
+	pr, pw := io.Pipe()
+	defer pr.Close()
+	go r.Stream(context.Background(), pw, 0)
+	waitForFollower(t, r)
+
+	// Appends only return once the last fills the window, so serializing them would hang
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- r.Append(cmd)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("failed to append: %v", err)
+		}
+	}
+	if got := storage.syncs.Load(); got != 1 {
+		t.Errorf("expected %d appends to share 1 sync, got %d", n, got)
+	}
+
+	// Shipped without a Sync call, since the appends were already durable
+	for i := 0; i < n; i++ {
+		if _, err := ReadRecord(pr); err != nil {
+			t.Fatalf("ReadRecord failed: %v", err)
+		}
+	}
+}
+
+func TestReplicatorSkipsFailedSync(t *testing.T) {
+	storage := &failingMemoryStorage{memoryStorage: &memoryStorage{}}
+	r, err := NewReplicator(NewWALWithStorage(storage), 16)
+	if err != nil {
+		t.Fatalf("NewReplicator failed: %v", err)
+	}
+
+	pr, pw := io.Pipe()
+	defer pr.Close()
+	go r.Stream(context.Background(), pw, 0)
+	waitForFollower(t, r)
+
+	for i, lockID := range []string{"lock1", "lock2", "lock3"} {
+		storage.fail = i == 1
+		err := r.AppendSync(command.Command{Type: command.CmdAcquire, LockID: lockID, FencingToken: 1})
+		if storage.fail != errors.Is(err, ErrSyncFailed) {
+			t.Fatalf("unexpected result appending %s: %v", lockID, err)
+		}
+	}
+
+	// The record rolled back by the failed sync never reaches the follower
+	for _, want := range []string{"lock1", "lock3"} {
+		cmd, err := ReadRecord(pr)
+		if err != nil {
+			t.Fatalf("ReadRecord failed: %v", err)
+		}
+		if cmd.LockID != want {
+			t.Errorf("expected %s, got %s", want, cmd.LockID)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package wal
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/mrdhat/clutchdb/command"
+)
+
+// ErrNotRolledBack is wrapped in the error from a failed sync when the records it covered could
+// not be discarded, so recovery may still replay them. If the storage failed part way through
+// discarding them, the WAL is broken and every later Append and Sync fails too.
+var ErrNotRolledBack = errors.New("unsynced records left in the log")
+
+// SyncAppender is implemented by WALs that can append a record and sync it in one step, telling
+// the caller whether its own record is durable. With separate Append and Sync calls, a sync that
+// fails also discards records other goroutines appended and have yet to sync.
+type SyncAppender interface {
+	AppendSync(cmd command.Command) error
+}
+
+// unsyncedDiscarder is implemented by storage backends that can drop every record written since
+// their last successful Sync
+type unsyncedDiscarder interface {
+	discardUnsynced() error
+}
+
+// AppendSync appends cmd and returns once it is durable. If the sync fails, cmd is discarded from
+// the log along with any other unsynced records and the error wraps ErrSyncFailed. Under
+// WithGroupSync it is Append.
+func (w *wal) AppendSync(cmd command.Command) error {
+	if w.groupDelay > 0 {
+		return w.Append(cmd)
+	}
+	payload := w.recordPayload(cmd)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return ErrClosed
+	}
+	if w.broken != nil {
+		return w.broken
+	}
+	if _, err := w.writeRecordLocked(payload); err != nil {
+		return err
+	}
+	if len(w.observers) > 0 {
+		w.unsynced = append(w.unsynced, cmd)
+	}
+	if err := w.syncLocked(); err != nil {
+		return fmt.Errorf("%w: %w", ErrSyncFailed, err)
+	}
+	return nil
+}
+
+// rollbackLocked discards the records a failed sync covered, so a command reported as failed is
+// never replayed. It returns ErrNotRolledBack if they stay in the log. Must be called with w.mu
+// held.
+func (w *wal) rollbackLocked() error {
+	storage, ok := w.storage.(unsyncedDiscarder)
+	if !ok {
+		// Kept for observers, who see them once a later sync covers them
+		return ErrNotRolledBack
+	}
+	if err := storage.discardUnsynced(); err != nil {
+		w.broken = fmt.Errorf("wal broken by a failed rollback: %w", err)
+		return fmt.Errorf("%w: %w", ErrNotRolledBack, err)
+	}
+	w.unsynced = nil
+	w.seq = w.syncedSeq
+	return nil
+}
+
+func (s *fileStorage) discardUnsynced() error {
+	if s.unsynced == 0 {
+		return nil
+	}
+	if err := s.rollback(s.end - s.unsynced); err != nil {
+		return err
+	}
+	s.unsynced = 0
+	return nil
+}
+
+func (s *memoryStorage) discardUnsynced() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.buf.Truncate(s.buf.Len() - min(s.unsynced, s.buf.Len()))
+	s.unsynced = 0
+	return nil
+}
+
+func (s *directStorage) discardUnsynced() error {
+	if s.unsynced == 0 {
+		return nil
+	}
+	end := s.end - s.unsynced
+	if err := s.file.Truncate(end); err != nil {
+		return err
+	}
+	s.end = end
+	s.unsynced = 0
+	return nil
+}
@@ -0,0 +1,228 @@
+package wal
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mrdhat/clutchdb/command"
+)
+
+// failingSyncFile wraps a file, failing Sync while failSync is set and Truncate while
+// failTruncate is
+type failingSyncFile struct {
+	*os.File
+	failSync     bool
+	failTruncate bool
+}
+
+func (f *failingSyncFile) Sync() error {
+	if f.failSync {
+		return errors.New("sync failed")
+	}
+	return f.File.Sync()
+}
+
+func (f *failingSyncFile) Truncate(size int64) error {
+	if f.failTruncate {
+		return errors.New("truncate failed")
+	}
+	return f.File.Truncate(size)
+}
+
+func TestAppendSyncRollsBackFailedSync(t *testing.T) {
+	cmd := func(token uint64) command.Command {
+		return command.Command{Type: command.CmdAcquire, LockID: "lock1", OwnerID: "owner1", FencingToken: token}
+	}
+
+	for _, preallocated := range []int64{0, 4096} {
+		path := filepath.Join(t.TempDir(), "wal")
+		file, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f := &failingSyncFile{File: file}
+		var storage WALStorage = &fileStorage{file: f}
+		if preallocated > 0 {
+			if storage, err = NewPreallocatedFileStorage(file, preallocated); err != nil {
+				t.Fatal(err)
+			}
+			storage.(*fileStorage).file = f
+		}
+		var seen []command.Command
+		w := NewWALWithStorage(storage, WithAppendObserver(func(cmd command.Command) {
+			seen = append(seen, cmd)
+		}))
+		appender := w.(SyncAppender)
+
+		if err := appender.AppendSync(cmd(1)); err != nil {
+			t.Fatalf("failed to append: %v", err)
+		}
+
+		// The record whose sync failed is gone, as is one appended before it without a sync
+		if err := w.Append(cmd(2)); err != nil {
+			t.Fatalf("failed to append: %v", err)
+		}
+		f.failSync = true
+		err = appender.AppendSync(cmd(3))
+		if !errors.Is(err, ErrSyncFailed) || errors.Is(err, ErrNotRolledBack) {
+			t.Fatalf("expected a rolled back ErrSyncFailed, got %v", err)
+		}
+
+		f.failSync = false
+		if err := appender.AppendSync(cmd(4)); err != nil {
+			t.Fatalf("failed to append after a failed sync: %v", err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("failed to close: %v", err)
+		}
+
+		reopened, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := (&wal{}).readRecords(reopened, false)
+		reopened.Close()
+		if err != nil {
+			t.Fatalf("failed to read log: %v", err)
+		}
+		if len(got) != 2 || got[0] != cmd(1) || got[1] != cmd(4) {
+			t.Errorf("preallocated %d: expected only the synced records, got %+v", preallocated, got)
+		}
+		if len(seen) != 2 || seen[0] != cmd(1) || seen[1] != cmd(4) {
+			t.Errorf("preallocated %d: expected observers to see only the synced records, got %+v", preallocated, seen)
+		}
+	}
+}
+
+func TestSyncRollsBackMemoryStorage(t *testing.T) {
+	storage := &failingMemoryStorage{memoryStorage: &memoryStorage{}}
+	w := NewWALWithStorage(storage)
+
+	kept := command.Command{Type: command.CmdAcquire, LockID: "lock1", OwnerID: "owner1", FencingToken: 1}
+	if err := w.Append(kept); err != nil {
+		t.Fatalf("failed to append: %v", err)
+	}
+	if err := w.Sync(); err != nil {
+		t.Fatalf("failed to sync: %v", err)
+	}
+
+	storage.fail = true
+	if err := w.Append(command.Command{Type: command.CmdRelease, LockID: "lock1", OwnerID: "owner1", FencingToken: 1}); err != nil {
+		t.Fatalf("failed to append: %v", err)
+	}
+	if err := w.Sync(); err == nil || errors.Is(err, ErrNotRolledBack) {
+		t.Fatalf("expected a rolled back sync failure, got %v", err)
+	}
+
+	got, err := w.ReadAll()
+	if err != nil {
+		t.Fatalf("failed to read all: %v", err)
+	}
+	if len(got) != 1 || got[0] != kept {
+		t.Errorf("expected only the synced record, got %+v", got)
+	}
+}
+
+func TestSyncRollbackKeepsRecordSequence(t *testing.T) {
+	storage := &failingMemoryStorage{memoryStorage: &memoryStorage{}}
+	block, err := aes.NewCipher(bytes.Repeat([]byte{0x42}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := NewWALWithStorage(storage, WithCipher(aead))
+
+	cmd := func(token uint64) command.Command {
+		return command.Command{Type: command.CmdAcquire, LockID: "lock1", OwnerID: "owner1", FencingToken: token}
+	}
+	if err := w.Append(cmd(1)); err != nil {
+		t.Fatalf("failed to append: %v", err)
+	}
+	if err := w.Sync(); err != nil {
+		t.Fatalf("failed to sync: %v", err)
+	}
+	storage.fail = true
+	if err := w.Append(cmd(2)); err != nil {
+		t.Fatalf("failed to append: %v", err)
+	}
+	if err := w.Sync(); err == nil {
+		t.Fatal("expected the sync to fail")
+	}
+
+	// The record after the rolled back one takes its sequence number, leaving no gap
+	storage.fail = false
+	if err := w.Append(cmd(3)); err != nil {
+		t.Fatalf("failed to append: %v", err)
+	}
+	got, err := w.ReadAll()
+	if err != nil {
+		t.Fatalf("failed to read all: %v", err)
+	}
+	if len(got) != 2 || got[0] != cmd(1) || got[1] != cmd(3) {
+		t.Errorf("expected the records either side of the rollback, got %+v", got)
+	}
+}
+
+func TestSyncFailureWithoutRollback(t *testing.T) {
+	// Storage that can't discard records keeps them and says so
+	storage := &failingSyncStorage{WALStorage: NewMemoryStorage(), fail: true}
+	w := NewWALWithStorage(storage).(SyncAppender)
+
+	err := w.AppendSync(command.Command{Type: command.CmdAcquire, LockID: "lock1", OwnerID: "owner1", FencingToken: 1})
+	if !errors.Is(err, ErrSyncFailed) || !errors.Is(err, ErrNotRolledBack) {
+		t.Fatalf("expected ErrSyncFailed and ErrNotRolledBack, got %v", err)
+	}
+	got, err := w.(WAL).ReadAll()
+	if err != nil {
+		t.Fatalf("failed to read all: %v", err)
+	}
+	if len(got) != 1 {
+		t.Errorf("expected the record to stay in the log, got %d records", len(got))
+	}
+}
+
+func TestFailedRollbackBreaksWAL(t *testing.T) {
+	file, err := os.Create(filepath.Join(t.TempDir(), "wal"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &failingSyncFile{File: file, failSync: true, failTruncate: true}
+	w := NewWALWithStorage(&fileStorage{file: f})
+	defer w.Close()
+
+	cmd := command.Command{Type: command.CmdAcquire, LockID: "lock1", OwnerID: "owner1", FencingToken: 1}
+	err = w.(SyncAppender).AppendSync(cmd)
+	if !errors.Is(err, ErrSyncFailed) || !errors.Is(err, ErrNotRolledBack) {
+		t.Fatalf("expected ErrSyncFailed and ErrNotRolledBack, got %v", err)
+	}
+
+	// Nothing more may land after records in an unknown state
+	f.failSync, f.failTruncate = false, false
+	if err := w.Append(cmd); err == nil {
+		t.Error("expected append to a broken wal to fail")
+	}
+	if err := w.Sync(); err == nil {
+		t.Error("expected sync of a broken wal to fail")
+	}
+}
+
+// failingMemoryStorage is memory storage whose Sync fails while fail is set
+type failingMemoryStorage struct {
+	*memoryStorage
+	fail bool
+}
+
+func (s *failingMemoryStorage) Sync() error {
+	if s.fail {
+		return errors.New("sync failed")
+	}
+	return s.memoryStorage.Sync()
+}
@@ -0,0 +1,170 @@
+package wal
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mrdhat/clutchdb/command"
+)
+
+// writeSegment writes cmds to a new segment named name in dir
+func writeSegment(t *testing.T, dir, name string, cmds ...command.Command) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	w := NewWAL(file)
+	for _, cmd := range cmds {
+		if err := w.Append(cmd); err != nil {
+			t.Fatalf("failed to append: %v", err)
+		}
+	}
+	if err := w.Sync(); err != nil {
+		t.Fatalf("failed to sync: %v", err)
+	}
+	return path
+}
+
+func TestReadAllDir(t *testing.T) {
+	dir := t.TempDir()
+
+	// 8, 9, 10 sorts incorrectly as strings, so this checks the numeric ordering
+	writeSegment(t, dir, "10.wal", command.Command{Type: command.CmdRelease, LockID: "lock3", FencingToken: 3})
+	writeSegment(t, dir, "8.wal",
+		command.Command{Type: command.CmdAcquire, LockID: "lock1", FencingToken: 1},
+		command.Command{Type: command.CmdAcquire, LockID: "lock2", FencingToken: 1},
+	)
+	writeSegment(t, dir, "9.wal", command.Command{Type: command.CmdAcquire, LockID: "lock3", FencingToken: 3})
+
+	// Non-segment files are ignored
+	if err := os.WriteFile(filepath.Join(dir, "README"), []byte("not a segment"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cmds, err := ReadAllDir(dir)
+	if err != nil {
+		t.Fatalf("ReadAllDir failed: %v", err)
+	}
+
+	want := []string{"lock1", "lock2", "lock3", "lock3"}
+	if len(cmds) != len(want) {
+		t.Fatalf("expected %d commands, got %d", len(want), len(cmds))
+	}
+	for i, lockID := range want {
+		if cmds[i].LockID != lockID {
+			t.Errorf("command %d: expected lock %s, got %s", i, lockID, cmds[i].LockID)
+		}
+	}
+	if cmds[3].Type != command.CmdRelease {
+		t.Errorf("expected last command to be the release, got type %d", cmds[3].Type)
+	}
+}
+
+func TestReadAllDirZeroPadded(t *testing.T) {
+	dir := t.TempDir()
+	writeSegment(t, dir, "000001.wal", command.Command{Type: command.CmdAcquire, LockID: "lock1"})
+	writeSegment(t, dir, "000002.wal", command.Command{Type: command.CmdAcquire, LockID: "lock2"})
+
+	cmds, err := ReadAllDir(dir)
+	if err != nil {
+		t.Fatalf("ReadAllDir failed: %v", err)
+	}
+	if len(cmds) != 2 || cmds[0].LockID != "lock1" || cmds[1].LockID != "lock2" {
+		t.Errorf("unexpected commands: %+v", cmds)
+	}
+}
+
+func TestReadAllDirPreallocatedSegments(t *testing.T) {
+	dir := t.TempDir()
+	first := writeSegment(t, dir, "1.wal",
+		command.Command{Type: command.CmdAcquire, LockID: "lock1"},
+		command.Command{Type: command.CmdRelease, LockID: "lock1"},
+	)
+	last := writeSegment(t, dir, "2.wal", command.Command{Type: command.CmdAcquire, LockID: "lock2"})
+
+	// Both segments were pre-allocated and only partly written, so each ends in zeros
+	for _, path := range []string{first, last} {
+		if err := os.Truncate(path, 64<<10); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cmds, err := ReadAllDir(dir)
+	if err != nil {
+		t.Fatalf("ReadAllDir failed: %v", err)
+	}
+	if len(cmds) != 3 || cmds[1].Type != command.CmdRelease || cmds[2].LockID != "lock2" {
+		t.Fatalf("expected only the written records, got %+v", cmds)
+	}
+
+	// A record after the zeros means they are damage, not unwritten space
+	file, err := os.OpenFile(first, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.Write(EncodeRecord(command.Command{Type: command.CmdAcquire, LockID: "lock3"})); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	_, err = ReadAllDir(dir)
+	if !errors.Is(err, ErrDataAfterZeroFill) {
+		t.Fatalf("expected ErrDataAfterZeroFill, got %v", err)
+	}
+}
+
+func TestReadAllDirMissingSegment(t *testing.T) {
+	dir := t.TempDir()
+	writeSegment(t, dir, "1.wal", command.Command{Type: command.CmdAcquire, LockID: "lock1"})
+	writeSegment(t, dir, "3.wal", command.Command{Type: command.CmdAcquire, LockID: "lock2"})
+
+	_, err := ReadAllDir(dir)
+	if err == nil || !strings.Contains(err.Error(), "missing segment") {
+		t.Fatalf("expected missing segment error, got %v", err)
+	}
+}
+
+func TestReadAllDirTornTail(t *testing.T) {
+	dir := t.TempDir()
+	first := writeSegment(t, dir, "1.wal", command.Command{Type: command.CmdAcquire, LockID: "lock1"})
+	last := writeSegment(t, dir, "2.wal",
+		command.Command{Type: command.CmdAcquire, LockID: "lock2"},
+		command.Command{Type: command.CmdAcquire, LockID: "lock3"},
+	)
+
+	// Chop the last record of the final segment in half
+	info, err := os.Stat(last)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(last, info.Size()-5); err != nil {
+		t.Fatal(err)
+	}
+
+	cmds, err := ReadAllDir(dir)
+	if err != nil {
+		t.Fatalf("ReadAllDir failed: %v", err)
+	}
+	if len(cmds) != 2 || cmds[1].LockID != "lock2" {
+		t.Errorf("expected the torn record to be dropped, got %+v", cmds)
+	}
+
+	// The same damage in a non-final segment is fatal
+	info, err = os.Stat(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(first, info.Size()-5); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadAllDir(dir); err == nil {
+		t.Fatal("expected error for torn record in a non-final segment")
+	}
+}
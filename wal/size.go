@@ -0,0 +1,49 @@
+package wal
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Sizer is implemented by WALs that can report how many bytes their log takes up
+type Sizer interface {
+	Size() (int64, error)
+}
+
+// sizedStorage is implemented by storage backends that know how many bytes of records they hold
+type sizedStorage interface {
+	size() (int64, error)
+}
+
+// Size returns how many bytes of records the log holds, framing included. Space pre-allocated
+// past the last record is not counted.
+func (w *wal) Size() (int64, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return 0, ErrClosed
+	}
+	storage, ok := w.storage.(sizedStorage)
+	if !ok {
+		return 0, errors.New("wal storage does not report its size")
+	}
+	return storage.size()
+}
+
+func (s *fileStorage) size() (int64, error) {
+	if s.preallocated > 0 {
+		return s.end, nil
+	}
+	size, err := s.file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get file size: %w", err)
+	}
+	return size, nil
+}
+
+func (s *memoryStorage) size() (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return int64(s.buf.Len()), nil
+}
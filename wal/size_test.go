@@ -0,0 +1,55 @@
+package wal
+
+import (
+	"os"
+	"testing"
+
+	"github.com/mrdhat/clutchdb/command"
+)
+
+func TestWALSize(t *testing.T) {
+	plain, err := os.CreateTemp("", "wal_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(plain.Name())
+
+	preallocated, err := os.CreateTemp("", "wal_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(preallocated.Name())
+	preallocatedStorage, err := NewPreallocatedFileStorage(preallocated, 4096)
+	if err != nil {
+		t.Fatalf("failed to pre-allocate: %v", err)
+	}
+
+	for name, w := range map[string]WAL{
+		"file":         NewWAL(plain),
+		"preallocated": NewWALWithStorage(preallocatedStorage),
+		"memory":       NewWALWithStorage(NewMemoryStorage()),
+	} {
+		sizer := w.(Sizer)
+		if size, err := sizer.Size(); err != nil || size != 0 {
+			t.Errorf("%s: empty log size = %d, %v", name, size, err)
+		}
+
+		cmd := command.Command{Type: command.CmdAcquire, LockID: "lock1", OwnerID: "owner1", TTLMillis: 1000}
+		record := EncodeRecord(cmd)
+		for i := 0; i < 3; i++ {
+			if err := w.Append(cmd); err != nil {
+				t.Fatalf("%s: failed to append: %v", name, err)
+			}
+		}
+		if size, err := sizer.Size(); err != nil || size != int64(3*len(record)) {
+			t.Errorf("%s: size = %d, %v, want %d", name, size, err, 3*len(record))
+		}
+
+		if err := w.Close(); err != nil {
+			t.Fatalf("%s: failed to close: %v", name, err)
+		}
+		if _, err := sizer.Size(); err != ErrClosed {
+			t.Errorf("%s: size after close returned %v, want ErrClosed", name, err)
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package wal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// WALStorage moves framed record bytes to and from a backing store.
+// Framing, checksums and encryption stay in the wal layer; a storage
+// backend only needs to append bytes durably and hand them back in order.
+type WALStorage interface {
+	// WriteRecord appends one fully framed record
+	WriteRecord(record []byte) error
+	// ReadRecords returns a reader over every record written so far, from the beginning
+	ReadRecords() (io.Reader, error)
+	// Sync makes all written records durable
+	Sync() error
+	// Close releases the backend's resources
+	Close() error
+}
+
+// storageFile is the subset of *os.File used by fileStorage
+type storageFile interface {
+	io.ReadWriteSeeker
+	io.Closer
+	Truncate(size int64) error
+	Sync() error
+}
+
+type fileStorage struct {
+	file storageFile
+
+	// preallocated is the size the file is kept grown to, 0 if it isn't pre-allocated. The
+	// records then end at end rather than at the end of the file.
+	preallocated int64
+	end          int64
+	unsynced     int64 // bytes written since the last successful Sync
+}
+
+// NewFileStorage returns a WALStorage backed by file
+func NewFileStorage(file *os.File) WALStorage {
+	return &fileStorage{file: file}
+}
+
+// NewPreallocatedFileStorage returns a WALStorage backed by file that grows file to size bytes up
+// front, so appends write into space that is already allocated instead of extending the file
+// one record at a time. The log ends at the first zero record length rather than at the end of
+// the file. A record that runs past the end of the file was torn by a crash and is discarded.
+func NewPreallocatedFileStorage(file *os.File, size int64) (WALStorage, error) {
+	if size <= 0 {
+		return nil, fmt.Errorf("invalid pre-allocation size: %d", size)
+	}
+	s := &fileStorage{file: file, preallocated: size}
+
+	end, err := s.scanEnd()
+	if err != nil {
+		return nil, err
+	}
+	// Zero anything past the last complete record so later appends can't run into stale bytes
+	if err := s.rollback(end); err != nil {
+		return nil, fmt.Errorf("failed to pre-allocate: %w", err)
+	}
+	return s, nil
+}
+
+// scanEnd walks the record length prefixes from the start of the file and returns the offset
+// just past the last complete record
+func (s *fileStorage) scanEnd() (int64, error) {
+	size, err := s.file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get file size: %w", err)
+	}
+	if _, err := s.file.Seek(0, io.SeekStart); err != nil {
+		return 0, fmt.Errorf("failed to seek to start: %w", err)
+	}
+
+	var offset int64
+	var length [4]byte
+	for {
+		if _, err := io.ReadFull(s.file, length[:]); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return offset, nil
+			}
+			return 0, fmt.Errorf("failed to read record length: %w", err)
+		}
+		prefix := binary.BigEndian.Uint32(length[:])
+		n := int64(prefix &^ padFlag)
+		if prefix == 0 || offset+4+n > size {
+			return offset, nil
+		}
+		offset += 4 + n
+		if _, err := s.file.Seek(offset, io.SeekStart); err != nil {
+			return 0, fmt.Errorf("failed to seek past record: %w", err)
+		}
+	}
+}
+
+// WriteRecord writes all of record at the end of the log, retrying short writes. If the write
+// fails part way, the file is truncated back to where the record started so no torn record is
+// left behind.
+func (s *fileStorage) WriteRecord(record []byte) error {
+	// Reads and reopened handles leave the file offset anywhere, so never write at it
+	var offset int64
+	var err error
+	if s.preallocated > 0 {
+		// The file doesn't end where the records do
+		offset, err = s.file.Seek(s.end, io.SeekStart)
+	} else {
+		offset, err = s.file.Seek(0, io.SeekEnd)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to seek to end of log: %w", err)
+	}
+
+	size := int64(len(record))
+	for len(record) > 0 {
+		n, err := s.file.Write(record)
+		record = record[n:]
+		if err == nil && n == 0 {
+			err = io.ErrShortWrite
+		}
+		if err != nil {
+			if truncErr := s.rollback(offset); truncErr != nil {
+				return fmt.Errorf("failed to write record: %w (rollback failed: %v)", err, truncErr)
+			}
+			return fmt.Errorf("failed to write record: %w", err)
+		}
+	}
+	s.end = offset + size
+	s.unsynced += size
+	return nil
+}
+
+// rollback discards everything written at or after offset. A pre-allocated file is grown back
+// to its full size, which zeroes the discarded bytes.
+func (s *fileStorage) rollback(offset int64) error {
+	if err := s.file.Truncate(offset); err != nil {
+		return err
+	}
+	if s.preallocated > offset {
+		if err := s.file.Truncate(s.preallocated); err != nil {
+			return err
+		}
+	}
+	s.end = offset
+	_, err := s.file.Seek(offset, io.SeekStart)
+	return err
+}
+
+func (s *fileStorage) ReadRecords() (io.Reader, error) {
+	// Seek to the beginning of the file
+	if _, err := s.file.Seek(0, 0); err != nil {
+		return nil, fmt.Errorf("failed to seek to start: %w", err)
+	}
+	if s.preallocated > 0 {
+		return io.LimitReader(s.file, s.end), nil
+	}
+	return s.file, nil
+}
+
+func (s *fileStorage) Sync() error {
+	if err := s.file.Sync(); err != nil {
+		return err
+	}
+	s.unsynced = 0
+	return nil
+}
+
+func (s *fileStorage) Close() error {
+	return s.file.Close()
+}
+
+type memoryStorage struct {
+	mu       sync.Mutex
+	buf      bytes.Buffer
+	unsynced int // bytes written since the last Sync
+}
+
+// NewMemoryStorage returns a WALStorage that keeps records in memory.
+// It is not durable and is meant for tests and tooling.
+func NewMemoryStorage() WALStorage {
+	return &memoryStorage{}
+}
+
+func (s *memoryStorage) WriteRecord(record []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.buf.Write(record)
+	s.unsynced += len(record)
+	return nil
+}
+
+func (s *memoryStorage) ReadRecords() (io.Reader, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return bytes.NewReader(bytes.Clone(s.buf.Bytes())), nil
+}
+
+func (s *memoryStorage) Sync() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.unsynced = 0
+	return nil
+}
+
+func (s *memoryStorage) Close() error {
+	return nil
+}
@@ -0,0 +1,251 @@
+package wal
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/mrdhat/clutchdb/command"
+)
+
+func TestMemoryStorageWAL(t *testing.T) {
+	w := NewWALWithStorage(NewMemoryStorage())
+
+	cmds := []command.Command{
+		{Type: command.CmdAcquire, RequestID: [16]byte{1}, LockID: "lock1", OwnerID: "owner1", TTLMillis: 1000, FencingToken: 1, CommitTimeMillis: 100},
+		{Type: command.CmdRenew, RequestID: [16]byte{2}, LockID: "lock1", OwnerID: "owner1", TTLMillis: 2000, FencingToken: 1, CommitTimeMillis: 200},
+		{Type: command.CmdRelease, RequestID: [16]byte{3}, LockID: "lock1", OwnerID: "owner1", FencingToken: 1, CommitTimeMillis: 300},
+	}
+
+	for _, cmd := range cmds {
+		if err := w.Append(cmd); err != nil {
+			t.Fatalf("failed to append: %v", err)
+		}
+	}
+	if err := w.Sync(); err != nil {
+		t.Fatalf("failed to sync: %v", err)
+	}
+
+	got, err := w.ReadAll()
+	if err != nil {
+		t.Fatalf("failed to read all: %v", err)
+	}
+	if len(got) != len(cmds) {
+		t.Fatalf("expected %d commands, got %d", len(cmds), len(got))
+	}
+	for i := range cmds {
+		if got[i] != cmds[i] {
+			t.Errorf("command %d mismatch: got %+v, want %+v", i, got[i], cmds[i])
+		}
+	}
+
+	// Appending after a read continues the log
+	if err := w.Append(cmds[0]); err != nil {
+		t.Fatalf("failed to append: %v", err)
+	}
+	got, err = w.ReadAll()
+	if err != nil {
+		t.Fatalf("failed to read all: %v", err)
+	}
+	if len(got) != len(cmds)+1 {
+		t.Errorf("expected %d commands, got %d", len(cmds)+1, len(got))
+	}
+}
+
+func TestMemoryStorageEncrypted(t *testing.T) {
+	w := NewWALWithStorage(NewMemoryStorage(), WithCipher(newTestAEAD(t, bytes.Repeat([]byte{0x01}, 16))))
+
+	cmd := command.Command{Type: command.CmdAcquire, LockID: "lock1", OwnerID: "owner1", FencingToken: 9}
+	if err := w.Append(cmd); err != nil {
+		t.Fatalf("failed to append: %v", err)
+	}
+
+	got, err := w.ReadAll()
+	if err != nil {
+		t.Fatalf("failed to read all: %v", err)
+	}
+	if len(got) != 1 || got[0] != cmd {
+		t.Errorf("unexpected commands: %+v", got)
+	}
+}
+
+// shortWriteFile wraps a file, writing at most maxWrite bytes per call and
+// optionally failing once failAfter bytes have been written in total
+type shortWriteFile struct {
+	*os.File
+	maxWrite  int
+	failAfter int
+	written   int
+}
+
+func (f *shortWriteFile) Write(p []byte) (int, error) {
+	if len(p) > f.maxWrite {
+		p = p[:f.maxWrite]
+	}
+	if f.failAfter > 0 && f.written+len(p) > f.failAfter {
+		p = p[:f.failAfter-f.written]
+		n, _ := f.File.Write(p)
+		f.written += n
+		return n, errors.New("disk full")
+	}
+	n, err := f.File.Write(p)
+	f.written += n
+	return n, err
+}
+
+func TestFileStorageShortWrites(t *testing.T) {
+	tmpFile, err := os.CreateTemp("", "wal_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	// Every write is cut to 3 bytes with a nil error
+	w := NewWALWithStorage(&fileStorage{file: &shortWriteFile{File: tmpFile, maxWrite: 3}})
+
+	cmds := []command.Command{
+		{Type: command.CmdAcquire, LockID: "lock1", OwnerID: "owner1", FencingToken: 1},
+		{Type: command.CmdAcquire, LockID: "lock2", OwnerID: "owner2", FencingToken: 1},
+	}
+	for _, cmd := range cmds {
+		if err := w.Append(cmd); err != nil {
+			t.Fatalf("failed to append: %v", err)
+		}
+	}
+
+	got, err := w.ReadAll()
+	if err != nil {
+		t.Fatalf("failed to read all: %v", err)
+	}
+	if len(got) != 2 || got[0] != cmds[0] || got[1] != cmds[1] {
+		t.Errorf("unexpected commands: %+v", got)
+	}
+}
+
+func TestFileStoragePartialWriteRollback(t *testing.T) {
+	tmpFile, err := os.CreateTemp("", "wal_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	good := command.Command{Type: command.CmdAcquire, LockID: "lock1", OwnerID: "owner1", FencingToken: 1}
+	recordSize := 4 + 4 + len(encodePayload(good))
+
+	// The first record fits; the second fails half way through
+	f := &shortWriteFile{File: tmpFile, maxWrite: 1 << 20, failAfter: recordSize + recordSize/2}
+	w := NewWALWithStorage(&fileStorage{file: f})
+
+	if err := w.Append(good); err != nil {
+		t.Fatalf("failed to append: %v", err)
+	}
+	if err := w.Append(good); err == nil {
+		t.Fatal("expected append to fail")
+	}
+
+	info, err := tmpFile.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != int64(recordSize) {
+		t.Errorf("expected file truncated to %d bytes, got %d", recordSize, info.Size())
+	}
+
+	// The log is still readable and appendable after the failed write
+	f.failAfter = 0
+	if err := w.Append(good); err != nil {
+		t.Fatalf("failed to append after rollback: %v", err)
+	}
+	got, err := w.ReadAll()
+	if err != nil {
+		t.Fatalf("failed to read all: %v", err)
+	}
+	if len(got) != 2 {
+		t.Errorf("expected 2 commands, got %d", len(got))
+	}
+}
+
+func TestPreallocatedFileStorage(t *testing.T) {
+	tmpFile, err := os.CreateTemp("", "wal_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	const size = 64 << 10
+	storage, err := NewPreallocatedFileStorage(tmpFile, size)
+	if err != nil {
+		t.Fatalf("failed to pre-allocate: %v", err)
+	}
+	w := NewWALWithStorage(storage)
+
+	cmds := []command.Command{
+		{Type: command.CmdAcquire, LockID: "lock1", OwnerID: "owner1", TTLMillis: 1000, FencingToken: 1},
+		{Type: command.CmdRelease, LockID: "lock1", OwnerID: "owner1", FencingToken: 1},
+	}
+	for _, cmd := range cmds {
+		if err := w.Append(cmd); err != nil {
+			t.Fatalf("failed to append: %v", err)
+		}
+	}
+	if err := w.Sync(); err != nil {
+		t.Fatalf("failed to sync: %v", err)
+	}
+
+	info, err := tmpFile.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != size {
+		t.Errorf("expected file pre-allocated to %d bytes, got %d", size, info.Size())
+	}
+
+	got, err := w.ReadAll()
+	if err != nil {
+		t.Fatalf("failed to read all: %v", err)
+	}
+	if len(got) != len(cmds) || got[0] != cmds[0] || got[1] != cmds[1] {
+		t.Fatalf("expected only the written records, got %+v", got)
+	}
+
+	// Appends after a read still land at the logical end
+	if err := w.Append(cmds[0]); err != nil {
+		t.Fatalf("failed to append: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("failed to close: %v", err)
+	}
+
+	// Reopening finds the logical end again, and a plain read stops at the zeroed space
+	file, err := os.OpenFile(tmpFile.Name(), os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage, err = NewPreallocatedFileStorage(file, size)
+	if err != nil {
+		t.Fatalf("failed to reopen: %v", err)
+	}
+	w = NewWALWithStorage(storage)
+	defer w.Close()
+	if err := w.Append(cmds[1]); err != nil {
+		t.Fatalf("failed to append after reopen: %v", err)
+	}
+	got, err = w.ReadAll()
+	if err != nil {
+		t.Fatalf("failed to read all: %v", err)
+	}
+	if len(got) != 4 {
+		t.Errorf("expected 4 commands, got %d", len(got))
+	}
+
+	file.Seek(0, io.SeekStart)
+	got, err = (&wal{}).readRecords(file, false)
+	if err != nil {
+		t.Fatalf("failed to read file directly: %v", err)
+	}
+	if len(got) != 4 {
+		t.Errorf("expected 4 commands read from the file, got %d", len(got))
+	}
+}
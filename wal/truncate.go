@@ -0,0 +1,206 @@
+package wal
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Truncater is implemented by WALs that can discard records made redundant by a snapshot.
+// Offsets count records from the current start of the log, so after TruncateBefore(n) the
+// record that was at offset n is at offset 0.
+type Truncater interface {
+	// TruncateBefore discards every record before offset
+	TruncateBefore(offset int64) error
+}
+
+// prefixTruncater is implemented by storage backends that can drop their first n bytes
+type prefixTruncater interface {
+	truncatePrefix(n int64) error
+}
+
+// TruncateBefore discards every record before offset. It holds the append lock throughout, so
+// no append can land in a log that is being rewritten.
+func (w *wal) TruncateBefore(offset int64) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return ErrClosed
+	}
+	if offset <= 0 {
+		return nil
+	}
+
+	storage, ok := w.storage.(prefixTruncater)
+	if !ok {
+		return errors.New("wal storage does not support truncation")
+	}
+	r, err := w.storage.ReadRecords()
+	if err != nil {
+		return err
+	}
+	n, err := recordsSize(r, offset, w.padded())
+	if err != nil {
+		return err
+	}
+	return storage.truncatePrefix(n)
+}
+
+// recordsSize returns how many bytes the first count records in r take up, along with any
+// padding after the last of them, so the log left behind still starts on a block boundary.
+// Padding is taken for damage unless padded is set.
+func recordsSize(r io.Reader, count int64, padded bool) (int64, error) {
+	br := bufio.NewReader(r)
+	var size int64
+	for i := int64(0); ; {
+		length, err := br.Peek(4)
+		if i == count && (err == io.EOF || err == nil && binary.BigEndian.Uint32(length)&padFlag == 0) {
+			return size, nil
+		}
+		if err != nil {
+			if err == io.EOF {
+				return 0, fmt.Errorf("offset %d is past the end of the log at %d", count, i)
+			}
+			return 0, fmt.Errorf("failed to read record %d length: %w", i, err)
+		}
+		prefix := binary.BigEndian.Uint32(length)
+		n := int64(prefix &^ padFlag)
+		if prefix == 0 {
+			return 0, fmt.Errorf("offset %d is past the end of the log at %d", count, i)
+		}
+		br.Discard(4)
+		if prefix&padFlag != 0 {
+			// Padding takes up space but isn't a record
+			if err := skipPadding(br, size, n, padded); err != nil {
+				return 0, fmt.Errorf("failed to skip padding after record %d: %w", i, err)
+			}
+		} else {
+			if _, err := io.CopyN(io.Discard, br, n); err != nil {
+				return 0, fmt.Errorf("failed to skip record %d: %w", i, err)
+			}
+			i++
+		}
+		size += 4 + n
+	}
+}
+
+// truncatePrefix copies everything after the first n bytes to a new file and renames it over
+// the old one, so a crash leaves either the whole old log or the whole new one
+func (s *fileStorage) truncatePrefix(n int64) error {
+	named, ok := s.file.(interface{ Name() string })
+	if !ok {
+		return errors.New("wal file has no name to rewrite")
+	}
+	path := named.Name()
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".truncate-*")
+	if err != nil {
+		return fmt.Errorf("failed to create truncated log: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+
+	if _, err := s.file.Seek(n, io.SeekStart); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to seek past truncated records: %w", err)
+	}
+	var src io.Reader = s.file
+	if s.preallocated > 0 {
+		src = io.LimitReader(s.file, s.end-n)
+	}
+	kept, err := io.Copy(tmp, src)
+	if err == nil && s.preallocated > kept {
+		err = tmp.Truncate(s.preallocated)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write truncated log: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to replace log: %w", err)
+	}
+	if err := syncDir(filepath.Dir(path)); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	s.file.Close()
+	s.file = tmp
+	s.end = kept
+	s.unsynced = 0 // the rewritten log was synced in full
+	_, err = tmp.Seek(kept, io.SeekStart)
+	return err
+}
+
+func (s *memoryStorage) truncatePrefix(n int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.buf.Next(int(n))
+	return nil
+}
+
+// syncDir makes a rename or removal in dir durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("failed to open directory: %w", err)
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("failed to sync directory: %w", err)
+	}
+	return nil
+}
+
+// TruncateDirBefore deletes the segments in dir whose records all come before offset, counting
+// records from the first segment, and returns how many records were removed. The segment holding
+// offset and everything after it are kept, and so is the final segment, which may still be
+// appended to, so a few records before offset can survive. Segments are removed oldest first,
+// so a crash part way leaves a contiguous run of segments.
+func TruncateDirBefore(dir string, offset int64, opts ...Option) (int64, error) {
+	segments, err := listSegments(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	r := &wal{}
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	var removed int64
+	for _, seg := range segments[:max(len(segments)-1, 0)] {
+		file, err := os.Open(seg.path)
+		if err != nil {
+			return removed, fmt.Errorf("failed to open segment: %w", err)
+		}
+		cmds, err := r.readRecords(file, false)
+		file.Close()
+		if err != nil {
+			return removed, fmt.Errorf("segment %s: %w", seg.path, err)
+		}
+
+		if removed+int64(len(cmds)) > offset {
+			break
+		}
+		if err := os.Remove(seg.path); err != nil {
+			return removed, fmt.Errorf("failed to remove segment: %w", err)
+		}
+		removed += int64(len(cmds))
+	}
+
+	if removed > 0 {
+		if err := syncDir(dir); err != nil {
+			return removed, err
+		}
+	}
+	return removed, nil
+}
@@ -0,0 +1,176 @@
+package wal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mrdhat/clutchdb/command"
+)
+
+// tokens returns the fencing token of each command, which the tests use to tell records apart
+func tokens(cmds []command.Command) []uint64 {
+	out := make([]uint64, len(cmds))
+	for i, cmd := range cmds {
+		out[i] = cmd.FencingToken
+	}
+	return out
+}
+
+func equalTokens(a, b []uint64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestTruncateBefore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "1.wal")
+	preallocated, err := os.Create(filepath.Join(t.TempDir(), "preallocated.wal"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	preallocatedStorage, err := NewPreallocatedFileStorage(preallocated, 4096)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logs := map[string]WAL{
+		"file":         NewWAL(file),
+		"preallocated": NewWALWithStorage(preallocatedStorage),
+		"memory":       NewWALWithStorage(NewMemoryStorage()),
+	}
+	for name, w := range logs {
+		for token := uint64(1); token <= 5; token++ {
+			if err := w.Append(command.Command{Type: command.CmdAcquire, LockID: "lock1", OwnerID: "owner1", FencingToken: token}); err != nil {
+				t.Fatalf("%s: failed to append: %v", name, err)
+			}
+		}
+
+		if err := w.(Truncater).TruncateBefore(3); err != nil {
+			t.Fatalf("%s: TruncateBefore failed: %v", name, err)
+		}
+		cmds, err := w.ReadAll()
+		if err != nil {
+			t.Fatalf("%s: failed to read all: %v", name, err)
+		}
+		if got := tokens(cmds); !equalTokens(got, []uint64{4, 5}) {
+			t.Errorf("%s: expected records 4 and 5 to survive, got %v", name, got)
+		}
+
+		// Appends carry on after the kept records
+		if err := w.Append(command.Command{Type: command.CmdRelease, LockID: "lock1", OwnerID: "owner1", FencingToken: 6}); err != nil {
+			t.Fatalf("%s: failed to append after truncation: %v", name, err)
+		}
+		cmds, err = w.ReadAll()
+		if err != nil {
+			t.Fatalf("%s: failed to read all: %v", name, err)
+		}
+		if got := tokens(cmds); !equalTokens(got, []uint64{4, 5, 6}) {
+			t.Errorf("%s: expected records 4 to 6, got %v", name, got)
+		}
+
+		if err := w.(Truncater).TruncateBefore(10); err == nil {
+			t.Errorf("%s: expected truncating past the end to fail", name)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("%s: failed to close: %v", name, err)
+		}
+	}
+
+	// The rewrite replaced the file on disk, without leaving temporary files behind
+	cmds, err := ReadAllDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatalf("ReadAllDir failed: %v", err)
+	}
+	if got := tokens(cmds); !equalTokens(got, []uint64{4, 5, 6}) {
+		t.Errorf("Expected records 4 to 6 on disk, got %v", got)
+	}
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	if len(entries) != 1 {
+		t.Errorf("Expected only the log in its directory, got %d entries", len(entries))
+	}
+}
+
+func TestTruncateDirBefore(t *testing.T) {
+	dir := t.TempDir()
+	writeSegment(t, dir, "1.wal",
+		command.Command{Type: command.CmdAcquire, LockID: "lock1", FencingToken: 1},
+		command.Command{Type: command.CmdAcquire, LockID: "lock1", FencingToken: 2},
+	)
+	writeSegment(t, dir, "2.wal", command.Command{Type: command.CmdAcquire, LockID: "lock1", FencingToken: 3})
+	writeSegment(t, dir, "3.wal", command.Command{Type: command.CmdAcquire, LockID: "lock1", FencingToken: 4})
+
+	// Offset 4 falls in the final segment, which is always kept
+	removed, err := TruncateDirBefore(dir, 4)
+	if err != nil {
+		t.Fatalf("TruncateDirBefore failed: %v", err)
+	}
+	if removed != 3 {
+		t.Errorf("Expected 3 records removed, got %d", removed)
+	}
+	cmds, err := ReadAllDir(dir)
+	if err != nil {
+		t.Fatalf("ReadAllDir failed: %v", err)
+	}
+	if got := tokens(cmds); !equalTokens(got, []uint64{4}) {
+		t.Errorf("Expected only record 4 to survive, got %v", got)
+	}
+}
+
+func TestTruncateDirBeforeKeepsSegmentHoldingOffset(t *testing.T) {
+	dir := t.TempDir()
+	writeSegment(t, dir, "1.wal", command.Command{Type: command.CmdAcquire, LockID: "lock1", FencingToken: 1})
+	writeSegment(t, dir, "2.wal",
+		command.Command{Type: command.CmdAcquire, LockID: "lock1", FencingToken: 2},
+		command.Command{Type: command.CmdAcquire, LockID: "lock1", FencingToken: 3},
+	)
+	writeSegment(t, dir, "3.wal", command.Command{Type: command.CmdAcquire, LockID: "lock1", FencingToken: 4})
+
+	removed, err := TruncateDirBefore(dir, 2)
+	if err != nil {
+		t.Fatalf("TruncateDirBefore failed: %v", err)
+	}
+	if removed != 1 {
+		t.Errorf("Expected 1 record removed, got %d", removed)
+	}
+	cmds, err := ReadAllDir(dir)
+	if err != nil {
+		t.Fatalf("ReadAllDir failed: %v", err)
+	}
+	if got := tokens(cmds); !equalTokens(got, []uint64{2, 3, 4}) {
+		t.Errorf("Expected records 2 to 4 to survive, got %v", got)
+	}
+}
+
+func TestTruncateBeforeKeepsPaddingAligned(t *testing.T) {
+	storage := NewMemoryStorage()
+	for i := range 3 {
+		record := EncodeRecord(command.Command{Type: command.CmdAcquire, LockID: "lock1", OwnerID: "owner1", FencingToken: uint64(i + 1), TTLMillis: 1000})
+		if err := storage.WriteRecord(append(record, paddingFrame(int64(len(record)))...)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w := NewWALWithStorage(storage, WithPadding())
+
+	// The padding after the last dropped record goes with it, so the rest still starts on a block
+	if err := w.(Truncater).TruncateBefore(1); err != nil {
+		t.Fatalf("failed to truncate: %v", err)
+	}
+	cmds, err := w.ReadAll()
+	if err != nil {
+		t.Fatalf("failed to read: %v", err)
+	}
+	if got := tokens(cmds); !equalTokens(got, []uint64{2, 3}) {
+		t.Errorf("expected tokens [2 3], got %v", got)
+	}
+}
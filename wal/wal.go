@@ -2,11 +2,17 @@ package wal
 
 import (
 	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"hash/crc32"
 	"io"
 	"os"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/mrdhat/clutchdb/command"
 )
@@ -23,6 +29,8 @@ import (
 	├───────────────────────────────────────┤
 	│ uint32  crc32                         │  (of payload only)
 	├───────────────────────────────────────┤
+	│ uint8   format_version                │  (0x80 | version)
+	├───────────────────────────────────────┤
 	│ uint8   command_type                  │
 	├───────────────────────────────────────┤
 	│ [16]byte request_id                   │
@@ -39,23 +47,159 @@ import (
 	├───────────────────────────────────────┤
 	│ uint64  fencing_token                 │
 	├───────────────────────────────────────┤
+	│ uint16  new_owner_id_length           │  (CmdTransfer only)
+	│ []byte  new_owner_id                  │
+	├───────────────────────────────────────┤
+
+	A zero record_length marks the end of the log in a pre-allocated file.
+	A record_length with the high bit set marks padding rather than a
+	record: the low 31 bits count the bytes to skip. Logs opened with
+	WithDirectIO pad every record out to a whole block.
+
+	Version 1 records predate format_version and start directly with
+	command_type. Command types stay below 0x80, so the high bit of the
+	first payload byte tells the two apart.
+
+	Version 3 records, written with WithVarints, have the same layout but
+	encode ttl_millis, commit_unix_millis and fencing_token as uvarints.
 
 *
 */
 type WAL interface {
 	Append(cmd command.Command) error
+	// Sync makes every appended record durable. If it fails, the records it covered are
+	// discarded from the log unless the error wraps ErrNotRolledBack.
 	Sync() error
 	ReadAll() ([]command.Command, error)
+	// Close syncs and releases the underlying storage. Calling it again is a no-op.
+	Close() error
 }
 
+// ErrClosed is returned by operations on a closed WAL
+var ErrClosed = errors.New("wal closed")
+
 type wal struct {
-	file *os.File
+	mu      sync.Mutex // serializes appends and guards closed
+	closed  bool
+	broken  error // set once a failed sync could not be rolled back
+	storage WALStorage
+	aead    cipher.AEAD
+
+	seq       uint64 // sequence number of the last record written, sealed into encrypted records
+	syncedSeq uint64 // seq as of the last successful sync, which a rollback returns to
+	seqLoaded bool   // seq was read from the log; appends to an existing encrypted log load it first
+
+	trimLegacyIDs bool
+	varints       bool
+	noDirLock     bool
+	directIO      bool
+	padding       bool
+	quarantine    bool
+	dirLock       *os.File          // LOCK file held by Open, nil otherwise
+	quarantined   *QuarantineReport // set by Open if it moved a damaged log aside
+
+	groupDelay time.Duration // 0 unless WithGroupSync
+	groupBytes int
+	batch      *syncBatch // appends waiting on the next group sync, nil if none
+
+	observers []func(command.Command)
+	unsynced  []command.Command // appended since the last Sync, kept only when there are observers
 }
 
-func (w *wal) Append(cmd command.Command) error {
+// Option configures optional WAL behavior
+type Option func(*wal)
+
+// WithCipher encrypts each record payload at rest using aead (e.g. AES-GCM).
+// A sequence number and a fresh random nonce are stored in the clear per record
+// between the crc32 and the ciphertext, so the record framing stays scannable:
+//
+//	| uint32 record_length | uint32 crc32 | uint64 sequence | nonce | ciphertext |
+//
+// The sequence number counts records from 1 and is authenticated as additional data, and
+// readers require each record's to follow the one before, so a record moved or replayed
+// elsewhere in the log fails authentication. The crc32 covers everything after it, so damage
+// is reported as such before decrypting, and nothing about the plaintext is stored in the clear.
+func WithCipher(aead cipher.AEAD) Option {
+	return func(w *wal) {
+		w.aead = aead
+	}
+}
+
+// WithLegacyIDTrim strips trailing NUL bytes from lock and owner IDs as records are read.
+// Logs written when IDs were fixed 16-byte wire fields may hold the padded form, which would
+// never match the unpadded keys used today.
+func WithLegacyIDTrim() Option {
+	return func(w *wal) {
+		w.trimLegacyIDs = true
+	}
+}
+
+// WithVarints writes ttl_millis, commit_unix_millis and fencing_token as uvarints, in record
+// format version 3. Small tokens and TTLs then take a byte or three instead of eight, which
+// adds up on high-churn logs. Readers handle both layouts, so a log may mix them.
+func WithVarints() Option {
+	return func(w *wal) {
+		w.varints = true
+	}
+}
+
+// formatVersion is the newest record format this package reads. Version 2 added format_version
+// and new_owner_id; version 3 encodes the uint64 fields as uvarints.
+const formatVersion = 3
+
+// fixedFormatVersion is the record format Append writes unless WithVarints is set
+const fixedFormatVersion = 2
+
+// varintFormatVersion is the record format Append writes with WithVarints
+const varintFormatVersion = 3
+
+// versionFlag marks the first payload byte as format_version rather than a version 1 command_type
+const versionFlag = 0x80
+
+// ErrUnsupportedVersion is returned by ReadAll for records written in a newer format than this
+// reader understands
+var ErrUnsupportedVersion = errors.New("unsupported record format version")
+
+// WithAppendObserver calls fn with every command once it is durable: after the Sync (or Close)
+// that covers its append, in log order. fn runs synchronously on the commit path with the WAL
+// locked, so it must be fast and must not call back into the WAL; hand slow work off to a
+// goroutine or a buffered channel.
+func WithAppendObserver(fn func(command.Command)) Option {
+	return func(w *wal) {
+		w.observers = append(w.observers, fn)
+	}
+}
 
+// ErrRecordAuthentication is returned by ReadAll when an encrypted record
+// cannot be decrypted, typically because the key is wrong or the record was tampered with
+var ErrRecordAuthentication = errors.New("record authentication failed")
+
+// ErrDataAfterZeroFill is returned by ReadAll when non-zero bytes follow a zeroed length prefix,
+// meaning the zeros are damage inside the log rather than unwritten pre-allocated space
+var ErrDataAfterZeroFill = errors.New("data after zero-filled region")
+
+// errZeroFill is returned by readRecord for an all-zero length prefix, which no record has
+var errZeroFill = errors.New("zero-filled region")
+
+// encodePayload serializes cmd in the fixed-width format
+func encodePayload(cmd command.Command) []byte {
+	return encodePayloadVersion(cmd, fixedFormatVersion)
+}
+
+// encodePayloadVersion serializes cmd in format version, fixedFormatVersion or varintFormatVersion
+func encodePayloadVersion(cmd command.Command, version byte) []byte {
 	// Serialize the payload (everything except record_length and crc32)
 	payload := new(bytes.Buffer)
+	writeUint64 := func(v uint64) {
+		if version == varintFormatVersion {
+			payload.Write(binary.AppendUvarint(nil, v))
+		} else {
+			binary.Write(payload, binary.BigEndian, v)
+		}
+	}
+
+	// format_version (uint8)
+	payload.WriteByte(versionFlag | version)
 
 	// command_type (uint8)
 	binary.Write(payload, binary.BigEndian, uint8(cmd.Type))
@@ -72,17 +216,128 @@ func (w *wal) Append(cmd command.Command) error {
 	payload.WriteString(cmd.OwnerID)
 
 	// ttl_millis (uint64)
-	binary.Write(payload, binary.BigEndian, cmd.TTLMillis)
+	writeUint64(cmd.TTLMillis)
 
 	// commit_unix_millis (uint64)
-	binary.Write(payload, binary.BigEndian, cmd.CommitTimeMillis)
+	writeUint64(cmd.CommitTimeMillis)
 
 	// fencing_token (uint64)
-	binary.Write(payload, binary.BigEndian, cmd.FencingToken)
+	writeUint64(cmd.FencingToken)
+
+	// new_owner_id_length (uint16) + new_owner_id ([]byte), only for handoffs
+	if cmd.Type == command.CmdTransfer {
+		binary.Write(payload, binary.BigEndian, uint16(len(cmd.NewOwnerID)))
+		payload.WriteString(cmd.NewOwnerID)
+	}
 
-	payloadBytes := payload.Bytes()
+	// value_length (uint16) + value ([]byte), only for acquires with a value. Readers that
+	// predate it ignore the trailing bytes.
+	if cmd.Type == command.CmdAcquire && cmd.Value != "" {
+		binary.Write(payload, binary.BigEndian, uint16(len(cmd.Value)))
+		payload.WriteString(cmd.Value)
+	}
 
-	// Calculate CRC32 of the payload
+	return payload.Bytes()
+}
+
+func (w *wal) Append(cmd command.Command) error {
+	payload := w.recordPayload(cmd)
+
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return ErrClosed
+	}
+	if w.broken != nil {
+		w.mu.Unlock()
+		return w.broken
+	}
+
+	record, err := w.writeRecordLocked(payload)
+	if err != nil {
+		w.mu.Unlock()
+		return err
+	}
+	if len(w.observers) > 0 {
+		w.unsynced = append(w.unsynced, cmd)
+	}
+	batch := w.joinBatchLocked(len(record))
+	w.mu.Unlock()
+
+	if batch == nil {
+		return nil
+	}
+	<-batch.done
+	if batch.err != nil {
+		return fmt.Errorf("%w: %w", ErrSyncFailed, batch.err)
+	}
+	return nil
+}
+
+// EncodeRecord frames cmd as an unencrypted record, as written to a log without WithCipher
+func EncodeRecord(cmd command.Command) []byte {
+	record, _ := (&wal{}).frameRecord(encodePayload(cmd), 0) // only encryption can fail
+	return record
+}
+
+// recordPayload serializes cmd in the format version w writes
+func (w *wal) recordPayload(cmd command.Command) []byte {
+	if w.varints {
+		return encodePayloadVersion(cmd, varintFormatVersion)
+	}
+	return encodePayload(cmd)
+}
+
+// writeRecordLocked frames payload as the next record and writes it, returning the record. The
+// sequence number only advances once the write succeeds, so a failed one leaves no gap. Must be
+// called with w.mu held.
+func (w *wal) writeRecordLocked(payload []byte) ([]byte, error) {
+	if err := w.loadSeqLocked(); err != nil {
+		return nil, err
+	}
+	record, err := w.frameRecord(payload, w.seq+1)
+	if err != nil {
+		return nil, err
+	}
+	if err := w.storage.WriteRecord(record); err != nil {
+		return nil, err
+	}
+	w.seq++
+	return record, nil
+}
+
+// loadSeqLocked reads the sequence number of the last record in an encrypted log, so appends
+// after a reopen carry on from it. Must be called with w.mu held.
+func (w *wal) loadSeqLocked() error {
+	if w.aead == nil || w.seqLoaded {
+		return nil
+	}
+	r, err := w.storage.ReadRecords()
+	if err != nil {
+		return err
+	}
+	var pos logPosition
+	if _, _, err := w.readPrefix(r, &pos); err != nil && err != io.ErrUnexpectedEOF {
+		return fmt.Errorf("failed to find the last record sequence: %w", err)
+	}
+	w.seq, w.syncedSeq, w.seqLoaded = pos.seq, pos.seq, true
+	return nil
+}
+
+// frameRecord frames payload as a complete record: record_length + crc32 + payload. Under
+// WithCipher the payload is sealed, bound to seq, the record's sequence number in the log.
+func (w *wal) frameRecord(payloadBytes []byte, seq uint64) ([]byte, error) {
+	if w.aead != nil {
+		sealed := binary.BigEndian.AppendUint64(nil, seq)
+		nonce := make([]byte, w.aead.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return nil, fmt.Errorf("failed to generate nonce: %w", err)
+		}
+		sealed = append(sealed, nonce...)
+		payloadBytes = w.aead.Seal(sealed, nonce, payloadBytes, sealed[:8])
+	}
+
+	// Calculate CRC32 of the payload, sealed if encrypted
 	checksum := crc32.ChecksumIEEE(payloadBytes)
 
 	// Build the final record: record_length + crc32 + payload
@@ -98,110 +353,459 @@ func (w *wal) Append(cmd command.Command) error {
 	// payload
 	finalRecord.Write(payloadBytes)
 
-	// Write to file
-	_, err := w.file.Write(finalRecord.Bytes())
-	return err
+	return finalRecord.Bytes(), nil
 }
 
 func (w *wal) Sync() error {
-	return w.file.Sync()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return ErrClosed
+	}
+	return w.syncLocked()
+}
+
+// syncLocked syncs the storage, then hands the result to observers and to any appends waiting
+// on a group sync. A failed sync rolls back the records it covered. Must be called with w.mu
+// held.
+func (w *wal) syncLocked() error {
+	if w.broken != nil {
+		return w.broken
+	}
+	err := w.storage.Sync()
+	if err == nil {
+		w.syncedSeq = w.seq
+		w.notifyObservers()
+	} else if rollbackErr := w.rollbackLocked(); rollbackErr != nil {
+		err = fmt.Errorf("%w (%w)", err, rollbackErr)
+	}
+	w.completeBatchLocked(err)
+	return err
+}
+
+// notifyObservers hands every command appended since the last Sync to the observers.
+// Must be called with w.mu held, after a successful sync.
+func (w *wal) notifyObservers() {
+	for _, cmd := range w.unsynced {
+		for _, fn := range w.observers {
+			fn(cmd)
+		}
+	}
+	w.unsynced = nil
+}
+
+// observeFrom adds fn as an append observer and returns how many records the log already holds
+// that fn won't be called with: every one but those waiting on a sync for other observers
+func (w *wal) observeFrom(fn func(command.Command)) (uint64, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return 0, ErrClosed
+	}
+
+	r, err := w.storage.ReadRecords()
+	if err != nil {
+		return 0, err
+	}
+	cmds, err := w.readRecords(r, false)
+	if err != nil {
+		return 0, err
+	}
+	w.observers = append(w.observers, fn)
+	return uint64(len(cmds) - len(w.unsynced)), nil
+}
+
+func (w *wal) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	// Release the directory only once nothing more can be written to the log
+	defer w.unlockDir()
+
+	if err := w.syncLocked(); err != nil {
+		w.storage.Close()
+		return fmt.Errorf("failed to sync on close: %w", err)
+	}
+	return w.storage.Close()
 }
 
 func (w *wal) ReadAll() ([]command.Command, error) {
-	// Seek to the beginning of the file
-	if _, err := w.file.Seek(0, 0); err != nil {
-		return nil, fmt.Errorf("failed to seek to start: %w", err)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return nil, ErrClosed
 	}
 
+	r, err := w.storage.ReadRecords()
+	if err != nil {
+		return nil, err
+	}
+
+	return w.readRecords(r, false)
+}
+
+// logPosition is where a reader is in a log: the byte offset its reader is at, and the sequence
+// number of the last encrypted record read, 0 before the first
+type logPosition struct {
+	offset int64
+	seq    uint64
+}
+
+// readRecords decodes records from r until EOF or a zero-filled tail, such as the unwritten
+// space of a pre-allocated file. A zeroed length prefix is only accepted as the end if nothing
+// but zeros follows it. If tolerateTornTail is set, a final record cut short by a crash
+// mid-write is dropped instead of failing the whole read.
+func (w *wal) readRecords(r io.Reader, tolerateTornTail bool) ([]command.Command, error) {
+	commands, _, err := w.readPrefix(r, &logPosition{})
+	if err == io.ErrUnexpectedEOF && tolerateTornTail {
+		return commands, nil
+	}
+	if err == io.ErrUnexpectedEOF {
+		return nil, fmt.Errorf("failed to read record: %w", err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return commands, nil
+}
+
+// readPrefix is readRecords up to the first record that can't be read. It returns the records
+// before it, the offset where it starts and why it can't be read, which is io.ErrUnexpectedEOF
+// for a record cut short. The error is nil if every record was read. pos is left at the last
+// record read.
+func (w *wal) readPrefix(r io.Reader, pos *logPosition) ([]command.Command, int64, error) {
 	var commands []command.Command
 
+	// Every record is read into the same buffer, which only grows for the largest
+	var scratch []byte
+	cr := &countingReader{r: r}
 	for {
-		var recordLength uint32
-		err := binary.Read(w.file, binary.BigEndian, &recordLength)
+		start := cr.n
+		pos.offset = start
+		cmd, err := w.readRecord(cr, pos, &scratch)
 		if err == io.EOF {
-			break
+			return commands, 0, nil
+		}
+		if err == errZeroFill {
+			if err := checkZeroTail(cr, start); err != nil {
+				return commands, start, err
+			}
+			return commands, 0, nil
 		}
 		if err != nil {
-			return nil, fmt.Errorf("failed to read record length: %w", err)
+			return commands, start, err
 		}
+		commands = append(commands, cmd)
+	}
+}
+
+// ReadRecord reads and decodes the next unencrypted record from r, such as one written by
+// EncodeRecord. It returns io.EOF if r ends cleanly before the record and io.ErrUnexpectedEOF
+// if r ends partway through it.
+func ReadRecord(r io.Reader) (command.Command, error) {
+	var scratch []byte
+	cmd, err := (&wal{}).readRecord(r, &logPosition{}, &scratch)
+	if err == errZeroFill {
+		return cmd, io.EOF
+	}
+	return cmd, err
+}
+
+// readRecord reads and decodes the next record from r, which is at pos in the log, into
+// *scratch, growing it if the record doesn't fit, and advances pos.seq past it. The decoded
+// command doesn't refer to scratch, so it can be reused for the next record. A frame cut short is
+// reported as a bare io.ErrUnexpectedEOF (io.EOF if nothing was read), so callers can tell a torn
+// tail apart from a damaged record, and a zeroed length prefix as errZeroFill.
+func (w *wal) readRecord(r io.Reader, pos *logPosition, scratch *[]byte) (command.Command, error) {
+	var cmd command.Command
 
-		// Read the entire record (CRC32 + Payload)
-		data := make([]byte, recordLength)
-		if _, err := io.ReadFull(w.file, data); err != nil {
-			return nil, fmt.Errorf("failed to read record data: %w", err)
+	offset := pos.offset
+	var recordLength uint32
+	for {
+		prefix := grow(scratch, 4)
+		if _, err := io.ReadFull(r, prefix); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return cmd, err
+			}
+			return cmd, fmt.Errorf("failed to read record length: %w", err)
+		}
+		recordLength = binary.BigEndian.Uint32(prefix)
+		if recordLength&padFlag == 0 {
+			break
 		}
+		n := int64(recordLength &^ padFlag)
+		if err := skipPadding(r, offset, n, w.padded()); err != nil {
+			return cmd, err
+		}
+		offset += 4 + n
+	}
+	if recordLength == 0 {
+		return cmd, errZeroFill
+	}
+	if recordLength < 4 {
+		return cmd, fmt.Errorf("invalid record length: %d", recordLength)
+	}
+
+	// Read the entire record (CRC32 + Payload)
+	data := grow(scratch, int(recordLength))
+	if _, err := io.ReadFull(r, data); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return cmd, io.ErrUnexpectedEOF
+		}
+		return cmd, fmt.Errorf("failed to read record data: %w", err)
+	}
 
-		// Extract CRC32
-		expectedCRC := binary.BigEndian.Uint32(data[0:4])
+	// Extract CRC32
+	expectedCRC := binary.BigEndian.Uint32(data[0:4])
 
-		// Extract Payload
-		payloadBytes := data[4:]
+	// Extract Payload
+	payloadBytes := data[4:]
 
-		// Verify CRC32
-		actualCRC := crc32.ChecksumIEEE(payloadBytes)
-		if actualCRC != expectedCRC {
-			return nil, fmt.Errorf("checksum mismatch: expected %d, got %d", expectedCRC, actualCRC)
+	// Verify CRC32, of the sealed payload if encrypted
+	actualCRC := crc32.ChecksumIEEE(payloadBytes)
+	if actualCRC != expectedCRC {
+		return cmd, fmt.Errorf("checksum mismatch: expected %d, got %d", expectedCRC, actualCRC)
+	}
+
+	if w.aead != nil {
+		nonceSize := w.aead.NonceSize()
+		if len(payloadBytes) < 8+nonceSize {
+			return cmd, fmt.Errorf("encrypted record too short: %d bytes", len(payloadBytes))
+		}
+		seq := binary.BigEndian.Uint64(payloadBytes[:8])
+		nonce, ciphertext := payloadBytes[8:8+nonceSize], payloadBytes[8+nonceSize:]
+		// Decrypted in place, over the ciphertext in scratch
+		plaintext, err := w.aead.Open(ciphertext[:0], nonce, ciphertext, payloadBytes[:8])
+		if err != nil {
+			return cmd, fmt.Errorf("failed to decrypt record: %w", ErrRecordAuthentication)
 		}
+		if pos.seq != 0 && seq != pos.seq+1 {
+			return cmd, fmt.Errorf("record sequence %d does not follow %d: %w", seq, pos.seq, ErrRecordAuthentication)
+		}
+		pos.seq = seq
+		payloadBytes = plaintext
+	}
 
-		// Parse Payload
-		payload := bytes.NewReader(payloadBytes)
-		var cmd command.Command
+	cmd, err := decodePayload(payloadBytes)
+	if err != nil {
+		return cmd, err
+	}
+	if w.trimLegacyIDs {
+		cmd.LockID = strings.TrimRight(cmd.LockID, "\x00")
+		cmd.OwnerID = strings.TrimRight(cmd.OwnerID, "\x00")
+		cmd.NewOwnerID = strings.TrimRight(cmd.NewOwnerID, "\x00")
+	}
+	return cmd, nil
+}
+
+// grow returns the first n bytes of *scratch, first reallocating it if it holds fewer
+func grow(scratch *[]byte, n int) []byte {
+	if cap(*scratch) < n {
+		*scratch = make([]byte, max(n, 2*cap(*scratch)))
+	}
+	return (*scratch)[:n]
+}
 
+// decodePayload decodes a record payload. It parses payloadBytes in place and copies out only
+// the IDs, so the caller may reuse payloadBytes afterwards.
+func decodePayload(payloadBytes []byte) (command.Command, error) {
+	payload := &payloadDecoder{data: payloadBytes}
+	var cmd command.Command
+
+	// format_version, absent in version 1 records
+	first, ok := payload.readByte()
+	if !ok {
+		return cmd, fmt.Errorf("failed to read command type: %w", io.EOF)
+	}
+	cmdType := first
+	if first&versionFlag != 0 {
+		version := first &^ versionFlag
+		if version > formatVersion {
+			return cmd, fmt.Errorf("%w: %d", ErrUnsupportedVersion, version)
+		}
+		payload.varints = version == varintFormatVersion
 		// command_type
-		var cmdType uint8
-		if err := binary.Read(payload, binary.BigEndian, &cmdType); err != nil {
-			return nil, fmt.Errorf("failed to read command type: %w", err)
+		if cmdType, ok = payload.readByte(); !ok {
+			return cmd, fmt.Errorf("failed to read command type: %w", io.EOF)
 		}
-		cmd.Type = command.CommandType(cmdType)
+	}
+	cmd.Type = command.CommandType(cmdType)
+	if cmd.Type < command.CmdAcquire || cmd.Type > command.CmdReset {
+		return cmd, fmt.Errorf("unknown command type: %d", cmdType)
+	}
 
-		// request_id
-		if _, err := io.ReadFull(payload, cmd.RequestID[:]); err != nil {
-			return nil, fmt.Errorf("failed to read request id: %w", err)
-		}
+	// request_id
+	requestID, ok := payload.next(len(cmd.RequestID))
+	if !ok {
+		return cmd, fmt.Errorf("failed to read request id: %w", io.ErrUnexpectedEOF)
+	}
+	copy(cmd.RequestID[:], requestID)
+
+	// lock_id and owner_id, copied out as one string that both slice into, with the owner ID's
+	// length prefix between them
+	idsStart := payload.offset
+	lockIDLen, ok := payload.readUint16()
+	if !ok {
+		return cmd, fmt.Errorf("failed to read lock id length: %w", io.ErrUnexpectedEOF)
+	}
+	if _, ok := payload.next(int(lockIDLen)); !ok {
+		return cmd, fmt.Errorf("failed to read lock id: %w", io.ErrUnexpectedEOF)
+	}
+	ownerIDLen, ok := payload.readUint16()
+	if !ok {
+		return cmd, fmt.Errorf("failed to read owner id length: %w", io.ErrUnexpectedEOF)
+	}
+	if _, ok := payload.next(int(ownerIDLen)); !ok {
+		return cmd, fmt.Errorf("failed to read owner id: %w", io.ErrUnexpectedEOF)
+	}
+	ids := string(payloadBytes[idsStart+2 : payload.offset])
+	cmd.LockID = ids[:lockIDLen]
+	cmd.OwnerID = ids[int(lockIDLen)+2:]
+
+	// ttl_millis
+	if err := payload.readUint64(&cmd.TTLMillis); err != nil {
+		return cmd, fmt.Errorf("failed to read ttl millis: %w", err)
+	}
+
+	// commit_unix_millis
+	if err := payload.readUint64(&cmd.CommitTimeMillis); err != nil {
+		return cmd, fmt.Errorf("failed to read commit millis: %w", err)
+	}
+
+	// fencing_token
+	if err := payload.readUint64(&cmd.FencingToken); err != nil {
+		return cmd, fmt.Errorf("failed to read fencing token: %w", err)
+	}
 
-		// lock_id
-		var lockIDLen uint16
-		if err := binary.Read(payload, binary.BigEndian, &lockIDLen); err != nil {
-			return nil, fmt.Errorf("failed to read lock id length: %w", err)
+	// new_owner_id
+	if cmd.Type == command.CmdTransfer {
+		newOwnerIDLen, ok := payload.readUint16()
+		if !ok {
+			return cmd, fmt.Errorf("failed to read new owner id length: %w", io.ErrUnexpectedEOF)
 		}
-		lockID := make([]byte, lockIDLen)
-		if _, err := io.ReadFull(payload, lockID); err != nil {
-			return nil, fmt.Errorf("failed to read lock id: %w", err)
+		newOwnerID, ok := payload.next(int(newOwnerIDLen))
+		if !ok {
+			return cmd, fmt.Errorf("failed to read new owner id: %w", io.ErrUnexpectedEOF)
 		}
-		cmd.LockID = string(lockID)
+		cmd.NewOwnerID = string(newOwnerID)
+	}
 
-		// owner_id
-		var ownerIDLen uint16
-		if err := binary.Read(payload, binary.BigEndian, &ownerIDLen); err != nil {
-			return nil, fmt.Errorf("failed to read owner id length: %w", err)
+	// value, absent from acquires without one
+	if cmd.Type == command.CmdAcquire && payload.offset < len(payloadBytes) {
+		valueLen, ok := payload.readUint16()
+		if !ok {
+			return cmd, fmt.Errorf("failed to read value length: %w", io.ErrUnexpectedEOF)
 		}
-		ownerID := make([]byte, ownerIDLen)
-		if _, err := io.ReadFull(payload, ownerID); err != nil {
-			return nil, fmt.Errorf("failed to read owner id: %w", err)
+		value, ok := payload.next(int(valueLen))
+		if !ok {
+			return cmd, fmt.Errorf("failed to read value: %w", io.ErrUnexpectedEOF)
 		}
-		cmd.OwnerID = string(ownerID)
+		cmd.Value = string(value)
+	}
 
-		// ttl_millis
-		if err := binary.Read(payload, binary.BigEndian, &cmd.TTLMillis); err != nil {
-			return nil, fmt.Errorf("failed to read ttl millis: %w", err)
-		}
+	return cmd, nil
+}
 
-		// commit_unix_millis
-		if err := binary.Read(payload, binary.BigEndian, &cmd.CommitTimeMillis); err != nil {
-			return nil, fmt.Errorf("failed to read commit millis: %w", err)
-		}
+// payloadDecoder reads the fields of a record payload from a slice, in order
+type payloadDecoder struct {
+	data    []byte
+	offset  int
+	varints bool // uint64 fields are uvarints, as in varintFormatVersion
+}
 
-		// fencing_token
-		if err := binary.Read(payload, binary.BigEndian, &cmd.FencingToken); err != nil {
-			return nil, fmt.Errorf("failed to read fencing token: %w", err)
+// next returns the next n bytes, or false if fewer are left
+func (d *payloadDecoder) next(n int) ([]byte, bool) {
+	if len(d.data)-d.offset < n {
+		return nil, false
+	}
+	b := d.data[d.offset : d.offset+n]
+	d.offset += n
+	return b, true
+}
+
+func (d *payloadDecoder) readByte() (byte, bool) {
+	b, ok := d.next(1)
+	if !ok {
+		return 0, false
+	}
+	return b[0], true
+}
+
+func (d *payloadDecoder) readUint16() (uint16, bool) {
+	b, ok := d.next(2)
+	if !ok {
+		return 0, false
+	}
+	return binary.BigEndian.Uint16(b), true
+}
+
+func (d *payloadDecoder) readUint64(v *uint64) error {
+	if !d.varints {
+		b, ok := d.next(8)
+		if !ok {
+			return io.ErrUnexpectedEOF
 		}
+		*v = binary.BigEndian.Uint64(b)
+		return nil
+	}
+	x, n := binary.Uvarint(d.data[d.offset:])
+	if n == 0 {
+		return io.ErrUnexpectedEOF
+	}
+	if n < 0 {
+		return errors.New("varint overflows a 64-bit integer")
+	}
+	*v = x
+	d.offset += n
+	return nil
+}
 
-		commands = append(commands, cmd)
+// NewWAL returns a WAL that appends records to file
+func NewWAL(file *os.File, opts ...Option) WAL {
+	return NewWALWithStorage(NewFileStorage(file), opts...)
+}
+
+// NewWALWithStorage returns a WAL that frames records onto an arbitrary storage backend
+func NewWALWithStorage(storage WALStorage, opts ...Option) WAL {
+	w := &wal{storage: storage}
+	for _, opt := range opts {
+		opt(w)
 	}
+	return w
+}
 
-	return commands, nil
+// countingReader counts the bytes read through it, to report where in a log damage starts
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
 }
 
-func NewWAL(file *os.File) WAL {
-	return &wal{file: file}
+// checkZeroTail reads r to the end and fails unless every byte is zero. start is the offset of
+// the zeroed length prefix that ended the records, for the error message.
+func checkZeroTail(r *countingReader, start int64) error {
+	buf := make([]byte, 32<<10)
+	for {
+		n, err := r.Read(buf)
+		for i, b := range buf[:n] {
+			if b != 0 {
+				at := r.n - int64(n) + int64(i)
+				return fmt.Errorf("%w: zeros from offset %d, then a non-zero byte at %d", ErrDataAfterZeroFill, start, at)
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read past zero-filled region: %w", err)
+		}
+	}
 }
@@ -1,7 +1,17 @@
 package wal
 
 import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"math"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/mrdhat/clutchdb/command"
@@ -66,3 +76,698 @@ func TestWAL(t *testing.T) {
 		t.Errorf("cmd2 mismatch: %+v", cmds[1])
 	}
 }
+
+func newTestAEAD(t *testing.T, key []byte) cipher.AEAD {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return aead
+}
+
+func TestWALEncryption(t *testing.T) {
+	tmpFile, err := os.CreateTemp("", "wal_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	key := bytes.Repeat([]byte{0x42}, 32)
+	w := NewWAL(tmpFile, WithCipher(newTestAEAD(t, key)))
+
+	cmd := command.Command{
+		Type:             command.CmdAcquire,
+		RequestID:        [16]byte{1, 2, 3},
+		LockID:           "lock1",
+		OwnerID:          "sensitive-owner",
+		TTLMillis:        1000,
+		FencingToken:     7,
+		CommitTimeMillis: 1678900000,
+	}
+
+	if err := w.Append(cmd); err != nil {
+		t.Fatalf("failed to append: %v", err)
+	}
+	if err := w.Sync(); err != nil {
+		t.Fatalf("failed to sync: %v", err)
+	}
+
+	// Owner ID must not appear in plaintext on disk
+	raw, err := os.ReadFile(tmpFile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(raw, []byte(cmd.OwnerID)) {
+		t.Error("expected owner id to be encrypted on disk")
+	}
+
+	cmds, err := w.ReadAll()
+	if err != nil {
+		t.Fatalf("failed to read all: %v", err)
+	}
+	if len(cmds) != 1 {
+		t.Fatalf("expected 1 command, got %d", len(cmds))
+	}
+	if cmds[0] != cmd {
+		t.Errorf("command mismatch: got %+v, want %+v", cmds[0], cmd)
+	}
+}
+
+func TestWALEncryptionWrongKey(t *testing.T) {
+	tmpFile, err := os.CreateTemp("", "wal_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	w := NewWAL(tmpFile, WithCipher(newTestAEAD(t, bytes.Repeat([]byte{0x42}, 32))))
+	if err := w.Append(command.Command{Type: command.CmdAcquire, LockID: "lock1", OwnerID: "owner1"}); err != nil {
+		t.Fatalf("failed to append: %v", err)
+	}
+
+	wrong := NewWAL(tmpFile, WithCipher(newTestAEAD(t, bytes.Repeat([]byte{0x24}, 32))))
+	_, err = wrong.ReadAll()
+	if !errors.Is(err, ErrRecordAuthentication) {
+		t.Fatalf("expected ErrRecordAuthentication, got %v", err)
+	}
+}
+
+func TestWALEncryptionBindsSequence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "000001.wal")
+	aead := newTestAEAD(t, bytes.Repeat([]byte{0x42}, 32))
+	cmds := []command.Command{
+		{Type: command.CmdAcquire, LockID: "lock1", OwnerID: "owner1", FencingToken: 1, TTLMillis: 1000},
+		{Type: command.CmdRelease, LockID: "lock1", OwnerID: "owner1", FencingToken: 1},
+		{Type: command.CmdAcquire, LockID: "lock1", OwnerID: "owner2", FencingToken: 2, TTLMillis: 1000},
+	}
+
+	// Appends after a reopen carry on the sequence of the records already in the log
+	for _, cmd := range cmds {
+		w, err := Open(path, WithCipher(aead))
+		if err != nil {
+			t.Fatalf("failed to open: %v", err)
+		}
+		if err := w.Append(cmd); err != nil {
+			t.Fatalf("failed to append: %v", err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("failed to close: %v", err)
+		}
+	}
+	readLog := func(data []byte) ([]command.Command, error) {
+		storage := NewMemoryStorage()
+		if err := storage.WriteRecord(data); err != nil {
+			t.Fatalf("failed to write log: %v", err)
+		}
+		return NewWALWithStorage(storage, WithCipher(aead)).ReadAll()
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read, err := readLog(data)
+	if err != nil {
+		t.Fatalf("failed to read: %v", err)
+	}
+	if !reflect.DeepEqual(read, cmds) {
+		t.Errorf("expected %+v, got %+v", cmds, read)
+	}
+
+	// The checksum is of the sealed record, not of the plaintext
+	first := int(binary.BigEndian.Uint32(data)) + 4
+	if crc := binary.BigEndian.Uint32(data[4:8]); crc == crc32.ChecksumIEEE(encodePayload(cmds[0])) {
+		t.Error("expected the stored checksum not to be that of the plaintext")
+	}
+
+	// A record replayed later in the log, or moved ahead of another, fails authentication
+	replayed := append(bytes.Clone(data), data[:first]...)
+	if _, err := readLog(replayed); !errors.Is(err, ErrRecordAuthentication) {
+		t.Errorf("expected a replayed record to fail authentication, got %v", err)
+	}
+	second := first + int(binary.BigEndian.Uint32(data[first:])) + 4
+	swapped := append(append(bytes.Clone(data[first:second]), data[:first]...), data[second:]...)
+	if _, err := readLog(swapped); !errors.Is(err, ErrRecordAuthentication) {
+		t.Errorf("expected reordered records to fail authentication, got %v", err)
+	}
+}
+
+func TestWALClose(t *testing.T) {
+	tmpFile, err := os.CreateTemp("", "wal_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	w := NewWAL(tmpFile)
+	cmd := command.Command{Type: command.CmdAcquire, LockID: "lock1", OwnerID: "owner1"}
+	if err := w.Append(cmd); err != nil {
+		t.Fatalf("failed to append: %v", err)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatalf("failed to close: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("expected second close to be a no-op, got %v", err)
+	}
+
+	if err := w.Append(cmd); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected ErrClosed appending after close, got %v", err)
+	}
+	if err := w.Sync(); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected ErrClosed syncing after close, got %v", err)
+	}
+	if _, err := w.ReadAll(); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected ErrClosed reading after close, got %v", err)
+	}
+
+	// The record written before close was flushed
+	file, err := os.Open(tmpFile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	reopened := NewWAL(file)
+	defer reopened.Close()
+	cmds, err := reopened.ReadAll()
+	if err != nil {
+		t.Fatalf("failed to read all: %v", err)
+	}
+	if len(cmds) != 1 || cmds[0] != cmd {
+		t.Errorf("unexpected commands after reopen: %+v", cmds)
+	}
+}
+
+func TestWALReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "1.wal")
+	cmds := []command.Command{
+		{Type: command.CmdAcquire, LockID: "lock1", OwnerID: "owner1", TTLMillis: 1000, FencingToken: 1},
+		{Type: command.CmdRenew, LockID: "lock1", OwnerID: "owner1", TTLMillis: 2000, FencingToken: 1},
+		{Type: command.CmdRelease, LockID: "lock1", OwnerID: "owner1", FencingToken: 1},
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := NewWAL(file)
+	for _, cmd := range cmds {
+		if err := w.Append(cmd); err != nil {
+			t.Fatalf("failed to append: %v", err)
+		}
+	}
+	if err := w.Sync(); err != nil {
+		t.Fatalf("failed to sync: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("failed to close: %v", err)
+	}
+
+	// A fresh handle on the same file sees every synced record, however often it is reopened
+	for i := 0; i < 2; i++ {
+		file, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reopened := NewWAL(file)
+		got, err := reopened.ReadAll()
+		reopened.Close()
+		if err != nil {
+			t.Fatalf("failed to read all: %v", err)
+		}
+		if len(got) != len(cmds) {
+			t.Fatalf("expected %d commands after reopen, got %d", len(cmds), len(got))
+		}
+		for j := range cmds {
+			if got[j] != cmds[j] {
+				t.Errorf("command %d: expected %+v, got %+v", j, cmds[j], got[j])
+			}
+		}
+	}
+}
+
+func TestWALCrashWithoutSync(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "1.wal")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	// Append writes straight to the file with no buffering in the process, so a record is in
+	// the OS page cache as soon as Append returns. A process crash before Sync loses nothing;
+	// only an OS crash or power loss can, which is what Sync guards against.
+	w := NewWAL(file)
+	cmd := command.Command{Type: command.CmdAcquire, LockID: "lock1", OwnerID: "owner1", TTLMillis: 1000, FencingToken: 1}
+	if err := w.Append(cmd); err != nil {
+		t.Fatalf("failed to append: %v", err)
+	}
+
+	// "Crash": abandon w without Sync or Close and recover through a new handle
+	recovered, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reopened := NewWAL(recovered)
+	defer reopened.Close()
+	got, err := reopened.ReadAll()
+	if err != nil {
+		t.Fatalf("failed to read all: %v", err)
+	}
+	if len(got) != 1 || got[0] != cmd {
+		t.Errorf("expected the unsynced record to survive a process crash, got %+v", got)
+	}
+}
+
+func TestWALAppendAfterReadAndReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "1.wal")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := NewWAL(file)
+	cmd := func(lockID string) command.Command {
+		return command.Command{Type: command.CmdAcquire, LockID: lockID, OwnerID: "owner1", TTLMillis: 1000, FencingToken: 1}
+	}
+	for _, lockID := range []string{"lock1", "lock2"} {
+		if err := w.Append(cmd(lockID)); err != nil {
+			t.Fatalf("failed to append: %v", err)
+		}
+	}
+
+	// ReadAll rewinds the file; the next append must still go after the last record
+	if _, err := w.ReadAll(); err != nil {
+		t.Fatalf("failed to read all: %v", err)
+	}
+	if err := w.Append(cmd("lock3")); err != nil {
+		t.Fatalf("failed to append: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("failed to close: %v", err)
+	}
+
+	// A reopened handle starts at offset 0; appending must not overwrite the first record
+	file, err = os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w = NewWAL(file)
+	defer w.Close()
+	if err := w.Append(cmd("lock4")); err != nil {
+		t.Fatalf("failed to append: %v", err)
+	}
+
+	got, err := w.ReadAll()
+	if err != nil {
+		t.Fatalf("failed to read all: %v", err)
+	}
+	if len(got) != 4 {
+		t.Fatalf("expected 4 commands, got %+v", got)
+	}
+	for i, lockID := range []string{"lock1", "lock2", "lock3", "lock4"} {
+		if got[i].LockID != lockID {
+			t.Errorf("command %d: expected lock %s, got %s", i, lockID, got[i].LockID)
+		}
+	}
+}
+
+func TestMemoryWALClose(t *testing.T) {
+	w := NewWALWithStorage(NewMemoryStorage())
+	if err := w.Close(); err != nil {
+		t.Fatalf("failed to close: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("expected second close to be a no-op, got %v", err)
+	}
+	if err := w.Append(command.Command{Type: command.CmdAcquire}); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected ErrClosed appending after close, got %v", err)
+	}
+}
+
+func TestWALLegacyIDTrim(t *testing.T) {
+	storage := NewMemoryStorage()
+
+	// Record as written when IDs were copied straight out of 16-byte wire fields
+	legacy := NewWALWithStorage(storage)
+	padded := command.Command{
+		Type:         command.CmdAcquire,
+		LockID:       "lock1\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00",
+		OwnerID:      "owner1\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00",
+		FencingToken: 1,
+	}
+	if err := legacy.Append(padded); err != nil {
+		t.Fatalf("failed to append: %v", err)
+	}
+
+	cmds, err := NewWALWithStorage(storage, WithLegacyIDTrim()).ReadAll()
+	if err != nil {
+		t.Fatalf("failed to read: %v", err)
+	}
+	if len(cmds) != 1 {
+		t.Fatalf("expected 1 command, got %d", len(cmds))
+	}
+	if cmds[0].LockID != "lock1" || cmds[0].OwnerID != "owner1" {
+		t.Errorf("expected trimmed ids, got %q and %q", cmds[0].LockID, cmds[0].OwnerID)
+	}
+
+	// Without the flag, records are returned exactly as stored
+	cmds, err = legacy.ReadAll()
+	if err != nil {
+		t.Fatalf("failed to read: %v", err)
+	}
+	if cmds[0].LockID != padded.LockID {
+		t.Errorf("expected padded lock id to be kept, got %q", cmds[0].LockID)
+	}
+}
+
+func TestWALNewCommandTypes(t *testing.T) {
+	w := NewWALWithStorage(NewMemoryStorage())
+
+	cmds := []command.Command{
+		{
+			Type:             command.CmdBump,
+			RequestID:        [16]byte{1},
+			LockID:           "lock1",
+			OwnerID:          "owner1",
+			FencingToken:     7,
+			TTLMillis:        1000,
+			CommitTimeMillis: 1678900000,
+		},
+		{
+			Type:             command.CmdTransfer,
+			RequestID:        [16]byte{2},
+			LockID:           "lock1",
+			OwnerID:          "owner1",
+			NewOwnerID:       "owner2",
+			FencingToken:     7,
+			TTLMillis:        2000,
+			CommitTimeMillis: 1678900100,
+		},
+		// A record after a transfer must still line up
+		{
+			Type:             command.CmdRelease,
+			RequestID:        [16]byte{3},
+			LockID:           "lock1",
+			OwnerID:          "owner2",
+			FencingToken:     7,
+			CommitTimeMillis: 1678900200,
+		},
+	}
+	for _, cmd := range cmds {
+		if err := w.Append(cmd); err != nil {
+			t.Fatalf("failed to append %d: %v", cmd.Type, err)
+		}
+	}
+
+	got, err := w.ReadAll()
+	if err != nil {
+		t.Fatalf("failed to read all: %v", err)
+	}
+	if len(got) != len(cmds) {
+		t.Fatalf("expected %d commands, got %d", len(cmds), len(got))
+	}
+	for i := range cmds {
+		if got[i] != cmds[i] {
+			t.Errorf("command %d mismatch:\nwant %+v\ngot  %+v", i, cmds[i], got[i])
+		}
+	}
+}
+
+func TestWALAcquireValue(t *testing.T) {
+	withValue := command.Command{Type: command.CmdAcquire, LockID: "leader", OwnerID: "owner1", Value: "10.0.0.7:7000", FencingToken: 1, TTLMillis: 1000}
+	withoutValue := command.Command{Type: command.CmdAcquire, LockID: "leader", OwnerID: "owner2", FencingToken: 2, TTLMillis: 1000}
+	cmds := []command.Command{withValue, withoutValue}
+
+	for name, opts := range map[string][]Option{"plain": nil, "varints": {WithVarints()}} {
+		w := NewWALWithStorage(NewMemoryStorage(), opts...)
+		for _, cmd := range cmds {
+			if err := w.Append(cmd); err != nil {
+				t.Fatalf("%s: failed to append: %v", name, err)
+			}
+		}
+		read, err := w.ReadAll()
+		if err != nil {
+			t.Fatalf("%s: failed to read all: %v", name, err)
+		}
+		if !reflect.DeepEqual(read, cmds) {
+			t.Errorf("%s: expected %+v, got %+v", name, cmds, read)
+		}
+	}
+
+	// An acquire without a value is encoded as it was before values existed
+	stripped := withValue
+	stripped.Value = ""
+	if got, want := len(encodePayload(withValue)), len(encodePayload(stripped))+2+len(withValue.Value); got != want {
+		t.Errorf("expected a %d byte payload, got %d", want, got)
+	}
+	if !bytes.HasPrefix(encodePayload(withValue), encodePayload(stripped)) {
+		t.Error("expected the value to trail the fields of an acquire without one")
+	}
+
+	// Only acquires carry one
+	release := command.Command{Type: command.CmdRelease, LockID: "leader", OwnerID: "owner1", Value: "ignored", FencingToken: 1}
+	cmd, err := decodePayload(encodePayload(release))
+	if err != nil {
+		t.Fatalf("failed to decode: %v", err)
+	}
+	if cmd.Value != "" {
+		t.Errorf("expected a release to carry no value, got %q", cmd.Value)
+	}
+}
+
+func TestWALFormatVersion(t *testing.T) {
+	transfer := command.Command{
+		Type:         command.CmdTransfer,
+		LockID:       "lock1",
+		OwnerID:      "owner1",
+		NewOwnerID:   "owner2",
+		FencingToken: 3,
+		TTLMillis:    1000,
+	}
+
+	// Both owners survive a round trip through a file
+	tmpFile, err := os.CreateTemp("", "wal_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpFile.Name())
+	w := NewWAL(tmpFile)
+	if err := w.Append(transfer); err != nil {
+		t.Fatalf("failed to append: %v", err)
+	}
+	cmds, err := w.ReadAll()
+	if err != nil {
+		t.Fatalf("failed to read all: %v", err)
+	}
+	if len(cmds) != 1 || cmds[0] != transfer {
+		t.Errorf("unexpected commands: %+v", cmds)
+	}
+
+	// A version 1 record has no format_version byte and is still readable
+	legacy := command.Command{Type: command.CmdAcquire, LockID: "lock1", OwnerID: "owner1", FencingToken: 1}
+	cmd, err := decodePayload(encodePayload(legacy)[1:])
+	if err != nil {
+		t.Fatalf("failed to decode version 1 record: %v", err)
+	}
+	if cmd != legacy {
+		t.Errorf("version 1 record mismatch: %+v", cmd)
+	}
+
+	// Records from a newer writer are refused rather than misread
+	future := encodePayload(transfer)
+	future[0] = versionFlag | (formatVersion + 1)
+	if _, err := decodePayload(future); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("expected ErrUnsupportedVersion, got %v", err)
+	}
+}
+
+func TestWALAppendObserver(t *testing.T) {
+	var seen []command.Command
+	storage := &failingSyncStorage{WALStorage: NewMemoryStorage()}
+	w := NewWALWithStorage(storage, WithAppendObserver(func(cmd command.Command) {
+		seen = append(seen, cmd)
+	}))
+
+	var cmds []command.Command
+	appendN := func(n int) {
+		t.Helper()
+		for i := 0; i < n; i++ {
+			cmd := command.Command{Type: command.CmdAcquire, LockID: "lock1", OwnerID: "owner1", FencingToken: uint64(len(cmds) + 1)}
+			cmds = append(cmds, cmd)
+			if err := w.Append(cmd); err != nil {
+				t.Fatalf("failed to append: %v", err)
+			}
+		}
+	}
+
+	// Nothing is observed before it is durable
+	appendN(2)
+	if len(seen) != 0 {
+		t.Fatalf("expected no observed commands before sync, got %d", len(seen))
+	}
+	if err := w.Sync(); err != nil {
+		t.Fatalf("failed to sync: %v", err)
+	}
+	if len(seen) != 2 {
+		t.Fatalf("expected 2 observed commands after sync, got %d", len(seen))
+	}
+
+	// A failed sync holds commands back until a later one succeeds
+	appendN(2)
+	storage.fail = true
+	if err := w.Sync(); err == nil {
+		t.Fatal("expected sync to fail")
+	}
+	if len(seen) != 2 {
+		t.Fatalf("expected failed sync not to notify, got %d observed", len(seen))
+	}
+	storage.fail = false
+	appendN(1)
+	if err := w.Close(); err != nil {
+		t.Fatalf("failed to close: %v", err)
+	}
+
+	if len(seen) != len(cmds) {
+		t.Fatalf("expected %d observed commands, got %d", len(cmds), len(seen))
+	}
+	for i := range cmds {
+		if seen[i] != cmds[i] {
+			t.Errorf("observed command %d out of order: %+v", i, seen[i])
+		}
+	}
+}
+
+// failingSyncStorage fails Sync while fail is set
+type failingSyncStorage struct {
+	WALStorage
+	fail bool
+}
+
+func (s *failingSyncStorage) Sync() error {
+	if s.fail {
+		return errors.New("sync failed")
+	}
+	return s.WALStorage.Sync()
+}
+
+func TestWALVarints(t *testing.T) {
+	cmds := []command.Command{
+		{Type: command.CmdAcquire, LockID: "lock1", OwnerID: "owner1", FencingToken: 1, TTLMillis: 30000, CommitTimeMillis: 1700000000000},
+		{Type: command.CmdTransfer, LockID: "lock1", OwnerID: "owner1", NewOwnerID: "owner2", FencingToken: 2, TTLMillis: 1000, CommitTimeMillis: 1700000000500},
+		{Type: command.CmdRelease, LockID: "lock2", OwnerID: "owner1", FencingToken: math.MaxUint64, TTLMillis: math.MaxUint64, CommitTimeMillis: math.MaxUint64},
+	}
+
+	// A log written with fixed fields, then reopened with varints, reads back as one
+	storage := NewMemoryStorage()
+	fixed := NewWALWithStorage(storage)
+	if err := fixed.Append(cmds[0]); err != nil {
+		t.Fatalf("failed to append: %v", err)
+	}
+	compact := NewWALWithStorage(storage, WithVarints())
+	for _, cmd := range cmds[1:] {
+		if err := compact.Append(cmd); err != nil {
+			t.Fatalf("failed to append: %v", err)
+		}
+	}
+	read, err := compact.ReadAll()
+	if err != nil {
+		t.Fatalf("failed to read all: %v", err)
+	}
+	if !reflect.DeepEqual(read, cmds) {
+		t.Errorf("expected %+v, got %+v", cmds, read)
+	}
+
+	// A young lock's record shrinks: a one-byte token, three-byte TTL and six-byte timestamp
+	fixedSize := len(encodePayloadVersion(cmds[0], fixedFormatVersion))
+	varintSize := len(encodePayloadVersion(cmds[0], varintFormatVersion))
+	if fixedSize-varintSize != 14 {
+		t.Errorf("expected varints to save 14 bytes, fixed %d, varint %d", fixedSize, varintSize)
+	}
+}
+
+func TestReadAllDecodesIntoSharedBuffer(t *testing.T) {
+	// Records shrink and grow, so later ones are read over the bytes of earlier, longer ones
+	cmds := []command.Command{
+		{Type: command.CmdTransfer, RequestID: [16]byte{1}, LockID: strings.Repeat("l", 300), OwnerID: strings.Repeat("o", 200), NewOwnerID: "owner2", FencingToken: 1, TTLMillis: 1000},
+		{Type: command.CmdAcquire, RequestID: [16]byte{2}, LockID: "a", OwnerID: "b", FencingToken: 2, TTLMillis: 1000},
+		{Type: command.CmdRelease, RequestID: [16]byte{3}, LockID: "", OwnerID: "", FencingToken: 3},
+		{Type: command.CmdAcquire, RequestID: [16]byte{4}, LockID: strings.Repeat("x", 1000), OwnerID: "owner1", FencingToken: 4, TTLMillis: 1000},
+	}
+	aead := newTestAEAD(t, bytes.Repeat([]byte{0x42}, 32))
+	for name, opts := range map[string][]Option{"plain": nil, "varints": {WithVarints()}, "encrypted": {WithCipher(aead)}} {
+		w := NewWALWithStorage(NewMemoryStorage(), opts...)
+		for _, cmd := range cmds {
+			if err := w.Append(cmd); err != nil {
+				t.Fatalf("%s: failed to append: %v", name, err)
+			}
+		}
+		read, err := w.ReadAll()
+		if err != nil {
+			t.Fatalf("%s: failed to read all: %v", name, err)
+		}
+		if !reflect.DeepEqual(read, cmds) {
+			t.Errorf("%s: expected %+v, got %+v", name, cmds, read)
+		}
+	}
+
+	// Every truncation of a payload is refused rather than misread
+	payload := encodePayload(cmds[0])
+	for n := range len(payload) {
+		if _, err := decodePayload(payload[:n]); err == nil {
+			t.Errorf("expected decoding %d of %d payload bytes to fail", n, len(payload))
+		}
+	}
+}
+
+func TestWALPaddingFrames(t *testing.T) {
+	cmds := []command.Command{
+		{Type: command.CmdAcquire, LockID: "lock1", OwnerID: "owner1", FencingToken: 1, TTLMillis: 1000},
+		{Type: command.CmdAcquire, LockID: "lock2", OwnerID: "owner1", FencingToken: 2, TTLMillis: 1000},
+	}
+	// Each record padded out to a block, as WithDirectIO writes them
+	var padded []byte
+	for _, cmd := range cmds {
+		record := EncodeRecord(cmd)
+		padded = append(padded, record...)
+		padded = append(padded, paddingFrame(int64(len(record)))...)
+	}
+	logOf := func(data []byte, opts ...Option) WAL {
+		storage := NewMemoryStorage()
+		if err := storage.WriteRecord(data); err != nil {
+			t.Fatalf("failed to write log: %v", err)
+		}
+		return NewWALWithStorage(storage, opts...)
+	}
+
+	read, err := logOf(padded, WithPadding()).ReadAll()
+	if err != nil {
+		t.Fatalf("failed to read padded log: %v", err)
+	}
+	if !reflect.DeepEqual(read, cmds) {
+		t.Errorf("expected %+v, got %+v", cmds, read)
+	}
+
+	// Padding in a log not expected to hold any is damage, not something to skip
+	if _, err := logOf(padded).ReadAll(); err == nil {
+		t.Error("expected padding to fail a read without WithPadding")
+	}
+
+	// as is a flipped top bit in a record length, which would otherwise skip what follows
+	flipped := bytes.Clone(padded)
+	flipped[0] |= 0x80
+	if _, err := logOf(flipped, WithPadding()).ReadAll(); err == nil {
+		t.Error("expected a length with its top bit flipped to fail the read")
+	}
+
+	// and padding that doesn't end on a block boundary
+	var short []byte
+	short = append(short, EncodeRecord(cmds[0])...)
+	short = append(short, paddingFrame(int64(len(short))+1)...)
+	short = append(short, EncodeRecord(cmds[1])...)
+	if _, err := logOf(short, WithPadding()).ReadAll(); err == nil {
+		t.Error("expected misaligned padding to fail the read")
+	}
+}
@@ -0,0 +1,162 @@
+// Package waltest provides WAL implementations for exercising error paths in tests
+package waltest
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/mrdhat/clutchdb/command"
+	"github.com/mrdhat/clutchdb/wal"
+)
+
+// ErrInjected is returned by a FaultyWAL Append configured to fail
+var ErrInjected = errors.New("injected wal fault")
+
+type fault int
+
+const (
+	faultNone fault = iota
+	faultShortWrite
+	faultCorrupt
+)
+
+// FaultyWAL is a WAL that misbehaves on demand. Appends, through Append or AppendSync, are
+// numbered from 1; each fault fires on the configured append only. Everything else is
+// forwarded to a real WAL over the given storage, faults being injected between the two.
+type FaultyWAL struct {
+	wal.WAL
+	storage *faultyStorage
+
+	mu        sync.Mutex // serializes appends so faults hit the intended record
+	appends   int
+	failAt    int
+	shortAt   int
+	corruptAt int
+}
+
+// NewFaultyWAL returns a FaultyWAL over in-memory storage with no faults configured
+func NewFaultyWAL(opts ...wal.Option) *FaultyWAL {
+	return NewFaultyWALWithStorage(wal.NewMemoryStorage(), opts...)
+}
+
+// NewFaultyWALWithStorage returns a FaultyWAL with no faults configured, forwarding to a WAL
+// over storage built with opts, such as file storage or WithCipher. Optional capabilities of
+// storage that the wal package only finds on its own backends, such as rolling back a failed
+// sync, are hidden by the fault layer.
+func NewFaultyWALWithStorage(storage wal.WALStorage, opts ...wal.Option) *FaultyWAL {
+	faulty := &faultyStorage{WALStorage: storage}
+	return &FaultyWAL{
+		WAL:     wal.NewWALWithStorage(faulty, opts...),
+		storage: faulty,
+	}
+}
+
+// FailAppend makes the nth Append return ErrInjected without writing anything
+func (w *FaultyWAL) FailAppend(n int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.failAt = n
+}
+
+// ShortWrite makes the nth Append write only half of its record and return io.ErrShortWrite,
+// leaving a torn record behind as a crash mid-write would
+func (w *FaultyWAL) ShortWrite(n int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.shortAt = n
+}
+
+// Corrupt makes the nth Append succeed but store its record with a flipped payload byte
+func (w *FaultyWAL) Corrupt(n int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.corruptAt = n
+}
+
+// StallSync makes every sync of the storage, by Sync or AppendSync, wait d first
+func (w *FaultyWAL) StallSync(d time.Duration) {
+	w.storage.mu.Lock()
+	defer w.storage.mu.Unlock()
+	w.storage.syncDelay = d
+}
+
+// Appends returns how many times Append or AppendSync has been called
+func (w *FaultyWAL) Appends() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.appends
+}
+
+func (w *FaultyWAL) Append(cmd command.Command) error {
+	return w.append(cmd, w.WAL.Append)
+}
+
+// AppendSync forwards to the real WAL's AppendSync, so callers that prefer it, such as the
+// server's commit path, take the same path they would in production
+func (w *FaultyWAL) AppendSync(cmd command.Command) error {
+	return w.append(cmd, w.WAL.(wal.SyncAppender).AppendSync)
+}
+
+// append numbers an append and arms whatever fault is configured for it before calling next
+func (w *FaultyWAL) append(cmd command.Command, next func(command.Command) error) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.appends++
+	switch w.appends {
+	case w.failAt:
+		return ErrInjected
+	case w.shortAt:
+		w.storage.setNext(faultShortWrite)
+	case w.corruptAt:
+		w.storage.setNext(faultCorrupt)
+	}
+	return next(cmd)
+}
+
+// faultyStorage applies a one-shot fault to the next record written, and stalls syncs
+type faultyStorage struct {
+	wal.WALStorage
+
+	mu        sync.Mutex
+	next      fault
+	syncDelay time.Duration
+}
+
+func (s *faultyStorage) Sync() error {
+	s.mu.Lock()
+	delay := s.syncDelay
+	s.mu.Unlock()
+
+	time.Sleep(delay)
+	return s.WALStorage.Sync()
+}
+
+func (s *faultyStorage) setNext(f fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.next = f
+}
+
+func (s *faultyStorage) WriteRecord(record []byte) error {
+	s.mu.Lock()
+	f := s.next
+	s.next = faultNone
+	s.mu.Unlock()
+
+	switch f {
+	case faultShortWrite:
+		if err := s.WALStorage.WriteRecord(record[:len(record)/2]); err != nil {
+			return err
+		}
+		return io.ErrShortWrite
+	case faultCorrupt:
+		corrupted := append([]byte(nil), record...)
+		corrupted[len(corrupted)-1] ^= 0xff
+		return s.WALStorage.WriteRecord(corrupted)
+	default:
+		return s.WALStorage.WriteRecord(record)
+	}
+}
@@ -0,0 +1,135 @@
+package waltest
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mrdhat/clutchdb/command"
+	"github.com/mrdhat/clutchdb/wal"
+)
+
+func TestFaultyWALFailAppend(t *testing.T) {
+	w := NewFaultyWAL()
+	w.FailAppend(2)
+
+	for i := 1; i <= 3; i++ {
+		err := w.Append(command.Command{Type: command.CmdAcquire, LockID: "lock1", FencingToken: uint64(i)})
+		if i == 2 && !errors.Is(err, ErrInjected) {
+			t.Fatalf("Expected append 2 to fail with ErrInjected, got %v", err)
+		}
+		if i != 2 && err != nil {
+			t.Fatalf("Append %d failed: %v", i, err)
+		}
+	}
+
+	cmds, err := w.ReadAll()
+	if err != nil {
+		t.Fatalf("ReadAll failed: %v", err)
+	}
+	if len(cmds) != 2 || cmds[0].FencingToken != 1 || cmds[1].FencingToken != 3 {
+		t.Errorf("Expected records 1 and 3, got %+v", cmds)
+	}
+}
+
+func TestFaultyWALShortWriteAndCorrupt(t *testing.T) {
+	short := NewFaultyWAL()
+	short.ShortWrite(1)
+	if err := short.Append(command.Command{Type: command.CmdAcquire, LockID: "lock1"}); err == nil {
+		t.Fatal("Expected short write to fail")
+	}
+	if _, err := short.ReadAll(); err == nil {
+		t.Error("Expected ReadAll to reject the torn record")
+	}
+
+	corrupt := NewFaultyWAL()
+	corrupt.Corrupt(1)
+	if err := corrupt.Append(command.Command{Type: command.CmdAcquire, LockID: "lock1"}); err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	if _, err := corrupt.ReadAll(); err == nil {
+		t.Error("Expected ReadAll to reject the corrupted record")
+	}
+}
+
+func TestFaultyWALStallSync(t *testing.T) {
+	w := NewFaultyWAL()
+	w.StallSync(20 * time.Millisecond)
+
+	start := time.Now()
+	if err := w.Sync(); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("Expected Sync to stall for 20ms, returned after %v", elapsed)
+	}
+}
+
+func TestFaultyWALAppendSync(t *testing.T) {
+	w := NewFaultyWAL()
+	w.FailAppend(2)
+	w.StallSync(20 * time.Millisecond)
+
+	start := time.Now()
+	if err := w.AppendSync(command.Command{Type: command.CmdAcquire, LockID: "lock1", FencingToken: 1}); err != nil {
+		t.Fatalf("AppendSync failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("Expected AppendSync to stall for 20ms, returned after %v", elapsed)
+	}
+	if err := w.AppendSync(command.Command{Type: command.CmdAcquire, LockID: "lock1", FencingToken: 2}); !errors.Is(err, ErrInjected) {
+		t.Fatalf("Expected append 2 to fail with ErrInjected, got %v", err)
+	}
+	if n := w.Appends(); n != 2 {
+		t.Errorf("Expected 2 appends, got %d", n)
+	}
+}
+
+func TestFaultyWALWithStorage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wal")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := aes.NewCipher(bytes.Repeat([]byte{0x42}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := NewFaultyWALWithStorage(wal.NewFileStorage(file), wal.WithCipher(aead))
+	w.Corrupt(2)
+	for i := 1; i <= 2; i++ {
+		if err := w.AppendSync(command.Command{Type: command.CmdAcquire, LockID: "lock1", FencingToken: uint64(i)}); err != nil {
+			t.Fatalf("AppendSync %d failed: %v", i, err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	// The records reached the caller's file, encrypted, with the second one corrupted
+	reopened, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("lock1")) {
+		t.Error("Expected the records to be encrypted")
+	}
+	if _, err := wal.NewWALWithStorage(wal.NewFileStorage(reopened), wal.WithCipher(aead)).ReadAll(); err == nil {
+		t.Error("Expected ReadAll to reject the corrupted record")
+	}
+}